@@ -0,0 +1,230 @@
+// Package audit reports on the health of the passwords in a db, those which are weak, breached, reused, old, expired
+// or violate their password policy.
+package audit
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// Defaults for the audit Options
+const (
+	// DefaultMinEntropy is the estimated entropy in bits below which a password is weak
+	DefaultMinEntropy = 50
+	// DefaultMaxAge is the age after which a password is old
+	DefaultMaxAge = 365 * 24 * time.Hour
+)
+
+// Kind is a kind of problem found with a password
+type Kind string
+
+// The kinds of problem found, in the order they are reported
+const (
+	Breached Kind = "breached"
+	Weak     Kind = "weak"
+	Reused   Kind = "reused"
+	Old      Kind = "old"
+	Expired  Kind = "expired"
+	Policy   Kind = "policy"
+)
+
+// Kinds is every kind of problem in the order they are reported
+var Kinds = []Kind{Breached, Weak, Reused, Old, Expired, Policy}
+
+// Description returns a heading for the kind of problem
+func (k Kind) Description() string {
+	switch k {
+	case Breached:
+		return "Breached passwords"
+	case Weak:
+		return "Weak passwords"
+	case Reused:
+		return "Reused passwords"
+	case Old:
+		return "Old passwords"
+	case Expired:
+		return "Expired passwords"
+	case Policy:
+		return "Password policy violations"
+	}
+	return string(k)
+}
+
+// Options configure an audit, the zero value uses the defaults
+type Options struct {
+	// MinEntropy is the estimated entropy in bits below which a password is weak, DefaultMinEntropy if 0
+	MinEntropy float64
+	// MaxAge is the age after which a password is old, DefaultMaxAge if 0
+	MaxAge time.Duration
+	// Dictionary adds words, such as company or product names, to those passwords are checked against
+	Dictionary []string
+	// Now is the time ages and expiry are compared to, the current time if zero
+	Now time.Time
+	// Breaches checks passwords against a list of breached passwords, such as a HashList, none are if nil
+	Breaches BreachChecker
+}
+
+// Finding is a problem with the password of a record
+type Finding struct {
+	Kind   Kind   `json:"kind"`
+	Title  string `json:"title"`
+	Group  string `json:"group,omitempty"`
+	UUID   string `json:"uuid"`
+	Detail string `json:"detail"`
+}
+
+// Report is the result of an audit
+type Report struct {
+	DB        string    `json:"db"`
+	Generated time.Time `json:"generated"`
+	// Records is the number of records audited
+	Records  int       `json:"records"`
+	Findings []Finding `json:"findings"`
+}
+
+// Audit checks the password of every record in the db. Aliases and shortcuts are skipped as they use the password of
+// their base record. Policies are only checked for V3 and V4 dbs. An error is only returned if checking for breaches
+// fails.
+func Audit(db pwsafe.DB, opts Options) (Report, error) {
+	if opts.MinEntropy == 0 {
+		opts.MinEntropy = DefaultMinEntropy
+	}
+	if opts.MaxAge == 0 {
+		opts.MaxAge = DefaultMaxAge
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	v3db, hasPolicies := pwsafe.BaseV3(db)
+
+	report := Report{DB: db.GetName(), Generated: opts.Now}
+	reuse := make(map[string][]pwsafe.Record)
+	for _, title := range db.List() {
+		record, _ := db.GetRecord(title)
+		report.Records++
+		add := func(kind Kind, format string, a ...interface{}) {
+			report.Findings = append(report.Findings, Finding{Kind: kind, Title: record.Title, Group: record.Group,
+				UUID: hex.EncodeToString(record.UUID[:]), Detail: fmt.Sprintf(format, a...)})
+		}
+
+		if record.Expired(opts.Now) {
+			add(Expired, "expired %s", record.PasswordExpiry.Format("2006-01-02"))
+		}
+		changed := record.PasswordModTime
+		if changed.IsZero() {
+			changed = record.ModTime
+		}
+		if age := opts.Now.Sub(changed); !changed.IsZero() && age > opts.MaxAge {
+			add(Old, "last changed %s, %d days ago", changed.Format("2006-01-02"), int(age.Hours()/24))
+		}
+
+		if _, alias := pwsafe.AliasBase(record.Password); alias || record.Password == "" {
+			continue
+		}
+		reuse[record.Password] = append(reuse[record.Password], record)
+		if opts.Breaches != nil {
+			n, err := opts.Breaches.Breaches(record.Password)
+			if err != nil {
+				return Report{}, fmt.Errorf("Error checking %q for breaches - %w", record.Title, err)
+			}
+			if n > 0 {
+				add(Breached, "seen %d times in data breaches", n)
+			}
+		}
+		if strength := Estimate(record.Password, opts.Dictionary); strength.Entropy < opts.MinEntropy {
+			detail := fmt.Sprintf("estimated %.0f bits of entropy", strength.Entropy)
+			if len(strength.Patterns) > 0 {
+				detail += ", " + strings.Join(strength.Patterns, ", ")
+			}
+			add(Weak, "%s", detail)
+		}
+		if hasPolicies {
+			policy, ok, err := v3db.RecordPolicy(record)
+			if err != nil {
+				add(Policy, "%s", err)
+			} else if violations := policy.Check(record.Password); ok && len(violations) > 0 {
+				add(Policy, "%s", strings.Join(violations, ", "))
+			}
+		}
+	}
+
+	for _, records := range reuse {
+		if len(records) < 2 {
+			continue
+		}
+		for _, record := range records {
+			var others []string
+			for _, other := range records {
+				if other.Title != record.Title {
+					others = append(others, fmt.Sprintf("%q", other.Title))
+				}
+			}
+			report.Findings = append(report.Findings, Finding{Kind: Reused, Title: record.Title, Group: record.Group,
+				UUID: hex.EncodeToString(record.UUID[:]), Detail: "also used by " + strings.Join(others, ", ")})
+		}
+	}
+
+	order := make(map[Kind]int, len(Kinds))
+	for i, kind := range Kinds {
+		order[kind] = i
+	}
+	sort.Slice(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if a.Kind != b.Kind {
+			return order[a.Kind] < order[b.Kind]
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.Title < b.Title
+	})
+	return report, nil
+}
+
+// Count returns the number of findings of a kind
+func (r Report) Count(kind Kind) int {
+	n := 0
+	for _, finding := range r.Findings {
+		if finding.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteText writes the report as text, a summary of the number of findings of each kind then the findings
+func (r Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Password audit of %s, %d records, %s\n\n", r.DB, r.Records, r.Generated.Format("2006-01-02 15:04"))
+	for _, kind := range Kinds {
+		fmt.Fprintf(&b, "%-28s %d\n", kind.Description()+":", r.Count(kind))
+	}
+	kind := Kind("")
+	for _, finding := range r.Findings {
+		if finding.Kind != kind {
+			kind = finding.Kind
+			fmt.Fprintf(&b, "\n%s\n", kind.Description())
+		}
+		name := finding.Title
+		if finding.Group != "" {
+			name = finding.Group + "." + finding.Title
+		}
+		fmt.Fprintf(&b, "  %s - %s\n", name, finding.Detail)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the report as indented JSON
+func (r Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
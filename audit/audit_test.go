@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// auditDB returns a db with a record for each kind of problem and one without any
+func auditDB(now time.Time) *pwsafe.V3 {
+	db := pwsafe.NewV3("audit", "password")
+	db.PasswordPolicy = pwsafe.FormatPasswordPolicies([]pwsafe.PasswordPolicy{
+		{Name: "pin", Flags: pwsafe.PolicyUseDigits, Length: 6, MinDigits: 6},
+	})
+	fresh := now.Add(-24 * time.Hour)
+	db.SetRecord(pwsafe.Record{Title: "good", Password: "x7#Kq9!vTz2@Lm4$"})
+	db.SetRecord(pwsafe.Record{Title: "weak", Group: "web", Password: "Summer2023!"})
+	db.SetRecord(pwsafe.Record{Title: "reused 1", Password: "Gq8#vR2!pLx9@wZ4"})
+	db.SetRecord(pwsafe.Record{Title: "reused 2", Group: "web", Password: "Gq8#vR2!pLx9@wZ4"})
+	db.SetRecord(pwsafe.Record{Title: "expired", Password: "Hb3$kT8!mQw2#zR7", PasswordExpiry: now.Add(-time.Hour)})
+	db.SetRecord(pwsafe.Record{Title: "pin", Password: "12ab56", PasswordPolicyName: "pin"})
+	good, _ := db.GetRecord("good")
+	db.SetRecord(pwsafe.Record{Title: "alias", Password: "[[" + hex.EncodeToString(good.UUID[:]) + "]]"})
+	db.SetRecord(pwsafe.Record{Title: "notes only"})
+	for _, title := range db.List() {
+		record, _ := db.GetRecord(title)
+		record.PasswordModTime = fresh
+		if title == "good" {
+			record.PasswordModTime = now.Add(-400 * 24 * time.Hour)
+		}
+		db.Records[title] = record
+	}
+	return db
+}
+
+func TestAudit(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	db := auditDB(now)
+	report, err := Audit(db, Options{Now: now})
+	assert.Nil(t, err)
+	assert.Equal(t, "audit", report.DB)
+	assert.Equal(t, 8, report.Records)
+
+	var got []string
+	for _, finding := range report.Findings {
+		got = append(got, string(finding.Kind)+" "+finding.Title)
+	}
+	assert.Equal(t, []string{"weak pin", "weak weak", "reused reused 1", "reused reused 2", "old good",
+		"expired expired", "policy pin"}, got)
+	assert.Equal(t, 2, report.Count(Reused))
+	assert.Equal(t, `also used by "reused 2"`, report.Findings[2].Detail)
+	assert.Equal(t, "last changed 2023-04-28, 400 days ago", report.Findings[4].Detail)
+	assert.Equal(t, "expired 2024-06-01", report.Findings[5].Detail)
+	assert.Equal(t, `4 digits, fewer than the minimum 6, has characters the policy doesn't use "ab"`,
+		report.Findings[6].Detail)
+
+	var text bytes.Buffer
+	assert.Nil(t, report.WriteText(&text))
+	assert.True(t, strings.HasPrefix(text.String(), "Password audit of audit, 8 records, 2024-06-01 12:00\n\n"+
+		"Breached passwords:          0\nWeak passwords:              2\nReused passwords:            2\n"), text.String())
+	assert.Contains(t, text.String(), "\nWeak passwords\n  pin - estimated")
+	assert.Contains(t, text.String(), "  web.weak - estimated")
+
+	var buf bytes.Buffer
+	assert.Nil(t, report.WriteJSON(&buf))
+	var decoded Report
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, report, decoded)
+
+	// a shorter maximum age and higher minimum entropy find more
+	report, err = Audit(db, Options{Now: now, MaxAge: time.Hour, MinEntropy: 200})
+	assert.Nil(t, err)
+	assert.Equal(t, 8, report.Count(Old))
+	assert.Equal(t, 6, report.Count(Weak))
+}
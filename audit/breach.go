@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachChecker returns how many times a password has been seen in data breaches, 0 if it hasn't
+type BreachChecker interface {
+	Breaches(password string) (int, error)
+}
+
+// rangePrefix is the number of hex digits of the SHA-1 hash in the name of a range file
+const rangePrefix = 5
+
+// maxLine is the longest line expected in a hash list, a hash, a colon and a count
+const maxLine = 128
+
+// HashList is a local copy of the Have I Been Pwned SHA-1 password hashes, either one file sorted by hash with a line
+// "HASH:COUNT" for each or a directory of range files named by the first five hex digits of the hashes, such as
+// 21BD1.txt, each with a sorted line "SUFFIX:COUNT" for the rest of the hash. Hashes are looked up with a binary
+// search of the file so the list is never loaded into memory.
+type HashList struct {
+	dir  string
+	file *os.File
+	size int64
+}
+
+// OpenHashList opens a sorted hash list file or a directory of range files
+func OpenHashList(path string) (*HashList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &HashList{dir: path}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &HashList{file: f, size: info.Size()}, nil
+}
+
+// Close closes the hash list file
+func (l *HashList) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Breaches returns the count of the SHA-1 hash of the password in the list, 0 if it is not in the list. A missing
+// range file is an error as the list is incomplete.
+func (l *HashList) Breaches(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if l.file != nil {
+		return searchHashes(l.file, l.size, hash)
+	}
+
+	prefix := hash[:rangePrefix]
+	var f *os.File
+	var err error
+	for _, name := range []string{prefix + ".txt", prefix, strings.ToLower(prefix) + ".txt", strings.ToLower(prefix)} {
+		if f, err = os.Open(filepath.Join(l.dir, name)); err == nil || !os.IsNotExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("no range file for the prefix %s - %w", prefix, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return searchHashes(f, info.Size(), hash[rangePrefix:])
+}
+
+// searchHashes binary searches the sorted lines of r for the hash, returning its count. Lines are "HASH:COUNT", the
+// count is 1 if there is none. Comparisons ignore case and line endings may be \n or \r\n.
+func searchHashes(r io.ReaderAt, size int64, hash string) (int, error) {
+	target := []byte(hash)
+	// Every line starting before lo is less than the hash and every line starting at or after hi is greater
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := lineFrom(r, size, mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi {
+			// no line starts in mid to hi so the hash can only be before mid
+			hi = mid
+			continue
+		}
+		key, count := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			key, count = line[:i], line[i+1:]
+		}
+		switch c := bytes.Compare(bytes.ToUpper(bytes.TrimSpace(key)), target); {
+		case c == 0:
+			if len(count) == 0 {
+				return 1, nil
+			}
+			n, err := strconv.Atoi(string(bytes.TrimSpace(count)))
+			if err != nil {
+				return 0, fmt.Errorf("invalid count in the hash list line %q", line)
+			}
+			return n, nil
+		case c < 0:
+			lo = start + int64(len(line)) + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, nil
+}
+
+// lineFrom returns the first line starting at or after off and its offset, without the line ending. The start is size
+// if there is no such line.
+func lineFrom(r io.ReaderAt, size, off int64) (int64, []byte, error) {
+	start := off
+	buf := make([]byte, maxLine)
+	if off > 0 {
+		// skip the rest of the line off is within, unless the previous byte ends a line
+		n, err := r.ReadAt(buf, off-1)
+		if err != nil && err != io.EOF {
+			return 0, nil, err
+		}
+		i := bytes.IndexByte(buf[:n], '\n')
+		if i < 0 {
+			if off-1+int64(n) >= size {
+				return size, nil, nil
+			}
+			return 0, nil, fmt.Errorf("hash list line at %d is longer than %d bytes", off, maxLine)
+		}
+		start = off + int64(i)
+	}
+	if start >= size {
+		return size, nil, nil
+	}
+	n, err := r.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	line := buf[:n]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	} else if start+int64(n) < size {
+		return 0, nil, fmt.Errorf("hash list line at %d is longer than %d bytes", start, maxLine)
+	}
+	return start, line, nil
+}
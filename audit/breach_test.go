@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// hashOf returns the upper case hex SHA-1 hash of the password
+func hashOf(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// writeHashList writes a sorted hash list of password0 to passwordN-1, the count of each is its number plus one, as
+// a single file and as a directory of range files
+func writeHashList(t *testing.T, n int) (file, dir string) {
+	var hashes []string
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		hash := hashOf(fmt.Sprintf("password%d", i))
+		hashes = append(hashes, hash)
+		counts[hash] = i + 1
+	}
+	sort.Strings(hashes)
+
+	tmp := t.TempDir()
+	file = filepath.Join(tmp, "pwned-passwords-sha1-ordered-by-hash.txt")
+	dir = filepath.Join(tmp, "ranges")
+	assert.Nil(t, os.Mkdir(dir, 0700))
+	var all strings.Builder
+	ranges := make(map[string]*strings.Builder)
+	for _, hash := range hashes {
+		fmt.Fprintf(&all, "%s:%d\r\n", hash, counts[hash])
+		prefix := hash[:rangePrefix]
+		if ranges[prefix] == nil {
+			ranges[prefix] = &strings.Builder{}
+		}
+		fmt.Fprintf(ranges[prefix], "%s:%d\n", strings.ToLower(hash[rangePrefix:]), counts[hash])
+	}
+	assert.Nil(t, os.WriteFile(file, []byte(all.String()), 0600))
+	for prefix, lines := range ranges {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(lines.String()), 0600))
+	}
+	return file, dir
+}
+
+func TestHashList(t *testing.T) {
+	file, dir := writeHashList(t, 500)
+	for _, path := range []string{file, dir} {
+		list, err := OpenHashList(path)
+		assert.Nil(t, err)
+		for i := 0; i < 500; i++ {
+			n, err := list.Breaches(fmt.Sprintf("password%d", i))
+			assert.Nil(t, err)
+			assert.Equal(t, i+1, n, "%s password%d", path, i)
+		}
+		assert.Nil(t, list.Close())
+	}
+
+	list, err := OpenHashList(file)
+	assert.Nil(t, err)
+	for i := 500; i < 600; i++ {
+		n, err := list.Breaches(fmt.Sprintf("password%d", i))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+	}
+	// the range file of a password not in the list is missing
+	list, err = OpenHashList(dir)
+	assert.Nil(t, err)
+	_, err = list.Breaches("not in the list")
+	assert.True(t, errors.Is(err, os.ErrNotExist), err)
+
+	_, err = OpenHashList(filepath.Join(dir, "missing"))
+	assert.NotNil(t, err)
+}
+
+func TestSearchHashes(t *testing.T) {
+	// lines without counts, a final line without a newline and a blank line
+	list := "\n" + strings.Join([]string{hashOf("a"), hashOf("b") + ":7", hashOf("c")}, "\n")
+	sorted := strings.Split(list, "\n")
+	sort.Strings(sorted)
+	list = strings.Join(sorted, "\n")
+	for password, want := range map[string]int{"a": 1, "b": 7, "c": 1, "d": 0} {
+		n, err := searchHashes(strings.NewReader(list), int64(len(list)), hashOf(password))
+		assert.Nil(t, err)
+		assert.Equal(t, want, n, password)
+	}
+	n, err := searchHashes(strings.NewReader(""), 0, hashOf("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	long := strings.Repeat("0", 300)
+	_, err = searchHashes(strings.NewReader(long), int64(len(long)), hashOf("a"))
+	assert.NotNil(t, err)
+}
+
+// breachErrors is a BreachChecker which always fails
+type breachErrors struct{}
+
+func (breachErrors) Breaches(string) (int, error) { return 0, errors.New("unavailable") }
+
+func TestAuditBreaches(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	db := auditDB(now)
+	file, _ := writeHashList(t, 10)
+	// the good password is in the list, despite being strong
+	good, _ := db.GetRecord("good")
+	good.Password = "password3"
+	db.Records["good"] = good
+
+	list, err := OpenHashList(file)
+	assert.Nil(t, err)
+	defer list.Close()
+	report, err := Audit(db, Options{Now: now, Breaches: list})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Count(Breached))
+	assert.Equal(t, Finding{Kind: Breached, Title: "good", UUID: hex.EncodeToString(good.UUID[:]),
+		Detail: "seen 4 times in data breaches"}, report.Findings[0])
+
+	_, err = Audit(db, Options{Now: now, Breaches: breachErrors{}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,55 @@
+package audit
+
+import "strings"
+
+// commonWords are common passwords and words used in passwords, most common first. Passwords made only of sequences
+// such as 123456 or qwerty are found as sequences so are not listed.
+var commonWords = strings.Fields(`
+password iloveyou princess admin welcome monkey login abc123 starwars dragon passw0rd master hello freedom whatever
+qazwsx trustno1 letmein football baseball sunshine shadow superman batman michael jennifer jordan hunter ranger
+buster soccer harley hockey killer george charlie andrew michelle love pepper daniel access joshua maggie silver
+william dallas yankees amanda orange biteme computer thunder nicole ginger heather summer winter spring autumn
+corvette taylor secret changeme default guest root user test pass money family flower lovely angel baby cookie
+chocolate cheese coffee purple blue green black white yellow tiger lion bear eagle falcon mustang ferrari porsche
+mercedes matrix ninja pokemon minecraft google apple microsoft samsung facebook linkedin office company server
+network database backup system manager service support private secure security nothing something internet london
+paris berlin america canada england jesus heaven peace happy smile friend friends forever hallo passwort zaq1xsw2
+1q2w3e4r january february march april may june july august september october november december monday tuesday
+wednesday thursday friday saturday sunday dragon qwerty asdf zxcv cowboy hammer austin merlin
+diamond golfer thomas robert matthew jessica ashley hannah jasmine sophie samantha anthony justin jackson
+chelsea arsenal liverpool united barcelona madrid chicago boston texas florida california phoenix garden
+castle rainbow butterfly dolphin spider killer snoopy scooter booboo pepsi cocacola bailey buddy lucky sparky
+rocky shelby muffin cookie whiskey camaro maverick viper gandalf frodo hobbit wizard magic knight warrior
+legend player gamer hacker admin1 administrator welcome1 temp temppass letmein1 p4ssword pa55word mypassword
+newpassword oldpassword password1 qwerty123 iloveu babygirl lovers loveme beautiful sweet sweetie honey darling
+kitten puppy doggy kitty bunny pretty cutie angel1 star stars moon sun sky ocean river mountain forest winter1
+`)
+
+var builtinWords = func() map[string]int {
+	words := make(map[string]int, len(commonWords))
+	for rank, word := range commonWords {
+		if _, ok := words[word]; !ok {
+			words[word] = rank
+		}
+	}
+	return words
+}()
+
+// dictionary returns the built in words with extra words added, ranked after them
+func dictionary(extra []string) map[string]int {
+	if len(extra) == 0 {
+		return builtinWords
+	}
+	words := make(map[string]int, len(builtinWords)+len(extra))
+	for word, rank := range builtinWords {
+		words[word] = rank
+	}
+	for i, word := range extra {
+		if word = strings.ToLower(word); word != "" {
+			if _, ok := words[word]; !ok {
+				words[word] = len(commonWords) + i
+			}
+		}
+	}
+	return words
+}
@@ -0,0 +1,217 @@
+package audit
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Strength is the estimated strength of a password
+type Strength struct {
+	// Entropy is the estimated number of bits an attacker must guess, taking into account the patterns found
+	Entropy float64
+	// Patterns describes the guessable parts of the password, such as dictionary words and keyboard sequences
+	Patterns []string
+}
+
+// sequences are runs of characters people commonly type in order, forwards or backwards
+var sequences = []string{
+	"abcdefghijklmnopqrstuvwxyz",
+	"0123456789",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+}
+
+// leet maps characters commonly substituted for letters back to the letter, 1 is tried as both i and l
+var leet = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '3': 'e', '9': 'g', '1': 'i', '!': 'i', '0': 'o', '5': 's', '$': 's', '7': 't',
+	'+': 't',
+}
+
+// step is a part of a password matched by a pattern while estimating its strength
+type step struct {
+	from    int
+	bits    float64
+	pattern string
+}
+
+// Estimate returns the estimated strength of the password. It finds the cheapest way to build the password out of
+// dictionary words, with capitalization and common substitutions, sequences, repeats, years and otherwise single
+// characters guessed from the character classes used in the password. The extra words are checked as well as the
+// built in dictionary of common passwords and words.
+func Estimate(password string, extra []string) Strength {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return Strength{Patterns: []string{"empty"}}
+	}
+	words := dictionary(extra)
+	if rank, ok := words[strings.ToLower(password)]; ok {
+		return Strength{Entropy: math.Log2(float64(rank + 2)), Patterns: []string{"a common password"}}
+	}
+
+	charBits := math.Log2(float64(poolSize(runes)))
+	lower := make([]rune, len(runes))
+	for i, c := range runes {
+		lower[i] = unicode.ToLower(c)
+	}
+	// best[j] is the cheapest way to guess the first j characters
+	best := make([]step, len(runes)+1)
+	for j := 1; j <= len(runes); j++ {
+		best[j].bits = math.Inf(1)
+	}
+	consider := func(i, j int, bits float64, pattern string) {
+		if total := best[i].bits + bits; total < best[j].bits {
+			best[j] = step{from: i, bits: total, pattern: pattern}
+		}
+	}
+	// best[i] is final once every shorter prefix has been considered
+	for i := range runes {
+		consider(i, i+1, charBits, "")
+		for j := i + 3; j <= len(runes); j++ {
+			part := string(runes[i:j])
+			if rank, ok := matchWord(lower[i:j], words); ok {
+				bits := math.Log2(float64(rank+2)) + capitalizationBits(runes[i:j])
+				if !wordIn(string(lower[i:j]), words) {
+					// a substitution was made
+					bits++
+				}
+				consider(i, j, bits, fmt.Sprintf("the dictionary word %q", part))
+			}
+			if isSequence(lower[i:j]) {
+				consider(i, j, math.Log2(float64(len(sequences[0])))+1+math.Log2(float64(j-i)),
+					fmt.Sprintf("the sequence %q", part))
+			}
+			if isRepeat(runes[i:j]) {
+				consider(i, j, charBits+math.Log2(float64(j-i)), fmt.Sprintf("the repeated character %q", part))
+			}
+			if i > 0 && strings.Contains(string(runes[:i]), part) {
+				consider(i, j, math.Log2(float64(i))+math.Log2(float64(j-i)), fmt.Sprintf("the repeat %q", part))
+			}
+			if j-i == 4 && isYear(part) {
+				consider(i, j, math.Log2(200), fmt.Sprintf("the year %s", part))
+			}
+		}
+	}
+
+	var patterns []string
+	for j := len(runes); j > 0; j = best[j].from {
+		if best[j].pattern != "" {
+			patterns = append([]string{best[j].pattern}, patterns...)
+		}
+	}
+	return Strength{Entropy: best[len(runes)].bits, Patterns: patterns}
+}
+
+// poolSize returns the number of characters in the classes used by the password
+func poolSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, c := range runes {
+		switch {
+		case c > unicode.MaxASCII:
+			other = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	size := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			size += class.size
+		}
+	}
+	return size
+}
+
+// matchWord returns the rank of the word, lower case, in the dictionary trying common substitutions
+func matchWord(word []rune, words map[string]int) (int, bool) {
+	rank, found := words[string(word)]
+	for _, one := range []rune{'i', 'l'} {
+		plain := make([]rune, len(word))
+		for i, c := range word {
+			plain[i] = c
+			if c == '1' {
+				plain[i] = one
+			} else if l, ok := leet[c]; ok {
+				plain[i] = l
+			}
+		}
+		if r, ok := words[string(plain)]; ok && (!found || r < rank) {
+			rank, found = r, true
+		}
+	}
+	return rank, found
+}
+
+// wordIn returns true if the word is in the dictionary without substitutions
+func wordIn(word string, words map[string]int) bool {
+	_, ok := words[word]
+	return ok
+}
+
+// capitalizationBits returns the bits needed to guess the capitalization of a word, none for all lower case and
+// one for only the first or all letters upper case
+func capitalizationBits(word []rune) float64 {
+	upper := 0
+	for _, c := range word {
+		if unicode.IsUpper(c) {
+			upper++
+		}
+	}
+	switch {
+	case upper == 0:
+		return 0
+	case upper == len(word) || upper == 1 && unicode.IsUpper(word[0]):
+		return 1
+	default:
+		return float64(upper)
+	}
+}
+
+// isSequence returns true if the characters are consecutive in one of the sequences, in either direction
+func isSequence(part []rune) bool {
+	for _, seq := range sequences {
+		for _, direction := range []int{1, -1} {
+			prev := strings.IndexRune(seq, part[0])
+			found := prev >= 0
+			for _, c := range part[1:] {
+				if !found {
+					break
+				}
+				next := strings.IndexRune(seq, c)
+				found = next >= 0 && next == prev+direction
+				prev = next
+			}
+			if found {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isRepeat returns true if every character is the same
+func isRepeat(part []rune) bool {
+	for _, c := range part[1:] {
+		if c != part[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// isYear returns true for the years 1900 to 2099
+func isYear(part string) bool {
+	return (strings.HasPrefix(part, "19") || strings.HasPrefix(part, "20")) &&
+		strings.Trim(part, "0123456789") == ""
+}
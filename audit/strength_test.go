@@ -0,0 +1,34 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimate(t *testing.T) {
+	for _, tc := range []struct {
+		password string
+		max      float64
+		patterns []string
+	}{
+		{"password", 2, []string{"a common password"}},
+		{"P@ssw0rd", 5, []string{`the dictionary word "P@ssw0rd"`}},
+		{"Summer2023!", 30, []string{`the dictionary word "Summer"`, "the year 2023"}},
+		{"abcdef123456", 20, []string{`the sequence "abcdef"`, `the sequence "123456"`}},
+		{"qwertyuiop", 10, []string{`the sequence "qwertyuiop"`}},
+		{"zzzzzzzzzz", 10, []string{`the repeated character "zzzzzzzzzz"`}},
+		{"acmeacmeacme", 25, []string{`the dictionary word "acme"`, `the repeat "acme"`, `the repeat "acme"`}},
+	} {
+		strength := Estimate(tc.password, []string{"Acme"})
+		assert.True(t, strength.Entropy <= tc.max, "%s: %f", tc.password, strength.Entropy)
+		assert.Equal(t, tc.patterns, strength.Patterns, tc.password)
+	}
+
+	random := Estimate("x7#Kq9!vTz2@Lm4$", nil)
+	assert.True(t, random.Entropy > 90, random.Entropy)
+	assert.Nil(t, random.Patterns)
+	assert.Equal(t, Strength{Patterns: []string{"empty"}}, Estimate("", nil))
+	// without the extra word acme is not a word
+	assert.NotContains(t, Estimate("acme", nil).Patterns, `the dictionary word "acme"`)
+}
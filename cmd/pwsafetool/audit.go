@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tkuhlman/gopwsafe/audit"
+)
+
+// auditDB reports the breached, weak, reused, old, expired and policy violating passwords of a db
+func auditDB(args []string) int {
+	flags := newFlagSet("audit")
+	jsonOut := flags.Bool("json", false, "write the report as JSON")
+	maxAge := flags.Int("max-age", int(audit.DefaultMaxAge.Hours()/24), "days after which a password is old")
+	minEntropy := flags.Float64("min-entropy", audit.DefaultMinEntropy, "estimated bits of entropy below which a password is weak")
+	breaches := flags.String("breaches", "", "a sorted SHA-1 hash list or directory of range files of breached passwords")
+	words := flags.String("words", "", "comma separated words, such as company names, weak passwords are checked against")
+	opts := openFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 || *maxAge <= 0 || *minEntropy <= 0 {
+		flags.Usage()
+		return 2
+	}
+
+	db, _, err := openDB(flags.Arg(0), *opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	auditOpts := audit.Options{MinEntropy: *minEntropy, MaxAge: time.Duration(*maxAge) * 24 * time.Hour}
+	for _, word := range strings.Split(*words, ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			auditOpts.Dictionary = append(auditOpts.Dictionary, word)
+		}
+	}
+	if *breaches != "" {
+		list, err := audit.OpenHashList(*breaches)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer list.Close()
+		auditOpts.Breaches = list
+	}
+	report, err := audit.Audit(db, auditOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *jsonOut {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// expiring lists the records whose passwords have expired or expire within a number of days, soonest first. The exit
+// status is 1 if any have expired so it can be used in scripts.
+func expiring(args []string) int {
+	flags := newFlagSet("expiring")
+	days := flags.Int("days", 14, "list passwords expiring within this many days, 0 for only those expired")
+	opts := openFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 || *days < 0 {
+		flags.Usage()
+		return 2
+	}
+
+	_, db, err := openDB(flags.Arg(0), *opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	now := time.Now()
+	expired := 0
+	for _, title := range db.ExpiringWithin(*days) {
+		record, _ := db.GetRecord(title)
+		name := record.Title
+		if record.Group != "" {
+			name = record.Group + "." + record.Title
+		}
+		state := ""
+		if record.Expired(now) {
+			state = " (expired)"
+			expired++
+		}
+		fmt.Printf("%s %s%s\n", record.PasswordExpiry.Format("2006-01-02"), name, state)
+	}
+	if expired > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// format is a file format records can be exported to or imported from, either may be nil if unsupported
+type format struct {
+	export func(db *pwsafe.V3, w io.Writer) error
+	load   func(db *pwsafe.V3, r io.Reader) (int, error)
+}
+
+var formats = map[string]format{
+	"bitwarden": {export: (*pwsafe.V3).ExportBitwarden, load: (*pwsafe.V3).ImportBitwarden},
+	"kdbx": {
+		export: func(db *pwsafe.V3, w io.Writer) error {
+			passwd, err := readPassword("KeePass password: ")
+			if err != nil {
+				return err
+			}
+			return pwsafe.ExportKDBX(db, w, passwd, pwsafe.KDBXOptions{})
+		},
+		load: func(db *pwsafe.V3, r io.Reader) (int, error) {
+			passwd, err := readPassword("KeePass password: ")
+			if err != nil {
+				return 0, err
+			}
+			return db.ImportKDBX(r, passwd)
+		},
+	},
+	"text": {export: (*pwsafe.V3).ExportText, load: (*pwsafe.V3).ImportText},
+	"xml":  {export: (*pwsafe.V3).ExportXML, load: (*pwsafe.V3).ImportXML},
+}
+
+// formatNames returns the names of the formats supporting export, or import if export is false
+func formatNames(export bool) string {
+	var names []string
+	for name, f := range formats {
+		if (export && f.export != nil) || (!export && f.load != nil) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// openDB opens the db at path prompting for the password, returning it along with its V3 which holds the records
+func openDB(path string, opts pwsafe.OpenOptions) (pwsafe.DB, *pwsafe.V3, error) {
+	passwd, err := readPassword("Password: ")
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := pwsafe.OpenPWSafeFileContext(context.Background(), path, passwd, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	v3db, ok := pwsafe.BaseV3(db)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not a supported db", path)
+	}
+	return db, v3db, nil
+}
+
+// export writes the records of a db to a file, or stdout if it is -
+func export(args []string) int {
+	flags := newFlagSet("export")
+	name := flags.String("format", "xml", "the export format, one of "+formatNames(true))
+	opts := openFlags(flags)
+	flags.Parse(args)
+	f, ok := formats[*name]
+	if flags.NArg() != 2 || !ok || f.export == nil {
+		flags.Usage()
+		return 2
+	}
+
+	_, db, err := openDB(flags.Arg(0), *opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	out := os.Stdout
+	if flags.Arg(1) != "-" {
+		// The export is not encrypted so is only readable by the user
+		if out, err = os.OpenFile(flags.Arg(1), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	err = f.export(db, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// importRecords adds the records in a file to a db, saving it when done
+func importRecords(args []string) int {
+	flags := newFlagSet("import")
+	name := flags.String("format", "xml", "the import format, one of "+formatNames(false))
+	opts := openFlags(flags)
+	flags.Parse(args)
+	f, ok := formats[*name]
+	if flags.NArg() != 2 || !ok || f.load == nil {
+		flags.Usage()
+		return 2
+	}
+
+	file, db, err := openDB(flags.Arg(0), *opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	in, err := os.Open(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer in.Close()
+
+	n, err := f.load(db, in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := pwsafe.WritePWSafeFile(file, flags.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Printf("Imported %d records into %s\n", n, flags.Arg(0))
+	return 0
+}
+
+// importCSV adds the logins in a browser password CSV export to a db, saving it when done
+func importCSV(args []string) int {
+	flags := newFlagSet("import-csv")
+	group := flags.String("group", "", "the group to add the records to")
+	opts := openFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	file, db, err := openDB(flags.Arg(0), *opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	in, err := os.Open(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer in.Close()
+
+	report, err := db.ImportCSV(in, *group)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Print(report)
+	if err := pwsafe.WritePWSafeFile(file, flags.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
+
+// import1PUX adds the items in a 1Password 1PUX export to a db, saving it when done
+func import1PUX(args []string) int {
+	flags := newFlagSet("import-1pux")
+	opts := openFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	file, db, err := openDB(flags.Arg(0), *opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	in, err := os.Open(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer in.Close()
+
+	report, err := db.Import1PUX(in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Print(report)
+	if err := pwsafe.WritePWSafeFile(file, flags.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
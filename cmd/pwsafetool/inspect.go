@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// inspect prints the decrypted structure of a db, exiting with 1 if it could only be partially read
+func inspect(args []string) int {
+	flags := newFlagSet("inspect")
+	opts := openFlags(flags)
+	asJSON := flags.Bool("json", false, "output JSON rather than text")
+	reveal := flags.Bool("reveal", false, "show the values of all fields, by default values which may be secret are redacted")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	passwd, err := readPassword("Password: ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer f.Close()
+
+	ins, err := pwsafe.Inspect(context.Background(), f, passwd, *opts, *reveal)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(ins)
+	} else {
+		err = ins.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if ins.Error != "" {
+		return 1
+	}
+	return 0
+}
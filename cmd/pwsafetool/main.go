@@ -0,0 +1,110 @@
+// pwsafetool works with Password Safe files from the command line without needing GTK, for checking damaged files and
+// other maintenance tasks
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+
+	"golang.org/x/term"
+)
+
+// command is a pwsafetool subcommand, run is passed the arguments following the command name and returns the exit code
+type command struct {
+	run   func(args []string) int
+	usage string
+}
+
+// commands is set in init as the commands themselves refer to it for their usage
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"audit":        {run: auditDB, usage: "audit [flags] <db> - report breached, weak, reused, old, expired and policy violating passwords"},
+		"expiring":     {run: expiring, usage: "expiring [flags] <db> - list expired passwords and those expiring soon"},
+		"export":       {run: export, usage: "export [flags] <db> <file> - write the records of a db to a file, - for stdout"},
+		"import":       {run: importRecords, usage: "import [flags] <db> <file> - add the records in a file to a db"},
+		"import-1pux":  {run: import1PUX, usage: "import-1pux [flags] <db> <1pux> - add the items in a 1Password export to a db"},
+		"import-csv":   {run: importCSV, usage: "import-csv [flags] <db> <csv> - add the logins in a browser CSV export to a db"},
+		"import-share": {run: importShare, usage: "import-share [flags] <db> <bundle> - add the records of a sharing bundle, - for stdin, to a db"},
+		"inspect":      {run: inspect, usage: "inspect [flags] <db> - print every header and record field as stored"},
+		"salvage":      {run: salvage, usage: "salvage [flags] <db> <new db> - recover the readable records of a damaged db"},
+		"share":        {run: share, usage: "share [flags] <db> <title>... - write records as an encrypted sharing bundle to stdout"},
+		"share-key":    {run: shareKey, usage: "share-key - print a new key pair for receiving sharing bundles"},
+		"sheet":        {run: sheet, usage: "sheet [flags] <db> <file> - write a printable HTML emergency sheet, - for stdout"},
+		"verify":       {run: verify, usage: "verify [flags] <db> - report any damaged structures in a db"},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] [args]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+// newFlagSet returns a FlagSet for the named command whose usage also prints the command usage
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s\n", os.Args[0], commands[name].usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// openFlags adds flags for the OpenOptions limits to flags
+func openFlags(flags *flag.FlagSet) *pwsafe.OpenOptions {
+	var opts pwsafe.OpenOptions
+	flags.Func("max-iterations", "largest key stretch iteration count accepted", func(s string) error {
+		_, err := fmt.Sscan(s, &opts.MaxIterations)
+		return err
+	})
+	flags.Int64Var(&opts.MaxFileSize, "max-size", 0, "largest db size in bytes accepted")
+	return &opts
+}
+
+// stdin is shared so passwords read one per line aren't lost to buffering
+var stdin = bufio.NewReader(os.Stdin)
+
+// readPassword prompts for a password reading it from the terminal without echo, or if stdin is not a terminal reads
+// a single line
+func readPassword(prompt string) (string, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return "", errors.New("No password given on stdin")
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passwd, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(passwd), err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// shareKey prints a new key pair for receiving shared records
+func shareKey(args []string) int {
+	flags := newFlagSet("share-key")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+	public, private, err := pwsafe.GenerateShareKey(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("public key: %s\nprivate key: %s\n", pwsafe.FormatShareKey(public), pwsafe.FormatShareKey(private))
+	return 0
+}
+
+// share writes records as an armored sharing bundle to stdout
+func share(args []string) int {
+	flags := newFlagSet("share")
+	recipient := flags.String("recipient", "", "the public key to share with, otherwise a passphrase is prompted for")
+	expires := flags.Duration("expires", 7*24*time.Hour, "how long the bundle can be imported for, 0 never expires")
+	opts := openFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return 2
+	}
+
+	_, db, err := openDB(flags.Arg(0), *opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	shareOpts := pwsafe.ShareOptions{Armor: true}
+	if *expires != 0 {
+		shareOpts.Expires = time.Now().Add(*expires)
+	}
+	if *recipient != "" {
+		key, err := pwsafe.ParseShareKey(*recipient)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		shareOpts.Recipient = &key
+	} else if shareOpts.Passphrase, err = readPassword("Share passphrase: "); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := db.ExportShare(os.Stdout, flags.Args()[1:], shareOpts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// importShare adds the records of a sharing bundle to a db, saving it when done
+func importShare(args []string) int {
+	flags := newFlagSet("import-share")
+	private := flags.Bool("private", false, "prompt for the private key the bundle was shared with, not a passphrase")
+	opts := openFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	file, db, err := openDB(flags.Arg(0), *opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var shareOpts pwsafe.ShareOptions
+	if *private {
+		text, err := readPassword("Private key: ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		key, err := pwsafe.ParseShareKey(text)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		shareOpts.PrivateKey = &key
+	} else if shareOpts.Passphrase, err = readPassword("Share passphrase: "); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	// The bundle is read after the passwords as both may come from stdin
+	var in io.Reader = stdin
+	if flags.Arg(1) != "-" {
+		f, err := os.Open(flags.Arg(1))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer f.Close()
+		in = f
+	}
+
+	n, err := db.ImportShare(in, shareOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := pwsafe.WritePWSafeFile(file, flags.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Printf("Imported %d records into %s\n", n, flags.Arg(0))
+	return 0
+}
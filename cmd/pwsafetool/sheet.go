@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// sheet writes a printable HTML emergency sheet of the records in some groups to a file, or stdout if it is -
+func sheet(args []string) int {
+	flags := newFlagSet("sheet")
+	groups := flags.String("groups", "", "comma separated groups to include, all records if empty")
+	qrCodes := flags.Bool("qr", false, "add a QR code of each password")
+	opts := openFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	_, db, err := openDB(flags.Arg(0), *opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	sheetOpts := pwsafe.SheetOptions{QRCodes: *qrCodes}
+	for _, group := range strings.Split(*groups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			sheetOpts.Filter.Groups = append(sheetOpts.Filter.Groups, group)
+		}
+	}
+	out := os.Stdout
+	if flags.Arg(1) != "-" {
+		// The sheet is not encrypted so is only readable by the user
+		if out, err = os.OpenFile(flags.Arg(1), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	n, err := db.ExportSheet(out, sheetOpts)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if out != os.Stdout {
+		fmt.Printf("Wrote %d records to %s\n", n, flags.Arg(1))
+	}
+	return 0
+}
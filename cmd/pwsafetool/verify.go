@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// verify reports on every damaged structure of a db, exiting with 1 if any are found
+func verify(args []string) int {
+	flags := newFlagSet("verify")
+	opts := openFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	passwd, err := readPassword("Password: ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer f.Close()
+
+	report, err := pwsafe.Verify(context.Background(), f, passwd, *opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Print(report)
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
+
+// salvage writes every record which can be read from a damaged db to a new db with the same password, reporting on
+// what was dropped
+func salvage(args []string) int {
+	flags := newFlagSet("salvage")
+	opts := openFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	out := flags.Arg(1)
+	if _, err := os.Stat(out); err == nil {
+		fmt.Fprintf(os.Stderr, "%s already exists, not overwriting\n", out)
+		return 2
+	}
+
+	passwd, err := readPassword("Password: ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer f.Close()
+
+	db, report, err := pwsafe.Salvage(context.Background(), f, passwd, *opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Print(report)
+	if db == nil {
+		fmt.Fprintln(os.Stderr, "Nothing could be recovered")
+		return 1
+	}
+
+	// Setting the password again generates a new salt and keys for the new file
+	if err := db.SetPassword(passwd); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := pwsafe.WritePWSafeFile(db, out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Printf("Wrote %d records to %s\n", len(db.Records), out)
+	return 0
+}
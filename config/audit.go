@@ -0,0 +1,12 @@
+package config
+
+//GetBreachList returns the path of the breached password hash list used by the password audit, "" if there is none
+func (conf Config) GetBreachList() string {
+	return conf.BreachList
+}
+
+//SetBreachList Sets and saves the path of the breached password hash list
+func (conf *Config) SetBreachList(path string) error {
+	conf.BreachList = path
+	return conf.Save()
+}
@@ -0,0 +1,11 @@
+package config
+
+//GetMaxIterations returns the largest key stretch iteration count a db may use to be opened, 0 means the default
+func (conf Config) GetMaxIterations() uint32 {
+	return conf.MaxIterations
+}
+
+//GetMaxFileSize returns the largest size in bytes of a db file which will be opened, 0 means the default
+func (conf Config) GetMaxFileSize() int64 {
+	return conf.MaxFileSize
+}
@@ -12,12 +12,21 @@ import (
 type Config struct {
 	History       []string `yaml:",omitempty"`
 	HistoryLength int
+	// MaxIterations and MaxFileSize limit the dbs which will be opened, 0 uses the pwsafe package defaults
+	MaxIterations uint32 `yaml:",omitempty"`
+	MaxFileSize   int64  `yaml:",omitempty"`
+	// BreachList is the path of the local breached password hash list the password audit checks against
+	BreachList string `yaml:",omitempty"`
 }
 
 // PWSafeDBConfig An interface that defines various methods for interacting with the pwsafe configuration
 type PWSafeDBConfig interface {
 	AddToPathHistory(string) error
 	GetPathHistory() []string
+	GetMaxIterations() uint32
+	GetMaxFileSize() int64
+	GetBreachList() string
+	SetBreachList(string) error
 	Save() error
 }
 
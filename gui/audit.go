@@ -0,0 +1,157 @@
+package gui
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/tkuhlman/gopwsafe/audit"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// auditColumns are the columns of the audit findings list, the title column is used to open a record
+var auditColumns = []string{"Problem", "Group", "Title", "Detail"}
+
+// auditWindow is a dashboard of the password health of a db, a count of each kind of problem then the findings.
+// Passwords are checked for breaches if a breach list is configured. Activating a finding opens its record.
+func (app *GoPWSafeGTK) auditWindow(db pwsafe.DB) {
+	window, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	logError(err, "")
+	window.SetPosition(gtk.WIN_POS_CENTER)
+	window.SetTitle("Password Audit of " + db.GetName())
+
+	summary, err := gtk.GridNew()
+	logError(err, "")
+	summary.SetColumnSpacing(10)
+	counts := make(map[audit.Kind]*gtk.Label)
+	for i, kind := range audit.Kinds {
+		label, err := gtk.LabelNew(kind.Description())
+		logError(err, "")
+		label.SetHAlign(gtk.ALIGN_START)
+		count, err := gtk.LabelNew("")
+		logError(err, "")
+		summary.Attach(label, 0, i, 1, 1)
+		summary.Attach(count, 1, i, 1, 1)
+		counts[kind] = count
+	}
+	recordsLabel, err := gtk.LabelNew("")
+	logError(err, "")
+
+	breachLabel, err := gtk.LabelNew("Breached password hash list")
+	logError(err, "")
+	breachValue, err := gtk.EntryNew()
+	logError(err, "")
+	breachValue.SetHExpand(true)
+	breachValue.SetPlaceholderText("A sorted SHA-1 hash list file or directory of range files")
+	breachValue.SetText(app.conf.GetBreachList())
+
+	findingsFrame, err := gtk.FrameNew("Findings")
+	logError(err, "")
+	findingsWin, err := gtk.ScrolledWindowNew(nil, nil)
+	logError(err, "")
+	findingsWin.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	findingsFrame.Add(findingsWin)
+	findingsStore, err := gtk.ListStoreNew(glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING)
+	logError(err, "")
+	findingsTree, err := gtk.TreeViewNewWithModel(findingsStore)
+	logError(err, "")
+	for i, name := range auditColumns {
+		cell, err := gtk.CellRendererTextNew()
+		logError(err, "")
+		column, err := gtk.TreeViewColumnNewWithAttribute(name, cell, "text", i)
+		logError(err, "")
+		column.SetResizable(true)
+		column.SetSortColumnID(i)
+		findingsTree.AppendColumn(column)
+	}
+	findingsWin.Add(findingsTree)
+
+	// update runs the audit again, showing the latest changes to the db
+	update := func() {
+		var opts audit.Options
+		path, err := breachValue.GetText()
+		logError(err, "")
+		if path != app.conf.GetBreachList() {
+			logError(app.conf.SetBreachList(path), "Failed to save the breach list path")
+		}
+		if path != "" {
+			list, err := audit.OpenHashList(path)
+			if err != nil {
+				app.errorDialog(fmt.Sprintf("Error opening the breached password hash list\n%s", err))
+				return
+			}
+			defer list.Close()
+			opts.Breaches = list
+		}
+		report, err := audit.Audit(db, opts)
+		if err != nil {
+			app.errorDialog(fmt.Sprintf("Error auditing passwords\n%s", err))
+			return
+		}
+		recordsLabel.SetText(fmt.Sprintf("%d records audited", report.Records))
+		for _, kind := range audit.Kinds {
+			counts[kind].SetText(strconv.Itoa(report.Count(kind)))
+		}
+		if n := report.Count(audit.Breached); n > 0 {
+			counts[audit.Breached].SetMarkup(fmt.Sprintf(`<span foreground="red"><b>%d</b></span>`, n))
+		}
+		findingsStore.Clear()
+		for _, finding := range report.Findings {
+			err := findingsStore.Set(findingsStore.Append(), []int{0, 1, 2, 3},
+				[]interface{}{finding.Kind.Description(), finding.Group, finding.Title, finding.Detail})
+			logError(err, "")
+		}
+	}
+	update()
+	breachValue.Connect("activate", update)
+
+	findingsTree.Connect("row_activated", func() {
+		selection, err := findingsTree.GetSelection()
+		logError(err, "")
+		_, iter, ok := selection.GetSelected()
+		if !ok {
+			return
+		}
+		value, err := findingsStore.GetValue(iter, 2)
+		logError(err, "")
+		title, err := value.GetString()
+		logError(err, "")
+		if record, ok := db.GetRecord(title); ok {
+			app.recordWindow(db, &record)
+		}
+	})
+
+	refreshButton, err := gtk.ButtonNewWithLabel("Refresh")
+	logError(err, "")
+	refreshButton.Connect("clicked", update)
+	closeButton, err := gtk.ButtonNewWithLabel("Close")
+	logError(err, "")
+	closeButton.Connect("clicked", func() {
+		window.Destroy()
+	})
+
+	window.Connect("destroy", window.Close)
+
+	//layout
+	vbox, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 1)
+	logError(err, "")
+	breachBox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 2)
+	logError(err, "")
+	breachBox.PackStart(breachLabel, false, false, 0)
+	breachBox.PackStart(breachValue, true, true, 0)
+	vbox.PackStart(breachBox, false, false, 1)
+	vbox.PackStart(recordsLabel, false, false, 1)
+	vbox.PackStart(summary, false, false, 1)
+	vbox.PackStart(findingsFrame, true, true, 0)
+
+	hbox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 1)
+	logError(err, "")
+	hbox.Add(refreshButton)
+	hbox.Add(closeButton)
+	vbox.PackStart(hbox, false, false, 0)
+
+	window.Add(vbox)
+	window.SetDefaultSize(800, 600)
+	window.ShowAll()
+}
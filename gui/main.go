@@ -5,6 +5,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/skratchdot/open-golang/open"
 	"github.com/tkuhlman/gopwsafe/config"
@@ -123,9 +124,11 @@ func (app *GoPWSafeGTK) mainWindow() *gtk.Window {
 	logError(err, "")
 	col2, err := gtk.TreeViewColumnNewWithAttribute("Name", cellText, "text", 1)
 	logError(err, "")
+	// expired records are highlighted with the text color in column 2
+	col2.AddAttribute(cellText, "foreground", 2)
 	app.recordTree.AppendColumn(col2)
 
-	app.recordStore, err = gtk.TreeStoreNew(glib.TYPE_OBJECT, glib.TYPE_STRING)
+	app.recordStore, err = gtk.TreeStoreNew(glib.TYPE_OBJECT, glib.TYPE_STRING, glib.TYPE_STRING)
 	logError(err, "")
 	app.recordTree.SetModel(app.recordStore)
 
@@ -247,18 +250,24 @@ func (app *GoPWSafeGTK) updateRecords(search string) {
 	iconErrors = append(iconErrors, err)
 	recordIcon, err := icons.LoadIcon("text-x-generic", 16, gtk.ICON_LOOKUP_FORCE_SIZE)
 	iconErrors = append(iconErrors, err)
+	expiredIcon, err := icons.LoadIcon("dialog-warning", 16, gtk.ICON_LOOKUP_FORCE_SIZE)
+	iconErrors = append(iconErrors, err)
 	for _, e := range iconErrors {
 		if e != nil {
 			log.Print(e)
 		}
 	}
 
+	now := time.Now()
 	app.recordStore.Clear()
 	for i, db := range app.dbs {
 		name := db.GetName()
 		if name == "" {
 			name = strconv.Itoa(i)
 		}
+		if db.ReadOnly() {
+			name += " (read-only)"
+		}
 		dbRoot := app.recordStore.Append(nil)
 		err := app.recordStore.SetValue(dbRoot, 0, rootIcon)
 		logError(err, "")
@@ -282,8 +291,15 @@ func (app *GoPWSafeGTK) updateRecords(search string) {
 
 				for _, recordName := range matches {
 					record := app.recordStore.Append(group)
-					err := app.recordStore.SetValue(record, 0, recordIcon)
-					logError(err, "")
+					if values, _ := db.GetRecord(recordName); values.Expired(now) {
+						err := app.recordStore.SetValue(record, 0, expiredIcon)
+						logError(err, "")
+						err = app.recordStore.SetValue(record, 2, "red")
+						logError(err, "")
+					} else {
+						err := app.recordStore.SetValue(record, 0, recordIcon)
+						logError(err, "")
+					}
 					err = app.recordStore.SetValue(record, 1, recordName)
 					logError(err, "")
 				}
@@ -340,6 +356,78 @@ func (app *GoPWSafeGTK) mainMenuBar() *gtk.MenuBar {
 	})
 	dbMenu.Append(newDB)
 
+	exportSubset, err := gtk.MenuItemNewWithLabel("Export Subset")
+	logError(err, "")
+	exportSubset.Connect("activate", func() {
+		db, record := app.getSelectedRecord()
+		if db != nil {
+			app.subsetWindow(db, record)
+		} else {
+			app.errorDialog("No DB is selected, please select a DB in the tree view to export from")
+		}
+	})
+	dbMenu.Append(exportSubset)
+
+	emergencySheet, err := gtk.MenuItemNewWithLabel("Emergency Sheet")
+	logError(err, "")
+	emergencySheet.Connect("activate", func() {
+		db, record := app.getSelectedRecord()
+		if db != nil {
+			app.sheetWindow(db, record)
+		} else {
+			app.errorDialog("No DB is selected, please select a DB in the tree view to export from")
+		}
+	})
+	dbMenu.Append(emergencySheet)
+
+	auditDB, err := gtk.MenuItemNewWithLabel("Password Audit")
+	logError(err, "")
+	auditDB.Connect("activate", func() {
+		db, _ := app.getSelectedRecord()
+		if db != nil {
+			app.auditWindow(db)
+		} else {
+			app.errorDialog("No DB is selected, please select a DB in the tree view to audit")
+		}
+	})
+	dbMenu.Append(auditDB)
+
+	rotatePasswords, err := gtk.MenuItemNewWithLabel("Rotate Passwords")
+	logError(err, "")
+	rotatePasswords.Connect("activate", func() {
+		db, record := app.getSelectedRecord()
+		if db != nil {
+			app.rotationWindow(db, record)
+		} else {
+			app.errorDialog("No DB is selected, please select a DB in the tree view to rotate passwords in")
+		}
+	})
+	dbMenu.Append(rotatePasswords)
+
+	shareRecord, err := gtk.MenuItemNewWithLabel("Share Record")
+	logError(err, "")
+	shareRecord.Connect("activate", func() {
+		db, record := app.getSelectedRecord()
+		if record != nil {
+			app.shareWindow(db, record)
+		} else {
+			app.errorDialog("No record is selected, please select a record in the tree view to share")
+		}
+	})
+	dbMenu.Append(shareRecord)
+
+	importShare, err := gtk.MenuItemNewWithLabel("Import Share")
+	logError(err, "")
+	importShare.Connect("activate", func() {
+		db, _ := app.getSelectedRecord()
+		if db != nil {
+			app.importShareWindow(db)
+		} else {
+			app.errorDialog("No DB is selected, please select a DB in the tree view to import into")
+		}
+	})
+	dbMenu.Append(importShare)
+
 	newRecord, err := gtk.MenuItemNewWithLabel("New Record")
 	logError(err, "")
 	newRecord.Connect("activate", func() {
@@ -357,7 +445,9 @@ func (app *GoPWSafeGTK) mainMenuBar() *gtk.MenuBar {
 			app.errorDialog("Error retrieving record.")
 		}
 		app.recordWindow(db, &pwsafe.Record{})
-		db.DeleteRecord(record.Title)
+		if err := db.DeleteRecord(record.Title); err != nil {
+			app.errorDialog(fmt.Sprintf("Error deleting record %q\n%s", record.Title, err))
+		}
 	})
 	dbMenu.Append(deleteRecord)
 
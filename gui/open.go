@@ -1,25 +1,58 @@
 package gui
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/gotk3/gotk3/glib"
 	"github.com/gotk3/gotk3/gtk"
 	"github.com/tkuhlman/gopwsafe/pwsafe"
 )
 
-func (app *GoPWSafeGTK) openDB(path string, password string) bool {
+// unlockTime is the target time to unlock a db used when calibrating key stretch iterations
+const unlockTime = time.Second
+
+// openDB decrypts the db at path in the background keeping the gui responsive and reporting key stretching progress
+// to the progress bar. done is called from the gtk main loop with true if the db was opened. A db opened readOnly
+// can't be modified or saved.
+func (app *GoPWSafeGTK) openDB(ctx context.Context, path string, password string, readOnly bool, progress *gtk.ProgressBar, done func(bool)) {
 
 	for _, db := range app.dbs {
-		v3db, ok := db.(*pwsafe.V3)
+		v3db, ok := pwsafe.BaseV3(db)
 		if !ok {
 			continue
 		}
 		if path == v3db.LastSavePath {
 			app.errorDialog(fmt.Sprintf("A password database at path %q is already open", path))
-			return false
+			done(false)
+			return
 		}
 	}
-	db, err := pwsafe.OpenPWSafeFile(path, password)
+	opts := pwsafe.OpenOptions{
+		MaxIterations: app.conf.GetMaxIterations(),
+		MaxFileSize:   app.conf.GetMaxFileSize(),
+		Progress: func(stretched, total uint32) {
+			glib.IdleAdd(func() {
+				progress.SetFraction(float64(stretched) / float64(total))
+			})
+		},
+		ReadOnly: readOnly,
+	}
+	go func() {
+		db, err := pwsafe.OpenPWSafeFileContext(ctx, path, password, opts)
+		glib.IdleAdd(func() {
+			done(app.addDB(db, path, password, err))
+		})
+	}()
+}
+
+// addDB finishes opening a db adding it to the history and tree view, if err is set it is reported instead
+func (app *GoPWSafeGTK) addDB(db pwsafe.DB, path string, password string, err error) bool {
+	if err == context.Canceled {
+		return false
+	}
 	if err != nil {
 		app.errorDialog(fmt.Sprintf("Error Opening file %s\n%s", path, err))
 		return false
@@ -29,11 +62,70 @@ func (app *GoPWSafeGTK) openDB(path string, password string) bool {
 		app.errorDialog(fmt.Sprintf("Error adding %s to History\n%s", path, err))
 		return false
 	}
+	if v3db, ok := pwsafe.BaseV3(db); ok && v3db.LegacyVersion() != 0 && !db.ReadOnly() {
+		app.errorDialog(fmt.Sprintf("%s is a Password Safe V%d file, it will be saved in the V3 format which older clients can't open.",
+			path, v3db.LegacyVersion()))
+	}
+	app.upgradeIterations(db, password)
 	app.dbs = append(app.dbs, db)
 	app.updateRecords("")
+	app.warnExpired(db)
 	return true
 }
 
+// warnExpired lists the records of a newly opened db whose passwords have expired
+func (app *GoPWSafeGTK) warnExpired(db pwsafe.DB) {
+	v3db, ok := pwsafe.BaseV3(db)
+	if !ok {
+		return
+	}
+	expired := v3db.ExpiringWithin(0)
+	if len(expired) == 0 {
+		return
+	}
+	const maxListed = 10
+	listed := expired
+	if len(listed) > maxListed {
+		listed = listed[:maxListed]
+	}
+	msg := fmt.Sprintf("%d records in %s have expired passwords which should be changed:\n%s",
+		len(expired), db.GetName(), strings.Join(listed, "\n"))
+	if len(expired) > maxListed {
+		msg += fmt.Sprintf("\nand %d more", len(expired)-maxListed)
+	}
+	app.errorDialog(msg)
+}
+
+// upgradeIterations offers to raise the key stretch iterations of a db using less than the default
+func (app *GoPWSafeGTK) upgradeIterations(db pwsafe.DB, password string) {
+	v3db, ok := pwsafe.BaseV3(db)
+	if !ok || db.ReadOnly() || v3db.Iter >= pwsafe.DefaultIterations {
+		return
+	}
+	// Setting the password of a V4 db replaces all of its passwords with the one it was opened with
+	if v4db, ok := db.(*pwsafe.V4); ok && v4db.Passwords() > 1 {
+		return
+	}
+	iter := pwsafe.CalibrateIterations(unlockTime)
+	if iter < pwsafe.DefaultIterations {
+		iter = pwsafe.DefaultIterations
+	}
+	msg := fmt.Sprintf("%s uses only %d key stretch iterations which is easy to brute force.\nUpgrade to %d iterations? The change is written on next save.",
+		db.GetName(), v3db.Iter, iter)
+	if !app.confirmDialog(msg) {
+		return
+	}
+	if err := v3db.SetIterations(iter); err != nil {
+		app.errorDialog(fmt.Sprintf("Error Updating iterations\n%s", err))
+		return
+	}
+	// The db method is used as a V4 db derives its keys differently
+	if err := db.SetPassword(password); err != nil {
+		// the db keeps its old key and iterations
+		app.errorDialog(fmt.Sprintf("Error Updating password\n%s", err))
+	}
+}
+
 func (app *GoPWSafeGTK) openWindow(dbFile string) {
 	window, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
 	logError(err, "")
@@ -41,7 +133,13 @@ func (app *GoPWSafeGTK) openWindow(dbFile string) {
 	window.SetTitle("GoPWSafe")
 	window.AddAccelGroup(app.accelGroup)
 
+	// cancel is set while a db is being opened
+	var cancel context.CancelFunc
+
 	window.Connect("destroy", func() {
+		if cancel != nil {
+			cancel()
+		}
 		window.Close()
 		app.GetWindowByID(app.mainWindowID).ShowAll()
 	})
@@ -82,20 +180,49 @@ func (app *GoPWSafeGTK) openWindow(dbFile string) {
 	passwordBox, err := gtk.EntryNew()
 	logError(err, "")
 	passwordBox.SetVisibility(false)
+
+	readOnlyCheck, err := gtk.CheckButtonNewWithLabel("Read-only")
+	logError(err, "")
+
+	progressBar, err := gtk.ProgressBarNew()
+	logError(err, "")
+
+	openButton, err := gtk.ButtonNewWithLabel("Open")
+	logError(err, "")
+	cancelButton, err := gtk.ButtonNewWithLabel("Cancel")
+	logError(err, "")
+	cancelButton.SetSensitive(false)
+
 	// Pressing enter in the password box opens the db
 	dbDecrypt := func() {
+		if cancel != nil {
+			return
+		}
 		text, err := passwordBox.GetText()
 		logError(err, "")
-		app.openDB(pathBox.GetActiveText(), text)
-		window.Close()
-		app.GetWindowByID(app.mainWindowID).ShowAll()
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		openButton.SetSensitive(false)
+		cancelButton.SetSensitive(true)
+		app.openDB(ctx, pathBox.GetActiveText(), text, readOnlyCheck.GetActive(), progressBar, func(opened bool) {
+			cancel()
+			cancel = nil
+			openButton.SetSensitive(true)
+			cancelButton.SetSensitive(false)
+			progressBar.SetFraction(0)
+			if opened {
+				window.Close()
+				app.GetWindowByID(app.mainWindowID).ShowAll()
+			}
+		})
 	}
 	passwordBox.Connect("activate", dbDecrypt)
-
-	openButton, err := gtk.ButtonNewWithLabel("Open")
-	logError(err, "")
-
 	openButton.Connect("clicked", dbDecrypt)
+	cancelButton.Connect("clicked", func() {
+		if cancel != nil {
+			cancel()
+		}
+	})
 
 	//layout
 	vbox, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 1)
@@ -105,7 +232,13 @@ func (app *GoPWSafeGTK) openWindow(dbFile string) {
 	vbox.Add(pathBox)
 	vbox.Add(passwdLabel)
 	vbox.Add(passwordBox)
-	vbox.Add(openButton)
+	vbox.Add(readOnlyCheck)
+	vbox.Add(progressBar)
+	hbox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 1)
+	logError(err, "")
+	hbox.Add(openButton)
+	hbox.Add(cancelButton)
+	vbox.Add(hbox)
 	window.Add(vbox)
 	window.SetSizeRequest(500, 150)
 
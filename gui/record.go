@@ -1,6 +1,7 @@
 package gui
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/gotk3/gotk3/gdk"
@@ -56,6 +57,27 @@ func (app *GoPWSafeGTK) recordWindow(db pwsafe.DB, record *pwsafe.Record) {
 		passwordValue.SetVisibility(!passwordValue.GetVisibility())
 	})
 
+	expiry, err := gtk.LabelNew("Password Expires")
+	logError(err, "")
+	expiryValue, err := gtk.LabelNew("Never")
+	logError(err, "")
+	expiryValue.SetHAlign(gtk.ALIGN_START)
+	wasExpired := record.Expired(time.Now())
+	if !record.PasswordExpiry.IsZero() {
+		expiryValue.SetText(record.PasswordExpiry.Format(time.UnixDate))
+	}
+	if wasExpired {
+		expiryValue.SetMarkup(fmt.Sprintf(`<span foreground="red">%s, expired</span>`,
+			record.PasswordExpiry.Format(time.UnixDate)))
+	}
+	interval, err := gtk.LabelNew("Expires Every")
+	logError(err, "")
+	intervalValue, err := gtk.SpinButtonNewWithRange(0, pwsafe.MaxExpiryInterval, 1)
+	logError(err, "")
+	intervalValue.SetValue(float64(record.ExpiryInterval()))
+	intervalDays, err := gtk.LabelNew("days, 0 for never")
+	logError(err, "")
+
 	modTime, err := gtk.LabelNew("Last Modification")
 	logError(err, "")
 	modValue, err := gtk.LabelNew(record.ModTime.Format(time.UnixDate))
@@ -95,13 +117,27 @@ func (app *GoPWSafeGTK) recordWindow(db pwsafe.DB, record *pwsafe.Record) {
 		end := buffer.GetEndIter()
 		record.Notes, err = buffer.GetText(start, end, true)
 		logError(err, "")
+		if err := record.SetExpiryInterval(intervalValue.GetValueAsInt()); err != nil {
+			app.errorDialog(err.Error())
+			return
+		}
 
 		// Update the record
 		if origName != record.Title { // The Record title has changed
-			db.DeleteRecord(origName)
+			if err := db.DeleteRecord(origName); err != nil {
+				app.errorDialog(fmt.Sprintf("Error renaming record %q\n%s", origName, err))
+				return
+			}
+			app.updateRecords("")
+		}
+		if err := db.SetRecord(*record); err != nil {
+			app.errorDialog(fmt.Sprintf("Error updating record %q\n%s", record.Title, err))
+			return
+		}
+		// A new password or interval may change whether the record is highlighted as expired
+		if stored, ok := db.GetRecord(record.Title); ok && stored.Expired(time.Now()) != wasExpired {
 			app.updateRecords("")
 		}
-		db.SetRecord(*record)
 		window.Destroy()
 	})
 	cancelButton, err := gtk.ButtonNewWithLabel("Cancel")
@@ -110,6 +146,17 @@ func (app *GoPWSafeGTK) recordWindow(db pwsafe.DB, record *pwsafe.Record) {
 		window.Destroy()
 	})
 
+	// A read-only db can be viewed and copied from but not edited
+	if db.ReadOnly() {
+		window.SetTitle(record.Title + " (read-only)")
+		for _, entry := range []*gtk.Entry{titleValue, groupValue, userValue, urlValue, passwordValue} {
+			entry.SetEditable(false)
+		}
+		textView.SetEditable(false)
+		intervalValue.SetSensitive(false)
+		okayButton.SetSensitive(false)
+	}
+
 	//layout
 	vbox, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 1)
 	logError(err, "")
@@ -136,8 +183,15 @@ func (app *GoPWSafeGTK) recordWindow(db pwsafe.DB, record *pwsafe.Record) {
 	grid.Attach(passwordValue, 1, 4, 1, 1)
 	grid.Attach(showPassword, 2, 4, 1, 1)
 
-	grid.Attach(modTime, 0, 5, 1, 1)
-	grid.Attach(modValue, 1, 5, 2, 1)
+	grid.Attach(expiry, 0, 5, 1, 1)
+	grid.Attach(expiryValue, 1, 5, 2, 1)
+
+	grid.Attach(interval, 0, 6, 1, 1)
+	grid.Attach(intervalValue, 1, 6, 1, 1)
+	grid.Attach(intervalDays, 2, 6, 1, 1)
+
+	grid.Attach(modTime, 0, 7, 1, 1)
+	grid.Attach(modValue, 1, 7, 2, 1)
 
 	vbox.PackStart(notesFrame, true, true, 0)
 	hbox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 1)
@@ -0,0 +1,271 @@
+package gui
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// rotationColumns are the text columns of the rotation checklist, following the done column
+var rotationColumns = []string{"Group", "Title", "URL", "Username"}
+
+// rotationWindow rotates the passwords of the records in some groups or matching a search. New passwords are generated
+// from each record's policy, each is copied and changed on its system then marked done, and the done passwords are
+// committed to the db with the old ones kept in the password history.
+func (app *GoPWSafeGTK) rotationWindow(db pwsafe.DB, record *pwsafe.Record) {
+	window, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	logError(err, "")
+	window.SetPosition(gtk.WIN_POS_CENTER)
+	window.SetTitle("Rotate Passwords of " + db.GetName())
+
+	v3db, ok := pwsafe.BaseV3(db)
+	if !ok {
+		log.Fatalf("Failed to cast Password DB %q as a V3 password safe", db.GetName())
+	}
+	var rotation pwsafe.Rotation
+
+	groupsLabel, err := gtk.LabelNew("Groups, comma separated")
+	logError(err, "")
+	groupsValue, err := gtk.EntryNew()
+	logError(err, "")
+	groupsValue.SetHExpand(true)
+	if record != nil {
+		groupsValue.SetText(record.Group)
+	}
+	searchLabel, err := gtk.LabelNew("Search title, username or URL")
+	logError(err, "")
+	searchValue, err := gtk.EntryNew()
+	logError(err, "")
+	searchValue.SetHExpand(true)
+
+	itemsFrame, err := gtk.FrameNew("Checklist")
+	logError(err, "")
+	itemsWin, err := gtk.ScrolledWindowNew(nil, nil)
+	logError(err, "")
+	itemsWin.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	itemsFrame.Add(itemsWin)
+	itemsStore, err := gtk.ListStoreNew(glib.TYPE_BOOLEAN, glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING,
+		glib.TYPE_STRING)
+	logError(err, "")
+	itemsTree, err := gtk.TreeViewNewWithModel(itemsStore)
+	logError(err, "")
+	doneCell, err := gtk.CellRendererToggleNew()
+	logError(err, "")
+	doneColumn, err := gtk.TreeViewColumnNewWithAttribute("Done", doneCell, "active", 0)
+	logError(err, "")
+	itemsTree.AppendColumn(doneColumn)
+	for i, name := range rotationColumns {
+		cell, err := gtk.CellRendererTextNew()
+		logError(err, "")
+		column, err := gtk.TreeViewColumnNewWithAttribute(name, cell, "text", i+1)
+		logError(err, "")
+		column.SetResizable(true)
+		itemsTree.AppendColumn(column)
+	}
+	itemsWin.Add(itemsTree)
+
+	statusLabel, err := gtk.LabelNew("")
+	logError(err, "")
+	// updateStatus shows how many items are done
+	updateStatus := func() {
+		statusLabel.SetText(fmt.Sprintf("%d of %d done", rotation.Done(), len(rotation.Items)))
+	}
+
+	// The store rows are in the same order as the rotation items so the row path is the item index
+	doneCell.Connect("toggled", func(cell *gtk.CellRendererToggle, path string) {
+		i, err := strconv.Atoi(path)
+		if err != nil || i >= len(rotation.Items) {
+			return
+		}
+		rotation.Items[i].Done = !rotation.Items[i].Done
+		iter, err := itemsStore.GetIterFromString(path)
+		logError(err, "")
+		logError(itemsStore.SetValue(iter, 0, rotation.Items[i].Done), "")
+		updateStatus()
+	})
+
+	planButton, err := gtk.ButtonNewWithLabel("Generate Passwords")
+	logError(err, "")
+	planButton.Connect("clicked", func() {
+		if rotation.Done() > 0 && !app.confirmDialog("Discard the passwords already marked done?") {
+			return
+		}
+		var filter pwsafe.RecordFilter
+		groups, err := groupsValue.GetText()
+		logError(err, "")
+		for _, group := range strings.Split(groups, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				filter.Groups = append(filter.Groups, group)
+			}
+		}
+		filter.Query, err = searchValue.GetText()
+		logError(err, "")
+		planned, err := v3db.PlanRotation(filter)
+		if err != nil {
+			app.errorDialog(fmt.Sprintf("Error generating passwords\n%s", err))
+			return
+		}
+		rotation = planned
+		itemsStore.Clear()
+		for _, item := range rotation.Items {
+			err := itemsStore.Set(itemsStore.Append(), []int{0, 1, 2, 3, 4},
+				[]interface{}{item.Done, item.Group, item.Title, item.URL, item.Username})
+			logError(err, "")
+		}
+		updateStatus()
+	})
+
+	// copyPassword copies the new password of the selected item to the clipboard
+	clipboard, err := gtk.ClipboardGet(gdk.SELECTION_CLIPBOARD)
+	logError(err, "")
+	copyPassword := func() {
+		selection, err := itemsTree.GetSelection()
+		logError(err, "")
+		_, iter, ok := selection.GetSelected()
+		if !ok {
+			app.errorDialog("No item is selected")
+			return
+		}
+		path, err := itemsStore.GetPath(iter)
+		logError(err, "")
+		if i := path.GetIndices()[0]; i < len(rotation.Items) {
+			clipboard.SetText(rotation.Items[i].Password)
+		}
+	}
+	itemsTree.Connect("row_activated", copyPassword)
+	copyButton, err := gtk.ButtonNewWithLabel("Copy New Password")
+	logError(err, "")
+	copyButton.Connect("clicked", copyPassword)
+
+	checklistPath, err := gtk.LabelNew("Checklist path")
+	logError(err, "")
+	checklistPathValue, err := gtk.EntryNew()
+	logError(err, "")
+	checklistPathValue.SetHExpand(true)
+	checklistButton, err := gtk.ButtonNewWithLabel("Save Checklist")
+	logError(err, "")
+	checklistButton.Connect("clicked", func() {
+		path, err := checklistPathValue.GetText()
+		logError(err, "")
+		if path == "" {
+			app.errorDialog("A checklist path is required")
+			return
+		}
+		if len(rotation.Items) == 0 {
+			app.errorDialog("Generate passwords before saving the checklist")
+			return
+		}
+		out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			app.errorDialog(fmt.Sprintf("Error Writing the checklist\n%s", err))
+			return
+		}
+		err = rotation.WriteChecklist(out)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+			app.errorDialog(fmt.Sprintf("Error Writing the checklist\n%s", err))
+			return
+		}
+		app.errorDialog(fmt.Sprintf("Wrote a checklist of %d systems to %s", len(rotation.Items), path))
+	})
+
+	// commit sets the passwords marked done in the db, returning false if it failed
+	commit := func() bool {
+		n, err := v3db.CommitRotation(rotation)
+		if err != nil {
+			app.errorDialog(fmt.Sprintf("Error committing the new passwords\n%s", err))
+			return false
+		}
+		rotation = pwsafe.Rotation{}
+		app.updateRecords("")
+		app.errorDialog(fmt.Sprintf("Changed %d passwords, save the db to keep them", n))
+		return true
+	}
+	commitButton, err := gtk.ButtonNewWithLabel("Commit Done")
+	logError(err, "")
+	commitButton.SetSensitive(!db.ReadOnly())
+	commitButton.Connect("clicked", func() {
+		done := rotation.Done()
+		if done == 0 {
+			app.errorDialog("No items are marked done")
+			return
+		}
+		if done < len(rotation.Items) && !app.confirmDialog(fmt.Sprintf(
+			"Only %d of %d items are done, the others keep their passwords. Continue?", done, len(rotation.Items))) {
+			return
+		}
+		if commit() {
+			window.Destroy()
+		}
+	})
+
+	// canClose offers to commit the items marked done, as their passwords may already be set on their systems, before
+	// the window is closed. It returns false to keep the window open.
+	canClose := func() bool {
+		done := rotation.Done()
+		if done == 0 {
+			return true
+		}
+		if !db.ReadOnly() && app.confirmDialog(fmt.Sprintf("Commit the %d passwords marked done before closing?", done)) {
+			return commit()
+		}
+		return app.confirmDialog("Discard the passwords marked done without committing them?")
+	}
+	closeButton, err := gtk.ButtonNewWithLabel("Close")
+	logError(err, "")
+	closeButton.Connect("clicked", func() {
+		if canClose() {
+			window.Destroy()
+		}
+	})
+
+	// Closing from the title bar asks the same as the close button
+	window.Connect("delete-event", func(win *gtk.Window, event *gdk.Event) bool {
+		return !canClose()
+	})
+	window.Connect("destroy", window.Close)
+
+	//layout
+	vbox, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 1)
+	logError(err, "")
+
+	grid, err := gtk.GridNew()
+	logError(err, "")
+	grid.SetColumnSpacing(2)
+	grid.Attach(groupsLabel, 0, 0, 1, 1)
+	grid.Attach(groupsValue, 1, 0, 1, 1)
+	grid.Attach(searchLabel, 0, 1, 1, 1)
+	grid.Attach(searchValue, 1, 1, 1, 1)
+	grid.Attach(planButton, 2, 0, 1, 2)
+	vbox.PackStart(grid, false, false, 1)
+	vbox.PackStart(itemsFrame, true, true, 0)
+	vbox.PackStart(statusLabel, false, false, 1)
+
+	checklistBox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 2)
+	logError(err, "")
+	checklistBox.PackStart(checklistPath, false, false, 0)
+	checklistBox.PackStart(checklistPathValue, true, true, 0)
+	checklistBox.PackStart(checklistButton, false, false, 0)
+	vbox.PackStart(checklistBox, false, false, 1)
+
+	hbox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 1)
+	logError(err, "")
+	hbox.Add(copyButton)
+	hbox.Add(commitButton)
+	hbox.Add(closeButton)
+	vbox.PackStart(hbox, false, false, 0)
+
+	window.Add(vbox)
+	window.SetDefaultSize(800, 600)
+	window.ShowAll()
+}
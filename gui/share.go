@@ -0,0 +1,255 @@
+package gui
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/gtk"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// shareWindow encrypts a record as an armored sharing bundle for a passphrase or public key, showing the text to copy
+func (app *GoPWSafeGTK) shareWindow(db pwsafe.DB, record *pwsafe.Record) {
+	window, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	logError(err, "")
+	window.SetPosition(gtk.WIN_POS_CENTER)
+	window.SetTitle("Share " + record.Title)
+
+	v3db, ok := pwsafe.BaseV3(db)
+	if !ok {
+		log.Fatalf("Failed to cast Password DB %q as a V3 password safe", db.GetName())
+	}
+
+	recipientLabel, err := gtk.LabelNew("Recipient public key")
+	logError(err, "")
+	recipientValue, err := gtk.EntryNew()
+	logError(err, "")
+	recipientValue.SetHExpand(true)
+
+	passphraseLabel, err := gtk.LabelNew("Or passphrase")
+	logError(err, "")
+	passphraseValue, err := gtk.EntryNew()
+	logError(err, "")
+	passphraseValue.SetVisibility(false)
+	passphraseValue.SetHExpand(true)
+
+	expiresLabel, err := gtk.LabelNew("Expires after days, 0 never")
+	logError(err, "")
+	expiresValue, err := gtk.EntryNew()
+	logError(err, "")
+	expiresValue.SetText("7")
+	expiresValue.SetHExpand(true)
+
+	bundleFrame, err := gtk.FrameNew("Sharing bundle")
+	logError(err, "")
+	bundleWin, err := gtk.ScrolledWindowNew(nil, nil)
+	logError(err, "")
+	bundleWin.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	bundleView, err := gtk.TextViewNew()
+	logError(err, "")
+	bundleView.SetEditable(false)
+	bundleView.SetMonospace(true)
+	buffer, err := bundleView.GetBuffer()
+	logError(err, "")
+	bundleWin.Add(bundleView)
+	bundleFrame.Add(bundleWin)
+
+	clipboard, err := gtk.ClipboardGet(gdk.SELECTION_CLIPBOARD)
+	logError(err, "")
+	copyButton, err := gtk.ButtonNewWithLabel("Copy")
+	logError(err, "")
+	copyButton.SetSensitive(false)
+	copyButton.Connect("clicked", func() {
+		text, err := buffer.GetText(buffer.GetStartIter(), buffer.GetEndIter(), true)
+		logError(err, "")
+		clipboard.SetText(text)
+	})
+
+	shareButton, err := gtk.ButtonNewWithLabel("Share")
+	logError(err, "")
+	shareButton.Connect("clicked", func() {
+		opts := pwsafe.ShareOptions{Armor: true}
+		recipient, err := recipientValue.GetText()
+		logError(err, "")
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			key, err := pwsafe.ParseShareKey(recipient)
+			if err != nil {
+				app.errorDialog(fmt.Sprintf("Invalid recipient public key\n%s", err))
+				return
+			}
+			opts.Recipient = &key
+		} else {
+			opts.Passphrase, err = passphraseValue.GetText()
+			logError(err, "")
+		}
+		daysText, err := expiresValue.GetText()
+		logError(err, "")
+		days, err := strconv.Atoi(strings.TrimSpace(daysText))
+		if err != nil || days < 0 {
+			app.errorDialog(fmt.Sprintf("Invalid number of days %q", daysText))
+			return
+		}
+		if days > 0 {
+			opts.Expires = time.Now().AddDate(0, 0, days)
+		}
+
+		var bundle bytes.Buffer
+		if err := v3db.ExportShare(&bundle, []string{record.Title}, opts); err != nil {
+			app.errorDialog(fmt.Sprintf("Error Sharing record\n%s", err))
+			return
+		}
+		buffer.SetText(bundle.String())
+		copyButton.SetSensitive(true)
+	})
+	closeButton, err := gtk.ButtonNewWithLabel("Close")
+	logError(err, "")
+	closeButton.Connect("clicked", func() {
+		window.Destroy()
+	})
+
+	window.Connect("destroy", window.Close)
+
+	//layout
+	vbox, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 1)
+	logError(err, "")
+
+	grid, err := gtk.GridNew()
+	logError(err, "")
+	vbox.PackStart(grid, false, true, 1)
+	grid.SetColumnSpacing(2)
+
+	grid.Attach(recipientLabel, 0, 0, 1, 1)
+	grid.Attach(recipientValue, 1, 0, 1, 1)
+
+	grid.Attach(passphraseLabel, 0, 1, 1, 1)
+	grid.Attach(passphraseValue, 1, 1, 1, 1)
+
+	grid.Attach(expiresLabel, 0, 2, 1, 1)
+	grid.Attach(expiresValue, 1, 2, 1, 1)
+
+	vbox.PackStart(bundleFrame, true, true, 0)
+
+	hbox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 1)
+	logError(err, "")
+	hbox.Add(shareButton)
+	hbox.Add(copyButton)
+	hbox.Add(closeButton)
+	vbox.PackStart(hbox, false, false, 0)
+
+	window.Add(vbox)
+	window.SetDefaultSize(500, 400)
+	window.ShowAll()
+}
+
+// importShareWindow adds the records of a pasted sharing bundle to db
+func (app *GoPWSafeGTK) importShareWindow(db pwsafe.DB) {
+	window, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	logError(err, "")
+	window.SetPosition(gtk.WIN_POS_CENTER)
+	window.SetTitle("Import Share into " + db.GetName())
+
+	v3db, ok := pwsafe.BaseV3(db)
+	if !ok {
+		log.Fatalf("Failed to cast Password DB %q as a V3 password safe", db.GetName())
+	}
+
+	bundleFrame, err := gtk.FrameNew("Paste the sharing bundle")
+	logError(err, "")
+	bundleWin, err := gtk.ScrolledWindowNew(nil, nil)
+	logError(err, "")
+	bundleWin.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	bundleView, err := gtk.TextViewNew()
+	logError(err, "")
+	bundleView.SetMonospace(true)
+	buffer, err := bundleView.GetBuffer()
+	logError(err, "")
+	bundleWin.Add(bundleView)
+	bundleFrame.Add(bundleWin)
+
+	passphraseLabel, err := gtk.LabelNew("Passphrase")
+	logError(err, "")
+	passphraseValue, err := gtk.EntryNew()
+	logError(err, "")
+	passphraseValue.SetVisibility(false)
+	passphraseValue.SetHExpand(true)
+
+	privateLabel, err := gtk.LabelNew("Or private key")
+	logError(err, "")
+	privateValue, err := gtk.EntryNew()
+	logError(err, "")
+	privateValue.SetVisibility(false)
+	privateValue.SetHExpand(true)
+
+	importButton, err := gtk.ButtonNewWithLabel("Import")
+	logError(err, "")
+	importButton.Connect("clicked", func() {
+		var opts pwsafe.ShareOptions
+		private, err := privateValue.GetText()
+		logError(err, "")
+		if private = strings.TrimSpace(private); private != "" {
+			key, err := pwsafe.ParseShareKey(private)
+			if err != nil {
+				app.errorDialog(fmt.Sprintf("Invalid private key\n%s", err))
+				return
+			}
+			opts.PrivateKey = &key
+		} else {
+			opts.Passphrase, err = passphraseValue.GetText()
+			logError(err, "")
+		}
+		text, err := buffer.GetText(buffer.GetStartIter(), buffer.GetEndIter(), true)
+		logError(err, "")
+
+		n, err := v3db.ImportShare(strings.NewReader(text), opts)
+		if err != nil {
+			app.errorDialog(fmt.Sprintf("Error Importing the sharing bundle\n%s", err))
+			return
+		}
+		app.updateRecords("")
+		app.errorDialog(fmt.Sprintf("Imported %d records, save the db to keep them", n))
+		window.Destroy()
+	})
+	cancelButton, err := gtk.ButtonNewWithLabel("Cancel")
+	logError(err, "")
+	cancelButton.Connect("clicked", func() {
+		window.Destroy()
+	})
+
+	if db.ReadOnly() {
+		window.SetTitle(window.GetTitle() + " (read-only)")
+		importButton.SetSensitive(false)
+	}
+
+	window.Connect("destroy", window.Close)
+
+	//layout
+	vbox, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 1)
+	logError(err, "")
+	vbox.PackStart(bundleFrame, true, true, 0)
+
+	grid, err := gtk.GridNew()
+	logError(err, "")
+	vbox.PackStart(grid, false, true, 1)
+	grid.SetColumnSpacing(2)
+
+	grid.Attach(passphraseLabel, 0, 0, 1, 1)
+	grid.Attach(passphraseValue, 1, 0, 1, 1)
+
+	grid.Attach(privateLabel, 0, 1, 1, 1)
+	grid.Attach(privateValue, 1, 1, 1, 1)
+
+	hbox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 1)
+	logError(err, "")
+	hbox.Add(importButton)
+	hbox.Add(cancelButton)
+	vbox.PackStart(hbox, false, false, 0)
+
+	window.Add(vbox)
+	window.SetDefaultSize(500, 400)
+	window.ShowAll()
+}
@@ -3,6 +3,9 @@ package gui
 import (
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gotk3/gotk3/gtk"
@@ -26,6 +29,22 @@ func (app *GoPWSafeGTK) errorDialog(msg string) {
 	// gotk3 bug but need to investigate more
 }
 
+// confirmDialog asks a yes/no question returning true if the answer is yes
+func (app *GoPWSafeGTK) confirmDialog(msg string) bool {
+	parent := app.GetWindowByID(app.mainWindowID)
+
+	messagedialog := gtk.MessageDialogNew(
+		parent,
+		gtk.DIALOG_MODAL,
+		gtk.MESSAGE_QUESTION,
+		gtk.BUTTONS_YES_NO,
+		"%s",
+		msg)
+	response := gtk.ResponseType(messagedialog.Run())
+	messagedialog.Destroy()
+	return response == gtk.RESPONSE_YES
+}
+
 func (app *GoPWSafeGTK) propertiesWindow(db pwsafe.DB) {
 	window, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
 	logError(err, "")
@@ -33,7 +52,7 @@ func (app *GoPWSafeGTK) propertiesWindow(db pwsafe.DB) {
 	dbName := db.GetName()
 	window.SetTitle(dbName)
 
-	v3db, ok := db.(*pwsafe.V3)
+	v3db, ok := pwsafe.BaseV3(db)
 	if !ok {
 		log.Fatalf("Failed to cast Password DB %q as a V3 password safe", dbName)
 	}
@@ -69,6 +88,19 @@ func (app *GoPWSafeGTK) propertiesWindow(db pwsafe.DB) {
 	password2Value.SetVisibility(false)
 	password2Value.SetHExpand(true)
 
+	iterLabel, err := gtk.LabelNew("Key Stretch Iterations")
+	logError(err, "")
+	iterValue, err := gtk.EntryNew()
+	logError(err, "")
+	iterValue.SetText(strconv.FormatUint(uint64(v3db.Iter), 10))
+	iterValue.SetHExpand(true)
+	calibrateButton, err := gtk.ButtonNewWithLabel("Calibrate")
+	logError(err, "")
+	calibrateButton.Connect("clicked", func() {
+		iter := pwsafe.CalibrateIterations(unlockTime)
+		iterValue.SetText(strconv.FormatUint(uint64(iter), 10))
+	})
+
 	descriptionFrame, err := gtk.FrameNew("Description")
 	logError(err, "")
 	descriptionWin, err := gtk.ScrolledWindowNew(nil, nil)
@@ -94,24 +126,46 @@ func (app *GoPWSafeGTK) propertiesWindow(db pwsafe.DB) {
 		v3db.Description, err = buffer.GetText(start, end, true)
 		logError(err, "")
 
+		iterText, err := iterValue.GetText()
+		logError(err, "")
+		iter, err := strconv.ParseUint(iterText, 10, 32)
+		if err != nil {
+			app.errorDialog(fmt.Sprintf("Invalid key stretch iterations %q", iterText))
+			return
+		}
+
 		pw, err := passwordValue.GetText()
 		logError(err, "")
+		pw2, err := password2Value.GetText()
+		logError(err, "")
+		if pw != pw2 {
+			app.errorDialog("Error Passwords don't match")
+			return
+		}
+		if uint32(iter) != v3db.Iter {
+			// The stretched key can only be recalculated with the password
+			if pw == "" {
+				app.errorDialog("A new password must be set to change the key stretch iterations")
+				return
+			}
+			if err := v3db.SetIterations(uint32(iter)); err != nil {
+				app.errorDialog(fmt.Sprintf("Error Updating iterations\n%s", err))
+				return
+			}
+		}
 		if pw != "" {
-			pw2, err := password2Value.GetText()
-			logError(err, "")
-			if pw != pw2 {
-				app.errorDialog("Error Passwords don't match")
-			} else if err := db.SetPassword(pw); err != nil {
+			if err := db.SetPassword(pw); err != nil {
+				// the db keeps its old key and iterations so nothing unopenable is written
 				app.errorDialog(fmt.Sprintf("Error Updating password\n%s", err))
+				return
 			}
-
 		}
 
 		path, err := savePathValue.GetText()
 		logError(err, "")
 
 		var new bool
-		v3db := db.(*pwsafe.V3)
+		v3db, _ := pwsafe.BaseV3(db)
 		if v3db.LastSavePath == "" {
 			new = true
 		}
@@ -130,6 +184,17 @@ func (app *GoPWSafeGTK) propertiesWindow(db pwsafe.DB) {
 		window.Destroy()
 	})
 
+	// A read-only db can't be changed or saved
+	if db.ReadOnly() {
+		window.SetTitle(dbName + " (read-only)")
+		for _, entry := range []*gtk.Entry{nameValue, savePathValue, passwordValue, password2Value, iterValue} {
+			entry.SetEditable(false)
+		}
+		textView.SetEditable(false)
+		calibrateButton.SetSensitive(false)
+		saveButton.SetSensitive(false)
+	}
+
 	window.Connect("destroy", window.Close)
 
 	//layout
@@ -157,6 +222,10 @@ func (app *GoPWSafeGTK) propertiesWindow(db pwsafe.DB) {
 	grid.Attach(password2Label, 0, 4, 1, 1)
 	grid.Attach(password2Value, 1, 4, 1, 1)
 
+	grid.Attach(iterLabel, 0, 5, 1, 1)
+	grid.Attach(iterValue, 1, 5, 1, 1)
+	grid.Attach(calibrateButton, 2, 5, 1, 1)
+
 	vbox.PackStart(descriptionFrame, true, true, 0)
 
 	hbox, err = gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 1)
@@ -169,3 +238,250 @@ func (app *GoPWSafeGTK) propertiesWindow(db pwsafe.DB) {
 	window.SetDefaultSize(500, 400)
 	window.ShowAll()
 }
+
+// subsetWindow exports the records of db matching a filter to a new db with its own password
+func (app *GoPWSafeGTK) subsetWindow(db pwsafe.DB, record *pwsafe.Record) {
+	window, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	logError(err, "")
+	window.SetPosition(gtk.WIN_POS_CENTER)
+	window.SetTitle("Export Subset of " + db.GetName())
+
+	v3db, ok := pwsafe.BaseV3(db)
+	if !ok {
+		log.Fatalf("Failed to cast Password DB %q as a V3 password safe", db.GetName())
+	}
+
+	groupsLabel, err := gtk.LabelNew("Groups, comma separated")
+	logError(err, "")
+	groupsValue, err := gtk.EntryNew()
+	logError(err, "")
+	groupsValue.SetHExpand(true)
+
+	queryLabel, err := gtk.LabelNew("Search")
+	logError(err, "")
+	queryValue, err := gtk.EntryNew()
+	logError(err, "")
+	queryValue.SetHExpand(true)
+
+	selectedCheck, err := gtk.CheckButtonNewWithLabel("Only the selected record")
+	logError(err, "")
+	if record == nil {
+		selectedCheck.SetSensitive(false)
+	} else {
+		groupsValue.SetText(record.Group)
+	}
+
+	savePath, err := gtk.LabelNew("Save path")
+	logError(err, "")
+	savePathValue, err := gtk.EntryNew()
+	logError(err, "")
+	savePathValue.SetHExpand(true)
+
+	passwordLabel, err := gtk.LabelNew("Password")
+	logError(err, "")
+	passwordValue, err := gtk.EntryNew()
+	logError(err, "")
+	passwordValue.SetVisibility(false)
+	passwordValue.SetHExpand(true)
+
+	password2Label, err := gtk.LabelNew("Repeated Password")
+	logError(err, "")
+	password2Value, err := gtk.EntryNew()
+	logError(err, "")
+	password2Value.SetVisibility(false)
+	password2Value.SetHExpand(true)
+
+	exportButton, err := gtk.ButtonNewWithLabel("Export")
+	logError(err, "")
+	exportButton.Connect("clicked", func() {
+		var filter pwsafe.RecordFilter
+		groups, err := groupsValue.GetText()
+		logError(err, "")
+		for _, group := range strings.Split(groups, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				filter.Groups = append(filter.Groups, group)
+			}
+		}
+		filter.Query, err = queryValue.GetText()
+		logError(err, "")
+		if selectedCheck.GetActive() && record != nil {
+			filter.UUIDs = [][16]byte{record.UUID}
+		}
+
+		path, err := savePathValue.GetText()
+		logError(err, "")
+		if path == "" {
+			app.errorDialog("A save path is required")
+			return
+		}
+		pw, err := passwordValue.GetText()
+		logError(err, "")
+		pw2, err := password2Value.GetText()
+		logError(err, "")
+		if pw == "" || pw != pw2 {
+			app.errorDialog("Error Passwords don't match")
+			return
+		}
+
+		matched := v3db.Select(filter)
+		if !app.confirmDialog(fmt.Sprintf("Export %d records, and the base records of any aliases, to %s?",
+			len(matched), path)) {
+			return
+		}
+		n, err := v3db.ExportSubset(filter, path, pw)
+		if err != nil {
+			app.errorDialog(fmt.Sprintf("Error Exporting records\n%s", err))
+			return
+		}
+		app.errorDialog(fmt.Sprintf("Exported %d records to %s", n, path))
+		window.Destroy()
+	})
+	cancelButton, err := gtk.ButtonNewWithLabel("Cancel")
+	logError(err, "")
+	cancelButton.Connect("clicked", func() {
+		window.Destroy()
+	})
+
+	window.Connect("destroy", window.Close)
+
+	//layout
+	vbox, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 1)
+	logError(err, "")
+
+	grid, err := gtk.GridNew()
+	logError(err, "")
+	vbox.PackStart(grid, false, true, 1)
+	grid.SetColumnSpacing(2)
+
+	grid.Attach(groupsLabel, 0, 0, 1, 1)
+	grid.Attach(groupsValue, 1, 0, 1, 1)
+
+	grid.Attach(queryLabel, 0, 1, 1, 1)
+	grid.Attach(queryValue, 1, 1, 1, 1)
+
+	grid.Attach(selectedCheck, 0, 2, 2, 1)
+
+	grid.Attach(savePath, 0, 3, 1, 1)
+	grid.Attach(savePathValue, 1, 3, 1, 1)
+
+	grid.Attach(passwordLabel, 0, 4, 1, 1)
+	grid.Attach(passwordValue, 1, 4, 1, 1)
+
+	grid.Attach(password2Label, 0, 5, 1, 1)
+	grid.Attach(password2Value, 1, 5, 1, 1)
+
+	hbox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 1)
+	logError(err, "")
+	hbox.Add(exportButton)
+	hbox.Add(cancelButton)
+	vbox.PackStart(hbox, false, false, 0)
+
+	window.Add(vbox)
+	window.SetDefaultSize(500, 250)
+	window.ShowAll()
+}
+
+// sheetWindow writes a printable HTML emergency sheet of the records in some groups
+func (app *GoPWSafeGTK) sheetWindow(db pwsafe.DB, record *pwsafe.Record) {
+	window, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	logError(err, "")
+	window.SetPosition(gtk.WIN_POS_CENTER)
+	window.SetTitle("Emergency Sheet of " + db.GetName())
+
+	v3db, ok := pwsafe.BaseV3(db)
+	if !ok {
+		log.Fatalf("Failed to cast Password DB %q as a V3 password safe", db.GetName())
+	}
+
+	groupsLabel, err := gtk.LabelNew("Groups, comma separated")
+	logError(err, "")
+	groupsValue, err := gtk.EntryNew()
+	logError(err, "")
+	groupsValue.SetHExpand(true)
+	if record != nil {
+		groupsValue.SetText(record.Group)
+	}
+
+	qrCheck, err := gtk.CheckButtonNewWithLabel("QR codes of the passwords")
+	logError(err, "")
+
+	savePath, err := gtk.LabelNew("Save path")
+	logError(err, "")
+	savePathValue, err := gtk.EntryNew()
+	logError(err, "")
+	savePathValue.SetHExpand(true)
+
+	saveButton, err := gtk.ButtonNewWithLabel("Save")
+	logError(err, "")
+	saveButton.Connect("clicked", func() {
+		opts := pwsafe.SheetOptions{QRCodes: qrCheck.GetActive()}
+		groups, err := groupsValue.GetText()
+		logError(err, "")
+		for _, group := range strings.Split(groups, ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				opts.Filter.Groups = append(opts.Filter.Groups, group)
+			}
+		}
+		path, err := savePathValue.GetText()
+		logError(err, "")
+		if path == "" {
+			app.errorDialog("A save path is required")
+			return
+		}
+		if !app.confirmDialog(fmt.Sprintf("The sheet is not encrypted, print it and then delete %s. Continue?", path)) {
+			return
+		}
+
+		// The sheet is not encrypted so is only readable by the user
+		out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			app.errorDialog(fmt.Sprintf("Error Writing the sheet\n%s", err))
+			return
+		}
+		n, err := v3db.ExportSheet(out, opts)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+			app.errorDialog(fmt.Sprintf("Error Writing the sheet\n%s", err))
+			return
+		}
+		app.errorDialog(fmt.Sprintf("Wrote %d records to %s", n, path))
+		window.Destroy()
+	})
+	cancelButton, err := gtk.ButtonNewWithLabel("Cancel")
+	logError(err, "")
+	cancelButton.Connect("clicked", func() {
+		window.Destroy()
+	})
+
+	window.Connect("destroy", window.Close)
+
+	//layout
+	vbox, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 1)
+	logError(err, "")
+
+	grid, err := gtk.GridNew()
+	logError(err, "")
+	vbox.PackStart(grid, false, true, 1)
+	grid.SetColumnSpacing(2)
+
+	grid.Attach(groupsLabel, 0, 0, 1, 1)
+	grid.Attach(groupsValue, 1, 0, 1, 1)
+
+	grid.Attach(qrCheck, 0, 1, 2, 1)
+
+	grid.Attach(savePath, 0, 2, 1, 1)
+	grid.Attach(savePathValue, 1, 2, 1, 1)
+
+	hbox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 1)
+	logError(err, "")
+	hbox.Add(saveButton)
+	hbox.Add(cancelButton)
+	vbox.PackStart(hbox, false, false, 0)
+
+	window.Add(vbox)
+	window.SetDefaultSize(500, 150)
+	window.ShowAll()
+}
@@ -0,0 +1,452 @@
+package pwsafe
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Bitwarden format is the unencrypted JSON export of the Bitwarden clients. Folders are nested by a "/" in their
+// name so map to the dot separated group path. Data with no Password Safe field, TOTP secrets, further URIs, custom
+// fields, cards and identities, is added to the notes on import.
+
+// Bitwarden item types
+const (
+	bitwardenLoginType      = 1
+	bitwardenSecureNoteType = 2
+	bitwardenCardType       = 3
+	bitwardenIdentityType   = 4
+	bitwardenSSHKeyType     = 5
+)
+
+// Custom fields holding the Password Safe fields Bitwarden has no field for
+const (
+	bitwardenEmail      = "Email"
+	bitwardenRunCommand = "Run Command"
+	bitwardenAutotype   = "Autotype"
+)
+
+// bitwardenTimeFormat is the UTC time format of Bitwarden dates
+const bitwardenTimeFormat = "2006-01-02T15:04:05.000Z"
+
+type bitwardenExport struct {
+	Encrypted   bool              `json:"encrypted"`
+	Folders     []bitwardenFolder `json:"folders"`
+	Collections []bitwardenFolder `json:"collections,omitempty"` // organization exports only
+	Items       []bitwardenItem   `json:"items"`
+}
+
+type bitwardenFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bitwardenItem struct {
+	PasswordHistory []bitwardenPassword    `json:"passwordHistory"`
+	RevisionDate    string                 `json:"revisionDate,omitempty"`
+	CreationDate    string                 `json:"creationDate,omitempty"`
+	DeletedDate     *string                `json:"deletedDate"`
+	ID              string                 `json:"id"`
+	OrganizationID  *string                `json:"organizationId"`
+	FolderID        *string                `json:"folderId"`
+	Type            int                    `json:"type"`
+	Reprompt        int                    `json:"reprompt"`
+	Name            string                 `json:"name"`
+	Notes           *string                `json:"notes"`
+	Favorite        bool                   `json:"favorite"`
+	Fields          []bitwardenField       `json:"fields,omitempty"`
+	Login           *bitwardenLogin        `json:"login,omitempty"`
+	SecureNote      *bitwardenSecureNote   `json:"secureNote,omitempty"`
+	Card            *bitwardenCard         `json:"card,omitempty"`
+	Identity        map[string]interface{} `json:"identity,omitempty"`
+	SSHKey          map[string]interface{} `json:"sshKey,omitempty"`
+	CollectionIDs   []string               `json:"collectionIds"`
+}
+
+type bitwardenPassword struct {
+	LastUsedDate string `json:"lastUsedDate"`
+	Password     string `json:"password"`
+}
+
+type bitwardenField struct {
+	Name  string  `json:"name"`
+	Value *string `json:"value"`
+	Type  int     `json:"type"`
+}
+
+type bitwardenLogin struct {
+	URIs                 []bitwardenURI `json:"uris"`
+	Username             *string        `json:"username"`
+	Password             *string        `json:"password"`
+	TOTP                 *string        `json:"totp"`
+	PasswordRevisionDate *string        `json:"passwordRevisionDate,omitempty"`
+}
+
+type bitwardenURI struct {
+	Match *int   `json:"match"`
+	URI   string `json:"uri"`
+}
+
+type bitwardenSecureNote struct {
+	Type int `json:"type"`
+}
+
+type bitwardenCard struct {
+	CardholderName *string `json:"cardholderName"`
+	Brand          *string `json:"brand"`
+	Number         *string `json:"number"`
+	ExpMonth       *string `json:"expMonth"`
+	ExpYear        *string `json:"expYear"`
+	Code           *string `json:"code"`
+}
+
+// bitwardenString returns the value of an optional string
+func bitwardenString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// optionalString returns nil for an empty string, as Bitwarden writes unset values
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// parseBitwardenTime parses a Bitwarden date, empty is the zero time
+func parseBitwardenTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return t, fmt.Errorf("%w, invalid Bitwarden date %q", ErrInvalidField, s)
+	}
+	return t.Local(), nil
+}
+
+// formatBitwardenTime formats a Bitwarden date, the zero time is empty
+func formatBitwardenTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(bitwardenTimeFormat)
+}
+
+// formatBitwardenID formats a UUID as Bitwarden does
+func formatBitwardenID(uuid [16]byte) string {
+	id := hex.EncodeToString(uuid[:])
+	return id[0:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:]
+}
+
+// parseBitwardenID parses a Bitwarden UUID, the zero UUID is returned if it is invalid
+func parseBitwardenID(s string) [16]byte {
+	var uuid [16]byte
+	if id, err := hex.DecodeString(strings.ReplaceAll(s, "-", "")); err == nil && len(id) == len(uuid) {
+		copy(uuid[:], id)
+	}
+	return uuid
+}
+
+//ExportBitwarden Writes the records of the db to w in the unencrypted JSON export format of Bitwarden. Groups become
+//folders, including the empty groups, with a "/" separating nested folders. A record with no username, password or URL
+//is a secure note, any other a login. The email, run command and autotype are custom fields. The file is not encrypted.
+func (db *V3) ExportBitwarden(w io.Writer) error {
+	export := bitwardenExport{Folders: []bitwardenFolder{}, Items: []bitwardenItem{}}
+	folders := make(map[string]string)
+	var addFolder func(group string) (string, error)
+	addFolder = func(group string) (string, error) {
+		if id, ok := folders[group]; ok {
+			return id, nil
+		}
+		if i := strings.LastIndex(group, "."); i >= 0 {
+			if _, err := addFolder(group[:i]); err != nil {
+				return "", err
+			}
+		}
+		uuid, err := db.newUUID()
+		if err != nil {
+			return "", err
+		}
+		folders[group] = formatBitwardenID(uuid)
+		export.Folders = append(export.Folders, bitwardenFolder{ID: folders[group],
+			Name: strings.ReplaceAll(group, ".", "/")})
+		return folders[group], nil
+	}
+	for _, group := range db.EmptyGroups {
+		if _, err := addFolder(group); err != nil {
+			return err
+		}
+	}
+
+	for _, title := range db.recordOrder() {
+		record := db.Records[title]
+		uuid := record.UUID
+		if uuid == [16]byte{} {
+			var err error
+			if uuid, err = db.newUUID(); err != nil {
+				return err
+			}
+		}
+		item := bitwardenItem{
+			ID:           formatBitwardenID(uuid),
+			Name:         record.Title,
+			Notes:        optionalString(record.Notes),
+			RevisionDate: formatBitwardenTime(record.ModTime),
+			CreationDate: formatBitwardenTime(record.CreateTime),
+			Type:         bitwardenSecureNoteType,
+			SecureNote:   &bitwardenSecureNote{},
+		}
+		if record.Group != "" {
+			id, err := addFolder(record.Group)
+			if err != nil {
+				return err
+			}
+			item.FolderID = &id
+		}
+		for _, field := range []struct{ name, value string }{
+			{bitwardenEmail, record.Email},
+			{bitwardenRunCommand, record.RunCommand},
+			{bitwardenAutotype, record.Autotype},
+		} {
+			if field.value != "" {
+				item.Fields = append(item.Fields, bitwardenField{Name: field.name, Value: optionalString(field.value)})
+			}
+		}
+		if record.Username != "" || record.Password != "" || record.URL != "" {
+			item.Type, item.SecureNote = bitwardenLoginType, nil
+			item.Login = &bitwardenLogin{
+				URIs:     []bitwardenURI{},
+				Username: optionalString(record.Username),
+				Password: optionalString(record.Password),
+			}
+			if record.URL != "" {
+				item.Login.URIs = append(item.Login.URIs, bitwardenURI{URI: record.URL})
+			}
+			if !record.PasswordModTime.IsZero() {
+				modified := formatBitwardenTime(record.PasswordModTime)
+				item.Login.PasswordRevisionDate = &modified
+			}
+		}
+		if record.PasswordHistory != "" {
+			history, err := ParsePasswordHistory(record.PasswordHistory)
+			if err != nil {
+				return fmt.Errorf("Error exporting record %q - %w", title, err)
+			}
+			// Bitwarden lists the most recent first
+			for i := len(history.Entries) - 1; i >= 0; i-- {
+				item.PasswordHistory = append(item.PasswordHistory, bitwardenPassword{
+					LastUsedDate: formatBitwardenTime(history.Entries[i].Changed),
+					Password:     history.Entries[i].Password,
+				})
+			}
+		}
+		export.Items = append(export.Items, item)
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+//ImportBitwarden Reads an unencrypted Bitwarden JSON export adding its items with SetRecord, a record whose title is
+//already used is renamed with a numbered suffix. Folders, or for organization exports the first collection, become the
+//group with "/" separating nested groups, and folders without items are added as empty groups. The first URI of a
+//login is the URL, the card number is the password and the cardholder the username. TOTP secrets, further URIs,
+//custom fields and the details of cards and identities are added to the notes, except the custom fields Email, Run
+//Command and Autotype, as written by ExportBitwarden, which set those fields. Items in the trash are skipped.
+//Returns the number of records imported.
+func (db *V3) ImportBitwarden(r io.Reader) (int, error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	var export bitwardenExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return 0, fmt.Errorf("Error parsing the Bitwarden JSON - %w", err)
+	}
+	if export.Encrypted {
+		return 0, fmt.Errorf("%w, encrypted Bitwarden exports are not supported", ErrInvalidField)
+	}
+	groups := make(map[string]string, len(export.Folders)+len(export.Collections))
+	for _, folder := range append(export.Folders, export.Collections...) {
+		groups[folder.ID] = strings.ReplaceAll(folder.Name, "/", ".")
+	}
+
+	var records []Record
+	for _, item := range export.Items {
+		if item.DeletedDate != nil {
+			continue
+		}
+		folder := bitwardenString(item.FolderID)
+		if folder == "" && len(item.CollectionIDs) > 0 {
+			folder = item.CollectionIDs[0]
+		}
+		record, err := item.record(groups[folder])
+		if err != nil {
+			return 0, fmt.Errorf("Error importing Bitwarden item %q - %w", item.Name, err)
+		}
+		records = append(records, record)
+	}
+	// A folder is empty if it has no items and no folders nested in it
+	var paths []string
+	for _, record := range records {
+		paths = append(paths, record.Group)
+	}
+	for _, folder := range export.Folders {
+		paths = append(paths, groups[folder.ID])
+	}
+	var emptyGroups []string
+	for _, folder := range export.Folders {
+		group, empty := groups[folder.ID], true
+		for _, record := range records {
+			empty = empty && record.Group != group
+		}
+		for _, path := range paths {
+			empty = empty && !strings.HasPrefix(path, group+".")
+		}
+		if empty {
+			emptyGroups = append(emptyGroups, group)
+		}
+	}
+
+	db.importRecords(records, emptyGroups)
+	return len(records), nil
+}
+
+// record converts the item to a Record in group
+func (item *bitwardenItem) record(group string) (Record, error) {
+	record := Record{
+		Group: group,
+		Title: item.Name,
+		Notes: bitwardenString(item.Notes),
+		UUID:  parseBitwardenID(item.ID),
+	}
+	var err error
+	if record.CreateTime, err = parseBitwardenTime(item.CreationDate); err != nil {
+		return record, err
+	}
+	if record.ModTime, err = parseBitwardenTime(item.RevisionDate); err != nil {
+		return record, err
+	}
+
+	var extra []string
+	note := func(label, value string) {
+		if value != "" {
+			extra = append(extra, label+": "+value)
+		}
+	}
+	switch item.Type {
+	case bitwardenLoginType:
+		if login := item.Login; login != nil {
+			record.Username = bitwardenString(login.Username)
+			record.Password = bitwardenString(login.Password)
+			for i, uri := range login.URIs {
+				if i == 0 {
+					record.URL = uri.URI
+				} else {
+					note("URL", uri.URI)
+				}
+			}
+			note("TOTP", bitwardenString(login.TOTP))
+			if record.PasswordModTime, err = parseBitwardenTime(bitwardenString(login.PasswordRevisionDate)); err != nil {
+				return record, err
+			}
+		}
+	case bitwardenCardType:
+		if card := item.Card; card != nil {
+			record.Username = bitwardenString(card.CardholderName)
+			record.Password = bitwardenString(card.Number)
+			note("Brand", bitwardenString(card.Brand))
+			if month, year := bitwardenString(card.ExpMonth), bitwardenString(card.ExpYear); month != "" || year != "" {
+				note("Expires", strings.TrimPrefix(month+"/"+year, "/"))
+			}
+			note("Security code", bitwardenString(card.Code))
+		}
+	case bitwardenIdentityType:
+		extra = append(extra, bitwardenDetails(item.Identity)...)
+	case bitwardenSSHKeyType:
+		extra = append(extra, bitwardenDetails(item.SSHKey)...)
+	}
+	for _, field := range item.Fields {
+		value := bitwardenString(field.Value)
+		switch field.Name {
+		case bitwardenEmail:
+			record.Email = value
+		case bitwardenRunCommand:
+			record.RunCommand = value
+		case bitwardenAutotype:
+			record.Autotype = value
+		default:
+			note(field.Name, value)
+		}
+	}
+	record.Notes = appendNotes(record.Notes, extra)
+
+	record.Title = importTitle(record.Title, record.URL)
+	if len(item.PasswordHistory) > 0 {
+		history := PasswordHistory{Enabled: true}
+		for i := len(item.PasswordHistory) - 1; i >= 0; i-- {
+			changed, err := parseBitwardenTime(item.PasswordHistory[i].LastUsedDate)
+			if err != nil {
+				return record, err
+			}
+			history.Entries = append(history.Entries, PasswordHistoryEntry{Changed: changed,
+				Password: item.PasswordHistory[i].Password})
+		}
+		record.PasswordHistory = trimHistory(history).String()
+	}
+	return record, nil
+}
+
+// bitwardenDetails returns a line for each set value of an identity or SSH key, sorted by name
+func bitwardenDetails(details map[string]interface{}) []string {
+	var lines []string
+	for name, value := range details {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			if v != "" {
+				lines = append(lines, name+": "+v)
+			}
+		case float64:
+			lines = append(lines, name+": "+strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			lines = append(lines, fmt.Sprintf("%s: %v", name, v))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// importTitle returns the title for an imported record, the URL host name or Untitled if it has none
+func importTitle(title, rawURL string) string {
+	if title != "" {
+		return title
+	}
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	return "Untitled"
+}
+
+// trimHistory returns an imported password history with only the newest entries the field can hold, at most 255, and
+// its maximum set to the number kept
+func trimHistory(history PasswordHistory) PasswordHistory {
+	if len(history.Entries) > 0xff {
+		history.Entries = history.Entries[len(history.Entries)-0xff:]
+	}
+	history.Max = len(history.Entries)
+	return history
+}
@@ -0,0 +1,197 @@
+package pwsafe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bitwardenTestExport is a Bitwarden export with each item type
+const bitwardenTestExport = `{
+  "encrypted": false,
+  "folders": [
+    {"id": "f1", "name": "Work"},
+    {"id": "f2", "name": "Work/Cards"},
+    {"id": "f3", "name": "Unused"},
+    {"id": "f4", "name": "Parent"},
+    {"id": "f5", "name": "Parent/Child"}
+  ],
+  "items": [
+    {
+      "passwordHistory": [
+        {"lastUsedDate": "2022-02-01T00:00:00.000Z", "password": "second"},
+        {"lastUsedDate": "2021-02-01T00:00:00.000Z", "password": "first"}
+      ],
+      "revisionDate": "2023-01-01T10:00:00.000Z",
+      "creationDate": "2020-01-01T10:00:00.000Z",
+      "deletedDate": null,
+      "id": "01020304-0506-0708-090a-0b0c0d0e0f10",
+      "organizationId": null,
+      "folderId": "f1",
+      "type": 1,
+      "reprompt": 0,
+      "name": "mail",
+      "notes": "some notes",
+      "favorite": true,
+      "fields": [
+        {"name": "PIN", "value": "1234", "type": 1, "linkedId": null},
+        {"name": "Email", "value": "alice@example.com", "type": 0, "linkedId": null},
+        {"name": "Linked", "value": null, "type": 3, "linkedId": 100}
+      ],
+      "login": {
+        "fido2Credentials": [],
+        "uris": [{"match": null, "uri": "https://mail.example.com"}, {"match": 3, "uri": "https://webmail.example.com"}],
+        "username": "alice",
+        "password": "current",
+        "totp": "otpauth://totp/mail?secret=ABC",
+        "passwordRevisionDate": "2022-02-01T00:00:00.000Z"
+      },
+      "collectionIds": null
+    },
+    {
+      "id": "n1", "folderId": null, "type": 2, "name": "note", "notes": "secret text",
+      "secureNote": {"type": 0}, "collectionIds": null
+    },
+    {
+      "id": "c1", "folderId": "f2", "type": 3, "name": "visa", "notes": null,
+      "card": {"cardholderName": "Alice", "brand": "Visa", "number": "4111111111111111", "expMonth": "1",
+        "expYear": "2030", "code": "123"},
+      "collectionIds": null
+    },
+    {
+      "id": "i1", "folderId": "f1", "type": 4, "name": "mail", "notes": null,
+      "identity": {"title": "Ms", "firstName": "Alice", "middleName": null, "lastName": "Smith", "email": ""},
+      "collectionIds": null
+    },
+    {
+      "id": "d1", "deletedDate": "2023-01-01T00:00:00.000Z", "folderId": null, "type": 2, "name": "trash",
+      "notes": "deleted", "secureNote": {"type": 0}
+    },
+    {
+      "id": "x1", "folderId": "f5", "type": 1, "name": "", "notes": null,
+      "login": {"uris": [{"uri": "https://bank.example.com/login"}], "username": "bob", "password": "pw", "totp": null}
+    }
+  ]
+}`
+
+func TestImportBitwarden(t *testing.T) {
+	db := NewV3("", "password")
+	n, err := db.ImportBitwarden(strings.NewReader(bitwardenTestExport))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []string{"bank.example.com", "mail", "mail (2)", "note", "visa"}, db.List())
+	assert.Equal(t, []string{"Unused"}, db.EmptyGroups)
+
+	mail, _ := db.GetRecord("mail")
+	assert.Equal(t, "Work", mail.Group)
+	assert.Equal(t, "alice", mail.Username)
+	assert.Equal(t, "current", mail.Password)
+	assert.Equal(t, "https://mail.example.com", mail.URL)
+	assert.Equal(t, "alice@example.com", mail.Email)
+	assert.Equal(t, "some notes\r\n\r\nURL: https://webmail.example.com\r\nTOTP: otpauth://totp/mail?secret=ABC\r\nPIN: 1234",
+		mail.Notes)
+	assert.Equal(t, [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, mail.UUID)
+	assert.Equal(t, time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC), mail.CreateTime.UTC())
+	assert.Equal(t, time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC), mail.ModTime.UTC())
+	assert.Equal(t, time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC), mail.PasswordModTime.UTC())
+	history, err := ParsePasswordHistory(mail.PasswordHistory)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history.Entries))
+	assert.Equal(t, "first", history.Entries[0].Password)
+	assert.Equal(t, "second", history.Entries[1].Password)
+	assert.Equal(t, time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC), history.Entries[1].Changed.UTC())
+
+	note, _ := db.GetRecord("note")
+	assert.Equal(t, "", note.Group)
+	assert.Equal(t, "secret text", note.Notes)
+
+	card, _ := db.GetRecord("visa")
+	assert.Equal(t, "Work.Cards", card.Group)
+	assert.Equal(t, "Alice", card.Username)
+	assert.Equal(t, "4111111111111111", card.Password)
+	assert.Equal(t, "Brand: Visa\r\nExpires: 1/2030\r\nSecurity code: 123", card.Notes)
+
+	identity, _ := db.GetRecord("mail (2)")
+	assert.Equal(t, "firstName: Alice\r\nlastName: Smith\r\ntitle: Ms", identity.Notes)
+
+	bank, _ := db.GetRecord("bank.example.com")
+	assert.Equal(t, "Parent.Child", bank.Group)
+
+	// Invalid and encrypted exports change nothing
+	db = NewV3("", "password")
+	_, err = db.ImportBitwarden(strings.NewReader(`{"encrypted": true, "items": []}`))
+	assert.True(t, errors.Is(err, ErrInvalidField))
+	_, err = db.ImportBitwarden(strings.NewReader(`{"items": [{"name": "a", "type": 1}, {"name": "b", "creationDate": "x"}]}`))
+	assert.True(t, errors.Is(err, ErrInvalidField))
+	_, err = db.ImportBitwarden(strings.NewReader("not json"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(db.Records))
+
+	db.readOnly = true
+	_, err = db.ImportBitwarden(strings.NewReader(bitwardenTestExport))
+	assert.Equal(t, ErrReadOnly, err)
+}
+
+func TestBitwardenRoundTrip(t *testing.T) {
+	source := fullDB()
+	source.SetRecord(Record{Title: "note only", Notes: "just a note"})
+	var buf bytes.Buffer
+	assert.Nil(t, source.ExportBitwarden(&buf))
+	assert.True(t, strings.Contains(buf.String(), `"name": "group/subgroup"`))
+
+	imported := NewV3("", "password")
+	n, err := imported.ImportBitwarden(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, len(source.Records), n)
+	assert.Equal(t, source.List(), imported.List())
+	assert.Equal(t, []string{"empty.sub"}, imported.EmptyGroups)
+	for title, want := range source.Records {
+		got, _ := imported.GetRecord(title)
+		assert.Equal(t, want.Group, got.Group, title)
+		assert.Equal(t, want.Username, got.Username, title)
+		assert.Equal(t, want.Password, got.Password, title)
+		assert.Equal(t, want.URL, got.URL, title)
+		assert.Equal(t, want.Notes, got.Notes, title)
+		assert.Equal(t, want.Email, got.Email, title)
+		assert.Equal(t, want.RunCommand, got.RunCommand, title)
+		assert.Equal(t, want.Autotype, got.Autotype, title)
+		assert.Equal(t, want.UUID, got.UUID, title)
+		assert.Equal(t, want.CreateTime.Unix(), got.CreateTime.Unix(), title)
+		assert.Equal(t, want.ModTime.Unix(), got.ModTime.Unix(), title)
+		assert.Equal(t, want.PasswordModTime.Unix(), got.PasswordModTime.Unix(), title)
+
+		wantHistory, err := ParsePasswordHistory(want.PasswordHistory)
+		assert.Nil(t, err, title)
+		gotHistory, err := ParsePasswordHistory(got.PasswordHistory)
+		assert.Nil(t, err, title)
+		assert.Equal(t, len(wantHistory.Entries), len(gotHistory.Entries), title)
+		for i := range wantHistory.Entries {
+			assert.Equal(t, wantHistory.Entries[i].Password, gotHistory.Entries[i].Password, title)
+			assert.Equal(t, wantHistory.Entries[i].Changed.Unix(), gotHistory.Entries[i].Changed.Unix(), title)
+		}
+	}
+}
+
+func TestImportTitle(t *testing.T) {
+	assert.Equal(t, "mail", importTitle("mail", "https://mail.example.com"))
+	assert.Equal(t, "mail.example.com", importTitle("", "https://mail.example.com:8443/login"))
+	assert.Equal(t, "Untitled", importTitle("", "mail.example.com"))
+	assert.Equal(t, "Untitled", importTitle("", ""))
+}
+
+func TestTrimHistory(t *testing.T) {
+	var history PasswordHistory
+	for i := 0; i < 300; i++ {
+		history.Entries = append(history.Entries, PasswordHistoryEntry{Password: fmt.Sprint(i)})
+	}
+	history = trimHistory(history)
+	assert.Equal(t, 255, history.Max)
+	assert.Equal(t, 255, len(history.Entries))
+	assert.Equal(t, "45", history.Entries[0].Password)
+	assert.Equal(t, 2, trimHistory(PasswordHistory{Entries: history.Entries[:2]}).Max)
+}
@@ -1,24 +1,25 @@
 package pwsafe
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
-
-	"github.com/fatih/structs"
 )
 
 // Equal returns true if the two dbs have the same data but not necessarily the same keys nor same LastSave time
 func (db *V3) Equal(other DB) (bool, error) {
+	otherV3, ok := other.(*V3)
+	if !ok {
+		return false, fmt.Errorf("can't compare a V3 db with %T", other)
+	}
 	// todo should I compare version?
-	skipHeaderFields := map[string]bool{"LastSave": true, "LastSaveBy": true, "UUID": true, "Version": true}
-	// restrict comparison to fields with a field struct tag
-	otherStruct := structs.New(other)
-	for _, field := range mapByFieldTag(db) {
-		if _, skip := skipHeaderFields[field.Name()]; skip {
+	skipHeaderFields := map[FieldType]bool{HeaderLastSave: true, HeaderLastSaveBy: true, HeaderUUID: true, HeaderVersion: true}
+	for _, field := range headerFields {
+		if _, skip := skipHeaderFields[field.Type]; skip {
 			continue
 		}
-		if !reflect.DeepEqual(field.Value(), otherStruct.Field(field.Name()).Value()) {
-			return false, fmt.Errorf("%v fields not equal, %v != %v", field.Name(), field.Value(), otherStruct.Field(field.Name()).Value())
+		if equal, err := headerFieldEqual(field, db, otherV3); !equal {
+			return false, err
 		}
 	}
 
@@ -37,22 +38,30 @@ func (db *V3) Equal(other DB) (bool, error) {
 	return true, nil
 }
 
+// headerFieldEqual compares the encoded values of the header field from each db
+func headerFieldEqual(field headerField, db, other *V3) (bool, error) {
+	values, otherValues := field.encode(db), field.encode(other)
+	if !reflect.DeepEqual(values, otherValues) {
+		return false, fmt.Errorf("%v fields not equal, %q != %q", field.Name, values, otherValues)
+	}
+	return true, nil
+}
+
 // compare two records returning true if they are the same, optionally skip comparison of create/mod times
 // always skip UUID comparison
 func recordsEqual(record, otherRecord Record, skipTimes bool) (bool, error) {
-	skipRecordFields := map[string]bool{"UUID": true}
+	skipRecordFields := map[FieldType]bool{RecordUUID: true}
 	if skipTimes {
-		for k, v := range map[string]bool{"AccessTime": true, "CreateTime": true, "ModTime": true, "UUID": true} {
-			skipRecordFields[k] = v
+		for _, t := range []FieldType{RecordAccessTime, RecordCreateTime, RecordModTime, RecordPasswordModTime} {
+			skipRecordFields[t] = true
 		}
 	}
-	otherFields := structs.New(otherRecord)
-	for _, field := range mapByFieldTag(record) {
-		if _, skip := skipRecordFields[field.Name()]; skip {
+	for _, field := range recordFields {
+		if _, skip := skipRecordFields[field.Type]; skip {
 			continue
 		}
-		if !reflect.DeepEqual(field.Value(), otherFields.Field(field.Name()).Value()) {
-			return false, fmt.Errorf("Records don't match, %v != %v", record, otherRecord)
+		if !bytes.Equal(field.encode(&record), field.encode(&otherRecord)) {
+			return false, fmt.Errorf("Records don't match, %v field differs for %q and %q", field.Name, record.Title, otherRecord.Title)
 		}
 	}
 	return true, nil
@@ -65,14 +74,26 @@ func (db *V3) Identical(other DB) (bool, error) {
 	if !equal {
 		return false, err
 	}
-	dbStruct := structs.New(*db)
-	otherStruct := structs.New(other)
-	skipHeaderFields := []string{"LastSaveBy", "UUID", "Version"}
-	encryptionFields := []string{"CBCIV", "EncryptionKey", "HMACKey", "Iter", "Salt", "StretchedKey"}
-	checkFields := append(skipHeaderFields, encryptionFields...)
-	for _, fieldName := range checkFields {
-		if !reflect.DeepEqual(dbStruct.Field(fieldName).Value(), otherStruct.Field(fieldName).Value()) {
-			return false, fmt.Errorf("%v fields not equal, %v != %v", fieldName, dbStruct.Field(fieldName).Value(), otherStruct.Field(fieldName).Value())
+	otherV3 := other.(*V3)
+	for _, t := range []FieldType{HeaderLastSaveBy, HeaderUUID, HeaderVersion} {
+		if equal, err := headerFieldEqual(*headerFieldsByType[t], db, otherV3); !equal {
+			return false, err
+		}
+	}
+	encryptionFields := []struct {
+		name         string
+		value, other interface{}
+	}{
+		{"CBCIV", db.CBCIV, otherV3.CBCIV},
+		{"EncryptionKey", db.EncryptionKey, otherV3.EncryptionKey},
+		{"HMACKey", db.HMACKey, otherV3.HMACKey},
+		{"Iter", db.Iter, otherV3.Iter},
+		{"Salt", db.Salt, otherV3.Salt},
+		{"StretchedKey", db.StretchedKey, otherV3.StretchedKey},
+	}
+	for _, field := range encryptionFields {
+		if field.value != field.other {
+			return false, fmt.Errorf("%v fields not equal, %v != %v", field.name, field.value, field.other)
 		}
 	}
 
@@ -0,0 +1,204 @@
+package pwsafe
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSV layouts recognized by ImportCSV
+const (
+	CSVLayoutChrome  = "chrome"
+	CSVLayoutFirefox = "firefox"
+	CSVLayoutGeneric = "generic"
+)
+
+//CSVSkipped A row of a CSV import which was not imported
+type CSVSkipped struct {
+	// Line is the line number in the file
+	Line     int
+	URL      string
+	Username string
+	Reason   string
+}
+
+//CSVReport What a CSV import added and skipped
+type CSVReport struct {
+	// Layout is the CSVLayout* constant the file was recognized as
+	Layout string
+	// Imported are the titles of the records added
+	Imported []string
+	Skipped  []CSVSkipped
+}
+
+//String Returns a human readable report with one line per skipped row
+func (r *CSVReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s layout, %d records imported, %d skipped\n", r.Layout, len(r.Imported), len(r.Skipped))
+	for _, s := range r.Skipped {
+		fmt.Fprintf(&b, "line %d %s %q: %s\n", s.Line, s.URL, s.Username, s.Reason)
+	}
+	return b.String()
+}
+
+// csvColumns maps the lower case column names of each layout to what they are read into, Firefox's optional columns
+// are added to the notes
+var csvColumns = map[string]func(r *Record, value string) error{
+	"name":     func(r *Record, v string) error { r.Title = v; return nil },
+	"title":    func(r *Record, v string) error { r.Title = v; return nil },
+	"url":      func(r *Record, v string) error { r.URL = v; return nil },
+	"username": func(r *Record, v string) error { r.Username = v; return nil },
+	"password": func(r *Record, v string) error { r.Password = v; return nil },
+	"note":     func(r *Record, v string) error { r.Notes = v; return nil },
+	"notes":    func(r *Record, v string) error { r.Notes = v; return nil },
+	"httprealm": func(r *Record, v string) error {
+		return csvNote(r, "HTTP realm", v)
+	},
+	"formactionorigin": func(r *Record, v string) error {
+		return csvNote(r, "Form action origin", v)
+	},
+	"timecreated":         csvFirefoxTime(func(r *Record) *time.Time { return &r.CreateTime }),
+	"timelastused":        csvFirefoxTime(func(r *Record) *time.Time { return &r.AccessTime }),
+	"timepasswordchanged": csvFirefoxTime(func(r *Record) *time.Time { return &r.PasswordModTime }),
+}
+
+// csvNote adds a labelled line to the notes if the value is set
+func csvNote(r *Record, label, value string) error {
+	if value == "" {
+		return nil
+	}
+	if r.Notes != "" {
+		r.Notes += "\r\n"
+	}
+	r.Notes += label + ": " + value
+	return nil
+}
+
+// csvFirefoxTime reads a time in milliseconds since the epoch as Firefox writes them
+func csvFirefoxTime(field func(r *Record) *time.Time) func(r *Record, value string) error {
+	return func(r *Record, v string) error {
+		if v == "" {
+			return nil
+		}
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w, invalid time %q", ErrInvalidField, v)
+		}
+		*field(r) = time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+		return nil
+	}
+}
+
+// csvLayout names the layout of the lower case header
+func csvLayout(header []string) string {
+	has := make(map[string]bool, len(header))
+	for _, name := range header {
+		has[name] = true
+	}
+	switch {
+	case has["guid"] || has["httprealm"] || has["formactionorigin"]:
+		return CSVLayoutFirefox
+	case len(header) >= 4 && strings.Join(header[:4], ",") == "name,url,username,password":
+		return CSVLayoutChrome
+	}
+	return CSVLayoutGeneric
+}
+
+// dedupeKey identifies a login by its URL and username, URLs are compared ignoring case of the scheme and host and
+// any trailing slash
+func dedupeKey(rawURL, username string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		u.Scheme = strings.ToLower(u.Scheme)
+		u.Host = strings.ToLower(u.Host)
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		u.Fragment = ""
+		rawURL = u.String()
+	}
+	return rawURL + "\x00" + username
+}
+
+//ImportCSV Reads logins from a browser password CSV export, recognizing the Chrome "name,url,username,password,note",
+//Firefox and generic layouts by the header line, adding them with SetRecord to group. A login whose URL and username
+//match an existing record or an earlier row is skipped as are rows without a password, rows with neither are never
+//duplicates. Records without a name are titled by the URL host, or Untitled if it has none, and a title already used is
+//given a numbered suffix. Nothing is added if any row is invalid.
+func (db *V3) ImportCSV(r io.Reader, group string) (*CSVReport, error) {
+	if db.readOnly {
+		return nil, ErrReadOnly
+	}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the CSV header - %w", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff")))
+	}
+	hasPassword := false
+	for _, name := range header {
+		hasPassword = hasPassword || name == "password"
+	}
+	if !hasPassword {
+		return nil, fmt.Errorf("%w, the CSV header has no password column", ErrInvalidField)
+	}
+	report := &CSVReport{Layout: csvLayout(header)}
+
+	seen := make(map[string]string, len(db.Records))
+	for title, record := range db.Records {
+		if record.URL != "" || record.Username != "" {
+			seen[dedupeKey(record.URL, record.Username)] = fmt.Sprintf("record %q", title)
+		}
+	}
+
+	var records []Record
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading CSV line %d - %w", line, err)
+		}
+		record := Record{Group: group}
+		for i, value := range row {
+			if i >= len(header) || csvColumns[header[i]] == nil {
+				continue
+			}
+			if err := csvColumns[header[i]](&record, value); err != nil {
+				return nil, fmt.Errorf("Error importing CSV line %d %s - %w", line, header[i], err)
+			}
+		}
+
+		skip := CSVSkipped{Line: line, URL: record.URL, Username: record.Username}
+		// like existing records, rows with neither a URL nor a username aren't compared
+		identified := record.URL != "" || record.Username != ""
+		key := dedupeKey(record.URL, record.Username)
+		switch {
+		case record.Password == "":
+			skip.Reason = "no password"
+		case record.Title == "" && record.URL == "":
+			skip.Reason = "no name or URL"
+		case identified && seen[key] != "":
+			skip.Reason = "duplicate of " + seen[key]
+		}
+		if skip.Reason != "" {
+			report.Skipped = append(report.Skipped, skip)
+			continue
+		}
+		if identified {
+			seen[key] = fmt.Sprintf("line %d", line)
+		}
+		record.Title = importTitle(record.Title, record.URL)
+		records = append(records, record)
+	}
+
+	report.Imported = db.importRecords(records, nil)
+	return report, nil
+}
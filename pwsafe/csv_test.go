@@ -0,0 +1,87 @@
+package pwsafe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportCSV(t *testing.T) {
+	chrome := "name,url,username,password,note\n" +
+		"example.com,https://example.com/,alice,secret1,\"a note, with a comma\"\n" +
+		"example.com,https://EXAMPLE.com,alice,secret2,\n" +
+		",https://other.example.com/login,bob,secret3,\n" +
+		"nopass,https://example.org,carol,,\n" +
+		"existing,https://Existing.example.com/,dave,secret4,\n"
+	db := NewV3("", "password")
+	db.SetRecord(Record{Title: "existing", Password: "p", URL: "https://existing.example.com", Username: "dave"})
+	db.SetRecord(Record{Title: "example.com", Password: "p", Username: "someone else"})
+	report, err := db.ImportCSV(strings.NewReader(chrome), "browser")
+	assert.Nil(t, err)
+	assert.Equal(t, CSVLayoutChrome, report.Layout)
+	assert.Equal(t, []string{"example.com (2)", "other.example.com"}, report.Imported)
+	assert.Equal(t, []CSVSkipped{
+		{Line: 3, URL: "https://EXAMPLE.com", Username: "alice", Reason: "duplicate of line 2"},
+		{Line: 5, URL: "https://example.org", Username: "carol", Reason: "no password"},
+		{Line: 6, URL: "https://Existing.example.com/", Username: "dave", Reason: `duplicate of record "existing"`},
+	}, report.Skipped)
+	assert.Contains(t, report.String(), "chrome layout, 2 records imported, 3 skipped")
+
+	record, _ := db.GetRecord("example.com (2)")
+	assert.Equal(t, Record{
+		Group: "browser", Title: "example.com (2)", URL: "https://example.com/", Username: "alice", Password: "secret1",
+		Notes: "a note, with a comma",
+	}, Record{Group: record.Group, Title: record.Title, URL: record.URL, Username: record.Username,
+		Password: record.Password, Notes: record.Notes})
+
+	firefox := "\"url\",\"username\",\"password\",\"httpRealm\",\"formActionOrigin\",\"guid\",\"timeCreated\",\"timeLastUsed\",\"timePasswordChanged\"\n" +
+		"\"https://site.example\",\"erin\",\"pw\",,\"https://site.example\",\"{guid}\",\"1600000000000\",\"1600000001000\",\"1600000002500\"\n"
+	db = NewV3("", "password")
+	report, err = db.ImportCSV(strings.NewReader(firefox), "")
+	assert.Nil(t, err)
+	assert.Equal(t, CSVLayoutFirefox, report.Layout)
+	assert.Equal(t, []string{"site.example"}, report.Imported)
+	record, _ = db.GetRecord("site.example")
+	assert.Equal(t, "Form action origin: https://site.example", record.Notes)
+	assert.Equal(t, time.Unix(1600000000, 0), record.CreateTime)
+	assert.Equal(t, time.Unix(1600000001, 0), record.AccessTime)
+	assert.Equal(t, time.Unix(1600000002, 500000000), record.PasswordModTime)
+
+	// Logins with neither a URL nor a username aren't duplicates, a URL without a host isn't a title
+	chrome = "name,url,username,password\nwifi,,,pw1\nalarm,,,pw2\n,example.net,,pw3\n"
+	db = NewV3("", "password")
+	db.SetRecord(Record{Title: "door", Password: "p"})
+	report, err = db.ImportCSV(strings.NewReader(chrome), "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"wifi", "alarm", "Untitled"}, report.Imported)
+	assert.Equal(t, 0, len(report.Skipped))
+
+	generic := "username,password,url,notes,extra\nfrank,pw,,,x\n"
+	db = NewV3("", "password")
+	report, err = db.ImportCSV(strings.NewReader(generic), "")
+	assert.Nil(t, err)
+	assert.Equal(t, CSVLayoutGeneric, report.Layout)
+	assert.Equal(t, 0, len(report.Imported))
+	assert.Equal(t, "no name or URL", report.Skipped[0].Reason)
+
+	for name, invalid := range map[string]string{
+		"empty":       "",
+		"no password": "name,url\na,b\n",
+		"time":        "url,password,timeCreated\nhttps://a,b,yesterday\n",
+		"quotes":      "url,password\nhttps://a,\"b\n",
+	} {
+		db := NewV3("", "password")
+		_, err := db.ImportCSV(strings.NewReader(invalid), "")
+		assert.NotNil(t, err, name)
+		assert.Equal(t, 0, len(db.Records), name)
+	}
+	_, err = db.ImportCSV(strings.NewReader("name,url\n"), "")
+	assert.True(t, errors.Is(err, ErrInvalidField))
+
+	db.readOnly = true
+	_, err = db.ImportCSV(strings.NewReader(chrome), "")
+	assert.Equal(t, ErrReadOnly, err)
+}
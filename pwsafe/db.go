@@ -4,69 +4,85 @@
 package pwsafe
 
 import (
-	"crypto/hmac"
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"fmt"
 	"io"
+	"math"
 	"sort"
 	"strings"
 	"time"
-
-	"github.com/pborman/uuid"
 )
 
-//Record The primary type for password DB entries
+//Record The primary type for password DB entries, the field types used in the db file are the Record* FieldType constants
 type Record struct {
-	AccessTime             time.Time `field:"09"`
-	Autotype               string    `field:"0e"`
-	CreateTime             time.Time `field:"07"`
-	DoubleClickAction      [2]byte   `field:"13"`
-	Email                  string    `field:"14"`
-	Group                  string    `field:"02"`
-	ModTime                time.Time `field:"0c"`
-	Notes                  string    `field:"05"`
-	Password               string    `field:"06"`
-	PasswordExpiry         time.Time `field:"0a"`
-	PasswordExpiryInterval [4]byte   `field:"11"`
-	PasswordHistory        string    `field:"0f"`
-	PasswordModTime        string    `field:"08"`
-	PasswordPolicy         string    `field:"10"`
-	PasswordPolicyName     string    `field:"18"`
-	ProtectedEntry         byte      `field:"15"`
-	RunCommand             string    `field:"12"`
-	ShiftDoubleClickAction [2]byte   `field:"17"`
-	Title                  string    `field:"03"`
-	Username               string    `field:"04"`
-	URL                    string    `field:"0d"`
-	UUID                   [16]byte  `field:"01"`
-}
-
-//V3 The type representing a password safe v3 database
+	AccessTime             time.Time
+	Autotype               string
+	CreateTime             time.Time
+	DoubleClickAction      [2]byte
+	Email                  string
+	Group                  string
+	ModTime                time.Time
+	Notes                  string
+	Password               string
+	PasswordExpiry         time.Time
+	PasswordExpiryInterval [4]byte
+	PasswordHistory        string
+	PasswordModTime        time.Time
+	PasswordPolicy         string
+	PasswordPolicyName     string
+	ProtectedEntry         byte
+	RunCommand             string
+	ShiftDoubleClickAction [2]byte
+	Title                  string
+	Username               string
+	URL                    string
+	UUID                   [16]byte
+}
+
+const (
+	// MinIterations is the smallest number of key stretching iterations allowed by the spec
+	MinIterations = 2048
+	// DefaultIterations is the number of key stretching iterations used for new databases
+	DefaultIterations = 600000
+	// progressInterval is how many key stretching iterations are done between checks for cancellation
+	progressInterval = 1 << 14
+)
+
+//V3 The type representing a password safe v3 database, the field types used in the db file for the header are the
+//Header* FieldType constants
 type V3 struct {
 	CBCIV          [16]byte //Random initial value for CBC
-	Description    string   `field:"0a"`
-	EmptyGroups    []string `field:"11"`
+	Description    string
+	EmptyGroups    []string
 	EncryptionKey  [32]byte
-	Filters        string   `field:"0b"`
+	Filters        string
 	HMAC           [32]byte //32bytes keyed-hash MAC with SHA-256 as the hash function.
 	HMACKey        [32]byte
 	Iter           uint32 //the number of iterations on the hash function to create the stretched key
 	LastMod        time.Time
-	LastSave       time.Time `field:"04"`
-	LastSaveBy     []byte    `field:"06"`
-	LastSaveHost   []byte    `field:"08"`
+	LastSave       time.Time
+	LastSaveBy     []byte
+	LastSaveHost   []byte
 	LastSavePath   string
-	LastSaveUser   []byte            `field:"07"`
-	Name           string            `field:"09"`
-	PasswordPolicy string            `field:"10"`
-	Preferences    string            `field:"02"`
+	LastSaveUser   []byte
+	Name           string
+	PasswordPolicy string
+	Preferences    string
 	Records        map[string]Record //the key is the record title
-	RecentyUsed    string            `field:"0f"`
+	Rand           io.Reader         //source of randomness for keys, salts, IVs, UUIDs and padding, crypto/rand if nil
+	Clock          func() time.Time  //source of timestamps, time.Now if nil
+	RecentyUsed    string
 	Salt           [32]byte
 	StretchedKey   [sha256.Size]byte
-	Tree           string   `field:"03"`
-	UUID           [16]byte `field:"01"`
-	Version        [2]byte  `field:"00"`
+	Tree           string
+	UUID           [16]byte
+	Version        [2]byte
+	order          []string //record titles in the order they were read or added, used when writing the file
+	readOnly       bool     //set when opened read-only, all mutators then return ErrReadOnly
+	legacyVersion  int      //1 or 2 when read from a V1 or V2 file by ReadLegacy
+	pendingIter    uint32   //iterations set by SetIterations, applied when the password is next set
 }
 
 //DB The interface representing the core functionality available for any password database
@@ -81,33 +97,54 @@ type DB interface {
 	List() []string
 	ListByGroup(string) []string
 	NeedsSave() bool
+	ReadOnly() bool
 	SetPassword(string) error
-	SetRecord(Record)
-	DeleteRecord(string)
-}
-
-//calculateHMAC calculate and set db.HMAC for the unencrypted data using HMACKey
-func (db *V3) calculateHMAC(unencrypted []byte) {
-	hmacHash := hmac.New(sha256.New, db.HMACKey[:])
-	hmacHash.Write(unencrypted)
-	copy(db.HMAC[:], hmacHash.Sum(nil))
+	SetRecord(Record) error
+	DeleteRecord(string) error
 }
 
 //calculateStretchKey Using the db Salt and Iter along with the passwd calculate the stretch key
 func (db *V3) calculateStretchKey(passwd string) {
-	iterations := int(db.Iter)
+	db.stretchKey(context.Background(), passwd, nil)
+}
+
+//stretchKey calculates the stretch key, every progressInterval iterations checking ctx for cancellation and
+//reporting to the progress func if it is not nil
+func (db *V3) stretchKey(ctx context.Context, passwd string, progress func(done, total uint32)) error {
 	salted := append([]byte(passwd), db.Salt[:]...)
 	stretched := sha256.Sum256(salted)
-	for i := 0; i < iterations; i++ {
+	for i := uint32(0); i < db.Iter; i++ {
+		if i%progressInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(i, db.Iter)
+			}
+		}
 		stretched = sha256.Sum256(stretched[:])
 	}
+	if progress != nil {
+		progress(db.Iter, db.Iter)
+	}
 	db.StretchedKey = stretched
+	return nil
 }
 
 //DeleteRecord Removes a record from the db
-func (db *V3) DeleteRecord(title string) {
+func (db *V3) DeleteRecord(title string) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
 	delete(db.Records, title)
-	db.LastMod = time.Now()
+	for i, t := range db.order {
+		if t == title {
+			db.order = append(db.order[:i], db.order[i+1:]...)
+			break
+		}
+	}
+	db.LastMod = db.now()
+	return nil
 }
 
 // GetName returns the database name or if unset the filename
@@ -149,20 +186,58 @@ func (db V3) List() []string {
 	return entries
 }
 
+//CalibrateIterations Returns the number of key stretching iterations which take roughly target time to compute on
+// this machine, never less than MinIterations.
+func CalibrateIterations(target time.Duration) uint32 {
+	var stretched [sha256.Size]byte
+	rounds := MinIterations
+	for {
+		start := time.Now()
+		for i := 0; i < rounds; i++ {
+			stretched = sha256.Sum256(stretched[:])
+		}
+		elapsed := time.Since(start)
+		// Measure for long enough that timer resolution doesn't skew the result
+		if elapsed < 20*time.Millisecond {
+			rounds *= 2
+			continue
+		}
+		iter := float64(rounds) * float64(target) / float64(elapsed)
+		switch {
+		case iter < MinIterations:
+			return MinIterations
+		case iter > math.MaxUint32:
+			return math.MaxUint32
+		}
+		return uint32(iter)
+	}
+}
+
 // NeedsSave Returns true if the db has unsaved modifiations
 func (db V3) NeedsSave() bool {
 	return db.LastSave.Before(db.LastMod)
 }
 
+//ReadOnly Returns true if the db was opened read-only, see OpenOptions
+func (db V3) ReadOnly() bool {
+	return db.readOnly
+}
+
+//LegacyVersion Returns 1 or 2 if the db was read from a Password Safe V1 or V2 file, which is saved as V3, otherwise 0
+func (db V3) LegacyVersion() int {
+	return db.legacyVersion
+}
+
 // NewV3 - create and initialize a new pwsafe.V3 db
 func NewV3(name, password string) *V3 {
 	var db V3
 	db.Name = name
-	// create the initial UUID
-	db.UUID = [16]byte(uuid.NewRandom().Array())
+	// create the initial UUID, if this fails it is retried on save
+	db.UUID, _ = db.newUUID()
 	// Set the DB version
 	db.Version = [2]byte{0x10, 0x03} // DB Format version 0x0310
 	db.Records = make(map[string]Record, 0)
+	db.Iter = DefaultIterations
 
 	// Set the password
 	db.SetPassword(password)
@@ -181,39 +256,144 @@ func (db V3) ListByGroup(group string) []string {
 	return entries
 }
 
+//SetIterations Sets the number of key stretching iterations, this takes effect the next time the password is set as
+//the key can only be stretched again with the password. If setting the password fails the iterations are unchanged.
+//The count must be no more than DefaultMaxIterations so the db can be opened with the default OpenOptions.
+func (db *V3) SetIterations(iter uint32) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	if iter < MinIterations || iter > DefaultMaxIterations {
+		return fmt.Errorf("Iterations must be from %d to %d", MinIterations, DefaultMaxIterations)
+	}
+	db.pendingIter = iter
+	return nil
+}
+
+// nextIterations returns the iterations to stretch the key with when the password is set, any set by SetIterations
+// otherwise the current count or the default if that is invalid
+func (db *V3) nextIterations() uint32 {
+	switch {
+	case db.pendingIter != 0:
+		return db.pendingIter
+	case db.Iter < MinIterations:
+		return DefaultIterations
+	}
+	return db.Iter
+}
+
 //SetPassword Sets the password that will be used to encrypt the file on next save
 func (db *V3) SetPassword(pw string) error {
-	// First recalculate the Salt and set iter
-	db.Iter = 86000
-	if _, err := rand.Read(db.Salt[:]); err != nil {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	// The salt and iterations only change once the salt is read so a failure leaves the key matching them, any
+	// pending iterations are dropped either way
+	iter := db.nextIterations()
+	db.pendingIter = 0
+	var salt [32]byte
+	if _, err := io.ReadFull(db.random(), salt[:]); err != nil {
 		return err
 	}
+	db.Salt, db.Iter = salt, iter
 	db.calculateStretchKey(pw)
-	db.LastMod = time.Now()
+	db.LastMod = db.now()
 	return nil
 }
 
 //SetRecord Adds or updates a record in the db
-func (db *V3) SetRecord(record Record) {
-	now := time.Now()
+func (db *V3) SetRecord(record Record) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	now := db.now()
 	//detect if there have been changes and only update if needed
 	oldRecord, prs := db.GetRecord(record.Title)
 	if prs {
 		equal, _ := recordsEqual(oldRecord, record, false)
 		if equal {
-			return
+			return nil
 		}
 	} else {
 		record.CreateTime = now
+		db.order = append(db.order, record.Title)
 	}
 
 	if record.UUID == [16]byte{} {
-		record.UUID = [16]byte(uuid.NewRandom().Array())
+		// if this fails the UUID is set on save
+		record.UUID, _ = db.newUUID()
 	}
+	updateExpiry(&record, oldRecord, prs, now)
 	record.ModTime = now
 	db.Records[record.Title] = record
 	db.LastMod = now
 	// todo add checking of db and record times to the tests
+	return nil
+}
+
+//newUUID Returns a random (version 4) UUID read from the db random source
+func (db *V3) newUUID() ([16]byte, error) {
+	var id [16]byte
+	if _, err := io.ReadFull(db.random(), id[:]); err != nil {
+		return id, err
+	}
+	id[6] = (id[6] & 0x0f) | 0x40 // Version 4
+	id[8] = (id[8] & 0x3f) | 0x80 // Variant is 10
+	return id, nil
+}
+
+//now Returns the current time from the db Clock
+func (db *V3) now() time.Time {
+	if db.Clock == nil {
+		return time.Now()
+	}
+	return db.Clock()
+}
+
+//random Returns the db source of randomness
+func (db *V3) random() io.Reader {
+	if db.Rand == nil {
+		return rand.Reader
+	}
+	return db.Rand
+}
+
+//recordOrder Returns the titles of all records in the order they are written to the file, that is the order they
+//were read followed by those added since. Any records added directly to the Records map are last sorted by title.
+func (db *V3) recordOrder() []string {
+	titles := make([]string, 0, len(db.Records))
+	seen := make(map[string]bool, len(db.Records))
+	for _, title := range db.order {
+		if _, prs := db.Records[title]; prs && !seen[title] {
+			seen[title] = true
+			titles = append(titles, title)
+		}
+	}
+	if len(titles) == len(db.Records) {
+		return titles
+	}
+	var unordered []string
+	for title := range db.Records {
+		if !seen[title] {
+			unordered = append(unordered, title)
+		}
+	}
+	sort.Strings(unordered)
+	return append(titles, unordered...)
+}
+
+//uniqueTitle Returns title if no record has it, otherwise title with the lowest free " (n)" suffix, used when
+//importing as records are identified by title
+func (db *V3) uniqueTitle(title string) string {
+	if _, prs := db.Records[title]; !prs {
+		return title
+	}
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)", title, i)
+		if _, prs := db.Records[candidate]; !prs {
+			return candidate
+		}
+	}
 }
 
 // TODO I may be able to replaces this with, binary.BigEndian.Uint32 or similar
@@ -1,9 +1,22 @@
 package pwsafe
 
-import "os"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
 
-//OpenPWSafeFile Opens a password safe v3 file and decrypts with the supplied password
+//OpenPWSafeFile Opens a password safe v3 or v4 file and decrypts with the supplied password, returning a *V3 or *V4.
+//A V1 or V2 file is read with ReadLegacy.
 func OpenPWSafeFile(dbPath string, passwd string) (DB, error) {
+	return OpenPWSafeFileContext(context.Background(), dbPath, passwd, OpenOptions{})
+}
+
+//OpenPWSafeFileContext Opens a password safe file like OpenPWSafeFile, stopping early if ctx is cancelled and
+//rejecting files which exceed the limits in opts
+func OpenPWSafeFileContext(ctx context.Context, dbPath string, passwd string, opts OpenOptions) (DB, error) {
 	var db V3
 
 	// Open the file
@@ -13,7 +26,45 @@ func OpenPWSafeFile(dbPath string, passwd string) (DB, error) {
 	}
 	defer f.Close()
 
-	_, err = db.Decrypt(f, passwd)
+	// Check the size before reading anything so oversized files fail fast
+	info, err := f.Stat()
+	if err != nil {
+		return &db, err
+	}
+	if info.Size() > opts.maxFileSize() {
+		return &db, ErrFileTooLarge
+	}
+
+	// V4 files are identified by their tag, anything else is read as V3 falling back to V1 and V2
+	var tag [len(v4Tag)]byte
+	if _, err := io.ReadFull(f, tag[:]); err == nil && string(tag[:]) == v4Tag {
+		v4db := &V4{}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return v4db, err
+		}
+		_, err = v4db.DecryptContext(ctx, f, passwd, opts)
+		v4db.LastSavePath = dbPath
+		return v4db, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return &db, err
+	}
+
+	_, err = db.DecryptContext(ctx, f, passwd, opts)
+	if errors.Is(err, ErrNotPWS3) {
+		// V1 and V2 files have no tag so the password is the only check
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return &db, err
+		}
+		legacy, legacyErr := ReadLegacy(f, passwd, opts)
+		if legacyErr == nil {
+			legacy.LastSavePath = dbPath
+			return legacy, nil
+		}
+		if !errors.Is(legacyErr, ErrInvalidPassword) {
+			err = legacyErr
+		}
+	}
 
 	db.LastSavePath = dbPath
 
@@ -21,9 +72,15 @@ func OpenPWSafeFile(dbPath string, passwd string) (DB, error) {
 }
 
 //WritePWSafeFile Writes a pwsafe.DB to disk, using either the specified path or the LastSavedPath
+//A db opened read-only is never written, ErrReadOnly is returned
 func WritePWSafeFile(db DB, path string) error {
-	//Only type pwsafe.V3 is currently supported
-	v3db := db.(*V3)
+	if db.ReadOnly() {
+		return ErrReadOnly
+	}
+	v3db, ok := BaseV3(db)
+	if !ok {
+		return fmt.Errorf("Writing a %T is not supported", db)
+	}
 
 	var savePath string
 	if path == "" {
@@ -39,7 +96,19 @@ func WritePWSafeFile(db DB, path string) error {
 	}
 	defer f.Close()
 
-	_, err = v3db.Encrypt(f)
+	_, err = db.Encrypt(f)
 
 	return err
 }
+
+//BaseV3 Returns the V3 holding the header fields and records of a *V3 or *V4 db, for a V4 this is its embedded V3 so
+//changes are made to the V4. The V3 methods writing a file must not be used on it as they would lose the V4 data.
+func BaseV3(db DB) (*V3, bool) {
+	switch typed := db.(type) {
+	case *V3:
+		return typed, true
+	case *V4:
+		return &typed.V3, true
+	}
+	return nil, false
+}
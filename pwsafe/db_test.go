@@ -1,8 +1,9 @@
 package pwsafe
 
 import (
-	"errors"
+	"bytes"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -58,7 +59,8 @@ func TestKeys(t *testing.T) {
 	db.calculateStretchKey("password")
 	assert.Equal(t, db.StretchedKey, expectedKey)
 
-	encryptedKeys := db.refreshEncryptedKeys()
+	encryptedKeys, err := db.refreshEncryptedKeys()
+	assert.Nil(t, err)
 	createdEncryptionKey := db.EncryptionKey
 	createdHMACKey := db.HMACKey
 
@@ -70,7 +72,62 @@ func TestKeys(t *testing.T) {
 
 func TestInvalidFile(t *testing.T) {
 	_, err := OpenPWSafeFile("./db.go", "password")
-	assert.Equal(t, err, errors.New("File is not a valid Password Safe v3 file"))
+	assert.Equal(t, ErrNotPWS3, err)
 	_, err = OpenPWSafeFile("./notafile", "password")
 	assert.NotNil(t, err)
 }
+
+func TestIterations(t *testing.T) {
+	db := NewV3("", "password")
+	assert.Equal(t, uint32(DefaultIterations), db.Iter)
+
+	assert.NotNil(t, db.SetIterations(MinIterations-1))
+	assert.NotNil(t, db.SetIterations(DefaultMaxIterations+1))
+	assert.Equal(t, uint32(DefaultIterations), db.Iter)
+
+	// The iterations only change with the password as the key must be stretched again
+	assert.Nil(t, db.SetIterations(4096))
+	assert.Equal(t, uint32(DefaultIterations), db.Iter)
+	var saved bytes.Buffer
+	_, err := db.Encrypt(&saved)
+	assert.Nil(t, err)
+	_, err = NewV3("", "").Decrypt(bytes.NewReader(saved.Bytes()), "password")
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.SetPassword("newpass"))
+	assert.Equal(t, uint32(4096), db.Iter)
+	saved.Reset()
+	_, err = db.Encrypt(&saved)
+	assert.Nil(t, err)
+	reopened := NewV3("", "")
+	_, err = reopened.Decrypt(bytes.NewReader(saved.Bytes()), "newpass")
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(4096), reopened.Iter)
+
+	// The iterations are kept when setting a new password
+	assert.Nil(t, db.SetPassword("another"))
+	assert.Equal(t, uint32(4096), db.Iter)
+
+	// A failed password change keeps the iterations and drops those pending
+	assert.Nil(t, db.SetIterations(5000))
+	db.Rand = bytes.NewReader(nil)
+	assert.NotNil(t, db.SetPassword("failed"))
+	assert.Equal(t, uint32(4096), db.Iter)
+	db.Rand = nil
+	assert.Nil(t, db.SetPassword("another"))
+	assert.Equal(t, uint32(4096), db.Iter)
+
+	// An unset or invalid iteration count is replaced with the default
+	var empty V3
+	assert.Nil(t, empty.SetPassword("password"))
+	assert.Equal(t, uint32(DefaultIterations), empty.Iter)
+}
+
+func TestCalibrateIterations(t *testing.T) {
+	assert.Equal(t, uint32(MinIterations), CalibrateIterations(0))
+
+	short := CalibrateIterations(10 * time.Millisecond)
+	long := CalibrateIterations(100 * time.Millisecond)
+	assert.True(t, short >= MinIterations)
+	assert.True(t, long > short)
+}
@@ -1,10 +1,21 @@
 package pwsafe
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/twofish"
 )
 
 func TestSimpleDB(t *testing.T) {
@@ -28,7 +39,7 @@ func TestSimpleDB(t *testing.T) {
 func TestBadHMAC(t *testing.T) {
 	// This test relies on the simple password db found at ./test_db/badHMAC.dat
 	_, err := OpenPWSafeFile("./test_dbs/badHMAC.dat", "password")
-	assert.Equal(t, errors.New("Error Calculated HMAC does not match read HMAC"), err)
+	assert.Equal(t, ErrBadHMAC, err)
 }
 
 func TestThreeDB(t *testing.T) {
@@ -126,7 +137,253 @@ func TestDBModifications(t *testing.T) {
 	assert.Equal(t, true, db.NeedsSave())
 
 }
+
+func TestReadOnly(t *testing.T) {
+	dbInterface, err := OpenPWSafeFileContext(context.Background(), "./test_dbs/simple.dat", "password", OpenOptions{ReadOnly: true})
+	assert.Nil(t, err)
+	assert.True(t, dbInterface.ReadOnly())
+	db := dbInterface.(*V3)
+
+	record, exists := db.GetRecord("Test entry")
+	assert.Equal(t, true, exists)
+	record.Username = "newuser"
+	assert.Equal(t, ErrReadOnly, db.SetRecord(record))
+	assert.Equal(t, ErrReadOnly, db.DeleteRecord("Test entry"))
+	assert.Equal(t, ErrReadOnly, db.SetPassword("newpass"))
+	assert.Equal(t, ErrReadOnly, db.SetIterations(DefaultIterations))
+	assert.Equal(t, false, db.NeedsSave())
+	record, exists = db.GetRecord("Test entry")
+	assert.Equal(t, true, exists)
+	assert.Equal(t, "test", record.Username)
+
+	// Nothing is written, even to a new path
+	path := "./test_dbs/readonly.dat"
+	assert.Equal(t, ErrReadOnly, WritePWSafeFile(db, path))
+	assert.Equal(t, ErrReadOnly, WritePWSafeFile(db, ""))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	lastSave, iv := db.LastSave, db.CBCIV
+	var buf bytes.Buffer
+	n, err := db.Encrypt(&buf)
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Equal(t, 0, n+buf.Len())
+	assert.Equal(t, lastSave, db.LastSave)
+	assert.Equal(t, iv, db.CBCIV)
+}
+
 func TestBadPassword(t *testing.T) {
 	_, err := OpenPWSafeFile("./test_dbs/simple.dat", "badpass")
-	assert.Equal(t, err, errors.New("Invalid Password"))
+	assert.Equal(t, ErrInvalidPassword, err)
+}
+
+func TestOpenLimits(t *testing.T) {
+	// simple.dat uses 2048 iterations
+	_, err := OpenPWSafeFileContext(context.Background(), "./test_dbs/simple.dat", "password", OpenOptions{MaxIterations: 1024})
+	assert.Equal(t, ErrIterationsExceeded, err)
+
+	_, err = OpenPWSafeFileContext(context.Background(), "./test_dbs/simple.dat", "password", OpenOptions{MaxFileSize: 100})
+	assert.Equal(t, ErrFileTooLarge, err)
+
+	// The size is also enforced for plain readers
+	f, err := os.Open("./test_dbs/simple.dat")
+	assert.Nil(t, err)
+	defer f.Close()
+	var db V3
+	_, err = db.DecryptContext(context.Background(), f, "password", OpenOptions{MaxFileSize: 100})
+	assert.Equal(t, ErrFileTooLarge, err)
+}
+
+func TestOpenCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := OpenPWSafeFileContext(ctx, "./test_dbs/simple.dat", "password", OpenOptions{})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestOpenProgress(t *testing.T) {
+	var lastDone, lastTotal uint32
+	opts := OpenOptions{
+		Progress: func(done, total uint32) {
+			assert.True(t, done >= lastDone)
+			lastDone, lastTotal = done, total
+		},
+	}
+	_, err := OpenPWSafeFileContext(context.Background(), "./test_dbs/simple.dat", "password", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2048), lastTotal)
+	assert.Equal(t, lastTotal, lastDone)
+}
+
+func TestTruncated(t *testing.T) {
+	raw, err := ioutil.ReadFile("./test_dbs/simple.dat")
+	assert.Nil(t, err)
+
+	// Cut off part of the HMAC, the EOF marker and the prelude
+	for _, size := range []int{len(raw) - 8, len(raw) - 40, 150} {
+		var db V3
+		_, err = db.Decrypt(bytes.NewReader(raw[:size]), "password")
+		assert.True(t, errors.Is(err, ErrTruncated), "size %d: %v", size, err)
+	}
+}
+
+func TestUnmarshalRecordErrors(t *testing.T) {
+	field := func(length uint32, btype byte, data []byte) []byte {
+		b := make([]byte, twofish.BlockSize)
+		binary.LittleEndian.PutUint32(b, length)
+		b[4] = btype
+		copy(b[5:], data)
+		return b
+	}
+	end := field(0, 0xff, nil)
+
+	var testData = []struct {
+		name   string
+		record []byte
+		err    error
+	}{
+		{name: "valid", record: append(field(4, 0x03, []byte("test")), end...)},
+		{name: "no end", record: field(4, 0x03, []byte("test")), err: ErrTruncated},
+		{name: "short field header", record: []byte{1, 0, 0}, err: ErrTruncated},
+		{name: "length past end", record: append(field(0xffffffff, 0x03, nil), end...), err: ErrTruncated},
+		{name: "unknown type", record: append(field(1, 0xfe, []byte{1}), end...), err: ErrUnknownField},
+		{name: "short time", record: append(field(3, 0x0c, []byte{1, 2, 3}), end...), err: ErrInvalidField},
+		{name: "short uuid", record: append(field(4, 0x01, []byte{1, 2, 3, 4}), end...), err: ErrInvalidField},
+		{name: "long protected", record: append(field(2, 0x15, []byte{1, 1}), end...), err: ErrInvalidField},
+	}
+
+	for _, test := range testData {
+		var record Record
+		err := unmarshalRecord(newFieldReader(bytes.NewReader(test.record), ioutil.Discard), record.decodeField)
+		if test.err == nil {
+			assert.Nil(t, err, test.name)
+			assert.Equal(t, "test", record.Title, test.name)
+		} else {
+			assert.True(t, errors.Is(err, test.err), "%s: %v", test.name, err)
+		}
+	}
+
+	// field errors identify the field
+	var record Record
+	err := unmarshalRecord(newFieldReader(bytes.NewReader(append(field(3, 0x0c, []byte{1, 2, 3}), end...)), ioutil.Discard), record.decodeField)
+	var fieldErr *FieldError
+	assert.True(t, errors.As(err, &fieldErr))
+	assert.Equal(t, byte(0x0c), fieldErr.Type)
+}
+
+// fuzzDBs are the test dbs and their passwords used to seed the fuzz tests
+var fuzzDBs = map[string]string{
+	"./test_dbs/simple.dat":  "password",
+	"./test_dbs/badHMAC.dat": "password",
+	"./test_dbs/three.dat":   "three3#;",
+}
+
+func FuzzDecrypt(f *testing.F) {
+	for path, passwd := range fuzzDBs {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(raw, passwd)
+	}
+	// Limit the iterations so each run is fast
+	opts := OpenOptions{MaxIterations: 4096}
+	f.Fuzz(func(t *testing.T, raw []byte, passwd string) {
+		var db V3
+		db.DecryptContext(context.Background(), bytes.NewReader(raw), passwd, opts)
+	})
+}
+
+// FuzzUnmarshalRecords fuzzes the decrypted data, which FuzzDecrypt rarely reaches as few mutations survive the
+// password check
+func FuzzUnmarshalRecords(f *testing.F) {
+	for path, passwd := range fuzzDBs {
+		db, err := OpenPWSafeFile(path, passwd)
+		if err != nil && !errors.Is(err, ErrBadHMAC) {
+			f.Fatal(err)
+		}
+		v3db := db.(*V3)
+		var fields fieldWriter
+		v3db.marshalHeader(&fields)
+		for _, record := range v3db.Records {
+			record.marshalRecord(&fields)
+		}
+		f.Add(fields.record)
+	}
+	f.Fuzz(func(t *testing.T, decrypted []byte) {
+		var db V3
+		fields := newFieldReader(bytes.NewReader(decrypted), ioutil.Discard)
+		if err := unmarshalRecord(fields, db.decodeField); err != nil {
+			return
+		}
+		db.unmarshalRecords(fields)
+	})
+}
+
+// TestDecryptReaders verifies readers which return short reads or data with the final error are handled
+func TestDecryptReaders(t *testing.T) {
+	raw, err := ioutil.ReadFile("./test_dbs/simple.dat")
+	assert.Nil(t, err)
+
+	readers := map[string]io.Reader{
+		"one byte": iotest.OneByteReader(bytes.NewReader(raw)),
+		"half":     iotest.HalfReader(bytes.NewReader(raw)),
+		"data err": iotest.DataErrReader(bytes.NewReader(raw)),
+	}
+	for name, reader := range readers {
+		var db V3
+		n, err := db.Decrypt(reader, "password")
+		assert.Nil(t, err, name)
+		assert.Equal(t, len(raw), n, name)
+		assert.Equal(t, 1, len(db.Records), name)
+	}
+
+	// A read error part way through is returned
+	var db V3
+	_, err = db.Decrypt(iotest.TimeoutReader(iotest.OneByteReader(bytes.NewReader(raw))), "password")
+	assert.Equal(t, iotest.ErrTimeout, err)
+
+	// So is data after the HMAC
+	_, err = db.Decrypt(bytes.NewReader(append(raw, 0)), "password")
+	assert.NotNil(t, err)
+}
+
+// benchmarkDB returns an encrypted db with the given number of records and the minimum iterations
+func benchmarkDB(b *testing.B, records int) []byte {
+	db := NewV3("benchmark", "")
+	db.SetIterations(MinIterations)
+	db.SetPassword("password")
+	for i := 0; i < records; i++ {
+		db.SetRecord(Record{
+			Title:    fmt.Sprintf("record %d", i),
+			Group:    fmt.Sprintf("group %d", i%50),
+			Username: "user",
+			Password: "password",
+			URL:      "https://example.com/login",
+			Notes:    "some notes which span more than a single block",
+		})
+	}
+	var buf bytes.Buffer
+	if _, err := db.Encrypt(&buf); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// BenchmarkDecrypt reports the time per record which should stay constant as the db grows
+func BenchmarkDecrypt(b *testing.B) {
+	for _, records := range []int{1000, 10000, 50000} {
+		raw := benchmarkDB(b, records)
+		b.Run(strconv.Itoa(records), func(b *testing.B) {
+			b.ReportAllocs()
+			start := time.Now()
+			b.SetBytes(int64(len(raw)))
+			for i := 0; i < b.N; i++ {
+				var db V3
+				if _, err := db.Decrypt(bytes.NewReader(raw), "password"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(b.N*records), "ns/record")
+		})
+	}
 }
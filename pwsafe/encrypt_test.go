@@ -1,10 +1,17 @@
 package pwsafe
 
 import (
+	"bytes"
+	"crypto/cipher"
+	"io/ioutil"
+	"math/rand"
 	"os"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/twofish"
 )
 
 // TestSaveSimpleDB - save simple DB, reopen and verify contents match the original but keys don't
@@ -65,3 +72,97 @@ func TestNewV3(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, true, equal)
 }
+
+// TestSaveIterations verifies the iteration setting is written to and read from the file
+func TestSaveIterations(t *testing.T) {
+	db := NewV3("", "password")
+	assert.Nil(t, db.SetIterations(5000))
+	assert.Nil(t, db.SetPassword("password"))
+
+	path := "./test_dbs/iterations.dat"
+	err := WritePWSafeFile(db, path)
+	defer os.Remove(path)
+	assert.Nil(t, err)
+
+	readDB, err := OpenPWSafeFile(path, "password")
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(5000), readDB.(*V3).Iter)
+}
+
+// deterministicDB returns a db with a seeded random source and fixed clock holding the given records in order
+func deterministicDB(titles ...string) *V3 {
+	db := NewV3("deterministic", "password")
+	db.Rand = rand.New(rand.NewSource(1))
+	db.Clock = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+	db.UUID, _ = db.newUUID()
+	db.SetIterations(MinIterations)
+	db.SetPassword("password")
+	for _, title := range titles {
+		db.SetRecord(Record{Title: title, Password: "password", Group: "group"})
+	}
+	return db
+}
+
+// TestDeterministicEncrypt verifies that with the same random source and clock the output is byte for byte identical
+// and that the Version header field is first
+func TestDeterministicEncrypt(t *testing.T) {
+	var first, second bytes.Buffer
+	db := deterministicDB("one", "two", "three")
+	_, err := db.Encrypt(&first)
+	assert.Nil(t, err)
+	_, err = deterministicDB("one", "two", "three").Encrypt(&second)
+	assert.Nil(t, err)
+	assert.Equal(t, first.Bytes(), second.Bytes())
+
+	// The first encrypted block holds the start of the version field
+	block, _ := twofish.NewCipher(db.EncryptionKey[:])
+	field := make([]byte, twofish.BlockSize)
+	cipher.NewCBCDecrypter(block, db.CBCIV[:]).CryptBlocks(field, first.Bytes()[152:152+twofish.BlockSize])
+	assert.Equal(t, []byte{2, 0, 0, 0, byte(HeaderVersion), 0x10, 0x03}, field[:7])
+}
+
+// TestRecordOrder verifies records are written in the order read followed by those added
+func TestRecordOrder(t *testing.T) {
+	var buf bytes.Buffer
+	titles := []string{"zulu", "alpha", "mike", "bravo"}
+	_, err := deterministicDB(titles...).Encrypt(&buf)
+	assert.Nil(t, err)
+
+	var db V3
+	_, err = db.Decrypt(bytes.NewReader(buf.Bytes()), "password")
+	assert.Nil(t, err)
+	assert.Equal(t, titles, db.recordOrder())
+
+	db.DeleteRecord("alpha")
+	db.SetRecord(Record{Title: "charlie", Password: "password"})
+	db.Records["delta"] = Record{Title: "delta", Password: "password"}
+	db.Records["beta"] = Record{Title: "beta", Password: "password"}
+	buf.Reset()
+	_, err = db.Encrypt(&buf)
+	assert.Nil(t, err)
+
+	var reread V3
+	_, err = reread.Decrypt(bytes.NewReader(buf.Bytes()), "password")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"zulu", "mike", "bravo", "charlie", "beta", "delta"}, reread.recordOrder())
+}
+
+// BenchmarkEncrypt reports the time per record which should stay constant as the db grows
+func BenchmarkEncrypt(b *testing.B) {
+	for _, records := range []int{1000, 10000, 50000} {
+		var db V3
+		if _, err := db.Decrypt(bytes.NewReader(benchmarkDB(b, records)), "password"); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(strconv.Itoa(records), func(b *testing.B) {
+			b.ReportAllocs()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.Encrypt(ioutil.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(b.N*records), "ns/record")
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package pwsafe
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReadOnly is returned by every method which would modify a db opened read-only
+var ErrReadOnly = errors.New("DB is open read-only")
+
+// Errors returned when reading a db, these may be wrapped so compare using errors.Is
+var (
+	// ErrNotPWS3 is returned when the data does not start with the Password Safe v3 tag
+	ErrNotPWS3 = errors.New("File is not a valid Password Safe v3 file")
+	// ErrNotPWS4 is returned when the data does not start with the Password Safe v4 tag
+	ErrNotPWS4 = errors.New("File is not a valid Password Safe v4 file")
+	// ErrInvalidPassword is returned when the password does not match the stored key hash
+	ErrInvalidPassword = errors.New("Invalid Password")
+	// ErrBadHMAC is returned when the calculated HMAC does not match the one stored, indicating corruption or tampering
+	ErrBadHMAC = errors.New("Error Calculated HMAC does not match read HMAC")
+	// ErrTruncated is returned when the data ends before a complete structure could be read
+	ErrTruncated = errors.New("DB is truncated")
+	// ErrBlockAlignment is returned when the encrypted data is not a whole number of cipher blocks
+	ErrBlockAlignment = errors.New("Encrypted data is not a multiple of the block size")
+	// ErrUnknownField is returned for a field type not defined in the spec
+	ErrUnknownField = errors.New("Encountered unknown Record Field type")
+	// ErrInvalidField is returned when a field's data does not fit its type, for example a time of the wrong length
+	ErrInvalidField = errors.New("Invalid field data")
+	// ErrIterationsExceeded is returned for a db whose key stretch iterations exceed the configured maximum
+	ErrIterationsExceeded = errors.New("DB key stretch iterations exceed the maximum allowed")
+	// ErrFileTooLarge is returned for a db larger than the configured maximum size
+	ErrFileTooLarge = errors.New("DB file is larger than the maximum size allowed")
+	// ErrShareExpired is returned when importing a sharing bundle after its expiry time
+	ErrShareExpired = errors.New("Sharing bundle has expired")
+	// ErrNotKDBX is returned when importing a file which is not a KeePass KDBX file or uses unsupported features
+	ErrNotKDBX = errors.New("File is not a supported KeePass KDBX file")
+)
+
+//FieldError Describes a header or record field which could not be parsed
+type FieldError struct {
+	// Record is the index of the record in the file, -1 for the header
+	Record int
+	// Type is the field type byte
+	Type byte
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	if e.Record < 0 {
+		return fmt.Sprintf("Error parsing header field 0x%02x - %v", e.Type, e.Err)
+	}
+	return fmt.Sprintf("Error parsing record %d field 0x%02x - %v", e.Record, e.Type, e.Err)
+}
+
+// Unwrap returns the underlying error so errors.Is can match the sentinel errors
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,83 @@
+package pwsafe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MaxExpiryInterval is the longest password expiry interval in days allowed by the spec
+const MaxExpiryInterval = 3650
+
+//ExpiryInterval Returns the number of days after each password change that the password expires, 0 if it doesn't
+//expire on a schedule
+func (r Record) ExpiryInterval() int {
+	return int(binary.LittleEndian.Uint32(r.PasswordExpiryInterval[:]))
+}
+
+//SetExpiryInterval Sets the number of days after each password change that the password expires, 0 for none. The
+//PasswordExpiry is updated from it by SetRecord.
+func (r *Record) SetExpiryInterval(days int) error {
+	if days < 0 || days > MaxExpiryInterval {
+		return fmt.Errorf("%w, a password expiry interval is 0 to %d days not %d", ErrInvalidField, MaxExpiryInterval,
+			days)
+	}
+	binary.LittleEndian.PutUint32(r.PasswordExpiryInterval[:], uint32(days))
+	return nil
+}
+
+//Expired Returns true if the record password has an expiry at or before t
+func (r Record) Expired(t time.Time) bool {
+	return !r.PasswordExpiry.IsZero() && !t.Before(r.PasswordExpiry)
+}
+
+// updateExpiry sets the password modification time and next expiry of a record set to replace old, prs is false for
+// a new record. A new record keeps a password modification time it already has, as when renamed or imported. The
+// expiry is set from the interval when the password changes, when the interval changes or if there is none. Removing
+// the interval clears the expiry it set unless a new expiry is given.
+func updateExpiry(record *Record, old Record, prs bool, now time.Time) {
+	changed := record.Password != old.Password
+	if !prs {
+		changed = record.Password != "" && record.PasswordModTime.IsZero()
+	}
+	if changed {
+		record.PasswordModTime = now
+	}
+
+	days := record.ExpiryInterval()
+	if days == 0 {
+		if prs && old.ExpiryInterval() != 0 && record.PasswordExpiry.Equal(old.PasswordExpiry) {
+			record.PasswordExpiry = time.Time{}
+		}
+		return
+	}
+	intervalChanged := prs && days != old.ExpiryInterval() && record.PasswordExpiry.Equal(old.PasswordExpiry)
+	if changed || intervalChanged || record.PasswordExpiry.IsZero() {
+		from := record.PasswordModTime
+		if from.IsZero() {
+			from = now
+		}
+		record.PasswordExpiry = from.AddDate(0, 0, days)
+	}
+}
+
+//ExpiringWithin Returns the titles of the records whose passwords expire within the number of days, including those
+//already expired, soonest first. 0 days returns only the expired records.
+func (db V3) ExpiringWithin(days int) []string {
+	limit := db.now().AddDate(0, 0, days)
+	var titles []string
+	for title, record := range db.Records {
+		if record.Expired(limit) {
+			titles = append(titles, title)
+		}
+	}
+	sort.Slice(titles, func(i, j int) bool {
+		a, b := db.Records[titles[i]].PasswordExpiry, db.Records[titles[j]].PasswordExpiry
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return titles[i] < titles[j]
+	})
+	return titles
+}
@@ -0,0 +1,125 @@
+package pwsafe
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryInterval(t *testing.T) {
+	var record Record
+	assert.Equal(t, 0, record.ExpiryInterval())
+	assert.Nil(t, record.SetExpiryInterval(90))
+	assert.Equal(t, [4]byte{90, 0, 0, 0}, record.PasswordExpiryInterval)
+	assert.Equal(t, 90, record.ExpiryInterval())
+	for _, invalid := range []int{-1, MaxExpiryInterval + 1} {
+		assert.True(t, errors.Is(record.SetExpiryInterval(invalid), ErrInvalidField))
+	}
+	assert.Equal(t, 90, record.ExpiryInterval())
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	record.PasswordExpiry = now
+	assert.True(t, record.Expired(now))
+	assert.False(t, record.Expired(now.Add(-time.Second)))
+	assert.False(t, Record{}.Expired(now))
+}
+
+func TestSetRecordExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := NewV3("", "password")
+	db.Clock = func() time.Time { return now }
+	day := 24 * time.Hour
+
+	record := Record{Title: "expiring", Password: "first"}
+	assert.Nil(t, record.SetExpiryInterval(30))
+	assert.Nil(t, db.SetRecord(record))
+	record, _ = db.GetRecord("expiring")
+	assert.Equal(t, now, record.PasswordModTime)
+	assert.Equal(t, now.Add(30*day), record.PasswordExpiry)
+
+	// other changes don't move the expiry
+	now = now.Add(day)
+	record.Notes = "changed"
+	assert.Nil(t, db.SetRecord(record))
+	record, _ = db.GetRecord("expiring")
+	assert.Equal(t, now.Add(-day), record.PasswordModTime)
+	assert.Equal(t, now.Add(29*day), record.PasswordExpiry)
+
+	// a new password expires an interval from the change
+	record.Password = "second"
+	assert.Nil(t, db.SetRecord(record))
+	record, _ = db.GetRecord("expiring")
+	assert.Equal(t, now, record.PasswordModTime)
+	assert.Equal(t, now.Add(30*day), record.PasswordExpiry)
+
+	// a new interval is from the last change
+	now = now.Add(day)
+	assert.Nil(t, record.SetExpiryInterval(7))
+	assert.Nil(t, db.SetRecord(record))
+	record, _ = db.GetRecord("expiring")
+	assert.Equal(t, now.Add(6*day), record.PasswordExpiry)
+
+	// removing the interval clears the expiry it set, unless another is given
+	later := now.Add(100 * day)
+	now = later
+	assert.Equal(t, []string{"expiring"}, db.ExpiringWithin(0))
+	assert.Nil(t, record.SetExpiryInterval(0))
+	assert.Nil(t, db.SetRecord(record))
+	record, _ = db.GetRecord("expiring")
+	assert.True(t, record.PasswordExpiry.IsZero())
+	assert.Equal(t, 0, len(db.ExpiringWithin(0)))
+	assert.Nil(t, record.SetExpiryInterval(7))
+	assert.Nil(t, db.SetRecord(record))
+	record, _ = db.GetRecord("expiring")
+	assert.Nil(t, record.SetExpiryInterval(0))
+	record.PasswordExpiry = later.Add(day)
+	assert.Nil(t, db.SetRecord(record))
+	record, _ = db.GetRecord("expiring")
+	assert.Equal(t, later.Add(day), record.PasswordExpiry)
+	assert.Nil(t, record.SetExpiryInterval(7))
+	record.PasswordExpiry = time.Time{}
+	assert.Nil(t, db.SetRecord(record))
+	record, _ = db.GetRecord("expiring")
+	assert.Equal(t, later.Add(-94*day), record.PasswordExpiry)
+
+	// renaming keeps the password times
+	assert.Nil(t, db.DeleteRecord("expiring"))
+	record.Title = "renamed"
+	assert.Nil(t, db.SetRecord(record))
+	renamed, _ := db.GetRecord("renamed")
+	assert.Equal(t, record.PasswordModTime, renamed.PasswordModTime)
+	assert.Equal(t, record.PasswordExpiry, renamed.PasswordExpiry)
+
+	// an expiry without an interval is kept
+	fixed := now.Add(100 * day)
+	assert.Nil(t, db.SetRecord(Record{Title: "fixed", Password: "pw", PasswordExpiry: fixed}))
+	record, _ = db.GetRecord("fixed")
+	assert.Equal(t, fixed, record.PasswordExpiry)
+	record.Password = "new"
+	assert.Nil(t, db.SetRecord(record))
+	record, _ = db.GetRecord("fixed")
+	assert.Equal(t, fixed, record.PasswordExpiry)
+
+	// imported records keep their password times
+	imported := Record{Title: "imported", Password: "pw", PasswordModTime: now.Add(-50 * day)}
+	assert.Nil(t, imported.SetExpiryInterval(30))
+	assert.Equal(t, []string{"imported"}, db.importRecords([]Record{imported}, nil))
+	record, _ = db.GetRecord("imported")
+	assert.Equal(t, now.Add(-50*day), record.PasswordModTime)
+	assert.Equal(t, now.Add(-20*day), record.PasswordExpiry)
+}
+
+func TestExpiringWithin(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := NewV3("", "password")
+	db.Clock = func() time.Time { return now }
+	for title, days := range map[string]int{"expired": -1, "today": 0, "week": 7, "month": 30, "later": 31} {
+		db.SetRecord(Record{Title: title, Password: "pw", PasswordExpiry: now.AddDate(0, 0, days)})
+	}
+	db.SetRecord(Record{Title: "never", Password: "pw"})
+	assert.Equal(t, []string{"expired", "today"}, db.ExpiringWithin(0))
+	assert.Equal(t, []string{"expired", "today", "week"}, db.ExpiringWithin(7))
+	assert.Equal(t, []string{"expired", "today", "week", "month"}, db.ExpiringWithin(30))
+}
@@ -0,0 +1,392 @@
+package pwsafe
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	pseudoRand "math/rand"
+	"time"
+
+	"golang.org/x/crypto/twofish"
+)
+
+// FieldType identifies a header or record field, header and record fields use separate sets of values.
+// The values are defined in the spec - https://github.com/pwsafe/pwsafe/blob/master/docs/formatV3.txt
+type FieldType byte
+
+// Header field types
+const (
+	HeaderVersion          FieldType = 0x00
+	HeaderUUID             FieldType = 0x01
+	HeaderPreferences      FieldType = 0x02
+	HeaderTree             FieldType = 0x03
+	HeaderLastSave         FieldType = 0x04
+	HeaderLastSaveBy       FieldType = 0x06
+	HeaderLastSaveUser     FieldType = 0x07
+	HeaderLastSaveHost     FieldType = 0x08
+	HeaderName             FieldType = 0x09
+	HeaderDescription      FieldType = 0x0a
+	HeaderFilters          FieldType = 0x0b
+	HeaderRecentlyUsed     FieldType = 0x0f
+	HeaderPasswordPolicies FieldType = 0x10
+	HeaderEmptyGroups      FieldType = 0x11
+)
+
+// Record field types
+const (
+	RecordUUID                   FieldType = 0x01
+	RecordGroup                  FieldType = 0x02
+	RecordTitle                  FieldType = 0x03
+	RecordUsername               FieldType = 0x04
+	RecordNotes                  FieldType = 0x05
+	RecordPassword               FieldType = 0x06
+	RecordCreateTime             FieldType = 0x07
+	RecordPasswordModTime        FieldType = 0x08
+	RecordAccessTime             FieldType = 0x09
+	RecordPasswordExpiry         FieldType = 0x0a
+	RecordModTime                FieldType = 0x0c
+	RecordURL                    FieldType = 0x0d
+	RecordAutotype               FieldType = 0x0e
+	RecordPasswordHistory        FieldType = 0x0f
+	RecordPasswordPolicy         FieldType = 0x10
+	RecordPasswordExpiryInterval FieldType = 0x11
+	RecordRunCommand             FieldType = 0x12
+	RecordDoubleClickAction      FieldType = 0x13
+	RecordEmail                  FieldType = 0x14
+	RecordProtectedEntry         FieldType = 0x15
+	RecordShiftDoubleClickAction FieldType = 0x17
+	RecordPasswordPolicyName     FieldType = 0x18
+)
+
+// FieldEnd marks the end of the header and of each record
+const FieldEnd FieldType = 0xff
+
+// headerField is the codec for a single header field, encode returns one value per field to write, empty if the field
+// is unset. Some header fields such as empty groups may repeat.
+type headerField struct {
+	Type   FieldType
+	Name   string
+	encode func(*V3) [][]byte
+	decode func(*V3, []byte) error
+}
+
+// recordField is the codec for a single record field, encode returns nil if the field is unset
+type recordField struct {
+	Type   FieldType
+	Name   string
+	encode func(*Record) []byte
+	decode func(*Record, []byte) error
+}
+
+// headerFields are all supported header fields in the order they are written, the spec requires Version first
+var headerFields = []headerField{
+	{HeaderVersion, "Version",
+		func(db *V3) [][]byte { return single(encodeArray(db.Version[:])) },
+		func(db *V3, data []byte) error { return decodeArray(db.Version[:], data) }},
+	{HeaderUUID, "UUID",
+		func(db *V3) [][]byte { return single(encodeArray(db.UUID[:])) },
+		func(db *V3, data []byte) error { return decodeArray(db.UUID[:], data) }},
+	{HeaderPreferences, "Preferences",
+		func(db *V3) [][]byte { return single(encodeString(db.Preferences)) },
+		func(db *V3, data []byte) error { return decodeString(&db.Preferences, data) }},
+	{HeaderTree, "Tree",
+		func(db *V3) [][]byte { return single(encodeString(db.Tree)) },
+		func(db *V3, data []byte) error { return decodeString(&db.Tree, data) }},
+	{HeaderLastSave, "LastSave",
+		func(db *V3) [][]byte { return single(encodeTime(db.LastSave)) },
+		func(db *V3, data []byte) error { return decodeTime(&db.LastSave, data) }},
+	{HeaderLastSaveBy, "LastSaveBy",
+		func(db *V3) [][]byte { return single(encodeBytes(db.LastSaveBy)) },
+		func(db *V3, data []byte) error { return decodeBytes(&db.LastSaveBy, data) }},
+	{HeaderLastSaveUser, "LastSaveUser",
+		func(db *V3) [][]byte { return single(encodeBytes(db.LastSaveUser)) },
+		func(db *V3, data []byte) error { return decodeBytes(&db.LastSaveUser, data) }},
+	{HeaderLastSaveHost, "LastSaveHost",
+		func(db *V3) [][]byte { return single(encodeBytes(db.LastSaveHost)) },
+		func(db *V3, data []byte) error { return decodeBytes(&db.LastSaveHost, data) }},
+	{HeaderName, "Name",
+		func(db *V3) [][]byte { return single(encodeString(db.Name)) },
+		func(db *V3, data []byte) error { return decodeString(&db.Name, data) }},
+	{HeaderDescription, "Description",
+		func(db *V3) [][]byte { return single(encodeString(db.Description)) },
+		func(db *V3, data []byte) error { return decodeString(&db.Description, data) }},
+	{HeaderFilters, "Filters",
+		func(db *V3) [][]byte { return single(encodeString(db.Filters)) },
+		func(db *V3, data []byte) error { return decodeString(&db.Filters, data) }},
+	{HeaderRecentlyUsed, "RecentyUsed",
+		func(db *V3) [][]byte { return single(encodeString(db.RecentyUsed)) },
+		func(db *V3, data []byte) error { return decodeString(&db.RecentyUsed, data) }},
+	{HeaderPasswordPolicies, "PasswordPolicy",
+		func(db *V3) [][]byte { return single(encodeString(db.PasswordPolicy)) },
+		func(db *V3, data []byte) error { return decodeString(&db.PasswordPolicy, data) }},
+	{HeaderEmptyGroups, "EmptyGroups",
+		func(db *V3) [][]byte {
+			values := make([][]byte, 0, len(db.EmptyGroups))
+			for _, group := range db.EmptyGroups {
+				values = append(values, []byte(group))
+			}
+			return values
+		},
+		func(db *V3, data []byte) error {
+			db.EmptyGroups = append(db.EmptyGroups, string(data))
+			return nil
+		}},
+}
+
+// recordFields are all supported record fields in the order they are written
+var recordFields = []recordField{
+	{RecordUUID, "UUID",
+		func(r *Record) []byte { return encodeArray(r.UUID[:]) },
+		func(r *Record, data []byte) error { return decodeArray(r.UUID[:], data) }},
+	{RecordGroup, "Group",
+		func(r *Record) []byte { return encodeString(r.Group) },
+		func(r *Record, data []byte) error { return decodeString(&r.Group, data) }},
+	{RecordTitle, "Title",
+		func(r *Record) []byte { return encodeString(r.Title) },
+		func(r *Record, data []byte) error { return decodeString(&r.Title, data) }},
+	{RecordUsername, "Username",
+		func(r *Record) []byte { return encodeString(r.Username) },
+		func(r *Record, data []byte) error { return decodeString(&r.Username, data) }},
+	{RecordNotes, "Notes",
+		func(r *Record) []byte { return encodeString(r.Notes) },
+		func(r *Record, data []byte) error { return decodeString(&r.Notes, data) }},
+	{RecordPassword, "Password",
+		func(r *Record) []byte { return encodeString(r.Password) },
+		func(r *Record, data []byte) error { return decodeString(&r.Password, data) }},
+	{RecordCreateTime, "CreateTime",
+		func(r *Record) []byte { return encodeTime(r.CreateTime) },
+		func(r *Record, data []byte) error { return decodeTime(&r.CreateTime, data) }},
+	{RecordPasswordModTime, "PasswordModTime",
+		func(r *Record) []byte { return encodeTime(r.PasswordModTime) },
+		func(r *Record, data []byte) error { return decodeTime(&r.PasswordModTime, data) }},
+	{RecordAccessTime, "AccessTime",
+		func(r *Record) []byte { return encodeTime(r.AccessTime) },
+		func(r *Record, data []byte) error { return decodeTime(&r.AccessTime, data) }},
+	{RecordPasswordExpiry, "PasswordExpiry",
+		func(r *Record) []byte { return encodeTime(r.PasswordExpiry) },
+		func(r *Record, data []byte) error { return decodeTime(&r.PasswordExpiry, data) }},
+	{RecordModTime, "ModTime",
+		func(r *Record) []byte { return encodeTime(r.ModTime) },
+		func(r *Record, data []byte) error { return decodeTime(&r.ModTime, data) }},
+	{RecordURL, "URL",
+		func(r *Record) []byte { return encodeString(r.URL) },
+		func(r *Record, data []byte) error { return decodeString(&r.URL, data) }},
+	{RecordAutotype, "Autotype",
+		func(r *Record) []byte { return encodeString(r.Autotype) },
+		func(r *Record, data []byte) error { return decodeString(&r.Autotype, data) }},
+	{RecordPasswordHistory, "PasswordHistory",
+		func(r *Record) []byte { return encodeString(r.PasswordHistory) },
+		func(r *Record, data []byte) error { return decodeString(&r.PasswordHistory, data) }},
+	{RecordPasswordPolicy, "PasswordPolicy",
+		func(r *Record) []byte { return encodeString(r.PasswordPolicy) },
+		func(r *Record, data []byte) error { return decodeString(&r.PasswordPolicy, data) }},
+	{RecordPasswordExpiryInterval, "PasswordExpiryInterval",
+		func(r *Record) []byte { return encodeArray(r.PasswordExpiryInterval[:]) },
+		func(r *Record, data []byte) error { return decodeArray(r.PasswordExpiryInterval[:], data) }},
+	{RecordRunCommand, "RunCommand",
+		func(r *Record) []byte { return encodeString(r.RunCommand) },
+		func(r *Record, data []byte) error { return decodeString(&r.RunCommand, data) }},
+	{RecordDoubleClickAction, "DoubleClickAction",
+		func(r *Record) []byte { return encodeArray(r.DoubleClickAction[:]) },
+		func(r *Record, data []byte) error { return decodeArray(r.DoubleClickAction[:], data) }},
+	{RecordEmail, "Email",
+		func(r *Record) []byte { return encodeString(r.Email) },
+		func(r *Record, data []byte) error { return decodeString(&r.Email, data) }},
+	{RecordProtectedEntry, "ProtectedEntry",
+		func(r *Record) []byte { return encodeByte(r.ProtectedEntry) },
+		func(r *Record, data []byte) error { return decodeByte(&r.ProtectedEntry, data) }},
+	{RecordShiftDoubleClickAction, "ShiftDoubleClickAction",
+		func(r *Record) []byte { return encodeArray(r.ShiftDoubleClickAction[:]) },
+		func(r *Record, data []byte) error { return decodeArray(r.ShiftDoubleClickAction[:], data) }},
+	{RecordPasswordPolicyName, "PasswordPolicyName",
+		func(r *Record) []byte { return encodeString(r.PasswordPolicyName) },
+		func(r *Record, data []byte) error { return decodeString(&r.PasswordPolicyName, data) }},
+}
+
+// headerFieldsByType and recordFieldsByType index the field codecs by type for decoding
+var (
+	headerFieldsByType [256]*headerField
+	recordFieldsByType [256]*recordField
+)
+
+func init() {
+	for i := range headerFields {
+		headerFieldsByType[headerFields[i].Type] = &headerFields[i]
+	}
+	for i := range recordFields {
+		recordFieldsByType[recordFields[i].Type] = &recordFields[i]
+	}
+}
+
+// decodeField sets the header field of type t from data
+func (db *V3) decodeField(t FieldType, data []byte) error {
+	field := headerFieldsByType[t]
+	if field == nil {
+		return ErrUnknownField
+	}
+	return field.decode(db, data)
+}
+
+// decodeField sets the record field of type t from data
+func (r *Record) decodeField(t FieldType, data []byte) error {
+	field := recordFieldsByType[t]
+	if field == nil {
+		return ErrUnknownField
+	}
+	return field.decode(r, data)
+}
+
+// single returns the value as the only value of a header field or no values if it is nil
+func single(value []byte) [][]byte {
+	if value == nil {
+		return nil
+	}
+	return [][]byte{value}
+}
+
+func encodeString(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	return []byte(s)
+}
+
+func decodeString(s *string, data []byte) error {
+	*s = string(data)
+	return nil
+}
+
+// encodeTime encodes a time as a 32 bit little endian time_t, falling back to 64 bits for times outside that range
+func encodeTime(t time.Time) []byte {
+	if t.IsZero() {
+		return nil
+	}
+	unix := t.Unix()
+	if unix >= 0 && unix <= math.MaxUint32 {
+		data := make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, uint32(unix))
+		return data
+	}
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(unix))
+	return data
+}
+
+// decodeTime decodes a 32 or 64 bit little endian time_t
+func decodeTime(t *time.Time, data []byte) error {
+	switch len(data) {
+	case 4:
+		*t = time.Unix(int64(binary.LittleEndian.Uint32(data)), 0)
+	case 8:
+		*t = time.Unix(int64(binary.LittleEndian.Uint64(data)), 0)
+	default:
+		return ErrInvalidField
+	}
+	return nil
+}
+
+// encodeArray returns the array data or nil if it is all zero
+func encodeArray(array []byte) []byte {
+	for _, b := range array {
+		if b != 0 {
+			return array
+		}
+	}
+	return nil
+}
+
+// decodeArray copies data into the array which must be exactly the same length
+func decodeArray(array []byte, data []byte) error {
+	if len(data) != len(array) {
+		return ErrInvalidField
+	}
+	copy(array, data)
+	return nil
+}
+
+func encodeByte(b byte) []byte {
+	if b == 0 {
+		return nil
+	}
+	return []byte{b}
+}
+
+func decodeByte(b *byte, data []byte) error {
+	if len(data) != 1 {
+		return ErrInvalidField
+	}
+	*b = data[0]
+	return nil
+}
+
+func encodeBytes(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+func decodeBytes(b *[]byte, data []byte) error {
+	*b = append([]byte(nil), data...)
+	return nil
+}
+
+// fieldWriter builds the binary format of a header or record as specified in the spec along with the field values
+// used for hmac calculations, it may be reset and reused
+type fieldWriter struct {
+	record []byte
+	values []byte
+	rand   io.Reader // source for the padding, math/rand if nil
+}
+
+func (w *fieldWriter) reset() {
+	w.record = w.record[:0]
+	w.values = w.values[:0]
+}
+
+// add appends a field, each is the length, type and data padded with random values to a block boundary. The padding
+// isn't secret so a failure reading random values just leaves it zeroed.
+func (w *fieldWriter) add(t FieldType, data []byte) {
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(data)))
+	w.record = append(w.record, length[:]...)
+	w.record = append(w.record, byte(t))
+	w.record = append(w.record, data...)
+	w.values = append(w.values, data...)
+
+	usedBlockSpace := (len(data) + 5) % twofish.BlockSize
+	if usedBlockSpace != 0 {
+		padding := twofish.BlockSize - usedBlockSpace
+		w.record = append(w.record, make([]byte, padding)...)
+		if w.rand == nil {
+			pseudoRand.Read(w.record[len(w.record)-padding:])
+		} else {
+			io.ReadFull(w.rand, w.record[len(w.record)-padding:])
+		}
+	}
+}
+
+// end finishes the header or record with the END field
+func (w *fieldWriter) end() {
+	w.add(FieldEnd, nil)
+}
+
+// marshalHeader adds all set header fields to w
+func (db *V3) marshalHeader(w *fieldWriter) {
+	for _, field := range headerFields {
+		for _, data := range field.encode(db) {
+			w.add(field.Type, data)
+		}
+	}
+	w.end()
+}
+
+// marshalRecord adds all set fields of the record to w
+func (r *Record) marshalRecord(w *fieldWriter) {
+	for _, field := range recordFields {
+		if data := field.encode(r); data != nil {
+			w.add(field.Type, data)
+		}
+	}
+	w.end()
+}
@@ -0,0 +1,132 @@
+package pwsafe
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testRecord returns a record with every field set
+func testRecord() Record {
+	return Record{
+		AccessTime:             time.Unix(1500000000, 0),
+		Autotype:               "\\u\\t\\p\\n",
+		CreateTime:             time.Unix(1400000000, 0),
+		DoubleClickAction:      [2]byte{1, 0},
+		Email:                  "test@example.com",
+		Group:                  "group.subgroup",
+		ModTime:                time.Unix(1500000001, 0),
+		Notes:                  "some notes\r\nspanning lines",
+		Password:               "password",
+		PasswordExpiry:         time.Unix(1600000000, 0),
+		PasswordExpiryInterval: [4]byte{90, 0, 0, 0},
+		PasswordHistory:        "10500",
+		PasswordModTime:        time.Unix(1450000000, 0),
+		PasswordPolicy:         "f00000c001001001001",
+		PasswordPolicyName:     "policy",
+		ProtectedEntry:         1,
+		RunCommand:             "ssh \\u@host",
+		ShiftDoubleClickAction: [2]byte{2, 0},
+		Title:                  "title",
+		Username:               "user",
+		URL:                    "https://example.com",
+		UUID:                   [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+	}
+}
+
+func TestRecordCodec(t *testing.T) {
+	record := testRecord()
+	var fields fieldWriter
+	record.marshalRecord(&fields)
+	assert.Equal(t, 0, len(fields.record)%16)
+
+	var decoded Record
+	err := unmarshalRecord(newFieldReader(bytes.NewReader(fields.record), ioutil.Discard), decoded.decodeField)
+	assert.Nil(t, err)
+	assert.Equal(t, record, decoded)
+
+	// unset fields are not written
+	var empty Record
+	fields.reset()
+	empty.marshalRecord(&fields)
+	assert.Equal(t, 16, len(fields.record))
+	assert.Equal(t, 0, len(fields.values))
+}
+
+func TestHeaderCodec(t *testing.T) {
+	db := NewV3("name", "password")
+	db.Description = "description"
+	db.EmptyGroups = []string{"empty", "empty.sub"}
+	db.LastSave = time.Unix(1500000000, 0)
+	db.LastSaveUser = []byte("user")
+
+	var fields fieldWriter
+	db.marshalHeader(&fields)
+	// The spec requires the version be the first field
+	assert.Equal(t, byte(HeaderVersion), fields.record[4])
+
+	var decoded V3
+	err := unmarshalRecord(newFieldReader(bytes.NewReader(fields.record), ioutil.Discard), decoded.decodeField)
+	assert.Nil(t, err)
+	assert.Equal(t, db.Version, decoded.Version)
+	assert.Equal(t, db.UUID, decoded.UUID)
+	assert.Equal(t, db.Name, decoded.Name)
+	assert.Equal(t, db.Description, decoded.Description)
+	assert.Equal(t, db.EmptyGroups, decoded.EmptyGroups)
+	assert.Equal(t, db.LastSave, decoded.LastSave)
+	assert.Equal(t, db.LastSaveUser, decoded.LastSaveUser)
+}
+
+func TestTimeCodec(t *testing.T) {
+	var testData = []struct {
+		time time.Time
+		size int
+	}{
+		{time: time.Unix(1500000000, 0), size: 4},
+		{time: time.Unix(0xffffffff, 0), size: 4},
+		// times past 2106 or before 1970 need 64 bits
+		{time: time.Unix(0x100000000, 0), size: 8},
+		{time: time.Unix(-1, 0), size: 8},
+	}
+
+	for _, test := range testData {
+		data := encodeTime(test.time)
+		assert.Equal(t, test.size, len(data))
+		var decoded time.Time
+		assert.Nil(t, decodeTime(&decoded, data))
+		assert.True(t, test.time.Equal(decoded))
+	}
+
+	assert.Nil(t, encodeTime(time.Time{}))
+	var decoded time.Time
+	assert.Equal(t, ErrInvalidField, decodeTime(&decoded, []byte{1, 2, 3}))
+}
+
+func BenchmarkMarshalRecord(b *testing.B) {
+	record := testRecord()
+	var fields fieldWriter
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fields.reset()
+		record.marshalRecord(&fields)
+	}
+}
+
+func BenchmarkUnmarshalRecord(b *testing.B) {
+	record := testRecord()
+	var fields fieldWriter
+	record.marshalRecord(&fields)
+	reader := bytes.NewReader(fields.record)
+	fr := newFieldReader(reader, ioutil.Discard)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reader.Reset(fields.record)
+		var decoded Record
+		if err := unmarshalRecord(fr, decoded.decodeField); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
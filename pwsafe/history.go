@@ -0,0 +1,122 @@
+package pwsafe
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//PasswordHistoryEntry A previous password of a record and when it was changed
+type PasswordHistoryEntry struct {
+	Changed  time.Time
+	Password string
+}
+
+//PasswordHistory The decoded form of the Record.PasswordHistory field
+//The field is stored as "fmmnn" where f is 1 if history is kept, mm the max entries kept and nn the number of entries,
+//all hex. Each entry follows as "TTTTTTTTLLLL" the change time as a hex time_t and the password length in hex then the
+//password itself.
+type PasswordHistory struct {
+	Enabled bool
+	Max     int
+	Entries []PasswordHistoryEntry // oldest first
+}
+
+//ParsePasswordHistory Decodes the Record.PasswordHistory field, an empty field gives an empty history
+func ParsePasswordHistory(field string) (PasswordHistory, error) {
+	var history PasswordHistory
+	if field == "" {
+		return history, nil
+	}
+	if len(field) < 5 {
+		return history, fmt.Errorf("%w, password history %q is too short", ErrInvalidField, field)
+	}
+	status, err := parseHex(field[0:1])
+	if err != nil {
+		return history, err
+	}
+	history.Enabled = status != 0
+	if history.Max, err = parseHex(field[1:3]); err != nil {
+		return history, err
+	}
+	num, err := parseHex(field[3:5])
+	if err != nil {
+		return history, err
+	}
+
+	rest := field[5:]
+	for i := 0; i < num; i++ {
+		if len(rest) < 12 {
+			return history, fmt.Errorf("%w, password history entry %d is truncated", ErrInvalidField, i)
+		}
+		changed, err := strconv.ParseUint(rest[:8], 16, 32)
+		if err != nil {
+			return history, fmt.Errorf("%w, password history entry %d time - %v", ErrInvalidField, i, err)
+		}
+		length, err := parseHex(rest[8:12])
+		if err != nil {
+			return history, err
+		}
+		// The length is in characters not bytes
+		password := []rune(rest[12:])
+		if len(password) < length {
+			return history, fmt.Errorf("%w, password history entry %d is truncated", ErrInvalidField, i)
+		}
+		entry := PasswordHistoryEntry{Password: string(password[:length])}
+		if changed != 0 {
+			entry.Changed = time.Unix(int64(changed), 0)
+		}
+		history.Entries = append(history.Entries, entry)
+		rest = rest[12+len(entry.Password):]
+	}
+	if rest != "" {
+		return history, fmt.Errorf("%w, unexpected data after the password history entries", ErrInvalidField)
+	}
+	return history, nil
+}
+
+//String Encodes the history in the Record.PasswordHistory field format, a history Format rejects gives an empty string
+func (h PasswordHistory) String() string {
+	field, err := h.Format()
+	if err != nil {
+		return ""
+	}
+	return field
+}
+
+//Format Encodes the history in the Record.PasswordHistory field format, returning an error if a value doesn't fit its
+//fixed width such as a max or number of entries above 255
+func (h PasswordHistory) Format() (string, error) {
+	if h.Max < 0 || h.Max > 0xff || len(h.Entries) > 0xff {
+		return "", fmt.Errorf("%w, a password history holds at most 255 entries not max %d with %d entries",
+			ErrInvalidField, h.Max, len(h.Entries))
+	}
+	var b strings.Builder
+	status := 0
+	if h.Enabled {
+		status = 1
+	}
+	fmt.Fprintf(&b, "%01x%02x%02x", status, h.Max, len(h.Entries))
+	for i, entry := range h.Entries {
+		var changed int64
+		if !entry.Changed.IsZero() {
+			changed = entry.Changed.Unix()
+		}
+		length := len([]rune(entry.Password))
+		if changed < 0 || changed > 0xffffffff || length > 0xffff {
+			return "", fmt.Errorf("%w, password history entry %d time or length is out of range", ErrInvalidField, i)
+		}
+		fmt.Fprintf(&b, "%08x%04x%s", changed, length, entry.Password)
+	}
+	return b.String(), nil
+}
+
+// parseHex parses a hex number from a fixed width field
+func parseHex(s string) (int, error) {
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w, invalid hex %q", ErrInvalidField, s)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,49 @@
+package pwsafe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordHistory(t *testing.T) {
+	history, err := ParsePasswordHistory("")
+	assert.Nil(t, err)
+	assert.Equal(t, PasswordHistory{}, history)
+
+	field := "1030255f5e1000005first5f5e10010009ünïcode !"
+	history, err = ParsePasswordHistory(field)
+	assert.Nil(t, err)
+	assert.Equal(t, PasswordHistory{Enabled: true, Max: 3, Entries: []PasswordHistoryEntry{
+		{Changed: time.Unix(0x55f5e100, 0), Password: "first"},
+		{Changed: time.Unix(0x5f5e1001, 0), Password: "ünïcode !"},
+	}}, history)
+	assert.Equal(t, field, history.String())
+
+	// values which don't fit their fixed width are rejected rather than corrupting the field
+	many := make([]PasswordHistoryEntry, 256)
+	for _, invalid := range []PasswordHistory{
+		{Max: 256},
+		{Max: -1},
+		{Max: 3, Entries: many},
+		{Max: 3, Entries: []PasswordHistoryEntry{{Changed: time.Unix(-1, 0), Password: "old"}}},
+		{Max: 3, Entries: []PasswordHistoryEntry{{Password: strings.Repeat("a", 0x10000)}}},
+	} {
+		_, err := invalid.Format()
+		assert.True(t, errors.Is(err, ErrInvalidField), invalid.Max)
+		assert.Equal(t, "", invalid.String())
+	}
+	field, err = PasswordHistory{Max: 255, Entries: many[1:]}.Format()
+	assert.Nil(t, err)
+	history, err = ParsePasswordHistory(field)
+	assert.Nil(t, err)
+	assert.Equal(t, 255, len(history.Entries))
+
+	for _, invalid := range []string{"1", "x0000", "10501", "1050100000000000", "105010000000000005abc", "10500extra"} {
+		_, err := ParsePasswordHistory(invalid)
+		assert.True(t, errors.Is(err, ErrInvalidField), invalid)
+	}
+}
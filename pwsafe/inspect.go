@@ -0,0 +1,241 @@
+package pwsafe
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/twofish"
+)
+
+// inspectFields describes how to present the fields of either the header or records
+type inspectFields struct {
+	name func(FieldType) string
+	// public field values describe the structure rather than secrets so are shown even if values aren't revealed
+	public map[FieldType]bool
+	times  map[FieldType]bool
+}
+
+var (
+	headerInspectFields = inspectFields{
+		name: func(t FieldType) string {
+			if field := headerFieldsByType[t]; field != nil {
+				return field.Name
+			}
+			return ""
+		},
+		public: map[FieldType]bool{HeaderVersion: true, HeaderUUID: true, HeaderLastSave: true},
+		times:  map[FieldType]bool{HeaderLastSave: true},
+	}
+	recordInspectFields = inspectFields{
+		name: func(t FieldType) string {
+			if field := recordFieldsByType[t]; field != nil {
+				return field.Name
+			}
+			return ""
+		},
+		public: map[FieldType]bool{
+			RecordUUID: true, RecordCreateTime: true, RecordPasswordModTime: true, RecordAccessTime: true,
+			RecordPasswordExpiry: true, RecordModTime: true, RecordPasswordExpiryInterval: true,
+			RecordDoubleClickAction: true, RecordProtectedEntry: true, RecordShiftDoubleClickAction: true,
+		},
+		times: map[FieldType]bool{
+			RecordCreateTime: true, RecordPasswordModTime: true, RecordAccessTime: true, RecordPasswordExpiry: true,
+			RecordModTime: true,
+		},
+	}
+)
+
+// inspector reads records from the decrypted data describing each field
+type inspector struct {
+	fields   *fieldReader
+	position *countingReader // the position in the decrypted data
+	reveal   bool
+}
+
+// read reads the next header or record into info, the real decoder is used for each field but errors are only noted
+func (in *inspector) read(info *RecordInfo, decode func(FieldType, []byte) error, kind inspectFields) error {
+	err := unmarshalRecord(in.fields, func(t FieldType, data []byte) error {
+		blocks := (len(data) + 5 + twofish.BlockSize - 1) / twofish.BlockSize
+		field := FieldInfo{
+			Type:    t,
+			Name:    kind.name(t),
+			Offset:  preludeSize + in.position.n - int64(blocks*twofish.BlockSize),
+			Length:  len(data),
+			Padding: blocks*twofish.BlockSize - len(data) - 5,
+		}
+		if err := decode(t, data); err != nil {
+			field.Error = err.Error()
+		}
+		switch {
+		case !in.reveal && !kind.public[t]:
+			field.Redacted = true
+		case kind.times[t] && field.Error == "":
+			var value time.Time
+			decodeTime(&value, data)
+			field.Value = value.UTC().Format(time.RFC3339)
+		case kind.public[t] || field.Name == "":
+			field.Value = hex.EncodeToString(data)
+		default:
+			field.Value = string(data)
+		}
+		info.Fields = append(info.Fields, field)
+		return nil
+	})
+	info.End = preludeSize + in.position.n - twofish.BlockSize
+	return err
+}
+
+//FieldInfo Describes a single field as stored in the db file
+type FieldInfo struct {
+	Type FieldType `json:"type"`
+	// Name is the spec name for the field, empty if the type is unknown
+	Name string `json:"name"`
+	// Offset is the byte offset in the file of the first block of the field
+	Offset int64 `json:"offset"`
+	Length int   `json:"length"`
+	// Padding is the number of bytes following the data to fill the last block
+	Padding int `json:"padding"`
+	// Value is the decoded value, empty if it is redacted
+	Value    string `json:"value,omitempty"`
+	Redacted bool   `json:"redacted,omitempty"`
+	// Error is set if the field could not be decoded
+	Error string `json:"error,omitempty"`
+}
+
+//RecordInfo Describes the fields of a record, or the header, in the order stored
+type RecordInfo struct {
+	Fields []FieldInfo `json:"fields"`
+	// End is the byte offset in the file of the END field
+	End int64 `json:"end"`
+}
+
+//Inspection The decrypted structure of a db file for debugging interoperability with other clients
+type Inspection struct {
+	Size       int64        `json:"size"`
+	Iterations uint32       `json:"iterations"`
+	Header     RecordInfo   `json:"header"`
+	Records    []RecordInfo `json:"records"`
+	HMACValid  bool         `json:"hmacValid"`
+	// Warnings describe deviations from the spec which don't prevent reading the db
+	Warnings []string `json:"warnings,omitempty"`
+	// Error is set if the db could only be partially read
+	Error string `json:"error,omitempty"`
+}
+
+//Inspect Decrypts the db read from reader returning the type, name, position, length, padding and value of each field
+//in the order stored. Values which could be sensitive are redacted unless reveal is true. Field values which fail to
+//decode are noted rather than stopping inspection. The returned error is set if the password is wrong or the file
+//can't be decrypted, if it can be decrypted but not completely parsed the Inspection is returned with Error set.
+func Inspect(ctx context.Context, reader io.Reader, passwd string, opts OpenOptions, reveal bool) (*Inspection, error) {
+	var db V3
+	var ins Inspection
+	counter := &countingReader{r: reader, max: opts.maxFileSize()}
+	buffered := bufio.NewReader(counter)
+
+	decrypted, err := db.decryptPrelude(ctx, buffered, passwd, opts)
+	if err != nil {
+		return nil, err
+	}
+	ins.Iterations = db.Iter
+
+	// Track the position in the decrypted data to find the file offset of each field
+	position := &countingReader{r: decrypted, max: math.MaxInt64}
+	mac := hmac.New(sha256.New, db.HMACKey[:])
+	in := &inspector{fields: newFieldReader(position, mac), position: position, reveal: reveal}
+
+	if err := ins.inspectRecords(in); err != nil {
+		ins.Error = err.Error()
+	} else if err := db.readHMAC(buffered); err != nil {
+		ins.Error = err.Error()
+	} else {
+		ins.HMACValid = hmac.Equal(db.HMAC[:], mac.Sum(nil))
+	}
+	ins.Size = counter.n
+	return &ins, nil
+}
+
+// inspectRecords reads the header and then each record checking them against the spec
+func (ins *Inspection) inspectRecords(in *inspector) error {
+	var db V3
+	if err := in.read(&ins.Header, db.decodeField, headerInspectFields); err != nil {
+		return fmt.Errorf("Error parsing the header - %w", err)
+	}
+	for i, field := range ins.Header.Fields {
+		if field.Type == HeaderVersion {
+			if i != 0 {
+				ins.Warnings = append(ins.Warnings, "The Version field is not the first header field")
+			}
+			break
+		}
+		if i == len(ins.Header.Fields)-1 {
+			ins.Warnings = append(ins.Warnings, "The mandatory Version header field is missing")
+		}
+	}
+
+	for i := 0; ; i++ {
+		var record Record
+		var info RecordInfo
+		err := in.read(&info, record.decodeField, recordInspectFields)
+		if err == io.EOF {
+			return nil
+		}
+		if len(info.Fields) > 0 {
+			ins.Records = append(ins.Records, info)
+		}
+		if err != nil {
+			return fmt.Errorf("Error parsing record %d - %w", i, err)
+		}
+		if record.UUID == [16]byte{} || record.Title == "" || record.Password == "" {
+			ins.Warnings = append(ins.Warnings, fmt.Sprintf("Record %d is missing a mandatory UUID, Title or Password", i))
+		}
+	}
+}
+
+//WriteText Writes the inspection in a human readable form, one line per field
+func (ins *Inspection) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Size %d bytes, %d key stretch iterations, %d records, HMAC valid %v\n",
+		ins.Size, ins.Iterations, len(ins.Records), ins.HMACValid)
+	writeRecord := func(title string, info RecordInfo) {
+		fmt.Fprintf(&b, "%s\n", title)
+		for _, field := range info.Fields {
+			name := field.Name
+			if name == "" {
+				name = "Unknown"
+			}
+			fmt.Fprintf(&b, "  %8d 0x%02x %-22s length %-4d padding %-2d", field.Offset, byte(field.Type), name,
+				field.Length, field.Padding)
+			switch {
+			case field.Redacted:
+				b.WriteString(" [redacted]")
+			case field.Value != "":
+				fmt.Fprintf(&b, " %q", field.Value)
+			}
+			if field.Error != "" {
+				fmt.Fprintf(&b, " error: %s", field.Error)
+			}
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "  %8d 0xff END\n", info.End)
+	}
+	writeRecord("Header", ins.Header)
+	for i, record := range ins.Records {
+		writeRecord(fmt.Sprintf("Record %d", i), record)
+	}
+	for _, warning := range ins.Warnings {
+		fmt.Fprintf(&b, "Warning: %s\n", warning)
+	}
+	if ins.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", ins.Error)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,113 @@
+package pwsafe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspect(t *testing.T) {
+	f, err := os.Open("./test_dbs/three.dat")
+	assert.Nil(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	assert.Nil(t, err)
+
+	ins, err := Inspect(context.Background(), f, "three3#;", OpenOptions{}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "", ins.Error)
+	assert.True(t, ins.HMACValid)
+	assert.Equal(t, info.Size(), ins.Size)
+	assert.Equal(t, 3, len(ins.Records))
+	assert.Equal(t, int64(preludeSize), ins.Header.Fields[0].Offset)
+	// This db was written by another client which doesn't set a version
+	assert.Equal(t, []string{"The mandatory Version header field is missing"}, ins.Warnings)
+
+	// Each field and record follows directly after the last
+	next := int64(preludeSize)
+	for _, record := range append([]RecordInfo{ins.Header}, ins.Records...) {
+		for _, field := range record.Fields {
+			assert.Equal(t, next, field.Offset, field.Name)
+			assert.Equal(t, 0, (5+field.Length+field.Padding)%16, field.Name)
+			next += int64(5 + field.Length + field.Padding)
+			if field.Type == RecordPassword {
+				assert.True(t, field.Redacted)
+				assert.Equal(t, "", field.Value)
+			}
+		}
+		assert.Equal(t, next, record.End)
+		next += 16
+	}
+	// The EOF marker and HMAC follow the last record
+	assert.Equal(t, info.Size(), next+16+32)
+
+	f.Seek(0, 0)
+	ins, err = Inspect(context.Background(), f, "three3#;", OpenOptions{}, true)
+	assert.Nil(t, err)
+	var passwords int
+	for _, record := range ins.Records {
+		for _, field := range record.Fields {
+			assert.False(t, field.Redacted)
+			if field.Type == RecordPassword {
+				assert.NotEqual(t, "", field.Value)
+				passwords++
+			}
+		}
+	}
+	assert.Equal(t, 3, passwords)
+
+	var text bytes.Buffer
+	assert.Nil(t, ins.WriteText(&text))
+	assert.True(t, strings.Contains(text.String(), "0x04 LastSave"), text.String())
+	data, err := json.Marshal(ins)
+	assert.Nil(t, err)
+	var decoded Inspection
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, *ins, decoded)
+
+	f.Seek(0, 0)
+	_, err = Inspect(context.Background(), f, "badpass", OpenOptions{}, false)
+	assert.Equal(t, ErrInvalidPassword, err)
+}
+
+// TestInspectOrder verifies the field order matches that written
+func TestInspectOrder(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := deterministicDB("one", "two").Encrypt(&buf)
+	assert.Nil(t, err)
+	ins, err := Inspect(context.Background(), &buf, "password", OpenOptions{}, false)
+	assert.Nil(t, err)
+	assert.Nil(t, ins.Warnings)
+
+	var types []FieldType
+	for _, field := range ins.Header.Fields {
+		types = append(types, field.Type)
+	}
+	assert.Equal(t, []FieldType{HeaderVersion, HeaderUUID, HeaderLastSave, HeaderName}, types)
+	assert.Equal(t, "1003", ins.Header.Fields[0].Value)
+	assert.Equal(t, "2020-01-02T03:04:05Z", ins.Header.Fields[2].Value)
+	assert.True(t, ins.Header.Fields[3].Redacted)
+
+	types = nil
+	for _, field := range ins.Records[0].Fields {
+		types = append(types, field.Type)
+	}
+	assert.Equal(t, []FieldType{RecordUUID, RecordGroup, RecordTitle, RecordPassword, RecordCreateTime,
+		RecordPasswordModTime, RecordModTime}, types)
+}
+
+func TestInspectBadHMAC(t *testing.T) {
+	f, err := os.Open("./test_dbs/badHMAC.dat")
+	assert.Nil(t, err)
+	defer f.Close()
+	ins, err := Inspect(context.Background(), f, "password", OpenOptions{}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "", ins.Error)
+	assert.False(t, ins.HMACValid)
+	assert.Equal(t, 1, len(ins.Records))
+}
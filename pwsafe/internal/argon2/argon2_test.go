@@ -0,0 +1,58 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package argon2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// TestVectors checks the test vectors of RFC 9106 section 5
+func TestVectors(t *testing.T) {
+	password := bytes.Repeat([]byte{1}, 32)
+	salt := bytes.Repeat([]byte{2}, 16)
+	secret := bytes.Repeat([]byte{3}, 8)
+	data := bytes.Repeat([]byte{4}, 12)
+	for mode, want := range map[int]string{
+		argon2d:  "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb",
+		argon2i:  "c814d9d1dc7f37aa13f0d77f2494bda1c8de6b016dd388d29952a4c4672b6ce8",
+		argon2id: "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659",
+	} {
+		key, err := deriveKey(mode, password, salt, secret, data, 3, 32, 4, 32, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(key); got != want {
+			t.Errorf("mode %d: got %s, want %s", mode, got, want)
+		}
+	}
+}
+
+// TestPasses checks pass is called before each pass and its error stops the derivation
+func TestPasses(t *testing.T) {
+	var passes []uint32
+	key, err := IDKeyPasses([]byte("password"), []byte("somesalt"), 3, 32, 1, 32, func(n uint32) error {
+		passes = append(passes, n)
+		return nil
+	})
+	if err != nil || !bytes.Equal(key, IDKey([]byte("password"), []byte("somesalt"), 3, 32, 1, 32)) {
+		t.Errorf("IDKeyPasses gave %x, %v", key, err)
+	}
+	if len(passes) != 3 || passes[2] != 2 {
+		t.Errorf("passes %v, want 0 to 2", passes)
+	}
+
+	stop := errors.New("stop")
+	if _, err := DKeyPasses([]byte("password"), []byte("somesalt"), 3, 32, 1, 32, func(n uint32) error {
+		if n == 1 {
+			return stop
+		}
+		return nil
+	}); err != stop {
+		t.Errorf("DKeyPasses returned %v, want %v", err, stop)
+	}
+}
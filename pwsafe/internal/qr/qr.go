@@ -0,0 +1,461 @@
+// Package qr encodes text as a QR code, ISO/IEC 18004, in byte mode with the medium error correction level. Only
+// versions 1 to 10 are supported which hold up to 213 bytes, enough for any password, so the symbol stays small enough
+// to scan from paper.
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxVersion is the largest version supported
+const maxVersion = 10
+
+// blockLayout is the error correction block structure of a version at level M
+type blockLayout struct {
+	ecPerBlock int
+	// blocks of the first group then the second which have one more data codeword
+	blocks1, data1, blocks2 int
+}
+
+var layouts = [maxVersion + 1]blockLayout{
+	1:  {10, 1, 16, 0},
+	2:  {16, 1, 28, 0},
+	3:  {26, 1, 44, 0},
+	4:  {18, 2, 32, 0},
+	5:  {24, 2, 43, 0},
+	6:  {16, 4, 27, 0},
+	7:  {18, 4, 31, 0},
+	8:  {22, 2, 38, 2},
+	9:  {22, 3, 36, 2},
+	10: {26, 4, 43, 1},
+}
+
+// alignment is the row and column positions of the alignment patterns of each version
+var alignment = [maxVersion + 1][]int{
+	2:  {6, 18},
+	3:  {6, 22},
+	4:  {6, 26},
+	5:  {6, 30},
+	6:  {6, 34},
+	7:  {6, 22, 38},
+	8:  {6, 24, 42},
+	9:  {6, 26, 46},
+	10: {6, 28, 50},
+}
+
+// dataCodewords returns the number of data codewords of a version
+func (l blockLayout) dataCodewords() int {
+	return l.blocks1*l.data1 + l.blocks2*(l.data1+1)
+}
+
+// ErrTooLong is returned when the text doesn't fit the largest supported version
+var ErrTooLong = errors.New("qr: text is too long")
+
+// Code is a QR code symbol, without the quiet zone
+type Code struct {
+	// Size is the number of modules on each side
+	Size    int
+	modules []bool
+}
+
+// Black returns true if the module in column x and row y is dark
+func (c *Code) Black(x, y int) bool {
+	return c.modules[y*c.Size+x]
+}
+
+// Encode returns the smallest QR code holding text
+func Encode(text string) (*Code, error) {
+	version := 1
+	for ; version <= maxVersion; version++ {
+		if 4+countBits(version)+8*len(text) <= layouts[version].dataCodewords()*8 {
+			break
+		}
+	}
+	if version > maxVersion {
+		return nil, fmt.Errorf("%w, %d bytes", ErrTooLong, len(text))
+	}
+	s := newSymbol(version)
+	s.drawCodewords(interleave(version, encodeData(version, text)))
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		s.applyMask(mask)
+		s.drawFormat(mask)
+		if penalty := s.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		// masking twice restores the modules
+		s.applyMask(mask)
+	}
+	s.applyMask(best)
+	s.drawFormat(best)
+	return &Code{Size: s.size, modules: s.modules}, nil
+}
+
+// SVG returns the code as an SVG image with a quiet zone of four modules, each module is scale pixels
+func (c *Code) SVG(scale int) string {
+	size := (c.Size + 8) * scale
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, c.Size+8, c.Size+8)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, c.Size+8, c.Size+8)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Black(x, y) {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x+4, y+4)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
+
+// countBits returns the length of the byte mode character count of a version
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// encodeData returns the data codewords of text in byte mode padded to fill the version
+func encodeData(version int, text string) []byte {
+	capacity := layouts[version].dataCodewords()
+	var bits bitBuffer
+	bits.append(4, 4)
+	bits.append(len(text), countBits(version))
+	for i := 0; i < len(text); i++ {
+		bits.append(int(text[i]), 8)
+	}
+	// the terminator and padding to a byte
+	terminator := capacity*8 - bits.n
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-bits.n%8)%8)
+	data := bits.bytes
+	for pad := byte(0xec); len(data) < capacity; pad ^= 0xec ^ 0x11 {
+		data = append(data, pad)
+	}
+	return data
+}
+
+// bitBuffer appends values most significant bit first
+type bitBuffer struct {
+	bytes []byte
+	n     int
+}
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		if b.n%8 == 0 {
+			b.bytes = append(b.bytes, 0)
+		}
+		if value>>uint(i)&1 == 1 {
+			b.bytes[b.n/8] |= 0x80 >> uint(b.n%8)
+		}
+		b.n++
+	}
+}
+
+// interleave splits the data into blocks, adds the error correction codewords of each and interleaves them
+func interleave(version int, data []byte) []byte {
+	l := layouts[version]
+	divisor := rsDivisor(l.ecPerBlock)
+	var blocks, ecc [][]byte
+	for i := 0; i < l.blocks1+l.blocks2; i++ {
+		n := l.data1
+		if i >= l.blocks1 {
+			n++
+		}
+		blocks = append(blocks, data[:n])
+		ecc = append(ecc, rsRemainder(data[:n], divisor))
+		data = data[n:]
+	}
+	var result []byte
+	for i := 0; i <= l.data1; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < l.ecPerBlock; i++ {
+		for _, block := range ecc {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo the QR code polynomial x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11d
+		z ^= int(y>>uint(i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of a degree without its leading term
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 2)
+	}
+	return result
+}
+
+// rsRemainder returns the Reed-Solomon error correction codewords of data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// symbol is a QR code being drawn, function marks the modules of the function patterns which aren't masked
+type symbol struct {
+	size     int
+	modules  []bool
+	function []bool
+}
+
+func newSymbol(version int) *symbol {
+	size := version*4 + 17
+	s := &symbol{size: size, modules: make([]bool, size*size), function: make([]bool, size*size)}
+	for i := 0; i < size; i++ {
+		s.set(6, i, i%2 == 0)
+		s.set(i, 6, i%2 == 0)
+	}
+	s.drawFinder(3, 3)
+	s.drawFinder(size-4, 3)
+	s.drawFinder(3, size-4)
+	positions := alignment[version]
+	for i, x := range positions {
+		for j, y := range positions {
+			// the corners with finder patterns
+			if i == 0 && j == 0 || i == 0 && j == len(positions)-1 || i == len(positions)-1 && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					s.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	// reserve the format areas, drawn once the mask is chosen
+	s.drawFormat(0)
+	if version >= 7 {
+		bits := version<<12 | bchRemainder(version, 0x1f25, 12)
+		for i := 0; i < 18; i++ {
+			a, b := size-11+i%3, i/3
+			s.set(a, b, bits>>uint(i)&1 == 1)
+			s.set(b, a, bits>>uint(i)&1 == 1)
+		}
+	}
+	return s
+}
+
+// set sets a function module
+func (s *symbol) set(x, y int, dark bool) {
+	s.modules[y*s.size+x] = dark
+	s.function[y*s.size+x] = true
+}
+
+// drawFinder draws a finder pattern and its separator centred on x, y
+func (s *symbol) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			if x+dx < 0 || x+dx >= s.size || y+dy < 0 || y+dy >= s.size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			s.set(x+dx, y+dy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// bchRemainder returns the BCH error correction bits of value
+func bchRemainder(value, generator, bits int) int {
+	rem := value
+	for i := 0; i < bits; i++ {
+		rem = rem<<1 ^ (rem>>uint(bits-1))*generator
+	}
+	return rem
+}
+
+// formatBits returns the format information of level M with a mask
+func formatBits(mask int) int {
+	return (mask<<10 | bchRemainder(mask, 0x537, 10)) ^ 0x5412
+}
+
+// drawFormat draws both copies of the format information
+func (s *symbol) drawFormat(mask int) {
+	bits := formatBits(mask)
+	bit := func(i int) bool { return bits>>uint(i)&1 == 1 }
+	for i := 0; i < 6; i++ {
+		s.set(8, i, bit(i))
+	}
+	s.set(8, 7, bit(6))
+	s.set(8, 8, bit(7))
+	s.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		s.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		s.set(s.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		s.set(8, s.size-15+i, bit(i))
+	}
+	s.set(8, s.size-8, true)
+}
+
+// drawCodewords places the codewords in the zigzag order from the bottom right corner
+func (s *symbol) drawCodewords(data []byte) {
+	i := 0
+	for right := s.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < s.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = s.size - 1 - vert
+				}
+				if !s.function[y*s.size+x] && i < len(data)*8 {
+					s.modules[y*s.size+x] = data[i/8]>>uint(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// maskBit returns true if the mask inverts the module in column x and row y
+func maskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// applyMask inverts the data modules selected by the mask
+func (s *symbol) applyMask(mask int) {
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			if !s.function[y*s.size+x] && maskBit(mask, x, y) {
+				s.modules[y*s.size+x] = !s.modules[y*s.size+x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the symbol is to scan, the mask with the lowest score is used
+func (s *symbol) penalty() int {
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			x, y = y, x
+		}
+		return s.modules[y*s.size+x]
+	}
+	finderLike := []bool{true, false, true, true, true, false, true, false, false, false, false}
+	penalty := 0
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < s.size; y++ {
+			// runs of five or more modules of the same colour
+			run := 1
+			for x := 1; x <= s.size; x++ {
+				if x < s.size && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+			// patterns like the finder pattern, in either direction
+			for x := 0; x+len(finderLike) <= s.size; x++ {
+				forward, backward := true, true
+				for i, dark := range finderLike {
+					forward = forward && at(x+i, y, vertical) == dark
+					backward = backward && at(x+len(finderLike)-1-i, y, vertical) == dark
+				}
+				if forward {
+					penalty += 40
+				}
+				if backward {
+					penalty += 40
+				}
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			if s.modules[y*s.size+x] {
+				dark++
+			}
+			// 2x2 blocks of the same colour
+			if x+1 < s.size && y+1 < s.size {
+				c := s.modules[y*s.size+x]
+				if c == s.modules[y*s.size+x+1] && c == s.modules[(y+1)*s.size+x] && c == s.modules[(y+1)*s.size+x+1] {
+					penalty += 3
+				}
+			}
+		}
+	}
+	// the proportion of dark modules away from half
+	total := s.size * s.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return penalty + k*10
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,158 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestReedSolomon checks the error correction of the version 1-M "HELLO WORLD" example of ISO/IEC 18004 annex I
+func TestReedSolomon(t *testing.T) {
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	for mask, want := range map[int]int{0: 0x5412, 5: 0x40ce, 7: 0x4aa0} {
+		if got := formatBits(mask); got != want {
+			t.Errorf("mask %d: got %#x, want %#x", mask, got, want)
+		}
+	}
+	for version, want := range map[int]int{7: 0x07c94, 10: 0x0a4d3} {
+		if got := version<<12 | bchRemainder(version, 0x1f25, 12); got != want {
+			t.Errorf("version %d: got %#x, want %#x", version, got, want)
+		}
+	}
+}
+
+// decode reads the text back from a code, without error correction, by reversing each step of Encode
+func decode(t *testing.T, c *Code) string {
+	version := (c.Size - 17) / 4
+	s := newSymbol(version)
+	bits := 0
+	for i := 0; i < 6; i++ {
+		if c.Black(8, i) {
+			bits |= 1 << uint(i)
+		}
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if formatBits(m)&0x3f == bits {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("no mask has the format bits %#x", bits)
+	}
+	for i := range s.modules {
+		if s.function[i] {
+			continue
+		}
+		s.modules[i] = c.modules[i]
+	}
+	s.applyMask(mask)
+
+	var codewords []byte
+	n := 0
+	for right := s.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < s.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = s.size - 1 - vert
+				}
+				if s.function[y*s.size+x] {
+					continue
+				}
+				if n%8 == 0 {
+					codewords = append(codewords, 0)
+				}
+				if s.modules[y*s.size+x] {
+					codewords[n/8] |= 0x80 >> uint(n%8)
+				}
+				n++
+			}
+		}
+	}
+
+	l := layouts[version]
+	blocks := make([][]byte, l.blocks1+l.blocks2)
+	for i := 0; i <= l.data1; i++ {
+		for b := range blocks {
+			if i < l.data1 || b >= l.blocks1 {
+				blocks[b] = append(blocks[b], codewords[0])
+				codewords = codewords[1:]
+			}
+		}
+	}
+	data := bytes.Join(blocks, nil)
+	for b, block := range blocks {
+		ecc := make([]byte, l.ecPerBlock)
+		for i := range ecc {
+			ecc[i] = codewords[i*len(blocks)+b]
+		}
+		if !bytes.Equal(ecc, rsRemainder(block, rsDivisor(l.ecPerBlock))) {
+			t.Errorf("block %d has the wrong error correction", b)
+		}
+	}
+
+	if data[0]>>4 != 4 {
+		t.Fatalf("mode %d is not byte mode", data[0]>>4)
+	}
+	var length int
+	if countBits(version) == 8 {
+		length = int(data[0]&0xf)<<4 | int(data[1]>>4)
+		data = data[1:]
+	} else {
+		length = int(data[0]&0xf)<<12 | int(data[1])<<4 | int(data[2]>>4)
+		data = data[2:]
+	}
+	text := make([]byte, length)
+	for i := range text {
+		text[i] = data[i]<<4 | data[i+1]>>4
+	}
+	return string(text)
+}
+
+func TestEncode(t *testing.T) {
+	for _, text := range []string{"", "password", "correct horse battery staple", strings.Repeat("x~9", 33),
+		strings.Repeat("long password ", 15)} {
+		c, err := Encode(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the timing patterns and the dark module
+		for i := 8; i < c.Size-8; i++ {
+			if c.Black(6, i) != (i%2 == 0) || c.Black(i, 6) != (i%2 == 0) {
+				t.Fatalf("%q: bad timing pattern at %d", text, i)
+			}
+		}
+		if !c.Black(8, c.Size-8) {
+			t.Errorf("%q: no dark module", text)
+		}
+		if got := decode(t, c); got != text {
+			t.Errorf("got %q, want %q", got, text)
+		}
+	}
+
+	c, _ := Encode("password")
+	if c.Size != 21 {
+		t.Errorf("got size %d, want version 1", c.Size)
+	}
+	if !strings.HasPrefix(c.SVG(4), `<svg xmlns="http://www.w3.org/2000/svg" width="116" height="116"`) {
+		t.Error("unexpected SVG")
+	}
+	if _, err := Encode(strings.Repeat("x", 214)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("got %v, want ErrTooLong", err)
+	}
+	if c, err := Encode(strings.Repeat("x", 213)); err != nil || c.Size != 57 {
+		t.Errorf("213 bytes didn't fit version 10, %v", err)
+	}
+}
@@ -0,0 +1,753 @@
+// The KeePass KDBX container format, versions 3.1 and 4
+// The format is described at https://keepass.info/help/kb/kdbx_4.html and https://keepass.info/help/kb/kdbx.html
+
+package pwsafe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe/internal/argon2"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+const (
+	kdbxSignature1 = 0x9AA2D903
+	kdbxSignature2 = 0xB54BFB67
+	// kdbxMaxMemory is the largest Argon2 memory accepted so a hostile file can't exhaust memory
+	kdbxMaxMemory = 1 << 30
+	// kdbxMaxArgon2Work is the most Argon2 memory in bytes times passes accepted so a hostile file can't take hours to
+	// open, over a minute at the limit
+	kdbxMaxArgon2Work = 1 << 36
+)
+
+// Outer header field ids
+const (
+	kdbxEndOfHeader        = 0
+	kdbxCipherID           = 2
+	kdbxCompressionFlags   = 3
+	kdbxMasterSeed         = 4
+	kdbxTransformSeed      = 5 // KDBX 3.1 only
+	kdbxTransformRounds    = 6 // KDBX 3.1 only
+	kdbxEncryptionIV       = 7
+	kdbxProtectedStreamKey = 8 // KDBX 3.1 only
+	kdbxStreamStartBytes   = 9 // KDBX 3.1 only
+	kdbxInnerRandomStream  = 10
+	kdbxKdfParameters      = 11 // KDBX 4 only
+)
+
+// Inner header field ids, KDBX 4 only
+const (
+	kdbxInnerEnd       = 0
+	kdbxInnerStreamID  = 1
+	kdbxInnerStreamKey = 2
+	kdbxInnerBinary    = 3
+)
+
+// Inner random stream ids, used to protect values in the XML
+const (
+	kdbxStreamSalsa20  = 2
+	kdbxStreamChaCha20 = 3
+)
+
+// Variant dictionary value types used for the KDF parameters
+const (
+	variantUint32    = 0x04
+	variantUint64    = 0x05
+	variantByteArray = 0x42
+)
+
+var (
+	kdbxCipherAES      = [16]byte{0x31, 0xc1, 0xf2, 0xe6, 0xbf, 0x71, 0x43, 0x50, 0xbe, 0x58, 0x05, 0x21, 0x6a, 0xfc, 0x5a, 0xff}
+	kdbxCipherChaCha20 = [16]byte{0xd6, 0x03, 0x8a, 0x2b, 0x8b, 0x6f, 0x4c, 0xb5, 0xa5, 0x24, 0x33, 0x9a, 0x31, 0xdb, 0xb5, 0x9a}
+	kdbxKDFAES3        = [16]byte{0xc9, 0xd9, 0xf3, 0x9a, 0x62, 0x8a, 0x44, 0x60, 0xbf, 0x74, 0x0d, 0x08, 0xc1, 0x8a, 0x4f, 0xea}
+	kdbxKDFAES4        = [16]byte{0x7c, 0x02, 0xbb, 0x82, 0x79, 0xa7, 0x4a, 0xc0, 0x92, 0x7d, 0x11, 0x4a, 0x00, 0x64, 0x82, 0x38}
+	kdbxKDFArgon2d     = [16]byte{0xef, 0x63, 0x6d, 0xdf, 0x8c, 0x29, 0x44, 0x4b, 0x91, 0xf7, 0xa9, 0xa4, 0x03, 0xe3, 0x0a, 0x0c}
+	kdbxKDFArgon2id    = [16]byte{0x9e, 0x29, 0x8b, 0x19, 0x56, 0xdb, 0x47, 0x73, 0xb2, 0x3d, 0xfc, 0x3e, 0xc6, 0xf0, 0xa1, 0xe6}
+	// kdbxSalsa20Nonce is the fixed nonce of the Salsa20 inner random stream
+	kdbxSalsa20Nonce = [8]byte{0xE8, 0x30, 0x09, 0x4B, 0x97, 0x20, 0x5D, 0x2A}
+)
+
+// kdbxKDF are the key derivation parameters, Seed is the AES-KDF seed or the Argon2 salt
+type kdbxKDF struct {
+	UUID        [16]byte
+	Rounds      uint64 // AES-KDF
+	Seed        []byte
+	Parallelism uint32 // Argon2
+	Memory      uint64 // Argon2, in bytes
+	Iterations  uint64 // Argon2
+	Version     uint32 // Argon2
+}
+
+// kdbxHeader holds the outer header fields used, raw is the header as read for checking its hash
+type kdbxHeader struct {
+	Major, Minor       uint16
+	Cipher             [16]byte
+	Compressed         bool
+	MasterSeed         []byte
+	EncryptionIV       []byte
+	KDF                kdbxKDF
+	ProtectedStreamKey []byte // KDBX 3.1, in KDBX 4 it is in the inner header
+	StreamStartBytes   []byte // KDBX 3.1
+	InnerStreamID      uint32 // KDBX 3.1, in KDBX 4 it is in the inner header
+	raw                []byte
+}
+
+// kdbxVariant is a variant dictionary entry
+type kdbxVariant struct {
+	Type  byte
+	Value []byte
+}
+
+// readKDBXHeader reads the outer header from the start of data
+func readKDBXHeader(data []byte) (*kdbxHeader, error) {
+	if len(data) < 12 || binary.LittleEndian.Uint32(data[0:4]) != kdbxSignature1 ||
+		binary.LittleEndian.Uint32(data[4:8]) != kdbxSignature2 {
+		return nil, ErrNotKDBX
+	}
+	h := &kdbxHeader{Minor: binary.LittleEndian.Uint16(data[8:10]), Major: binary.LittleEndian.Uint16(data[10:12])}
+	if h.Major != 3 && h.Major != 4 {
+		return nil, fmt.Errorf("%w, version %d.%d is not supported", ErrNotKDBX, h.Major, h.Minor)
+	}
+
+	pos := 12
+	for {
+		sizeLen := 4
+		if h.Major == 3 {
+			sizeLen = 2
+		}
+		if len(data) < pos+1+sizeLen {
+			return nil, fmt.Errorf("%w, KDBX header", ErrTruncated)
+		}
+		id := data[pos]
+		var size int
+		if sizeLen == 2 {
+			size = int(binary.LittleEndian.Uint16(data[pos+1:]))
+		} else {
+			size = int(binary.LittleEndian.Uint32(data[pos+1:]))
+		}
+		pos += 1 + sizeLen
+		if size < 0 || len(data) < pos+size {
+			return nil, fmt.Errorf("%w, KDBX header", ErrTruncated)
+		}
+		value := data[pos : pos+size]
+		pos += size
+		if id == kdbxEndOfHeader {
+			break
+		}
+		if err := h.setField(id, value); err != nil {
+			return nil, err
+		}
+	}
+	h.raw = data[:pos]
+
+	if h.Cipher != kdbxCipherAES && h.Cipher != kdbxCipherChaCha20 {
+		return nil, fmt.Errorf("%w, the KDBX cipher %x is not supported", ErrNotKDBX, h.Cipher)
+	}
+	if len(h.MasterSeed) != 32 {
+		return nil, fmt.Errorf("%w, KDBX master seed", ErrInvalidField)
+	}
+	return h, nil
+}
+
+// setField sets a header field from its value
+func (h *kdbxHeader) setField(id byte, value []byte) error {
+	uint32Field := func() (uint32, error) {
+		if len(value) != 4 {
+			return 0, fmt.Errorf("%w, KDBX header field %d", ErrInvalidField, id)
+		}
+		return binary.LittleEndian.Uint32(value), nil
+	}
+	var err error
+	switch id {
+	case kdbxCipherID:
+		if len(value) != 16 {
+			return fmt.Errorf("%w, KDBX cipher", ErrInvalidField)
+		}
+		copy(h.Cipher[:], value)
+	case kdbxCompressionFlags:
+		var flags uint32
+		flags, err = uint32Field()
+		h.Compressed = flags == 1
+	case kdbxMasterSeed:
+		h.MasterSeed = value
+	case kdbxTransformSeed:
+		h.KDF.UUID = kdbxKDFAES3
+		h.KDF.Seed = value
+	case kdbxTransformRounds:
+		if len(value) != 8 {
+			return fmt.Errorf("%w, KDBX transform rounds", ErrInvalidField)
+		}
+		h.KDF.Rounds = binary.LittleEndian.Uint64(value)
+	case kdbxEncryptionIV:
+		h.EncryptionIV = value
+	case kdbxProtectedStreamKey:
+		h.ProtectedStreamKey = value
+	case kdbxStreamStartBytes:
+		h.StreamStartBytes = value
+	case kdbxInnerRandomStream:
+		h.InnerStreamID, err = uint32Field()
+	case kdbxKdfParameters:
+		err = h.KDF.parse(value)
+	}
+	return err
+}
+
+// parse reads the KDF parameters from a variant dictionary
+func (kdf *kdbxKDF) parse(data []byte) error {
+	variants, err := parseVariants(data)
+	if err != nil {
+		return err
+	}
+	get := func(key string, typ byte, size int) ([]byte, error) {
+		v, ok := variants[key]
+		if !ok || v.Type != typ || (size > 0 && len(v.Value) != size) {
+			return nil, fmt.Errorf("%w, KDBX KDF parameter %s", ErrInvalidField, key)
+		}
+		return v.Value, nil
+	}
+	uuid, err := get("$UUID", variantByteArray, 16)
+	if err != nil {
+		return err
+	}
+	copy(kdf.UUID[:], uuid)
+	if kdf.Seed, err = get("S", variantByteArray, 0); err != nil {
+		return err
+	}
+	switch kdf.UUID {
+	case kdbxKDFAES3, kdbxKDFAES4:
+		rounds, err := get("R", variantUint64, 8)
+		if err != nil {
+			return err
+		}
+		kdf.Rounds = binary.LittleEndian.Uint64(rounds)
+	case kdbxKDFArgon2d, kdbxKDFArgon2id:
+		for _, p := range []struct {
+			key   string
+			typ   byte
+			value interface{}
+		}{
+			{"P", variantUint32, &kdf.Parallelism},
+			{"M", variantUint64, &kdf.Memory},
+			{"I", variantUint64, &kdf.Iterations},
+			{"V", variantUint32, &kdf.Version},
+		} {
+			size := 4
+			if p.typ == variantUint64 {
+				size = 8
+			}
+			value, err := get(p.key, p.typ, size)
+			if err != nil {
+				return err
+			}
+			if size == 4 {
+				*p.value.(*uint32) = binary.LittleEndian.Uint32(value)
+			} else {
+				*p.value.(*uint64) = binary.LittleEndian.Uint64(value)
+			}
+		}
+	default:
+		return fmt.Errorf("%w, the KDBX KDF %x is not supported", ErrNotKDBX, kdf.UUID)
+	}
+	return nil
+}
+
+// parseVariants reads a KDBX variant dictionary
+func parseVariants(data []byte) (map[string]kdbxVariant, error) {
+	invalid := fmt.Errorf("%w, KDBX variant dictionary", ErrInvalidField)
+	if len(data) < 2 || data[1] != 1 {
+		return nil, invalid
+	}
+	variants := make(map[string]kdbxVariant)
+	for pos := 2; ; {
+		if pos >= len(data) {
+			return nil, invalid
+		}
+		typ := data[pos]
+		pos++
+		if typ == 0 {
+			return variants, nil
+		}
+		var fields [2][]byte
+		for i := range fields {
+			if len(data) < pos+4 {
+				return nil, invalid
+			}
+			size := int(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+			if size < 0 || len(data) < pos+size {
+				return nil, invalid
+			}
+			fields[i] = data[pos : pos+size]
+			pos += size
+		}
+		variants[string(fields[0])] = kdbxVariant{Type: typ, Value: fields[1]}
+	}
+}
+
+// format writes the KDBX 4 KDF parameters as a variant dictionary
+func (kdf *kdbxKDF) format() []byte {
+	data := []byte{0, 1}
+	variant := func(typ byte, key string, value []byte) {
+		data = append(data, typ)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(key)))
+		data = append(data, key...)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(value)))
+		data = append(data, value...)
+	}
+	variant(variantByteArray, "$UUID", kdf.UUID[:])
+	variant(variantByteArray, "S", kdf.Seed)
+	switch kdf.UUID {
+	case kdbxKDFAES3, kdbxKDFAES4:
+		variant(variantUint64, "R", binary.LittleEndian.AppendUint64(nil, kdf.Rounds))
+	default:
+		variant(variantUint32, "P", binary.LittleEndian.AppendUint32(nil, kdf.Parallelism))
+		variant(variantUint64, "M", binary.LittleEndian.AppendUint64(nil, kdf.Memory))
+		variant(variantUint64, "I", binary.LittleEndian.AppendUint64(nil, kdf.Iterations))
+		variant(variantUint32, "V", binary.LittleEndian.AppendUint32(nil, kdf.Version))
+	}
+	return append(data, 0)
+}
+
+// kdbxCompositeKey is the KeePass composite key of a password only key
+func kdbxCompositeKey(password string) [32]byte {
+	passwordHash := sha256.Sum256([]byte(password))
+	return sha256.Sum256(passwordHash[:])
+}
+
+// transformKey applies the KDF to the composite key, checking ctx for cancellation and reporting the AES rounds or
+// Argon2 passes done to opts.Progress as it goes. AES rounds and Argon2 passes over opts.MaxIterations and Argon2
+// parameters needing more than kdbxMaxMemory or kdbxMaxArgon2Work are rejected with ErrIterationsExceeded.
+func (kdf *kdbxKDF) transformKey(ctx context.Context, composite [32]byte, opts OpenOptions) ([]byte, error) {
+	switch kdf.UUID {
+	case kdbxKDFAES3, kdbxKDFAES4:
+		if kdf.Rounds > uint64(opts.maxIterations()) {
+			return nil, fmt.Errorf("%w, the KDBX AES-KDF has %d rounds", ErrIterationsExceeded, kdf.Rounds)
+		}
+		block, err := aes.NewCipher(kdf.Seed)
+		if err != nil {
+			return nil, fmt.Errorf("%w, KDBX AES-KDF seed - %v", ErrInvalidField, err)
+		}
+		rounds := uint32(kdf.Rounds)
+		key := composite
+		for i := uint32(0); i < rounds; i++ {
+			if i%progressInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				if opts.Progress != nil {
+					opts.Progress(i, rounds)
+				}
+			}
+			block.Encrypt(key[0:16], key[0:16])
+			block.Encrypt(key[16:32], key[16:32])
+		}
+		if opts.Progress != nil {
+			opts.Progress(rounds, rounds)
+		}
+		transformed := sha256.Sum256(key[:])
+		return transformed[:], nil
+	case kdbxKDFArgon2d, kdbxKDFArgon2id:
+		if kdf.Version != argon2.Version {
+			return nil, fmt.Errorf("%w, Argon2 version 0x%x is not supported", ErrNotKDBX, kdf.Version)
+		}
+		if kdf.Iterations == 0 || kdf.Parallelism == 0 || kdf.Parallelism > math.MaxUint8 {
+			return nil, fmt.Errorf("%w, the KDBX Argon2 parameters are out of range", ErrInvalidField)
+		}
+		if kdf.Memory > kdbxMaxMemory || kdf.Iterations > uint64(opts.maxIterations()) ||
+			kdf.Iterations > kdbxMaxArgon2Work/(kdf.Memory+1) {
+			return nil, fmt.Errorf("%w, the KDBX Argon2 KDF has %d passes over %d bytes", ErrIterationsExceeded,
+				kdf.Iterations, kdf.Memory)
+		}
+		derive := argon2.DKeyPasses
+		if kdf.UUID == kdbxKDFArgon2id {
+			derive = argon2.IDKeyPasses
+		}
+		passes := uint32(kdf.Iterations)
+		key, err := derive(composite[:], kdf.Seed, passes, uint32(kdf.Memory/1024), uint8(kdf.Parallelism), 32,
+			func(n uint32) error {
+				if opts.Progress != nil {
+					opts.Progress(n, passes)
+				}
+				return ctx.Err()
+			})
+		if err != nil {
+			return nil, err
+		}
+		if opts.Progress != nil {
+			opts.Progress(passes, passes)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w, the KDBX KDF %x is not supported", ErrNotKDBX, kdf.UUID)
+}
+
+// kdbxBlockKey is the HMAC key for a KDBX 4 block, the header uses index math.MaxUint64
+func kdbxBlockKey(hmacKey []byte, index uint64) []byte {
+	var indexBytes [8]byte
+	binary.LittleEndian.PutUint64(indexBytes[:], index)
+	key := sha512.Sum512(append(indexBytes[:], hmacKey...))
+	return key[:]
+}
+
+// keys derives the payload cipher key and the KDBX 4 HMAC key
+func (h *kdbxHeader) keys(ctx context.Context, password string, opts OpenOptions) (cipherKey, hmacKey []byte,
+	err error) {
+	transformed, err := h.KDF.transformKey(ctx, kdbxCompositeKey(password), opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	seeded := append(append([]byte{}, h.MasterSeed...), transformed...)
+	master := sha256.Sum256(seeded)
+	hmacBase := sha512.Sum512(append(seeded, 1))
+	return master[:], hmacBase[:], nil
+}
+
+// payload decrypts or encrypts the payload with the header cipher
+func (h *kdbxHeader) payload(key, data []byte, encrypt bool) ([]byte, error) {
+	if h.Cipher == kdbxCipherChaCha20 {
+		stream, err := chacha20.NewUnauthenticatedCipher(key, h.EncryptionIV)
+		if err != nil {
+			return nil, fmt.Errorf("%w, KDBX encryption IV - %v", ErrInvalidField, err)
+		}
+		out := make([]byte, len(data))
+		stream.XORKeyStream(out, data)
+		return out, nil
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(h.EncryptionIV) != aes.BlockSize {
+		return nil, fmt.Errorf("%w, KDBX encryption IV", ErrInvalidField)
+	}
+	if encrypt {
+		padding := aes.BlockSize - len(data)%aes.BlockSize
+		out := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+		cipher.NewCBCEncrypter(block, h.EncryptionIV).CryptBlocks(out, out)
+		return out, nil
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%w, the KDBX payload is not a multiple of the block size", ErrBlockAlignment)
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, h.EncryptionIV).CryptBlocks(out, data)
+	padding := int(out[len(out)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(out[len(out)-padding:],
+		bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ErrInvalidPassword
+	}
+	return out[:len(out)-padding], nil
+}
+
+// kdbxInnerStream returns the stream cipher protecting values in the XML
+func kdbxInnerStream(id uint32, key []byte) (cipher.Stream, error) {
+	switch id {
+	case kdbxStreamSalsa20:
+		return newSalsa20Stream(sha256.Sum256(key)), nil
+	case kdbxStreamChaCha20:
+		hash := sha512.Sum512(key)
+		return chacha20.NewUnauthenticatedCipher(hash[:32], hash[32:44])
+	}
+	return nil, fmt.Errorf("%w, the KDBX inner random stream %d is not supported", ErrNotKDBX, id)
+}
+
+// salsa20Stream is the Salsa20 key stream with the KeePass nonce, continuing across calls
+type salsa20Stream struct {
+	key     [32]byte
+	counter [16]byte // the nonce then the block counter
+	block   [64]byte
+	used    int
+}
+
+func newSalsa20Stream(key [32]byte) *salsa20Stream {
+	s := &salsa20Stream{key: key, used: 64}
+	copy(s.counter[:8], kdbxSalsa20Nonce[:])
+	return s
+}
+
+func (s *salsa20Stream) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if s.used == len(s.block) {
+			var zero [64]byte
+			salsa.XORKeyStream(s.block[:], zero[:], &s.counter, &s.key)
+			binary.LittleEndian.PutUint64(s.counter[8:], binary.LittleEndian.Uint64(s.counter[8:])+1)
+			s.used = 0
+		}
+		dst[i] = src[i] ^ s.block[s.used]
+		s.used++
+	}
+}
+
+// kdbxContent is the decrypted content of a KDBX file, the XML with protected values already decrypted
+type kdbxContent struct {
+	Header *kdbxHeader
+	XML    []byte
+	// Binaries are the attachments of a KDBX 4 file, in KDBX 3.1 they are in the XML
+	Binaries [][]byte
+}
+
+// readKDBX decrypts a KDBX 3.1 or 4 file protected by only a password, rejecting files which exceed the limits in opts
+func readKDBX(ctx context.Context, r io.Reader, password string, opts OpenOptions) (*kdbxContent, error) {
+	data, err := io.ReadAll(&countingReader{r: r, max: opts.maxFileSize()})
+	if err != nil {
+		return nil, err
+	}
+	header, err := readKDBXHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	cipherKey, hmacKey, err := header.keys(ctx, password, opts)
+	if err != nil {
+		return nil, err
+	}
+	content := &kdbxContent{Header: header}
+	body := data[len(header.raw):]
+
+	var payload []byte
+	if header.Major == 3 {
+		decrypted, err := header.payload(cipherKey, body, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(decrypted) < 32 || !bytes.Equal(decrypted[:32], header.StreamStartBytes) {
+			return nil, ErrInvalidPassword
+		}
+		if payload, err = readHashedBlocks(decrypted[32:]); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(body) < 64 {
+			return nil, fmt.Errorf("%w, KDBX header hash", ErrTruncated)
+		}
+		headerHash := sha256.Sum256(header.raw)
+		if !bytes.Equal(body[:32], headerHash[:]) {
+			return nil, fmt.Errorf("%w, the KDBX header hash does not match", ErrBadHMAC)
+		}
+		mac := hmac.New(sha256.New, kdbxBlockKey(hmacKey, math.MaxUint64))
+		mac.Write(header.raw)
+		if !hmac.Equal(body[32:64], mac.Sum(nil)) {
+			return nil, ErrInvalidPassword
+		}
+		encrypted, err := readHMACBlocks(body[64:], hmacKey)
+		if err != nil {
+			return nil, err
+		}
+		if payload, err = header.payload(cipherKey, encrypted, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if header.Compressed {
+		gz, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("Error decompressing the KDBX payload - %w", err)
+		}
+		if payload, err = io.ReadAll(&countingReader{r: gz, max: opts.maxFileSize()}); err != nil {
+			return nil, fmt.Errorf("Error decompressing the KDBX payload - %w", err)
+		}
+	}
+	if header.Major == 4 {
+		if payload, err = content.readInnerHeader(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	stream, err := kdbxInnerStream(header.InnerStreamID, header.ProtectedStreamKey)
+	if err != nil {
+		return nil, err
+	}
+	if content.XML, err = xorProtected(payload, stream, false); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// readHashedBlocks reads the KDBX 3.1 hashed block stream
+func readHashedBlocks(data []byte) ([]byte, error) {
+	var out []byte
+	for index := uint32(0); ; index++ {
+		if len(data) < 40 {
+			return nil, fmt.Errorf("%w, KDBX block %d", ErrTruncated, index)
+		}
+		hash, size := data[4:36], int(binary.LittleEndian.Uint32(data[36:40]))
+		if binary.LittleEndian.Uint32(data[:4]) != index {
+			return nil, fmt.Errorf("%w, KDBX block %d is out of order", ErrInvalidField, index)
+		}
+		data = data[40:]
+		if size == 0 {
+			return out, nil
+		}
+		if size < 0 || len(data) < size {
+			return nil, fmt.Errorf("%w, KDBX block %d", ErrTruncated, index)
+		}
+		if blockHash := sha256.Sum256(data[:size]); !bytes.Equal(hash, blockHash[:]) {
+			return nil, fmt.Errorf("%w, KDBX block %d", ErrBadHMAC, index)
+		}
+		out = append(out, data[:size]...)
+		data = data[size:]
+	}
+}
+
+// readHMACBlocks reads the KDBX 4 HMAC block stream
+func readHMACBlocks(data []byte, hmacKey []byte) ([]byte, error) {
+	var out []byte
+	for index := uint64(0); ; index++ {
+		if len(data) < 36 {
+			return nil, fmt.Errorf("%w, KDBX block %d", ErrTruncated, index)
+		}
+		blockMAC, size := data[:32], int(binary.LittleEndian.Uint32(data[32:36]))
+		if size < 0 || len(data) < 36+size {
+			return nil, fmt.Errorf("%w, KDBX block %d", ErrTruncated, index)
+		}
+		mac := hmac.New(sha256.New, kdbxBlockKey(hmacKey, index))
+		var indexBytes [8]byte
+		binary.LittleEndian.PutUint64(indexBytes[:], index)
+		mac.Write(indexBytes[:])
+		mac.Write(data[32 : 36+size])
+		if !hmac.Equal(blockMAC, mac.Sum(nil)) {
+			return nil, fmt.Errorf("%w, KDBX block %d", ErrBadHMAC, index)
+		}
+		if size == 0 {
+			return out, nil
+		}
+		out = append(out, data[36:36+size]...)
+		data = data[36+size:]
+	}
+}
+
+// writeHMACBlocks writes data as the KDBX 4 HMAC block stream in blocks of at most size bytes
+func writeHMACBlocks(w io.Writer, data []byte, hmacKey []byte, size int) error {
+	for index := uint64(0); ; index++ {
+		block := data
+		if len(block) > size {
+			block = block[:size]
+		}
+		data = data[len(block):]
+		mac := hmac.New(sha256.New, kdbxBlockKey(hmacKey, index))
+		var indexBytes [8]byte
+		binary.LittleEndian.PutUint64(indexBytes[:], index)
+		mac.Write(indexBytes[:])
+		sizeBytes := binary.LittleEndian.AppendUint32(nil, uint32(len(block)))
+		mac.Write(sizeBytes)
+		mac.Write(block)
+		for _, b := range [][]byte{mac.Sum(nil), sizeBytes, block} {
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+		}
+		if len(block) == 0 {
+			return nil
+		}
+	}
+}
+
+// readInnerHeader reads the KDBX 4 inner header returning the XML following it
+func (c *kdbxContent) readInnerHeader(data []byte) ([]byte, error) {
+	for {
+		if len(data) < 5 {
+			return nil, fmt.Errorf("%w, KDBX inner header", ErrTruncated)
+		}
+		id, size := data[0], int(binary.LittleEndian.Uint32(data[1:5]))
+		if size < 0 || len(data) < 5+size {
+			return nil, fmt.Errorf("%w, KDBX inner header", ErrTruncated)
+		}
+		value := data[5 : 5+size]
+		data = data[5+size:]
+		switch id {
+		case kdbxInnerEnd:
+			return data, nil
+		case kdbxInnerStreamID:
+			if len(value) != 4 {
+				return nil, fmt.Errorf("%w, KDBX inner random stream", ErrInvalidField)
+			}
+			c.Header.InnerStreamID = binary.LittleEndian.Uint32(value)
+		case kdbxInnerStreamKey:
+			c.Header.ProtectedStreamKey = value
+		case kdbxInnerBinary:
+			if len(value) < 1 {
+				return nil, fmt.Errorf("%w, KDBX binary", ErrInvalidField)
+			}
+			// The first byte is flags, whether the binary is protected in memory
+			c.Binaries = append(c.Binaries, value[1:])
+		}
+	}
+}
+
+// xorProtected decrypts the values marked Protected in the XML, or if protect is true encrypts them. They are encrypted
+// with one stream in document order and stored base64 encoded.
+func xorProtected(data []byte, stream cipher.Stream, protect bool) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var out bytes.Buffer
+	if protect {
+		out.WriteString(xml.Header)
+	}
+	encoder := xml.NewEncoder(&out)
+	var protected *strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing the KDBX XML - %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "Protected" && kdbxBool(attr.Value) {
+					protected = &strings.Builder{}
+				}
+			}
+		case xml.CharData:
+			if protected != nil {
+				protected.Write(t)
+				continue
+			}
+		case xml.EndElement:
+			if protected != nil {
+				var value []byte
+				if protect {
+					value = []byte(protected.String())
+					stream.XORKeyStream(value, value)
+					value = []byte(base64.StdEncoding.EncodeToString(value))
+				} else {
+					if value, err = base64.StdEncoding.DecodeString(strings.TrimSpace(protected.String())); err != nil {
+						return nil, fmt.Errorf("%w, KDBX protected value - %v", ErrInvalidField, err)
+					}
+					stream.XORKeyStream(value, value)
+				}
+				if err := encoder.EncodeToken(xml.CharData(value)); err != nil {
+					return nil, err
+				}
+				protected = nil
+			}
+		case xml.ProcInst:
+			// The encoder writes the declaration only at the start
+			continue
+		}
+		if err := encoder.EncodeToken(xml.CopyToken(token)); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,369 @@
+package pwsafe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// KeePass defaults for new databases
+const (
+	kdbxDefaultMemory      = 64 << 20
+	kdbxDefaultIterations  = 2
+	kdbxDefaultParallelism = 2
+	// kdbxBlockSize is the size of the HMAC blocks written
+	kdbxBlockSize = 1 << 20
+)
+
+// Custom string fields holding the Password Safe fields KeePass has no field for
+const (
+	kdbxEmail      = "Email"
+	kdbxRunCommand = "Run Command"
+	kdbxAutotype   = "Autotype"
+)
+
+// kdbxAutoTypeSequence maps Password Safe autotype codes to KeePass placeholders, the reverse of
+// kdbxAutoTypePlaceholders
+var kdbxAutoTypeSequence = strings.NewReplacer(
+	`\\`, `\`,
+	`\u`, "{USERNAME}",
+	`\p`, "{PASSWORD}",
+	`\t`, "{TAB}",
+	`\n`, "{ENTER}",
+)
+
+// kdbxAutoTypeCodes removes the codes kdbxAutoTypeSequence converts, whatever is left must be plain text
+var kdbxAutoTypeCodes = strings.NewReplacer(`\\`, "", `\u`, "", `\p`, "", `\t`, "", `\n`, "")
+
+//KDBXOptions Settings for ExportKDBX, the zero value is AES-256 with the KeePass default Argon2 parameters
+type KDBXOptions struct {
+	// ChaCha20 encrypts with ChaCha20 rather than AES-256
+	ChaCha20 bool
+	// Memory is the Argon2 memory in bytes, 64 MiB if zero
+	Memory uint64
+	// Iterations is the number of Argon2 passes, 2 if zero
+	Iterations uint64
+	// Parallelism is the number of Argon2 lanes, 2 if zero
+	Parallelism uint32
+}
+
+//ExportKDBX Writes the records of db to w as a KeePass KDBX 4 file protected by password, the key is derived with
+//Argon2d. Password Safe groups become nested KeePass groups by splitting the group path on dots, the email and run
+//command are custom string fields as is an autotype which has no KeePass equivalent. Previous passwords are written
+//as the entry history.
+func ExportKDBX(db DB, w io.Writer, password string, opts KDBXOptions) error {
+	x := kdbxWriter{random: rand.Reader, now: time.Now()}
+	titles := db.List()
+	var description string
+	var emptyGroups []string
+	if v3, ok := BaseV3(db); ok {
+		x.random, x.now = v3.random(), v3.now()
+		titles = v3.recordOrder()
+		description = v3.Description
+		emptyGroups = v3.EmptyGroups
+	}
+
+	name := db.GetName()
+	if name == "" {
+		name = "Root"
+	}
+	root, err := x.group(name)
+	if err != nil {
+		return err
+	}
+	for _, group := range emptyGroups {
+		if _, err := x.path(&root, group); err != nil {
+			return err
+		}
+	}
+	for _, title := range titles {
+		record, _ := db.GetRecord(title)
+		entry, err := x.entry(record)
+		if err != nil {
+			return fmt.Errorf("Error exporting record %q - %w", title, err)
+		}
+		group, err := x.path(&root, record.Group)
+		if err != nil {
+			return err
+		}
+		group.Entries = append(group.Entries, entry)
+	}
+
+	doc := kdbxXML{Meta: kdbxMeta{
+		Generator:           "gopwsafe",
+		DatabaseName:        db.GetName(),
+		DatabaseDescription: description,
+		MemoryProtection: &kdbxMemoryProtection{
+			ProtectTitle:    formatKDBXBool(false),
+			ProtectUserName: formatKDBXBool(false),
+			ProtectPassword: formatKDBXBool(true),
+			ProtectURL:      formatKDBXBool(false),
+			ProtectNotes:    formatKDBXBool(false),
+		},
+	}}
+	doc.Root.Group = root
+	data, err := xml.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return err
+	}
+	return x.write(w, data, password, opts)
+}
+
+// kdbxWriter holds the randomness and time used while exporting
+type kdbxWriter struct {
+	random io.Reader
+	now    time.Time
+}
+
+// bytes returns n random bytes
+func (x *kdbxWriter) bytes(n int) ([]byte, error) {
+	data := make([]byte, n)
+	_, err := io.ReadFull(x.random, data)
+	return data, err
+}
+
+// uuid returns the base64 encoded uuid, a random one if it is zero
+func (x *kdbxWriter) uuid(uuid [16]byte) (string, error) {
+	if uuid == [16]byte{} {
+		if _, err := io.ReadFull(x.random, uuid[:]); err != nil {
+			return "", err
+		}
+	}
+	return base64.StdEncoding.EncodeToString(uuid[:]), nil
+}
+
+// times returns the KeePass times of an entry or group, zero times are now
+func (x *kdbxWriter) times(created, modified, accessed time.Time) kdbxTimes {
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			t = x.now
+		}
+		return formatKDBXTime(t)
+	}
+	return kdbxTimes{
+		CreationTime:         format(created),
+		LastModificationTime: format(modified),
+		LastAccessTime:       format(accessed),
+		ExpiryTime:           format(time.Time{}),
+		Expires:              formatKDBXBool(false),
+		LocationChanged:      format(modified),
+	}
+}
+
+// group returns a new empty group
+func (x *kdbxWriter) group(name string) (kdbxGroup, error) {
+	uuid, err := x.uuid([16]byte{})
+	return kdbxGroup{UUID: uuid, Name: name, Times: x.times(time.Time{}, time.Time{}, time.Time{})}, err
+}
+
+// path returns the group below root at the dot separated path, adding any groups which don't exist
+func (x *kdbxWriter) path(root *kdbxGroup, path string) (*kdbxGroup, error) {
+	group := root
+	if path == "" {
+		return group, nil
+	}
+	for _, name := range strings.Split(path, ".") {
+		var child *kdbxGroup
+		for i := range group.Groups {
+			if group.Groups[i].Name == name {
+				child = &group.Groups[i]
+				break
+			}
+		}
+		if child == nil {
+			added, err := x.group(name)
+			if err != nil {
+				return nil, err
+			}
+			group.Groups = append(group.Groups, added)
+			child = &group.Groups[len(group.Groups)-1]
+		}
+		group = child
+	}
+	return group, nil
+}
+
+// entry converts a record to an entry, the password history becomes previous versions of the entry
+func (x *kdbxWriter) entry(record Record) (kdbxEntry, error) {
+	uuid, err := x.uuid(record.UUID)
+	if err != nil {
+		return kdbxEntry{}, err
+	}
+	entry := kdbxEntry{
+		UUID:     uuid,
+		Times:    x.times(record.CreateTime, record.ModTime, record.AccessTime),
+		AutoType: &kdbxAutoType{Enabled: formatKDBXBool(true)},
+	}
+	if !record.PasswordExpiry.IsZero() {
+		entry.Times.Expires = formatKDBXBool(true)
+		entry.Times.ExpiryTime = formatKDBXTime(record.PasswordExpiry)
+	}
+	custom := map[string]string{kdbxEmail: record.Email, kdbxRunCommand: record.RunCommand}
+	if record.Autotype != "" {
+		if strings.ContainsAny(kdbxAutoTypeCodes.Replace(record.Autotype), `\{}+^%~()[]`) {
+			custom[kdbxAutotype] = record.Autotype
+		} else {
+			entry.AutoType.DefaultSequence = kdbxAutoTypeSequence.Replace(record.Autotype)
+		}
+	}
+	entry.Strings = []kdbxString{
+		{Key: kdbxTitle, Value: kdbxValue{Text: record.Title}},
+		{Key: kdbxUserName, Value: kdbxValue{Text: record.Username}},
+		{Key: kdbxPassword, Value: kdbxValue{Protected: formatKDBXBool(true), Text: record.Password}},
+		{Key: kdbxURL, Value: kdbxValue{Text: record.URL}},
+		{Key: kdbxNotes, Value: kdbxValue{Text: record.Notes}},
+	}
+	for _, key := range []string{kdbxEmail, kdbxRunCommand, kdbxAutotype} {
+		if custom[key] != "" {
+			entry.Strings = append(entry.Strings, kdbxString{Key: key, Value: kdbxValue{Text: custom[key]}})
+		}
+	}
+
+	// A version is saved with each previous password and with the current one when it was set
+	version := func(password string, changed time.Time) kdbxEntry {
+		v := entry
+		v.AutoType, v.Times = nil, x.times(record.CreateTime, changed, changed)
+		v.Strings = append([]kdbxString{}, entry.Strings...)
+		v.Strings[2].Value.Text = password
+		return v
+	}
+	var versions []kdbxEntry
+	if record.PasswordHistory != "" {
+		history, err := ParsePasswordHistory(record.PasswordHistory)
+		if err != nil {
+			return entry, err
+		}
+		for _, previous := range history.Entries {
+			versions = append(versions, version(previous.Password, previous.Changed))
+		}
+	}
+	if !record.PasswordModTime.IsZero() && record.PasswordModTime.Before(record.ModTime) {
+		versions = append(versions, version(record.Password, record.PasswordModTime))
+	}
+	if len(versions) > 0 {
+		entry.History = &kdbxHistory{Entries: versions}
+	}
+	return entry, nil
+}
+
+// format writes the KDBX 4 outer header
+func (h *kdbxHeader) format() []byte {
+	data := binary.LittleEndian.AppendUint32(nil, kdbxSignature1)
+	data = binary.LittleEndian.AppendUint32(data, kdbxSignature2)
+	data = binary.LittleEndian.AppendUint16(data, h.Minor)
+	data = binary.LittleEndian.AppendUint16(data, h.Major)
+	field := func(id byte, value []byte) {
+		data = append(data, id)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(value)))
+		data = append(data, value...)
+	}
+	var compression uint32
+	if h.Compressed {
+		compression = 1
+	}
+	field(kdbxCipherID, h.Cipher[:])
+	field(kdbxCompressionFlags, binary.LittleEndian.AppendUint32(nil, compression))
+	field(kdbxMasterSeed, h.MasterSeed)
+	field(kdbxEncryptionIV, h.EncryptionIV)
+	field(kdbxKdfParameters, h.KDF.format())
+	field(kdbxEndOfHeader, []byte("\r\n\r\n"))
+	return data
+}
+
+// write encrypts the XML document with fresh keys writing the KDBX 4 file to w
+func (x *kdbxWriter) write(w io.Writer, doc []byte, password string, opts KDBXOptions) error {
+	h := &kdbxHeader{
+		Major:      4,
+		Cipher:     kdbxCipherAES,
+		Compressed: true,
+		KDF: kdbxKDF{
+			UUID:        kdbxKDFArgon2d,
+			Parallelism: opts.Parallelism,
+			Memory:      opts.Memory,
+			Iterations:  opts.Iterations,
+			Version:     0x13,
+		},
+	}
+	if h.KDF.Parallelism == 0 {
+		h.KDF.Parallelism = kdbxDefaultParallelism
+	}
+	if h.KDF.Memory == 0 {
+		h.KDF.Memory = kdbxDefaultMemory
+	}
+	if h.KDF.Iterations == 0 {
+		h.KDF.Iterations = kdbxDefaultIterations
+	}
+	ivSize := 16
+	if opts.ChaCha20 {
+		h.Cipher, ivSize = kdbxCipherChaCha20, 12
+	}
+	var err error
+	if h.MasterSeed, err = x.bytes(32); err != nil {
+		return err
+	}
+	if h.EncryptionIV, err = x.bytes(ivSize); err != nil {
+		return err
+	}
+	if h.KDF.Seed, err = x.bytes(32); err != nil {
+		return err
+	}
+	streamKey, err := x.bytes(64)
+	if err != nil {
+		return err
+	}
+	h.raw = h.format()
+	// the limits on opening also apply so the file can be imported again
+	cipherKey, hmacKey, err := h.keys(context.Background(), password, OpenOptions{})
+	if err != nil {
+		return err
+	}
+
+	stream, err := kdbxInnerStream(kdbxStreamChaCha20, streamKey)
+	if err != nil {
+		return err
+	}
+	if doc, err = xorProtected(doc, stream, true); err != nil {
+		return err
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	innerField := func(id byte, value []byte) {
+		gz.Write([]byte{id})
+		gz.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(value))))
+		gz.Write(value)
+	}
+	innerField(kdbxInnerStreamID, binary.LittleEndian.AppendUint32(nil, kdbxStreamChaCha20))
+	innerField(kdbxInnerStreamKey, streamKey)
+	innerField(kdbxInnerEnd, nil)
+	gz.Write(doc)
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	encrypted, err := h.payload(cipherKey, compressed.Bytes(), true)
+	if err != nil {
+		return err
+	}
+
+	out := bytes.NewBuffer(h.raw)
+	headerHash := sha256.Sum256(h.raw)
+	out.Write(headerHash[:])
+	mac := hmac.New(sha256.New, kdbxBlockKey(hmacKey, math.MaxUint64))
+	mac.Write(h.raw)
+	out.Write(mac.Sum(nil))
+	if err := writeHMACBlocks(out, encrypted, hmacKey, kdbxBlockSize); err != nil {
+		return err
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
@@ -0,0 +1,217 @@
+package pwsafe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// kdbxAutoTypePlaceholders map KeePass auto-type placeholders to Password Safe autotype codes
+var kdbxAutoTypePlaceholders = strings.NewReplacer(
+	`\`, `\\`,
+	"{USERNAME}", `\u`,
+	"{PASSWORD}", `\p`,
+	"{TAB}", `\t`,
+	"{ENTER}", `\n`,
+)
+
+//ImportKDBX Reads a KeePass KDBX 3.1 or 4 file protected by password adding its entries with SetRecord, a record whose
+//title is already used is renamed with a numbered suffix. KeePass groups below the root group become the dot
+//separated group path, so a dot in a group name also separates groups, and groups without entries are added as empty
+//groups. The custom fields Email, Run Command and Autotype, as written by ExportKDBX, set those fields. Other custom
+//fields, tags and auto-type sequences which can't be converted are added to the notes and previous passwords in the
+//entry history to the password history. Entries in the recycle bin are skipped. The db name and description are imported if they are unset in the db.
+//Returns the number of records imported.
+func (db *V3) ImportKDBX(r io.Reader, password string) (int, error) {
+	return db.ImportKDBXContext(context.Background(), r, password, OpenOptions{})
+}
+
+//ImportKDBXContext Imports a KDBX file like ImportKDBX, stopping early if ctx is cancelled and rejecting files which
+//exceed the limits in opts. The AES-KDF rounds and Argon2 passes are limited by opts.MaxIterations and reported to
+//opts.Progress.
+func (db *V3) ImportKDBXContext(ctx context.Context, r io.Reader, password string, opts OpenOptions) (int, error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	content, err := readKDBX(ctx, r, password, opts)
+	if err != nil {
+		return 0, err
+	}
+	var doc kdbxXML
+	if err := xml.Unmarshal(content.XML, &doc); err != nil {
+		return 0, fmt.Errorf("Error parsing the KDBX XML - %w", err)
+	}
+	if doc.Meta.HeaderHash != "" {
+		hash := sha256.Sum256(content.Header.raw)
+		if doc.Meta.HeaderHash != base64.StdEncoding.EncodeToString(hash[:]) {
+			return 0, fmt.Errorf("%w, the KDBX header hash does not match", ErrBadHMAC)
+		}
+	}
+
+	var recycleBin [16]byte
+	if kdbxBool(doc.Meta.RecycleBinEnabled) {
+		recycleBin = kdbxUUID(doc.Meta.RecycleBinUUID)
+	}
+	var records []Record
+	var emptyGroups []string
+	var walk func(group kdbxGroup, path []string) error
+	walk = func(group kdbxGroup, path []string) error {
+		if recycleBin != [16]byte{} && kdbxUUID(group.UUID) == recycleBin {
+			return nil
+		}
+		if len(path) > 0 && len(group.Entries) == 0 && len(group.Groups) == 0 {
+			emptyGroups = append(emptyGroups, strings.Join(path, "."))
+		}
+		for _, entry := range group.Entries {
+			record, err := entry.record(strings.Join(path, "."))
+			if err != nil {
+				return fmt.Errorf("Error importing KDBX entry %q - %w", record.Title, err)
+			}
+			records = append(records, record)
+		}
+		for _, child := range group.Groups {
+			if err := walk(child, append(path[:len(path):len(path)], child.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(doc.Root.Group, nil); err != nil {
+		return 0, err
+	}
+
+	if db.Name == "" {
+		db.Name = doc.Meta.DatabaseName
+	}
+	if db.Description == "" {
+		db.Description = doc.Meta.DatabaseDescription
+	}
+	db.importRecords(records, emptyGroups)
+	return len(records), nil
+}
+
+// strings returns the string fields of the entry by key
+func (entry *kdbxEntry) strings() map[string]string {
+	fields := make(map[string]string, len(entry.Strings))
+	for _, s := range entry.Strings {
+		fields[s.Key] = s.Value.Text
+	}
+	return fields
+}
+
+// record converts the entry to a Record in group
+func (entry *kdbxEntry) record(group string) (Record, error) {
+	fields := entry.strings()
+	record := Record{
+		Group:    group,
+		Title:    fields[kdbxTitle],
+		Username: fields[kdbxUserName],
+		Password: fields[kdbxPassword],
+		URL:      fields[kdbxURL],
+		Notes:    fields[kdbxNotes],
+		UUID:     kdbxUUID(entry.UUID),
+	}
+	record.Title = importTitle(record.Title, record.URL)
+
+	var err error
+	for _, t := range []struct {
+		field *time.Time
+		value string
+	}{
+		{&record.CreateTime, entry.Times.CreationTime},
+		{&record.ModTime, entry.Times.LastModificationTime},
+		{&record.AccessTime, entry.Times.LastAccessTime},
+	} {
+		if *t.field, err = parseKDBXTime(t.value); err != nil {
+			return record, err
+		}
+	}
+	if kdbxBool(entry.Times.Expires) {
+		if record.PasswordExpiry, err = parseKDBXTime(entry.Times.ExpiryTime); err != nil {
+			return record, err
+		}
+	}
+
+	// Everything which doesn't have a Password Safe field is kept in the notes
+	var extra []string
+	var custom []string
+	for key, value := range fields {
+		switch key {
+		case kdbxTitle, kdbxUserName, kdbxPassword, kdbxURL, kdbxNotes:
+		case kdbxEmail:
+			record.Email = value
+		case kdbxRunCommand:
+			record.RunCommand = value
+		case kdbxAutotype:
+			record.Autotype = value
+		default:
+			custom = append(custom, key)
+		}
+	}
+	sort.Strings(custom)
+	for _, key := range custom {
+		extra = append(extra, key+": "+fields[key])
+	}
+	if entry.Tags != "" {
+		extra = append(extra, "Tags: "+entry.Tags)
+	}
+	if entry.AutoType != nil && entry.AutoType.DefaultSequence != "" && record.Autotype == "" {
+		autotype := kdbxAutoTypePlaceholders.Replace(entry.AutoType.DefaultSequence)
+		if strings.ContainsAny(autotype, "{}") {
+			extra = append(extra, "Auto-Type: "+entry.AutoType.DefaultSequence)
+		} else {
+			record.Autotype = autotype
+		}
+	}
+	for _, binary := range entry.Binaries {
+		extra = append(extra, "Attachment not imported: "+binary.Key)
+	}
+	record.Notes = appendNotes(record.Notes, extra)
+
+	// The password was set by the earliest version with the current password
+	record.PasswordModTime = record.ModTime
+	if entry.History != nil {
+		versions := entry.History.Entries
+		for i := len(versions) - 1; i >= 0 && versions[i].strings()[kdbxPassword] == record.Password; i-- {
+			if record.PasswordModTime, err = parseKDBXTime(versions[i].Times.LastModificationTime); err != nil {
+				return record, err
+			}
+		}
+		history, err := kdbxPasswordHistory(versions, record.Password)
+		if err != nil {
+			return record, err
+		}
+		if len(history.Entries) > 0 {
+			record.PasswordHistory = history.String()
+		}
+	}
+	return record, nil
+}
+
+// kdbxPasswordHistory returns the passwords of the previous versions of an entry, oldest first, which differ from the
+// version following them each with the time the version was saved
+func kdbxPasswordHistory(versions []kdbxEntry, current string) (PasswordHistory, error) {
+	history := PasswordHistory{Enabled: true}
+	for i, version := range versions {
+		password := version.strings()[kdbxPassword]
+		next := current
+		if i+1 < len(versions) {
+			next = versions[i+1].strings()[kdbxPassword]
+		}
+		if password == next {
+			continue
+		}
+		changed, err := parseKDBXTime(version.Times.LastModificationTime)
+		if err != nil {
+			return history, err
+		}
+		history.Entries = append(history.Entries, PasswordHistoryEntry{Changed: changed, Password: password})
+	}
+	return trimHistory(history), nil
+}
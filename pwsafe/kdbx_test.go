@@ -0,0 +1,482 @@
+package pwsafe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// kdbxTestFile describes a KDBX file to build for tests, it is written as KeePass would
+type kdbxTestFile struct {
+	major    uint16
+	cipher   [16]byte
+	kdf      kdbxKDF
+	stream   uint32
+	compress bool
+}
+
+// build encrypts the XML doc, values marked Protected are given in plain text and protected here
+func (f kdbxTestFile) build(t *testing.T, password, doc string) []byte {
+	le32 := func(n uint32) []byte { return binary.LittleEndian.AppendUint32(nil, n) }
+	le64 := func(n uint64) []byte { return binary.LittleEndian.AppendUint64(nil, n) }
+	streamKey := bytes.Repeat([]byte{7}, 32)
+	startBytes := bytes.Repeat([]byte{9}, 32)
+	iv := bytes.Repeat([]byte{5}, 16)
+	if f.cipher == kdbxCipherChaCha20 {
+		iv = iv[:12]
+	}
+
+	var raw bytes.Buffer
+	raw.Write(le32(kdbxSignature1))
+	raw.Write(le32(kdbxSignature2))
+	raw.Write(le32(uint32(f.major)<<16 | 1))
+	field := func(w *bytes.Buffer, short bool, id byte, value []byte) {
+		w.WriteByte(id)
+		if short {
+			w.Write(binary.LittleEndian.AppendUint16(nil, uint16(len(value))))
+		} else {
+			w.Write(le32(uint32(len(value))))
+		}
+		w.Write(value)
+	}
+	v3 := f.major == 3
+	compress := uint32(0)
+	if f.compress {
+		compress = 1
+	}
+	field(&raw, v3, kdbxCipherID, f.cipher[:])
+	field(&raw, v3, kdbxCompressionFlags, le32(compress))
+	field(&raw, v3, kdbxMasterSeed, bytes.Repeat([]byte{1}, 32))
+	field(&raw, v3, kdbxEncryptionIV, iv)
+	if v3 {
+		field(&raw, v3, kdbxTransformSeed, f.kdf.Seed)
+		field(&raw, v3, kdbxTransformRounds, le64(f.kdf.Rounds))
+		field(&raw, v3, kdbxProtectedStreamKey, streamKey)
+		field(&raw, v3, kdbxStreamStartBytes, startBytes)
+		field(&raw, v3, kdbxInnerRandomStream, le32(f.stream))
+	} else {
+		var variants bytes.Buffer
+		variants.Write([]byte{0, 1})
+		variant := func(typ byte, key string, value []byte) {
+			variants.WriteByte(typ)
+			variants.Write(le32(uint32(len(key))))
+			variants.WriteString(key)
+			variants.Write(le32(uint32(len(value))))
+			variants.Write(value)
+		}
+		variant(variantByteArray, "$UUID", f.kdf.UUID[:])
+		variant(variantByteArray, "S", f.kdf.Seed)
+		if f.kdf.UUID == kdbxKDFAES4 {
+			variant(variantUint64, "R", le64(f.kdf.Rounds))
+		} else {
+			variant(variantUint32, "P", le32(f.kdf.Parallelism))
+			variant(variantUint64, "M", le64(f.kdf.Memory))
+			variant(variantUint64, "I", le64(f.kdf.Iterations))
+			variant(variantUint32, "V", le32(0x13))
+		}
+		variants.WriteByte(0)
+		field(&raw, v3, kdbxKdfParameters, variants.Bytes())
+	}
+	field(&raw, v3, kdbxEndOfHeader, []byte("\r\n\r\n"))
+
+	header, err := readKDBXHeader(raw.Bytes())
+	assert.Nil(t, err)
+	cipherKey, hmacKey, err := header.keys(context.Background(), password, OpenOptions{})
+	assert.Nil(t, err)
+
+	hash := sha256.Sum256(raw.Bytes())
+	doc = strings.Replace(doc, "HEADERHASH", base64.StdEncoding.EncodeToString(hash[:]), 1)
+	stream, err := kdbxInnerStream(f.stream, streamKey)
+	assert.Nil(t, err)
+	doc = regexp.MustCompile(`<Value Protected="True">[^<]*</Value>`).ReplaceAllStringFunc(doc, func(value string) string {
+		plain := []byte(strings.TrimSuffix(strings.TrimPrefix(value, `<Value Protected="True">`), "</Value>"))
+		stream.XORKeyStream(plain, plain)
+		return `<Value Protected="True">` + base64.StdEncoding.EncodeToString(plain) + "</Value>"
+	})
+
+	var payload bytes.Buffer
+	if !v3 {
+		field(&payload, false, kdbxInnerStreamID, le32(f.stream))
+		field(&payload, false, kdbxInnerStreamKey, streamKey)
+		field(&payload, false, kdbxInnerBinary, []byte{1, 'a', 't', 't'})
+		field(&payload, false, kdbxInnerEnd, nil)
+	}
+	payload.WriteString(doc)
+	plain := payload.Bytes()
+	if f.compress {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(plain)
+		gz.Close()
+		plain = compressed.Bytes()
+	}
+
+	out := bytes.NewBuffer(raw.Bytes())
+	if v3 {
+		blockHash := sha256.Sum256(plain)
+		blocks := append(append([]byte{}, startBytes...), le32(0)...)
+		blocks = append(append(append(blocks, blockHash[:]...), le32(uint32(len(plain)))...), plain...)
+		blocks = append(append(append(blocks, le32(1)...), make([]byte, 32)...), le32(0)...)
+		encrypted, err := header.payload(cipherKey, blocks, true)
+		assert.Nil(t, err)
+		out.Write(encrypted)
+		return out.Bytes()
+	}
+
+	encrypted, err := header.payload(cipherKey, plain, true)
+	assert.Nil(t, err)
+	out.Write(hash[:])
+	mac := hmac.New(sha256.New, kdbxBlockKey(hmacKey, math.MaxUint64))
+	mac.Write(raw.Bytes())
+	out.Write(mac.Sum(nil))
+	for i, block := range [][]byte{encrypted, nil} {
+		mac := hmac.New(sha256.New, kdbxBlockKey(hmacKey, uint64(i)))
+		mac.Write(le64(uint64(i)))
+		mac.Write(le32(uint32(len(block))))
+		mac.Write(block)
+		out.Write(mac.Sum(nil))
+		out.Write(le32(uint32(len(block))))
+		out.Write(block)
+	}
+	return out.Bytes()
+}
+
+// kdbxTestDoc has entries in the root group, nested groups and the recycle bin
+const kdbxTestDoc = `<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<KeePassFile>
+	<Meta>
+		<Generator>KeePass</Generator>
+		<HeaderHash>HEADERHASH</HeaderHash>
+		<DatabaseName>keepass db</DatabaseName>
+		<DatabaseDescription>from keepass</DatabaseDescription>
+		<RecycleBinEnabled>True</RecycleBinEnabled>
+		<RecycleBinUUID>AgICAgICAgICAgICAgICAg==</RecycleBinUUID>
+	</Meta>
+	<Root>
+		<Group>
+			<UUID>AAAAAAAAAAAAAAAAAAAAAQ==</UUID>
+			<Name>Root</Name>
+			<Entry>
+				<UUID>AQEBAQEBAQEBAQEBAQEBAQ==</UUID>
+				<Tags>work;mail</Tags>
+				<Times>
+					<CreationTime>2020-01-02T03:04:05Z</CreationTime>
+					<LastModificationTime>gG9H2A4AAAA=</LastModificationTime>
+					<LastAccessTime>2021-01-01T00:00:00Z</LastAccessTime>
+					<ExpiryTime>2030-01-01T00:00:00Z</ExpiryTime>
+					<Expires>True</Expires>
+				</Times>
+				<String><Key>Title</Key><Value>mail</Value></String>
+				<String><Key>UserName</Key><Value>alice</Value></String>
+				<String><Key>Password</Key><Value Protected="True">current</Value></String>
+				<String><Key>URL</Key><Value>https://mail.example.com</Value></String>
+				<String><Key>Notes</Key><Value>some notes</Value></String>
+				<String><Key>PIN</Key><Value Protected="True">1234</Value></String>
+				<Binary><Key>key.pem</Key><Value Ref="0"/></Binary>
+				<AutoType><Enabled>True</Enabled><DefaultSequence>{USERNAME}{TAB}{PASSWORD}{ENTER}</DefaultSequence></AutoType>
+				<History>
+					<Entry>
+						<Times><LastModificationTime>2020-01-02T03:04:05Z</LastModificationTime></Times>
+						<String><Key>Password</Key><Value Protected="True">first</Value></String>
+					</Entry>
+					<Entry>
+						<Times><LastModificationTime>2020-06-01T00:00:00Z</LastModificationTime></Times>
+						<String><Key>Password</Key><Value Protected="True">first</Value></String>
+					</Entry>
+					<Entry>
+						<Times><LastModificationTime>2021-01-01T00:00:00Z</LastModificationTime></Times>
+						<String><Key>Password</Key><Value Protected="True">current</Value></String>
+					</Entry>
+				</History>
+			</Entry>
+			<Group>
+				<UUID>AwMDAwMDAwMDAwMDAwMDAw==</UUID>
+				<Name>Banking</Name>
+				<Group>
+					<UUID>BAQEBAQEBAQEBAQEBAQEBA==</UUID>
+					<Name>Cards</Name>
+					<Entry>
+						<UUID>BQUFBQUFBQUFBQUFBQUFBQ==</UUID>
+						<String><Key>Title</Key><Value>mail</Value></String>
+						<String><Key>UserName</Key><Value>bob &amp; carol</Value></String>
+						<String><Key>Password</Key><Value Protected="True">pin & card</Value></String>
+						<AutoType><Enabled>True</Enabled><DefaultSequence>{USERNAME}{DELAY 100}</DefaultSequence></AutoType>
+					</Entry>
+				</Group>
+			</Group>
+			<Group>
+				<UUID>AgICAgICAgICAgICAgICAg==</UUID>
+				<Name>Recycle Bin</Name>
+				<Entry>
+					<String><Key>Title</Key><Value>deleted</Value></String>
+					<String><Key>Password</Key><Value Protected="True">gone</Value></String>
+				</Entry>
+			</Group>
+		</Group>
+	</Root>
+</KeePassFile>
+`
+
+func TestImportKDBX(t *testing.T) {
+	salt := bytes.Repeat([]byte{3}, 32)
+	files := map[string]kdbxTestFile{
+		"3.1 AES-KDF AES Salsa20": {major: 3, cipher: kdbxCipherAES, kdf: kdbxKDF{UUID: kdbxKDFAES3, Rounds: 100, Seed: salt},
+			stream: kdbxStreamSalsa20, compress: true},
+		"4 Argon2d ChaCha20": {major: 4, cipher: kdbxCipherChaCha20, kdf: kdbxKDF{UUID: kdbxKDFArgon2d, Seed: salt,
+			Parallelism: 2, Memory: 64 * 1024, Iterations: 2}, stream: kdbxStreamChaCha20, compress: true},
+		"4 Argon2id AES": {major: 4, cipher: kdbxCipherAES, kdf: kdbxKDF{UUID: kdbxKDFArgon2id, Seed: salt,
+			Parallelism: 1, Memory: 32 * 1024, Iterations: 1}, stream: kdbxStreamChaCha20},
+		"4 AES-KDF AES": {major: 4, cipher: kdbxCipherAES, kdf: kdbxKDF{UUID: kdbxKDFAES4, Rounds: 10, Seed: salt},
+			stream: kdbxStreamSalsa20, compress: true},
+	}
+	for name, file := range files {
+		doc := kdbxTestDoc
+		if file.major == 4 {
+			doc = strings.Replace(doc, "<HeaderHash>HEADERHASH</HeaderHash>", "", 1)
+		}
+		data := file.build(t, "keepass", doc)
+
+		db := NewV3("", "password")
+		n, err := db.ImportKDBX(bytes.NewReader(data), "keepass")
+		assert.Nil(t, err, name)
+		assert.Equal(t, 2, n, name)
+		assert.Equal(t, "keepass db", db.Name, name)
+		assert.Equal(t, "from keepass", db.Description, name)
+		assert.Equal(t, []string{"mail", "mail (2)"}, db.List(), name)
+
+		mail, _ := db.GetRecord("mail")
+		assert.Equal(t, "", mail.Group, name)
+		assert.Equal(t, "alice", mail.Username, name)
+		assert.Equal(t, "current", mail.Password, name)
+		assert.Equal(t, "https://mail.example.com", mail.URL, name)
+		assert.Equal(t, "some notes\r\n\r\nPIN: 1234\r\nTags: work;mail\r\nAttachment not imported: key.pem", mail.Notes,
+			name)
+		assert.Equal(t, `\u\t\p\n`, mail.Autotype, name)
+		assert.Equal(t, [16]byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, mail.UUID, name)
+		assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), mail.CreateTime.UTC(), name)
+		assert.Equal(t, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), mail.ModTime.UTC(), name)
+		assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), mail.AccessTime.UTC(), name)
+		assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), mail.PasswordModTime.UTC(), name)
+		assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), mail.PasswordExpiry.UTC(), name)
+		history, err := ParsePasswordHistory(mail.PasswordHistory)
+		assert.Nil(t, err, name)
+		assert.Equal(t, 1, len(history.Entries), name)
+		assert.Equal(t, "first", history.Entries[0].Password, name)
+		assert.Equal(t, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), history.Entries[0].Changed.UTC(), name)
+
+		card, _ := db.GetRecord("mail (2)")
+		assert.Equal(t, "Banking.Cards", card.Group, name)
+		assert.Equal(t, "pin & card", card.Password, name)
+		assert.Equal(t, "bob & carol", card.Username, name)
+		assert.Equal(t, "Auto-Type: {USERNAME}{DELAY 100}", card.Notes, name)
+		assert.Equal(t, "", card.Autotype, name)
+
+		// Wrong passwords and damage are detected
+		db = NewV3("", "password")
+		_, err = db.ImportKDBX(bytes.NewReader(data), "wrong")
+		assert.True(t, errors.Is(err, ErrInvalidPassword), name)
+		data[len(data)-50] ^= 1
+		_, err = db.ImportKDBX(bytes.NewReader(data), "keepass")
+		assert.NotNil(t, err, name)
+		assert.Equal(t, 0, len(db.Records), name)
+	}
+
+	db := NewV3("", "password")
+	_, err := db.ImportKDBX(strings.NewReader("not a kdbx file"), "keepass")
+	assert.True(t, errors.Is(err, ErrNotKDBX))
+	data := files["4 AES-KDF AES"].build(t, "keepass", kdbxTestDoc)
+	data = bytes.Replace(data, kdbxKDFAES4[:], make([]byte, 16), 1)
+	_, err = db.ImportKDBX(bytes.NewReader(data), "keepass")
+	assert.True(t, errors.Is(err, ErrNotKDBX))
+
+	db.readOnly = true
+	_, err = db.ImportKDBX(strings.NewReader("not a kdbx file"), "keepass")
+	assert.Equal(t, ErrReadOnly, err)
+}
+
+// The keepass test files were saved by KeePass, they are the example files of the gokeepasslib tests
+// (github.com/tobischo/gokeepasslib, MIT license) and all have the password abcdefg12345678
+func TestImportKeePassFiles(t *testing.T) {
+	for file, records := range map[string]int{
+		"./test_dbs/keepass3.kdbx":          3,
+		"./test_dbs/keepass4.kdbx":          4,
+		"./test_dbs/keepass4-chacha20.kdbx": 4,
+	} {
+		f, err := os.Open(file)
+		assert.Nil(t, err, file)
+		db := NewV3("", "password")
+		n, err := db.ImportKDBX(f, "abcdefg12345678")
+		f.Close()
+		assert.Nil(t, err, file)
+		assert.Equal(t, records, n, file)
+		assert.Equal(t, []string{"Network", "Internet", "eMail", "Homebanking"}, db.EmptyGroups, file)
+
+		sample, _ := db.GetRecord("Sample Entry")
+		assert.Equal(t, "General", sample.Group, file)
+		assert.Equal(t, "User Name", sample.Username, file)
+		assert.Equal(t, "Password", sample.Password, file)
+		assert.Equal(t, "http://keepass.info/", sample.URL, file)
+		assert.Equal(t, "Notes", sample.Notes, file)
+		assert.Equal(t, "ac69c17b58088a42bcf5a643ea7fe994", hex.EncodeToString(sample.UUID[:]), file)
+		assert.Equal(t, time.Date(2015, 6, 19, 15, 38, 42, 0, time.UTC), sample.CreateTime.UTC(), file)
+
+		sample2, _ := db.GetRecord("Sample Entry2")
+		assert.Equal(t, "test", sample2.Username, file)
+		assert.Equal(t, "AnotherPassword", sample2.Password, file)
+		assert.Equal(t, time.Date(2015, 9, 11, 19, 11, 28, 0, time.UTC), sample2.CreateTime.UTC(), file)
+
+		attached, _ := db.GetRecord("File test")
+		assert.Equal(t, "Windows", attached.Group, file)
+		assert.Equal(t, "Attachment not imported: example.txt", attached.Notes, file)
+		if records == 4 {
+			copied, _ := db.GetRecord("File test - Copy")
+			assert.Equal(t, "test: prova\r\nAttachment not imported: example.txt", copied.Notes, file)
+			assert.Equal(t, time.Date(2018, 11, 13, 14, 56, 2, 0, time.UTC), copied.CreateTime.UTC(), file)
+		}
+
+		f, err = os.Open(file)
+		assert.Nil(t, err, file)
+		_, err = NewV3("", "password").ImportKDBX(f, "wrong")
+		f.Close()
+		assert.True(t, errors.Is(err, ErrInvalidPassword), file)
+	}
+}
+
+func TestKDBXLimits(t *testing.T) {
+	seed := bytes.Repeat([]byte{3}, 32)
+	var composite [32]byte
+	ctx := context.Background()
+	for name, kdf := range map[string]kdbxKDF{
+		"AES rounds": {UUID: kdbxKDFAES3, Rounds: math.MaxUint64, Seed: seed},
+		"Argon2 passes": {UUID: kdbxKDFArgon2d, Seed: seed, Parallelism: 1, Memory: 1024,
+			Iterations: math.MaxUint32, Version: 0x13},
+		"Argon2 memory": {UUID: kdbxKDFArgon2d, Seed: seed, Parallelism: 1, Memory: 2 << 30, Iterations: 1,
+			Version: 0x13},
+		"Argon2 total work": {UUID: kdbxKDFArgon2id, Seed: seed, Parallelism: 1, Memory: 1 << 30, Iterations: 100,
+			Version: 0x13},
+		"Argon2 max options": {UUID: kdbxKDFArgon2id, Seed: seed, Parallelism: 1, Memory: 1024, Iterations: 11,
+			Version: 0x13},
+	} {
+		opts := OpenOptions{}
+		if name == "Argon2 max options" {
+			opts.MaxIterations = 10
+		}
+		_, err := kdf.transformKey(ctx, composite, opts)
+		assert.True(t, errors.Is(err, ErrIterationsExceeded), name)
+	}
+
+	argon := kdbxKDF{UUID: kdbxKDFArgon2d, Seed: seed, Parallelism: 1, Memory: 1024 * 1024, Iterations: 2, Version: 0x10}
+	_, err := argon.transformKey(ctx, composite, OpenOptions{})
+	assert.True(t, errors.Is(err, ErrNotKDBX))
+
+	// Progress is reported and cancellation stops the KDF
+	aes := kdbxKDF{UUID: kdbxKDFAES4, Rounds: 3 * progressInterval, Seed: seed}
+	argon.Version = 0x13
+	for _, kdf := range []kdbxKDF{aes, argon} {
+		var done []uint32
+		_, err = kdf.transformKey(ctx, composite, OpenOptions{Progress: func(n, total uint32) { done = append(done, n) }})
+		assert.Nil(t, err)
+		assert.True(t, len(done) > 1)
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err = kdf.transformKey(cancelled, composite, OpenOptions{})
+		assert.Equal(t, context.Canceled, err)
+	}
+
+	// The import limits apply
+	file := kdbxTestFile{major: 4, cipher: kdbxCipherAES, kdf: kdbxKDF{UUID: kdbxKDFAES4, Rounds: 100, Seed: seed},
+		stream: kdbxStreamSalsa20}
+	data := file.build(t, "keepass", strings.Replace(kdbxTestDoc, "<HeaderHash>HEADERHASH</HeaderHash>", "", 1))
+	db := NewV3("", "password")
+	_, err = db.ImportKDBXContext(ctx, bytes.NewReader(data), "keepass", OpenOptions{MaxIterations: 50})
+	assert.True(t, errors.Is(err, ErrIterationsExceeded))
+	n, err := db.ImportKDBXContext(ctx, bytes.NewReader(data), "keepass", OpenOptions{MaxIterations: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestExportKDBX(t *testing.T) {
+	source := fullDB()
+	source.SetRecord(Record{Title: "delayed", Group: "group", Password: "pw", Autotype: `\u\d100\p`})
+	for name, opts := range map[string]KDBXOptions{
+		"AES":      {Memory: 64 * 1024, Iterations: 1, Parallelism: 1},
+		"ChaCha20": {ChaCha20: true, Memory: 32 * 1024, Iterations: 2, Parallelism: 2},
+	} {
+		var buf bytes.Buffer
+		assert.Nil(t, ExportKDBX(source, &buf, "keepass", opts), name)
+
+		content, err := readKDBX(context.Background(), bytes.NewReader(buf.Bytes()), "keepass", OpenOptions{})
+		assert.Nil(t, err, name)
+		assert.Equal(t, uint16(4), content.Header.Major, name)
+		assert.Equal(t, kdbxKDFArgon2d, content.Header.KDF.UUID, name)
+		assert.Equal(t, opts.Memory, content.Header.KDF.Memory, name)
+		assert.Equal(t, opts.ChaCha20, content.Header.Cipher == kdbxCipherChaCha20, name)
+		// The password is only in the file protected
+		assert.False(t, bytes.Contains(buf.Bytes(), []byte("current")), name)
+
+		imported := NewV3("", "password")
+		n, err := imported.ImportKDBX(bytes.NewReader(buf.Bytes()), "keepass")
+		assert.Nil(t, err, name)
+		assert.Equal(t, len(source.Records), n, name)
+		assert.Equal(t, source.Name, imported.Name, name)
+		assert.Equal(t, source.Description, imported.Description, name)
+		// Only groups without subgroups are empty in KeePass
+		assert.Equal(t, []string{"empty.sub"}, imported.EmptyGroups, name)
+		assert.Equal(t, source.List(), imported.List(), name)
+		for title, want := range source.Records {
+			got, _ := imported.GetRecord(title)
+			assert.Equal(t, want.Group, got.Group, title)
+			assert.Equal(t, want.Username, got.Username, title)
+			assert.Equal(t, want.Password, got.Password, title)
+			assert.Equal(t, want.URL, got.URL, title)
+			assert.Equal(t, want.Notes, got.Notes, title)
+			assert.Equal(t, want.Email, got.Email, title)
+			assert.Equal(t, want.RunCommand, got.RunCommand, title)
+			assert.Equal(t, want.Autotype, got.Autotype, title)
+			assert.Equal(t, want.UUID, got.UUID, title)
+			assert.Equal(t, want.CreateTime.Unix(), got.CreateTime.Unix(), title)
+			assert.Equal(t, want.ModTime.Unix(), got.ModTime.Unix(), title)
+			assert.Equal(t, want.PasswordExpiry.Unix(), got.PasswordExpiry.Unix(), title)
+			// Unset times are exported as the time of export
+			if !want.AccessTime.IsZero() {
+				assert.Equal(t, want.AccessTime.Unix(), got.AccessTime.Unix(), title)
+			}
+			if !want.PasswordModTime.IsZero() {
+				assert.Equal(t, want.PasswordModTime.Unix(), got.PasswordModTime.Unix(), title)
+			}
+
+			wantHistory, err := ParsePasswordHistory(want.PasswordHistory)
+			assert.Nil(t, err, title)
+			gotHistory, err := ParsePasswordHistory(got.PasswordHistory)
+			assert.Nil(t, err, title)
+			assert.Equal(t, len(wantHistory.Entries), len(gotHistory.Entries), title)
+			for i := range wantHistory.Entries {
+				assert.Equal(t, wantHistory.Entries[i].Password, gotHistory.Entries[i].Password, title)
+				assert.Equal(t, wantHistory.Entries[i].Changed.Unix(), gotHistory.Entries[i].Changed.Unix(), title)
+			}
+		}
+	}
+
+	// Autotype codes KeePass has placeholders for are converted
+	var buf bytes.Buffer
+	assert.Nil(t, ExportKDBX(source, &buf, "keepass", KDBXOptions{Memory: 8 * 1024, Iterations: 1, Parallelism: 1}))
+	content, err := readKDBX(context.Background(), &buf, "keepass", OpenOptions{})
+	assert.Nil(t, err)
+	assert.True(t, bytes.Contains(content.XML, []byte("<DefaultSequence>{USERNAME}{TAB}{PASSWORD}{ENTER}</DefaultSequence>")))
+	assert.True(t, bytes.Contains(content.XML, []byte(`<Value>\u\d100\p</Value>`)))
+}
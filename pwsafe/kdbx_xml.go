@@ -0,0 +1,147 @@
+package pwsafe
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The XML document inside a KDBX file, only the elements mapped to Password Safe are kept. Element order is that
+// KeePass writes.
+
+type kdbxXML struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    kdbxMeta `xml:"Meta"`
+	Root    struct {
+		Group kdbxGroup `xml:"Group"`
+	} `xml:"Root"`
+}
+
+type kdbxMeta struct {
+	Generator           string                `xml:"Generator"`
+	HeaderHash          string                `xml:"HeaderHash,omitempty"` // KDBX 3.1 only
+	DatabaseName        string                `xml:"DatabaseName"`
+	DatabaseDescription string                `xml:"DatabaseDescription"`
+	MemoryProtection    *kdbxMemoryProtection `xml:"MemoryProtection"`
+	RecycleBinEnabled   string                `xml:"RecycleBinEnabled,omitempty"`
+	RecycleBinUUID      string                `xml:"RecycleBinUUID,omitempty"`
+}
+
+type kdbxMemoryProtection struct {
+	ProtectTitle    string
+	ProtectUserName string
+	ProtectPassword string
+	ProtectURL      string
+	ProtectNotes    string
+}
+
+type kdbxGroup struct {
+	UUID    string      `xml:"UUID"`
+	Name    string      `xml:"Name"`
+	Notes   string      `xml:"Notes,omitempty"`
+	Times   kdbxTimes   `xml:"Times"`
+	Entries []kdbxEntry `xml:"Entry"`
+	Groups  []kdbxGroup `xml:"Group"`
+}
+
+type kdbxTimes struct {
+	CreationTime         string `xml:"CreationTime"`
+	LastModificationTime string `xml:"LastModificationTime"`
+	LastAccessTime       string `xml:"LastAccessTime"`
+	ExpiryTime           string `xml:"ExpiryTime"`
+	Expires              string `xml:"Expires"`
+	UsageCount           int    `xml:"UsageCount"`
+	LocationChanged      string `xml:"LocationChanged"`
+}
+
+type kdbxEntry struct {
+	UUID     string        `xml:"UUID"`
+	Tags     string        `xml:"Tags,omitempty"`
+	Times    kdbxTimes     `xml:"Times"`
+	Strings  []kdbxString  `xml:"String"`
+	Binaries []kdbxString  `xml:"Binary"`
+	AutoType *kdbxAutoType `xml:"AutoType"`
+	History  *kdbxHistory  `xml:"History"`
+}
+
+type kdbxString struct {
+	Key   string    `xml:"Key"`
+	Value kdbxValue `xml:"Value"`
+}
+
+type kdbxValue struct {
+	Protected string `xml:"Protected,attr,omitempty"`
+	Ref       string `xml:"Ref,attr,omitempty"` // set for binaries
+	Text      string `xml:",chardata"`
+}
+
+type kdbxAutoType struct {
+	Enabled                 string `xml:"Enabled"`
+	DataTransferObfuscation int    `xml:"DataTransferObfuscation"`
+	DefaultSequence         string `xml:"DefaultSequence,omitempty"`
+}
+
+type kdbxHistory struct {
+	Entries []kdbxEntry `xml:"Entry"`
+}
+
+// The string fields KeePass always has
+const (
+	kdbxTitle    = "Title"
+	kdbxUserName = "UserName"
+	kdbxPassword = "Password"
+	kdbxURL      = "URL"
+	kdbxNotes    = "Notes"
+)
+
+// kdbxEpoch is the number of seconds from the KDBX 4 time epoch, 0001-01-01, to the Unix epoch
+const kdbxEpoch = 62135596800
+
+// parseKDBXTime parses either a KDBX 3.1 ISO time or a KDBX 4 base64 encoded count of seconds, empty is the zero time
+func parseKDBXTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if strings.Contains(s, "-") {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return t, fmt.Errorf("%w, invalid KDBX time %q", ErrInvalidField, s)
+		}
+		return t.Local(), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(data) != 8 {
+		return time.Time{}, fmt.Errorf("%w, invalid KDBX time %q", ErrInvalidField, s)
+	}
+	return time.Unix(int64(binary.LittleEndian.Uint64(data))-kdbxEpoch, 0), nil
+}
+
+// formatKDBXTime formats a time as a KDBX 4 base64 encoded count of seconds
+func formatKDBXTime(t time.Time) string {
+	return base64.StdEncoding.EncodeToString(binary.LittleEndian.AppendUint64(nil, uint64(t.Unix()+kdbxEpoch)))
+}
+
+// formatKDBXBool formats a KeePass boolean
+func formatKDBXBool(b bool) string {
+	if b {
+		return "True"
+	}
+	return "False"
+}
+
+// kdbxBool parses a KeePass boolean, which is written True or False
+func kdbxBool(s string) bool {
+	return strings.EqualFold(s, "True")
+}
+
+// kdbxUUID decodes a base64 UUID, the zero UUID is returned if it is invalid
+func kdbxUUID(s string) [16]byte {
+	var uuid [16]byte
+	if data, err := base64.StdEncoding.DecodeString(s); err == nil && len(data) == len(uuid) {
+		copy(uuid[:], data)
+	}
+	return uuid
+}
@@ -0,0 +1,355 @@
+package pwsafe
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/blowfish"
+)
+
+// The V1 and V2 formats of Password Safe 1.x and 2.x have no tag. The file starts with 8 random bytes and a hash of
+// them and the password which verifies it, then a salt and the IV for Blowfish in CBC mode. Each field is a block
+// holding its length, and in V2 its type, followed by its data padded to whole blocks. V1 records are a name, which
+// holds the title and username, a password and notes. V2 starts with a header record then typed fields with an end
+// of record field. There is no HMAC.
+
+const (
+	legacyRandSize  = 8
+	legacyHashSize  = sha1.Size
+	legacySaltSize  = 20
+	legacyBlockSize = blowfish.BlockSize
+	// legacyPreludeSize is the size of the random bytes, their hash, the salt and the IV
+	legacyPreludeSize = legacyRandSize + legacyHashSize + legacySaltSize + legacyBlockSize
+	// legacyHashRounds is the number of times the random bytes are encrypted to check the password
+	legacyHashRounds = 1000
+	// legacyV2Header is the name of the first record of a V2 file, so V1 clients show a warning
+	legacyV2Header = "!!!Version 2 File Format!!! Please upgrade to PasswordSafe 2.0 or later"
+	// legacySplitChar separates the title from the username in a V1 name
+	legacySplitChar = '\u00ad'
+	// legacyDefaultUserChar ends the title of a V1 name whose username is the client's default username
+	legacyDefaultUserChar = '\u00a0'
+)
+
+// V2 field types
+const (
+	legacyName     = 0x00
+	legacyUUID     = 0x01
+	legacyGroup    = 0x02
+	legacyTitle    = 0x03
+	legacyUser     = 0x04
+	legacyNotes    = 0x05
+	legacyPassword = 0x06
+	legacyEnd      = 0xff
+)
+
+// legacyBlowfish is Blowfish as Password Safe V1 and V2 use it, the implementation loads each 32 bit half of a block
+// little endian rather than big endian
+type legacyBlowfish struct {
+	c *blowfish.Cipher
+}
+
+func newLegacyBlowfish(key []byte) (legacyBlowfish, error) {
+	c, err := blowfish.NewCipher(key)
+	return legacyBlowfish{c}, err
+}
+
+func (b legacyBlowfish) BlockSize() int { return legacyBlockSize }
+
+func (b legacyBlowfish) Encrypt(dst, src []byte) {
+	swapBlowfishHalves(dst, src)
+	b.c.Encrypt(dst, dst)
+	swapBlowfishHalves(dst, dst)
+}
+
+func (b legacyBlowfish) Decrypt(dst, src []byte) {
+	swapBlowfishHalves(dst, src)
+	b.c.Decrypt(dst, dst)
+	swapBlowfishHalves(dst, dst)
+}
+
+// swapBlowfishHalves reverses the byte order of each 32 bit half of a block
+func swapBlowfishHalves(dst, src []byte) {
+	left, right := binary.LittleEndian.Uint32(src[0:4]), binary.LittleEndian.Uint32(src[4:8])
+	binary.BigEndian.PutUint32(dst[0:4], left)
+	binary.BigEndian.PutUint32(dst[4:8], right)
+}
+
+// legacyRandHash is the hash stored to check the password. The random bytes, extended with two zero bytes, are
+// encrypted with a key hashed from them and the password then hashed again by SHA-1 with its initial state zeroed, as
+// the original SHA-1 implementation wiped its state when finished and the context was reused.
+func legacyRandHash(random []byte, password []byte) ([legacyHashSize]byte, error) {
+	stuff := make([]byte, legacyRandSize+2)
+	copy(stuff, random)
+	key := sha1.Sum(append(append([]byte{}, stuff...), password...))
+	block, err := newLegacyBlowfish(key[:])
+	if err != nil {
+		return [legacyHashSize]byte{}, err
+	}
+	for i := 0; i < legacyHashRounds; i++ {
+		block.Encrypt(stuff, stuff)
+	}
+	return sha1WithState([5]uint32{}, stuff), nil
+}
+
+// sha1WithState is SHA-1 starting from the given initial state rather than the standard one
+func sha1WithState(h [5]uint32, data []byte) [sha1.Size]byte {
+	msg := append(append([]byte{}, data...), 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.BigEndian.AppendUint64(msg, uint64(len(data))*8)
+	for ; len(msg) > 0; msg = msg[64:] {
+		var w [80]uint32
+		for i := 0; i < 16; i++ {
+			w[i] = binary.BigEndian.Uint32(msg[4*i:])
+		}
+		for i := 16; i < 80; i++ {
+			w[i] = bits.RotateLeft32(w[i-3]^w[i-8]^w[i-14]^w[i-16], 1)
+		}
+		a, b, c, d, e := h[0], h[1], h[2], h[3], h[4]
+		for i := 0; i < 80; i++ {
+			var f, k uint32
+			switch {
+			case i < 20:
+				f, k = b&c|^b&d, 0x5a827999
+			case i < 40:
+				f, k = b^c^d, 0x6ed9eba1
+			case i < 60:
+				f, k = b&c|b&d|c&d, 0x8f1bbcdc
+			default:
+				f, k = b^c^d, 0xca62c1d6
+			}
+			a, b, c, d, e = bits.RotateLeft32(a, 5)+f+e+k+w[i], a, bits.RotateLeft32(b, 30), c, d
+		}
+		h[0], h[1], h[2], h[3], h[4] = h[0]+a, h[1]+b, h[2]+c, h[3]+d, h[4]+e
+	}
+	var sum [sha1.Size]byte
+	for i, v := range h {
+		binary.BigEndian.PutUint32(sum[4*i:], v)
+	}
+	return sum
+}
+
+// legacyPasswords are the encodings of the password to try, the clients used the system code page so for a
+// non-ASCII password Latin-1 is tried after UTF-8
+func legacyPasswords(password string) [][]byte {
+	passwords := [][]byte{[]byte(password)}
+	latin1 := make([]byte, 0, len(password))
+	for _, r := range password {
+		if r > 0xff {
+			return passwords
+		}
+		latin1 = append(latin1, byte(r))
+	}
+	if !bytes.Equal(latin1, passwords[0]) {
+		passwords = append(passwords, latin1)
+	}
+	return passwords
+}
+
+// legacyString decodes text which is UTF-8 if valid, otherwise it is taken as Latin-1
+func legacyString(data []byte) string {
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// legacyFieldReader reads the CBC encrypted fields of a V1 or V2 file
+type legacyFieldReader struct {
+	in  io.Reader
+	cbc cipher.BlockMode
+}
+
+// next reads a field, io.EOF is returned only at the end of the data
+func (r *legacyFieldReader) next() (byte, []byte, error) {
+	var block [legacyBlockSize]byte
+	if n, err := io.ReadFull(r.in, block[:]); err != nil {
+		if err == io.ErrUnexpectedEOF || (err == io.EOF && n > 0) {
+			return 0, nil, fmt.Errorf("%w, field length", ErrTruncated)
+		}
+		return 0, nil, err
+	}
+	r.cbc.CryptBlocks(block[:], block[:])
+	length := int64(binary.LittleEndian.Uint32(block[0:4]))
+	padded := (length + legacyBlockSize - 1) / legacyBlockSize * legacyBlockSize
+	if padded == 0 {
+		padded = legacyBlockSize
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r.in, padded); err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("%w, field data", ErrTruncated)
+		}
+		return 0, nil, err
+	}
+	data := buf.Bytes()
+	r.cbc.CryptBlocks(data, data)
+	return block[4], data[:length], nil
+}
+
+//ReadLegacy Reads a Password Safe V1 or V2 file, the Blowfish based formats of Password Safe 1.x and 2.x, returning a V3
+//db with its records which is saved in the V3 format. The db has a new UUID and key and so needs saving, the records
+//keep any UUID and are given unique titles. ErrInvalidPassword is returned if the password is wrong, which as the
+//formats have no tag is also the case for data which is not a V1 or V2 file.
+func ReadLegacy(r io.Reader, passwd string, opts OpenOptions) (*V3, error) {
+	in := &countingReader{r: r, max: opts.maxFileSize()}
+	var prelude [legacyPreludeSize]byte
+	if _, err := io.ReadFull(in, prelude[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w, DB file is smaller than minimum size", ErrTruncated)
+		}
+		return nil, err
+	}
+	random := prelude[:legacyRandSize]
+	hash := prelude[legacyRandSize : legacyRandSize+legacyHashSize]
+	salt := prelude[legacyRandSize+legacyHashSize : legacyRandSize+legacyHashSize+legacySaltSize]
+	iv := prelude[legacyPreludeSize-legacyBlockSize:]
+
+	var password []byte
+	for _, candidate := range legacyPasswords(passwd) {
+		randHash, err := legacyRandHash(random, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(randHash[:], hash) {
+			password = candidate
+			break
+		}
+	}
+	if password == nil {
+		return nil, ErrInvalidPassword
+	}
+	key := sha1.Sum(append(append([]byte{}, password...), salt...))
+	block, err := newLegacyBlowfish(key[:])
+	if err != nil {
+		return nil, err
+	}
+	fields := &legacyFieldReader{in: in, cbc: cipher.NewCBCDecrypter(block, iv)}
+
+	records, version, err := readLegacyRecords(fields)
+	if err != nil {
+		return nil, err
+	}
+	db := NewV3("", passwd)
+	db.legacyVersion = version
+	for i := range records {
+		if records[i].Title == "" {
+			records[i].Title = "Untitled"
+		}
+	}
+	db.importRecords(records, nil)
+	db.readOnly = opts.ReadOnly
+	return db, nil
+}
+
+// readLegacyRecords reads the records of a V1 or V2 file returning them and the version
+func readLegacyRecords(fields *legacyFieldReader) ([]Record, int, error) {
+	var records []Record
+	_, name, err := fields.next()
+	if err == io.EOF {
+		return nil, 1, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if string(name) != legacyV2Header {
+		// V1 records are a name, password and notes
+		for index := 0; ; index++ {
+			if index > 0 {
+				if _, name, err = fields.next(); err == io.EOF {
+					return records, 1, nil
+				} else if err != nil {
+					return nil, 0, err
+				}
+			}
+			record := Record{}
+			setLegacyName(&record, name)
+			for _, field := range []*string{&record.Password, &record.Notes} {
+				_, data, err := fields.next()
+				if err == io.EOF {
+					err = fmt.Errorf("%w, record %d", ErrTruncated, index)
+				}
+				if err != nil {
+					return nil, 0, err
+				}
+				*field = legacyString(data)
+			}
+			records = append(records, record)
+		}
+	}
+
+	// The rest of the V2 header record is the version and preferences
+	for i := 0; i < 2; i++ {
+		if _, _, err := fields.next(); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("%w, V2 header", ErrTruncated)
+			}
+			return nil, 0, err
+		}
+	}
+	var record Record
+	started := false
+	for {
+		fieldType, data, err := fields.next()
+		if err == io.EOF {
+			if started {
+				return nil, 0, fmt.Errorf("%w, record %d has no end", ErrTruncated, len(records))
+			}
+			return records, 2, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		started = true
+		switch fieldType {
+		case legacyName:
+			setLegacyName(&record, data)
+		case legacyUUID:
+			if len(data) != len(record.UUID) {
+				return nil, 0, &FieldError{Record: len(records), Type: fieldType, Err: ErrInvalidField}
+			}
+			copy(record.UUID[:], data)
+		case legacyGroup:
+			record.Group = legacyString(data)
+		case legacyTitle:
+			record.Title = legacyString(data)
+		case legacyUser:
+			record.Username = legacyString(data)
+		case legacyNotes:
+			record.Notes = legacyString(data)
+		case legacyPassword:
+			record.Password = legacyString(data)
+		case legacyEnd:
+			records = append(records, record)
+			record, started = Record{}, false
+		}
+		// Other fields were reserved for later versions and are ignored
+	}
+}
+
+// setLegacyName sets the title and username from a V1 name, the username follows a split character while a default
+// username character ends a title whose username was the client default, which is unknown
+func setLegacyName(record *Record, data []byte) {
+	name := legacyString(data)
+	if i := strings.IndexRune(name, legacySplitChar); i >= 0 {
+		record.Title = strings.TrimRight(name[:i], " ")
+		record.Username = strings.TrimLeft(name[i+utf8.RuneLen(legacySplitChar):], " ")
+	} else if i := strings.IndexRune(name, legacyDefaultUserChar); i >= 0 {
+		record.Title = strings.TrimRight(name[:i], " ")
+	} else {
+		record.Title = name
+	}
+}
@@ -0,0 +1,189 @@
+package pwsafe
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// legacyTestField is a field of a V1 or V2 test file
+type legacyTestField struct {
+	fieldType byte
+	data      string
+}
+
+// buildLegacy writes a V1 or V2 file with the fields as the Password Safe 1.x and 2.x clients do
+func buildLegacy(t *testing.T, password string, fields []legacyTestField) []byte {
+	random := bytes.Repeat([]byte{1}, legacyRandSize)
+	salt := bytes.Repeat([]byte{2}, legacySaltSize)
+	iv := bytes.Repeat([]byte{3}, legacyBlockSize)
+	hash, err := legacyRandHash(random, []byte(password))
+	assert.Nil(t, err)
+	out := bytes.NewBuffer(append(append(append(random, hash[:]...), salt...), iv...))
+
+	key := sha1.Sum(append([]byte(password), salt...))
+	block, err := newLegacyBlowfish(key[:])
+	assert.Nil(t, err)
+	cbc := cipher.NewCBCEncrypter(block, iv)
+	for _, field := range fields {
+		length := make([]byte, legacyBlockSize)
+		binary.LittleEndian.PutUint32(length, uint32(len(field.data)))
+		length[4] = field.fieldType
+		data := []byte(field.data)
+		for len(data) == 0 || len(data)%legacyBlockSize != 0 {
+			data = append(data, 0)
+		}
+		encrypted := append(length, data...)
+		cbc.CryptBlocks(encrypted, encrypted)
+		out.Write(encrypted)
+	}
+	return out.Bytes()
+}
+
+func TestLegacyBlowfish(t *testing.T) {
+	// The standard Blowfish test vector with the halves of each block byte swapped
+	block, err := newLegacyBlowfish(make([]byte, 8))
+	assert.Nil(t, err)
+	out := make([]byte, 8)
+	block.Encrypt(out, make([]byte, 8))
+	assert.Equal(t, "4597f94e78dd9861", hex.EncodeToString(out))
+	block.Decrypt(out, out)
+	assert.Equal(t, make([]byte, 8), out)
+}
+
+func TestSHA1WithState(t *testing.T) {
+	standard := [5]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476, 0xc3d2e1f0}
+	for _, size := range []int{0, 10, 55, 56, 64, 100} {
+		data := bytes.Repeat([]byte{'a'}, size)
+		assert.Equal(t, sha1.Sum(data), sha1WithState(standard, data), size)
+	}
+	assert.NotEqual(t, sha1.Sum(nil), sha1WithState([5]uint32{}, nil))
+}
+
+func TestReadLegacy(t *testing.T) {
+	v1 := buildLegacy(t, "password", []legacyTestField{
+		{0, "mail  \xad  alice"}, {0, "secret"}, {0, "some notes\r\nmore"},
+		{0, "caf\xe9\xa0"}, {0, "latte"}, {0, ""},
+		{0, "mail  \xad  bob"}, {0, "other"}, {0, ""},
+		{0, "café"}, {0, "utf8"}, {0, ""},
+	})
+	db, err := ReadLegacy(bytes.NewReader(v1), "password", OpenOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, db.LegacyVersion())
+	assert.True(t, db.NeedsSave())
+	assert.Equal(t, []string{"café", "café (2)", "mail", "mail (2)"}, db.List())
+	mail, _ := db.GetRecord("mail")
+	assert.Equal(t, "alice", mail.Username)
+	assert.Equal(t, "secret", mail.Password)
+	assert.Equal(t, "some notes\r\nmore", mail.Notes)
+	mail, _ = db.GetRecord("mail (2)")
+	assert.Equal(t, "bob", mail.Username)
+	cafe, _ := db.GetRecord("café")
+	assert.Equal(t, "", cafe.Username)
+	assert.Equal(t, "latte", cafe.Password)
+
+	v2 := buildLegacy(t, "pässword", []legacyTestField{
+		{legacyName, legacyV2Header}, {legacyPassword, "2.0"}, {legacyNotes, "B 1 1 I 2 3"},
+		{legacyUUID, string([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})},
+		{legacyGroup, "work.mail"}, {legacyTitle, "mail"}, {legacyUser, "alice"}, {legacyNotes, "notes"},
+		{legacyPassword, "secret"}, {0x10, "ignored"}, {legacyEnd, ""},
+		{legacyTitle, "bank"}, {legacyPassword, "money"}, {legacyEnd, ""},
+	})
+	db, err = ReadLegacy(bytes.NewReader(v2), "pässword", OpenOptions{ReadOnly: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, db.LegacyVersion())
+	assert.True(t, db.ReadOnly())
+	assert.Equal(t, []string{"bank", "mail"}, db.List())
+	mail, _ = db.GetRecord("mail")
+	assert.Equal(t, Record{UUID: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, Group: "work.mail",
+		Title: "mail", Username: "alice", Notes: "notes", Password: "secret", CreateTime: mail.CreateTime,
+		ModTime: mail.ModTime}, mail)
+
+	// Clients using a Latin-1 code page stored the password in it
+	latin1 := buildLegacy(t, "p\xe4ssword", []legacyTestField{{0, "title"}, {0, "pw"}, {0, ""}})
+	_, err = ReadLegacy(bytes.NewReader(latin1), "pässword", OpenOptions{})
+	assert.Nil(t, err)
+
+	_, err = ReadLegacy(bytes.NewReader(v2), "wrong", OpenOptions{})
+	assert.Equal(t, ErrInvalidPassword, err)
+	_, err = ReadLegacy(bytes.NewReader(v2[:len(v2)-4]), "pässword", OpenOptions{})
+	assert.True(t, errors.Is(err, ErrTruncated))
+	_, err = ReadLegacy(bytes.NewReader(v2[:len(v2)-16]), "pässword", OpenOptions{})
+	assert.True(t, errors.Is(err, ErrTruncated))
+	_, err = ReadLegacy(bytes.NewReader(v2[:20]), "pässword", OpenOptions{})
+	assert.True(t, errors.Is(err, ErrTruncated))
+}
+
+func TestOpenLegacy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "old.dat")
+	assert.Nil(t, os.WriteFile(path, buildLegacy(t, "password", []legacyTestField{
+		{0, "mail  \xad  alice"}, {0, "secret"}, {0, ""},
+	}), 0600))
+
+	db, err := OpenPWSafeFile(path, "password")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, db.(*V3).LegacyVersion())
+	assert.Equal(t, path, db.(*V3).LastSavePath)
+
+	// Saving writes V3
+	assert.Nil(t, WritePWSafeFile(db, ""))
+	db, err = OpenPWSafeFile(path, "password")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, db.(*V3).LegacyVersion())
+	mail, _ := db.GetRecord("mail")
+	assert.Equal(t, "secret", mail.Password)
+
+	// Neither a V3 file nor a V1 or V2 file with the password
+	_, err = OpenPWSafeFile(filepath.Join(".", "test_dbs", "simple.dat"), "wrong")
+	assert.Equal(t, ErrInvalidPassword, err)
+	assert.Nil(t, os.WriteFile(path, bytes.Repeat([]byte{9}, 200), 0600))
+	_, err = OpenPWSafeFile(path, "password")
+	assert.True(t, errors.Is(err, ErrNotPWS3))
+}
+
+// The legacy test files weren't written by this package or by buildLegacy but by a separate script using OpenSSL's
+// Blowfish, following the file format of the Password Safe 2.x source. No file saved by the 1.x or 2.x clients is
+// included. Both have the password password.
+func TestReadLegacyFiles(t *testing.T) {
+	db, err := OpenPWSafeFile("./test_dbs/legacy1.dat", "password")
+	assert.Nil(t, err)
+	v1 := db.(*V3)
+	assert.Equal(t, 1, v1.LegacyVersion())
+	assert.Equal(t, []string{"bank", "mail"}, v1.List())
+	mail, _ := v1.GetRecord("mail")
+	assert.Equal(t, "alice", mail.Username)
+	assert.Equal(t, "secret", mail.Password)
+	assert.Equal(t, "some notes\r\nmore", mail.Notes)
+	bank, _ := v1.GetRecord("bank")
+	assert.Equal(t, "", bank.Username)
+	assert.Equal(t, "money", bank.Password)
+
+	db, err = OpenPWSafeFile("./test_dbs/legacy2.dat", "password")
+	assert.Nil(t, err)
+	v2 := db.(*V3)
+	assert.Equal(t, 2, v2.LegacyVersion())
+	assert.Equal(t, []string{"café", "mail"}, v2.List())
+	mail, _ = v2.GetRecord("mail")
+	assert.Equal(t, [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, mail.UUID)
+	assert.Equal(t, "work.mail", mail.Group)
+	assert.Equal(t, "alice", mail.Username)
+	assert.Equal(t, "notes", mail.Notes)
+	assert.Equal(t, "secret", mail.Password)
+	cafe, _ := v2.GetRecord("café")
+	assert.Equal(t, "latte", cafe.Password)
+
+	f, err := os.Open("./test_dbs/legacy2.dat")
+	assert.Nil(t, err)
+	defer f.Close()
+	_, err = ReadLegacy(f, "wrong", OpenOptions{})
+	assert.Equal(t, ErrInvalidPassword, err)
+}
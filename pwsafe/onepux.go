@@ -0,0 +1,358 @@
+package pwsafe
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The 1PUX format is the 1Password unencrypted export, a zip archive whose export.data file is JSON listing the
+// accounts, their vaults and the items in each. Item details are login fields, notes, a password history and sections
+// of typed fields.
+
+// puxDataFile is the name of the JSON file in the archive
+const puxDataFile = "export.data"
+
+// 1Password item categories
+const (
+	puxLogin      = "001"
+	puxCreditCard = "002"
+	puxSecureNote = "003"
+	puxPassword   = "005"
+)
+
+// puxCategories names the categories which are not imported for the report
+var puxCategories = map[string]string{
+	"004": "Identity",
+	"006": "Document",
+	"100": "Software License",
+	"101": "Bank Account",
+	"102": "Database",
+	"103": "Driver License",
+	"104": "Outdoor License",
+	"105": "Membership",
+	"106": "Passport",
+	"107": "Reward Program",
+	"108": "Social Security Number",
+	"109": "Wireless Router",
+	"110": "Server",
+	"111": "Email Account",
+	"112": "API Credential",
+	"113": "Medical Record",
+	"114": "SSH Key",
+	"115": "Crypto Wallet",
+}
+
+//PUXSkipped An item of a 1PUX import which was not imported as its category is not supported
+type PUXSkipped struct {
+	Vault    string
+	Title    string
+	Category string
+}
+
+//PUXReport What a 1PUX import added and skipped
+type PUXReport struct {
+	// Imported are the titles of the records added
+	Imported []string
+	Skipped  []PUXSkipped
+}
+
+//String Returns a human readable report with the number of items skipped for each category
+func (r *PUXReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d records imported, %d skipped\n", len(r.Imported), len(r.Skipped))
+	counts := make(map[string]int)
+	var categories []string
+	for _, s := range r.Skipped {
+		if counts[s.Category] == 0 {
+			categories = append(categories, s.Category)
+		}
+		counts[s.Category]++
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		fmt.Fprintf(&b, "%s items are not supported, %d skipped\n", category, counts[category])
+	}
+	return b.String()
+}
+
+type puxExport struct {
+	Accounts []struct {
+		Vaults []struct {
+			Attrs struct {
+				Name string `json:"name"`
+			} `json:"attrs"`
+			Items []puxItem `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+type puxItem struct {
+	CreatedAt    int64  `json:"createdAt"`
+	UpdatedAt    int64  `json:"updatedAt"`
+	State        string `json:"state"`
+	CategoryUUID string `json:"categoryUuid"`
+	Details      struct {
+		LoginFields []struct {
+			Value       string `json:"value"`
+			Name        string `json:"name"`
+			FieldType   string `json:"fieldType"`
+			Designation string `json:"designation"`
+		} `json:"loginFields"`
+		NotesPlain      string       `json:"notesPlain"`
+		Password        string       `json:"password"`
+		Sections        []puxSection `json:"sections"`
+		PasswordHistory []struct {
+			Value string `json:"value"`
+			Time  int64  `json:"time"`
+		} `json:"passwordHistory"`
+	} `json:"details"`
+	Overview struct {
+		Title string   `json:"title"`
+		URL   string   `json:"url"`
+		Tags  []string `json:"tags"`
+		URLs  []struct {
+			URL string `json:"url"`
+		} `json:"urls"`
+	} `json:"overview"`
+}
+
+type puxSection struct {
+	Title  string `json:"title"`
+	Fields []struct {
+		Title string                     `json:"title"`
+		ID    string                     `json:"id"`
+		Value map[string]json.RawMessage `json:"value"`
+	} `json:"fields"`
+}
+
+// puxTime converts a 1Password time in seconds since the epoch, zero is the zero time
+func puxTime(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// puxValue formats a typed section field value as text, the value has a single key naming its type
+func puxValue(value map[string]json.RawMessage) (string, error) {
+	for kind, raw := range value {
+		var err error
+		switch kind {
+		case "monthYear":
+			var monthYear int
+			if err = json.Unmarshal(raw, &monthYear); err == nil && monthYear != 0 {
+				return fmt.Sprintf("%02d/%d", monthYear%100, monthYear/100), nil
+			}
+		case "date":
+			var date int64
+			if err = json.Unmarshal(raw, &date); err == nil && date != 0 {
+				return time.Unix(date, 0).UTC().Format("2006-01-02"), nil
+			}
+		case "email":
+			var email struct {
+				Address string `json:"email_address"`
+			}
+			if err = json.Unmarshal(raw, &email); err == nil {
+				return email.Address, nil
+			}
+		case "address":
+			var address struct {
+				Street  string `json:"street"`
+				City    string `json:"city"`
+				State   string `json:"state"`
+				Zip     string `json:"zip"`
+				Country string `json:"country"`
+			}
+			if err = json.Unmarshal(raw, &address); err == nil {
+				var parts []string
+				for _, part := range []string{address.Street, address.City, address.State, address.Zip, address.Country} {
+					if part != "" {
+						parts = append(parts, part)
+					}
+				}
+				return strings.Join(parts, ", "), nil
+			}
+		case "file":
+			var file struct {
+				FileName string `json:"fileName"`
+			}
+			if err = json.Unmarshal(raw, &file); err == nil {
+				return "attachment not imported " + file.FileName, nil
+			}
+		default:
+			var text string
+			if json.Unmarshal(raw, &text) == nil {
+				return text, nil
+			}
+			// Other structured values are kept as their JSON
+			return string(raw), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w, invalid 1PUX %s value - %v", ErrInvalidField, kind, err)
+		}
+	}
+	return "", nil
+}
+
+//Import1PUX Reads a 1Password 1PUX export adding its logins, passwords, secure notes and credit cards with SetRecord,
+//a record whose title is already used is renamed with a numbered suffix. Each vault becomes a group. The card number is
+//the password and the cardholder the username. Other login fields, further URLs, tags and the fields of each section
+//are added to the notes. Items of other categories are skipped and listed in the report. Nothing is added if any item
+//is invalid.
+func (db *V3) Import1PUX(r io.Reader) (*PUXReport, error) {
+	if db.readOnly {
+		return nil, ErrReadOnly
+	}
+	limit := OpenOptions{}.maxFileSize()
+	archive, err := io.ReadAll(&countingReader{r: r, max: limit})
+	if err != nil {
+		return nil, err
+	}
+	files, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the 1PUX archive - %w", err)
+	}
+	var export puxExport
+	found := false
+	for _, file := range files.File {
+		if file.Name != puxDataFile {
+			continue
+		}
+		data, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the 1PUX archive - %w", err)
+		}
+		err = json.NewDecoder(&countingReader{r: data, max: limit}).Decode(&export)
+		data.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing the 1PUX %s - %w", puxDataFile, err)
+		}
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("%w, the 1PUX archive has no %s", ErrInvalidField, puxDataFile)
+	}
+
+	report := &PUXReport{}
+	var records []Record
+	for _, account := range export.Accounts {
+		for _, vault := range account.Vaults {
+			for _, item := range vault.Items {
+				if item.State == "deleted" {
+					continue
+				}
+				switch item.CategoryUUID {
+				case puxLogin, puxPassword, puxSecureNote, puxCreditCard:
+				default:
+					category := puxCategories[item.CategoryUUID]
+					if category == "" {
+						category = "Category " + item.CategoryUUID
+					}
+					report.Skipped = append(report.Skipped, PUXSkipped{Vault: vault.Attrs.Name,
+						Title: item.Overview.Title, Category: category})
+					continue
+				}
+				record, err := item.record(vault.Attrs.Name)
+				if err != nil {
+					return nil, fmt.Errorf("Error importing 1PUX item %q - %w", item.Overview.Title, err)
+				}
+				records = append(records, record)
+			}
+		}
+	}
+
+	report.Imported = db.importRecords(records, nil)
+	return report, nil
+}
+
+// record converts the item to a Record in group
+func (item *puxItem) record(group string) (Record, error) {
+	details := &item.Details
+	record := Record{
+		Group:      group,
+		Title:      item.Overview.Title,
+		URL:        item.Overview.URL,
+		Notes:      details.NotesPlain,
+		Password:   details.Password,
+		CreateTime: puxTime(item.CreatedAt),
+		ModTime:    puxTime(item.UpdatedAt),
+	}
+
+	var extra []string
+	note := func(label, value string) {
+		if value != "" {
+			extra = append(extra, label+": "+value)
+		}
+	}
+	for _, field := range details.LoginFields {
+		switch {
+		case field.Designation == "username":
+			record.Username = field.Value
+		case field.Designation == "password":
+			record.Password = field.Value
+		case field.FieldType != "B" && field.FieldType != "I": // skip buttons and submit inputs
+			note(field.Name, field.Value)
+		}
+	}
+	for _, u := range item.Overview.URLs {
+		if record.URL == "" {
+			record.URL = u.URL
+		} else if u.URL != record.URL {
+			note("URL", u.URL)
+		}
+	}
+	if len(item.Overview.Tags) > 0 {
+		note("Tags", strings.Join(item.Overview.Tags, ", "))
+	}
+	for _, section := range details.Sections {
+		var lines []string
+		for _, field := range section.Fields {
+			value, err := puxValue(field.Value)
+			if err != nil {
+				return record, err
+			}
+			if item.CategoryUUID == puxCreditCard {
+				switch field.ID {
+				case "cardholder":
+					record.Username = value
+					continue
+				case "ccnum":
+					record.Password = value
+					continue
+				}
+			}
+			label := field.Title
+			if label == "" {
+				label = field.ID
+			}
+			if value != "" {
+				lines = append(lines, label+": "+value)
+			}
+		}
+		if len(lines) > 0 && section.Title != "" {
+			extra = append(extra, section.Title)
+		}
+		extra = append(extra, lines...)
+	}
+	record.Notes = appendNotes(record.Notes, extra)
+
+	record.Title = importTitle(record.Title, record.URL)
+	if len(details.PasswordHistory) > 0 {
+		history := PasswordHistory{Enabled: true}
+		for _, previous := range details.PasswordHistory {
+			history.Entries = append(history.Entries, PasswordHistoryEntry{Changed: puxTime(previous.Time),
+				Password: previous.Value})
+		}
+		sort.SliceStable(history.Entries, func(i, j int) bool {
+			return history.Entries[i].Changed.Before(history.Entries[j].Changed)
+		})
+		record.PasswordHistory = trimHistory(history).String()
+	}
+	return record, nil
+}
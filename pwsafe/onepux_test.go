@@ -0,0 +1,155 @@
+package pwsafe
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// puxTestData is an export.data with each supported category and two unsupported items
+const puxTestData = `{
+  "accounts": [{
+    "attrs": {"accountName": "Test", "name": "Alice", "email": "alice@example.com"},
+    "vaults": [{
+      "attrs": {"uuid": "v1", "name": "Personal", "type": "P"},
+      "items": [
+        {
+          "uuid": "fkruyzrldvizuqlnavfj3gltfe",
+          "createdAt": 1600000000,
+          "updatedAt": 1650000000,
+          "state": "active",
+          "categoryUuid": "001",
+          "details": {
+            "loginFields": [
+              {"value": "alice", "name": "email", "fieldType": "E", "designation": "username"},
+              {"value": "current", "name": "password", "fieldType": "P", "designation": "password"},
+              {"value": "", "name": "submit", "fieldType": "I"},
+              {"value": "eu", "name": "region", "fieldType": "T"}
+            ],
+            "notesPlain": "some notes",
+            "sections": [
+              {"title": "", "fields": [{"title": "one-time password", "id": "totp", "value": {"totp": "otpauth://totp/x?secret=ABC"}}]},
+              {"title": "Security", "fields": [
+                {"title": "PIN", "id": "pin", "value": {"concealed": "1234"}},
+                {"title": "recovery email", "id": "mail", "value": {"email": {"email_address": "r@example.com", "provider": null}}}
+              ]}
+            ],
+            "passwordHistory": [{"value": "second", "time": 1640000000}, {"value": "first", "time": 1620000000}]
+          },
+          "overview": {
+            "title": "mail",
+            "url": "https://mail.example.com",
+            "urls": [{"label": "", "url": "https://mail.example.com"}, {"label": "", "url": "https://webmail.example.com"}],
+            "tags": ["work", "email"]
+          }
+        },
+        {
+          "uuid": "p1", "createdAt": 1600000000, "updatedAt": 1600000000, "state": "active", "categoryUuid": "005",
+          "details": {"password": "wifi pass", "notesPlain": ""},
+          "overview": {"title": "wifi"}
+        },
+        {
+          "uuid": "n1", "createdAt": 1600000000, "updatedAt": 1600000000, "state": "archived", "categoryUuid": "003",
+          "details": {"notesPlain": "secret text"},
+          "overview": {"title": "mail"}
+        },
+        {
+          "uuid": "i1", "categoryUuid": "004", "details": {}, "overview": {"title": "me"}
+        }
+      ]
+    }, {
+      "attrs": {"uuid": "v2", "name": "Shared", "type": "U"},
+      "items": [
+        {
+          "uuid": "c1", "createdAt": 1600000000, "updatedAt": 1600000000, "state": "active", "categoryUuid": "002",
+          "details": {"sections": [{"title": "", "fields": [
+            {"title": "cardholder name", "id": "cardholder", "value": {"string": "Alice"}},
+            {"title": "type", "id": "type", "value": {"creditCardType": "visa"}},
+            {"title": "number", "id": "ccnum", "value": {"creditCardNumber": "4111111111111111"}},
+            {"title": "verification number", "id": "cvv", "value": {"concealed": "123"}},
+            {"title": "expiry date", "id": "expiry", "value": {"monthYear": 203001}}
+          ]}]},
+          "overview": {"title": "visa"}
+        },
+        {
+          "uuid": "s1", "categoryUuid": "114", "details": {}, "overview": {"title": "key"}
+        }
+      ]
+    }]
+  }]
+}`
+
+// puxArchive returns a 1PUX archive with the given export.data
+func puxArchive(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	attributes, err := archive.Create("export.attributes")
+	assert.Nil(t, err)
+	attributes.Write([]byte(`{"version": 3, "description": "1Password Unencrypted Export"}`))
+	export, err := archive.Create(puxDataFile)
+	assert.Nil(t, err)
+	export.Write([]byte(data))
+	assert.Nil(t, archive.Close())
+	return buf.Bytes()
+}
+
+func TestImport1PUX(t *testing.T) {
+	db := NewV3("", "password")
+	report, err := db.Import1PUX(bytes.NewReader(puxArchive(t, puxTestData)))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"mail", "wifi", "mail (2)", "visa"}, report.Imported)
+	assert.Equal(t, []PUXSkipped{{Vault: "Personal", Title: "me", Category: "Identity"},
+		{Vault: "Shared", Title: "key", Category: "SSH Key"}}, report.Skipped)
+	assert.Equal(t, "4 records imported, 2 skipped\nIdentity items are not supported, 1 skipped\n"+
+		"SSH Key items are not supported, 1 skipped\n", report.String())
+
+	mail, _ := db.GetRecord("mail")
+	assert.Equal(t, "Personal", mail.Group)
+	assert.Equal(t, "alice", mail.Username)
+	assert.Equal(t, "current", mail.Password)
+	assert.Equal(t, "https://mail.example.com", mail.URL)
+	assert.Equal(t, "some notes\r\n\r\nregion: eu\r\nURL: https://webmail.example.com\r\nTags: work, email\r\n"+
+		"one-time password: otpauth://totp/x?secret=ABC\r\nSecurity\r\nPIN: 1234\r\nrecovery email: r@example.com",
+		mail.Notes)
+	assert.Equal(t, time.Unix(1600000000, 0).Unix(), mail.CreateTime.Unix())
+	assert.Equal(t, time.Unix(1650000000, 0).Unix(), mail.ModTime.Unix())
+	history, err := ParsePasswordHistory(mail.PasswordHistory)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history.Entries))
+	assert.Equal(t, "first", history.Entries[0].Password)
+	assert.Equal(t, "second", history.Entries[1].Password)
+
+	wifi, _ := db.GetRecord("wifi")
+	assert.Equal(t, "wifi pass", wifi.Password)
+
+	note, _ := db.GetRecord("mail (2)")
+	assert.Equal(t, "secret text", note.Notes)
+
+	card, _ := db.GetRecord("visa")
+	assert.Equal(t, "Shared", card.Group)
+	assert.Equal(t, "Alice", card.Username)
+	assert.Equal(t, "4111111111111111", card.Password)
+	assert.Equal(t, "type: visa\r\nverification number: 123\r\nexpiry date: 01/2030", card.Notes)
+
+	// Invalid archives change nothing
+	db = NewV3("", "password")
+	_, err = db.Import1PUX(strings.NewReader("not a zip"))
+	assert.NotNil(t, err)
+	var buf bytes.Buffer
+	zip.NewWriter(&buf).Close()
+	_, err = db.Import1PUX(&buf)
+	assert.True(t, errors.Is(err, ErrInvalidField))
+	invalid := strings.Replace(puxTestData, `{"monthYear": 203001}`, `{"monthYear": "soon"}`, 1)
+	_, err = db.Import1PUX(bytes.NewReader(puxArchive(t, invalid)))
+	assert.True(t, errors.Is(err, ErrInvalidField))
+	assert.Equal(t, 0, len(db.Records))
+
+	db.readOnly = true
+	_, err = db.Import1PUX(bytes.NewReader(puxArchive(t, puxTestData)))
+	assert.Equal(t, ErrReadOnly, err)
+}
@@ -0,0 +1,329 @@
+package pwsafe
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+//PolicyFlags The character classes and options of a password policy
+type PolicyFlags uint16
+
+// Password policy flags as defined in the spec
+const (
+	PolicyUseLowercase      PolicyFlags = 0x8000
+	PolicyUseUppercase      PolicyFlags = 0x4000
+	PolicyUseDigits         PolicyFlags = 0x2000
+	PolicyUseSymbols        PolicyFlags = 0x1000
+	PolicyUseHexDigits      PolicyFlags = 0x0800
+	PolicyUseEasyVision     PolicyFlags = 0x0400
+	PolicyMakePronounceable PolicyFlags = 0x0200
+)
+
+//PasswordPolicy The decoded form of the Record.PasswordPolicy field and of each policy in the V3.PasswordPolicy header
+//field of named policies. Name and Symbols are only stored for named policies.
+type PasswordPolicy struct {
+	Name         string
+	Flags        PolicyFlags
+	Length       int
+	MinLowercase int
+	MinUppercase int
+	MinDigits    int
+	MinSymbols   int
+	Symbols      string // the symbols to use rather than the default set
+}
+
+// policyLength is the length of a policy in the record field format "ffffnnnllluuudddsss", the flags then the
+// length and minimum lower case, upper case, digit and symbol counts all as hex
+const policyLength = 19
+
+//ParsePasswordPolicy Decodes the Record.PasswordPolicy field
+func ParsePasswordPolicy(field string) (PasswordPolicy, error) {
+	var policy PasswordPolicy
+	if len(field) != policyLength {
+		return policy, fmt.Errorf("%w, password policy %q is not %d characters", ErrInvalidField, field, policyLength)
+	}
+	err := policy.parse(field)
+	return policy, err
+}
+
+// parse decodes the policy from the record field format
+func (p *PasswordPolicy) parse(field string) error {
+	flags, err := parseHex(field[0:4])
+	if err != nil {
+		return err
+	}
+	p.Flags = PolicyFlags(flags)
+	for i, value := range []*int{&p.Length, &p.MinLowercase, &p.MinUppercase, &p.MinDigits, &p.MinSymbols} {
+		if *value, err = parseHex(field[4+i*3 : 7+i*3]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//String Encodes the policy in the Record.PasswordPolicy field format
+func (p PasswordPolicy) String() string {
+	return fmt.Sprintf("%04x%03x%03x%03x%03x%03x", uint16(p.Flags), p.Length, p.MinLowercase, p.MinUppercase,
+		p.MinDigits, p.MinSymbols)
+}
+
+//ParsePasswordPolicies Decodes the V3.PasswordPolicy header field of named policies
+//The field is "NN" the number of policies in hex, then for each "LL" the name length in hex, the name, the policy in
+//the record field format, "MM" the symbols length in hex and the symbols. Lengths are in characters.
+func ParsePasswordPolicies(field string) ([]PasswordPolicy, error) {
+	if field == "" {
+		return nil, nil
+	}
+	if len(field) < 2 {
+		return nil, fmt.Errorf("%w, password policies %q is too short", ErrInvalidField, field)
+	}
+	num, err := parseHex(field[:2])
+	if err != nil {
+		return nil, err
+	}
+	rest := field[2:]
+	// take removes n characters from the start of rest
+	take := func(n int) (string, error) {
+		if utf8.RuneCountInString(rest) < n {
+			return "", fmt.Errorf("%w, password policies are truncated", ErrInvalidField)
+		}
+		runes := []rune(rest)
+		taken := string(runes[:n])
+		rest = rest[len(taken):]
+		return taken, nil
+	}
+	takeHex := func(n int) (int, error) {
+		s, err := take(n)
+		if err != nil {
+			return 0, err
+		}
+		return parseHex(s)
+	}
+
+	policies := make([]PasswordPolicy, 0, num)
+	for i := 0; i < num; i++ {
+		var policy PasswordPolicy
+		length, err := takeHex(2)
+		if err != nil {
+			return nil, err
+		}
+		if policy.Name, err = take(length); err != nil {
+			return nil, err
+		}
+		encoded, err := take(policyLength)
+		if err != nil {
+			return nil, err
+		}
+		if err := policy.parse(encoded); err != nil {
+			return nil, err
+		}
+		if length, err = takeHex(2); err != nil {
+			return nil, err
+		}
+		if policy.Symbols, err = take(length); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("%w, unexpected data after the password policies", ErrInvalidField)
+	}
+	return policies, nil
+}
+
+//FormatPasswordPolicies Encodes named policies in the V3.PasswordPolicy header field format
+func FormatPasswordPolicies(policies []PasswordPolicy) string {
+	if len(policies) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%02x", len(policies))
+	for _, p := range policies {
+		fmt.Fprintf(&b, "%02x%s%s%02x%s", utf8.RuneCountInString(p.Name), p.Name, p.String(),
+			utf8.RuneCountInString(p.Symbols), p.Symbols)
+	}
+	return b.String()
+}
+
+// The character sets of the official client, the easy vision sets leave out characters which are easily confused
+const (
+	policyLowercase           = "abcdefghijklmnopqrstuvwxyz"
+	policyUppercase           = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	policyDigits              = "0123456789"
+	policySymbols             = "+-=_@#$%^&;:,.<>/~\\[](){}?!|"
+	policyHexDigits           = "0123456789abcdef"
+	policyEasyVisionLowercase = "abcdefghijkmnopqrstuvwxyz"
+	policyEasyVisionUppercase = "ABCDEFGHJKLMNPQRTUVWXY"
+	policyEasyVisionDigits    = "346789"
+	policyEasyVisionSymbols   = "+-=_@#$%^&<>/~\\?"
+)
+
+// charSets returns the lower case, upper case, digit and symbol characters the policy uses, empty for those classes it
+// doesn't use. A hex digit policy has only digits.
+func (p PasswordPolicy) charSets() (lower, upper, digits, symbols string) {
+	if p.Flags&PolicyUseHexDigits != 0 {
+		return "", "", policyHexDigits, ""
+	}
+	easy := p.Flags&PolicyUseEasyVision != 0
+	pick := func(flag PolicyFlags, standard, easyVision string) string {
+		switch {
+		case p.Flags&flag == 0:
+			return ""
+		case easy:
+			return easyVision
+		default:
+			return standard
+		}
+	}
+	symbols = pick(PolicyUseSymbols, policySymbols, policyEasyVisionSymbols)
+	if symbols != "" && p.Symbols != "" {
+		symbols = p.Symbols
+	}
+	return pick(PolicyUseLowercase, policyLowercase, policyEasyVisionLowercase),
+		pick(PolicyUseUppercase, policyUppercase, policyEasyVisionUppercase),
+		pick(PolicyUseDigits, policyDigits, policyEasyVisionDigits), symbols
+}
+
+//Check Returns how the password violates the policy, it is shorter than the policy length, has fewer characters of a
+//class than the minimum or has characters the policy doesn't use. A policy without any character class flags only
+//checks the length.
+func (p PasswordPolicy) Check(password string) []string {
+	var violations []string
+	if n := utf8.RuneCountInString(password); n < p.Length {
+		violations = append(violations, fmt.Sprintf("%d characters, shorter than the policy length %d", n, p.Length))
+	}
+	lower, upper, digits, symbols := p.charSets()
+	if lower == "" && upper == "" && digits == "" && symbols == "" {
+		return violations
+	}
+	if p.Flags&PolicyUseHexDigits != 0 {
+		// hex digits may be either case
+		password = strings.ToLower(password)
+	}
+
+	var counts [4]int
+	var disallowed []rune
+	for _, c := range password {
+		found := false
+		for i, set := range []string{lower, upper, digits, symbols} {
+			if set != "" && strings.ContainsRune(set, c) {
+				counts[i]++
+				found = true
+				break
+			}
+		}
+		if !found && !strings.ContainsRune(string(disallowed), c) {
+			disallowed = append(disallowed, c)
+		}
+	}
+	for i, class := range []struct {
+		name string
+		min  int
+	}{{"lower case letters", p.MinLowercase}, {"upper case letters", p.MinUppercase}, {"digits", p.MinDigits},
+		{"symbols", p.MinSymbols}} {
+		if p.Flags&PolicyUseHexDigits == 0 && counts[i] < class.min {
+			violations = append(violations, fmt.Sprintf("%d %s, fewer than the minimum %d", counts[i], class.name,
+				class.min))
+		}
+	}
+	if len(disallowed) > 0 {
+		violations = append(violations, fmt.Sprintf("has characters the policy doesn't use %q", string(disallowed)))
+	}
+	return violations
+}
+
+//DefaultPasswordPolicy The policy of the official client used to generate passwords for records without a policy
+var DefaultPasswordPolicy = PasswordPolicy{
+	Flags:        PolicyUseLowercase | PolicyUseUppercase | PolicyUseDigits | PolicyUseSymbols,
+	Length:       12,
+	MinLowercase: 1,
+	MinUppercase: 1,
+	MinDigits:    1,
+	MinSymbols:   1,
+}
+
+//Generate Returns a password meeting the policy with characters chosen uniformly from random, crypto/rand if nil.
+//The password is longer than the policy length if the class minimums need it. A policy without any character class
+//flags uses letters and digits and the default length if it has none. Pronounceable passwords aren't generated, the
+//flag is ignored.
+func (p PasswordPolicy) Generate(random io.Reader) (string, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	lower, upper, digits, symbols := p.charSets()
+	mins := []int{p.MinLowercase, p.MinUppercase, p.MinDigits, p.MinSymbols}
+	if p.Flags&PolicyUseHexDigits != 0 {
+		mins = []int{0, 0, 0, 0}
+	}
+	if lower == "" && upper == "" && digits == "" && symbols == "" {
+		lower, upper, digits = policyLowercase, policyUppercase, policyDigits
+		mins = []int{0, 0, 0, 0}
+	}
+	length := p.Length
+	if length == 0 {
+		length = DefaultPasswordPolicy.Length
+	}
+
+	// pick returns a random character of set
+	pick := func(set string) (rune, error) {
+		chars := []rune(set)
+		i, err := rand.Int(random, big.NewInt(int64(len(chars))))
+		if err != nil {
+			return 0, err
+		}
+		return chars[i.Int64()], nil
+	}
+	var password []rune
+	for i, set := range []string{lower, upper, digits, symbols} {
+		for j := 0; set != "" && j < mins[i]; j++ {
+			c, err := pick(set)
+			if err != nil {
+				return "", err
+			}
+			password = append(password, c)
+		}
+	}
+	all := lower + upper + digits + symbols
+	for len(password) < length {
+		c, err := pick(all)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	// shuffle so the required characters aren't first
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(random, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+	return string(password), nil
+}
+
+//RecordPolicy Returns the password policy of a record, its named policy if it has one otherwise its own policy field,
+//false if it has neither
+func (db V3) RecordPolicy(record Record) (PasswordPolicy, bool, error) {
+	if record.PasswordPolicyName != "" {
+		policies, err := ParsePasswordPolicies(db.PasswordPolicy)
+		if err != nil {
+			return PasswordPolicy{}, false, err
+		}
+		for _, policy := range policies {
+			if policy.Name == record.PasswordPolicyName {
+				return policy, true, nil
+			}
+		}
+		return PasswordPolicy{}, false, fmt.Errorf("No password policy named %q", record.PasswordPolicyName)
+	}
+	if record.PasswordPolicy == "" {
+		return PasswordPolicy{}, false, nil
+	}
+	policy, err := ParsePasswordPolicy(record.PasswordPolicy)
+	return policy, err == nil, err
+}
@@ -0,0 +1,116 @@
+package pwsafe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy, err := ParsePasswordPolicy("f00000c001002003004")
+	assert.Nil(t, err)
+	assert.Equal(t, PasswordPolicy{
+		Flags:  PolicyUseLowercase | PolicyUseUppercase | PolicyUseDigits | PolicyUseSymbols,
+		Length: 12, MinLowercase: 1, MinUppercase: 2, MinDigits: 3, MinSymbols: 4,
+	}, policy)
+	assert.Equal(t, "f00000c001002003004", policy.String())
+
+	for _, invalid := range []string{"", "f00000c00100200300", "f00000c00100200300x"} {
+		_, err := ParsePasswordPolicy(invalid)
+		assert.True(t, errors.Is(err, ErrInvalidField), invalid)
+	}
+}
+
+func TestPasswordPolicies(t *testing.T) {
+	policies := []PasswordPolicy{
+		{Name: "pin", Flags: PolicyUseDigits, Length: 6, MinDigits: 6},
+		{Name: "sÿmbols", Flags: PolicyUseSymbols, Length: 10, MinSymbols: 10, Symbols: "!€#"},
+	}
+	field := FormatPasswordPolicies(policies)
+	assert.Equal(t, "0203pin20000060000000060000007sÿmbols100000a00000000000a03!€#", field)
+	parsed, err := ParsePasswordPolicies(field)
+	assert.Nil(t, err)
+	assert.Equal(t, policies, parsed)
+
+	parsed, err = ParsePasswordPolicies("")
+	assert.Nil(t, err)
+	assert.Nil(t, parsed)
+
+	for _, invalid := range []string{"0", "01", "0103pi", field + "x"} {
+		_, err := ParsePasswordPolicies(invalid)
+		assert.True(t, errors.Is(err, ErrInvalidField), invalid)
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := PasswordPolicy{Flags: PolicyUseLowercase | PolicyUseDigits | PolicyUseSymbols, Length: 8, MinDigits: 2,
+		MinSymbols: 1}
+	assert.Nil(t, policy.Check("abcd12+-"))
+	assert.Equal(t, []string{"4 characters, shorter than the policy length 8", "1 digits, fewer than the minimum 2",
+		"0 symbols, fewer than the minimum 1", `has characters the policy doesn't use "B"`}, policy.Check("aBB1"))
+
+	policy.Symbols = "!"
+	assert.Equal(t, []string{"0 symbols, fewer than the minimum 1", `has characters the policy doesn't use "+"`},
+		policy.Check("abcd12+a"))
+	assert.Nil(t, policy.Check("abcd12!a"))
+
+	easy := PasswordPolicy{Flags: PolicyUseLowercase | PolicyUseEasyVision, Length: 4}
+	assert.Equal(t, []string{`has characters the policy doesn't use "l"`}, easy.Check("hello"))
+
+	hex := PasswordPolicy{Flags: PolicyUseHexDigits | PolicyUseSymbols, Length: 4, MinSymbols: 2}
+	assert.Nil(t, hex.Check("0aF9"))
+	assert.Equal(t, []string{`has characters the policy doesn't use "g"`}, hex.Check("0agf"))
+
+	assert.Nil(t, PasswordPolicy{Length: 3}.Check("any password"))
+}
+
+func TestPolicyGenerate(t *testing.T) {
+	for _, policy := range []PasswordPolicy{
+		DefaultPasswordPolicy,
+		{Flags: PolicyUseDigits, Length: 6, MinDigits: 6},
+		{Flags: PolicyUseLowercase | PolicyUseSymbols, Length: 20, MinSymbols: 2, Symbols: "!"},
+		{Flags: PolicyUseLowercase | PolicyUseUppercase | PolicyUseEasyVision, Length: 10, MinUppercase: 3},
+		{Flags: PolicyUseHexDigits | PolicyUseSymbols, Length: 16, MinSymbols: 2},
+		{Flags: PolicyUseUppercase | PolicyUseDigits, Length: 4, MinUppercase: 3, MinDigits: 3},
+	} {
+		for i := 0; i < 20; i++ {
+			password, err := policy.Generate(nil)
+			assert.Nil(t, err)
+			assert.Nil(t, policy.Check(password), password)
+			if policy.Length == 4 {
+				assert.Equal(t, 6, len(password))
+			}
+		}
+	}
+
+	password, err := PasswordPolicy{}.Generate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultPasswordPolicy.Length, len(password))
+	assert.Nil(t, PasswordPolicy{Flags: PolicyUseLowercase | PolicyUseUppercase | PolicyUseDigits}.Check(password))
+
+	_, err = DefaultPasswordPolicy.Generate(strings.NewReader(""))
+	assert.NotNil(t, err)
+}
+
+func TestRecordPolicy(t *testing.T) {
+	db := subsetDB()
+	portal, _ := db.GetRecord("acme portal")
+	policy, ok, err := db.RecordPolicy(portal)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "strong", policy.Name)
+
+	policy, ok, err = db.RecordPolicy(Record{PasswordPolicy: "f00000c001002003004"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 12, policy.Length)
+
+	_, ok, err = db.RecordPolicy(Record{})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, ok, err = db.RecordPolicy(Record{PasswordPolicyName: "missing"})
+	assert.NotNil(t, err)
+	assert.False(t, ok)
+}
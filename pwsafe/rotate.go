@@ -0,0 +1,170 @@
+package pwsafe
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// defaultHistoryMax is the number of previous passwords kept when rotation enables the history of a record, the
+// default of the official client
+const defaultHistoryMax = 3
+
+//RotationItem A record in a password rotation and the new password it is to be given
+type RotationItem struct {
+	Title    string
+	Group    string
+	URL      string
+	Username string
+	UUID     [16]byte
+	// Password is the new password generated from the record password policy
+	Password string
+	// Done is set once the password has been changed on the system the record is for, only done items are committed
+	Done bool
+	// old is the record password when the rotation was planned
+	old string
+}
+
+//Rotation A planned change of the passwords of a set of records, such as every credential someone leaving had
+//access to. The new passwords are only set in the db by CommitRotation once their items are marked done.
+type Rotation struct {
+	Items []RotationItem // sorted by title
+}
+
+//PlanRotation Generates a new password for each record matching the filter from the record password policy, or
+//DefaultPasswordPolicy if it has none. Aliases and shortcuts use the password of their base record and records without
+//a password have nothing to rotate so both are left out. The db isn't changed.
+func (db *V3) PlanRotation(filter RecordFilter) (Rotation, error) {
+	var rotation Rotation
+	for _, title := range db.Select(filter) {
+		record := db.Records[title]
+		if _, alias := AliasBase(record.Password); alias || record.Password == "" {
+			continue
+		}
+		policy, ok, err := db.RecordPolicy(record)
+		if err != nil {
+			return Rotation{}, fmt.Errorf("Error with the password policy of %q - %w", title, err)
+		}
+		if !ok {
+			policy = DefaultPasswordPolicy
+		}
+		password, err := policy.Generate(db.random())
+		if err != nil {
+			return Rotation{}, err
+		}
+		rotation.Items = append(rotation.Items, RotationItem{Title: title, Group: record.Group, URL: record.URL,
+			Username: record.Username, UUID: record.UUID, Password: password, old: record.Password})
+	}
+	if len(rotation.Items) == 0 {
+		return Rotation{}, errors.New("No records with a password match the filter")
+	}
+	return rotation, nil
+}
+
+//Done Returns the number of items marked done
+func (r Rotation) Done() int {
+	n := 0
+	for _, item := range r.Items {
+		if item.Done {
+			n++
+		}
+	}
+	return n
+}
+
+//WriteChecklist Writes the systems to update as CSV, a header then the done state, group, title, URL and username of
+//each item. The new passwords aren't written.
+func (r Rotation) WriteChecklist(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"done", "group", "title", "url", "username"})
+	for _, item := range r.Items {
+		done := ""
+		if item.Done {
+			done = "x"
+		}
+		writer.Write([]string{done, item.Group, item.Title, item.URL, item.Username})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+//CommitRotation Sets the new password of each item marked done, keeping the old password in the record password
+//history, and returns the number of records changed. Items which aren't done are left unchanged. Nothing is changed
+//if a record to be updated has been deleted or its password changed since the rotation was planned.
+func (db *V3) CommitRotation(rotation Rotation) (int, error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	var changed []Record
+	for _, item := range rotation.Items {
+		if !item.Done {
+			continue
+		}
+		record, err := db.rotationRecord(item)
+		if err != nil {
+			return 0, err
+		}
+		if record.Password != item.old {
+			return 0, fmt.Errorf("The password of %q has changed since the rotation was planned", record.Title)
+		}
+		if err := keepPassword(&record); err != nil {
+			return 0, fmt.Errorf("Error with the password history of %q - %w", record.Title, err)
+		}
+		record.Password = item.Password
+		changed = append(changed, record)
+	}
+	for _, record := range changed {
+		if err := db.SetRecord(record); err != nil {
+			return 0, err
+		}
+	}
+	return len(changed), nil
+}
+
+// rotationRecord returns the record of an item, the record with its title if that has its UUID otherwise the record
+// it has been renamed to. A renamed record is only found if no other record has its UUID.
+func (db *V3) rotationRecord(item RotationItem) (Record, error) {
+	if record, prs := db.Records[item.Title]; prs && record.UUID == item.UUID {
+		return record, nil
+	}
+	var found []Record
+	for _, record := range db.Records {
+		if record.UUID == item.UUID {
+			found = append(found, record)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Record{}, fmt.Errorf("%q has been deleted since the rotation was planned", item.Title)
+	case 1:
+		return found[0], nil
+	}
+	return Record{}, fmt.Errorf("%q has been renamed since the rotation was planned and %d records have its UUID",
+		item.Title, len(found))
+}
+
+// keepPassword adds the record password to its password history with the time it was set, dropping the oldest
+// entries beyond the maximum. History is enabled if it isn't.
+func keepPassword(record *Record) error {
+	history, err := ParsePasswordHistory(record.PasswordHistory)
+	if err != nil {
+		return err
+	}
+	if !history.Enabled || history.Max == 0 {
+		history.Enabled = true
+		if history.Max < defaultHistoryMax {
+			history.Max = defaultHistoryMax
+		}
+	}
+	set := record.PasswordModTime
+	if set.IsZero() {
+		set = record.CreateTime
+	}
+	history.Entries = append(history.Entries, PasswordHistoryEntry{Changed: set, Password: record.Password})
+	if len(history.Entries) > history.Max {
+		history.Entries = history.Entries[len(history.Entries)-history.Max:]
+	}
+	record.PasswordHistory = history.String()
+	return nil
+}
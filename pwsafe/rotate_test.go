@@ -0,0 +1,156 @@
+package pwsafe
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanRotation(t *testing.T) {
+	db := subsetDB()
+	rotation, err := db.PlanRotation(RecordFilter{Groups: []string{"clients"}})
+	assert.Nil(t, err)
+	// the alias acme vpn is left out
+	assert.Equal(t, 3, len(rotation.Items))
+	var titles []string
+	for _, item := range rotation.Items {
+		titles = append(titles, item.Title)
+		record, _ := db.GetRecord(item.Title)
+		assert.Equal(t, record.UUID, item.UUID)
+		assert.NotEqual(t, record.Password, item.Password)
+		policy, ok, _ := db.RecordPolicy(record)
+		if !ok {
+			policy = DefaultPasswordPolicy
+		}
+		assert.Nil(t, policy.Check(item.Password), item.Title)
+	}
+	assert.Equal(t, []string{"acme portal", "acmeish", "other portal"}, titles)
+	assert.Equal(t, "alice", rotation.Items[0].Username)
+	assert.Equal(t, "https://acme.example.com", rotation.Items[0].URL)
+	assert.Equal(t, 6, len(rotation.Items[1].Password))
+	assert.Equal(t, 0, rotation.Done())
+
+	_, err = db.PlanRotation(RecordFilter{Groups: []string{"none"}})
+	assert.NotNil(t, err)
+	_, err = db.PlanRotation(RecordFilter{Query: "acme vpn"})
+	assert.NotNil(t, err)
+
+	db.SetRecord(Record{Title: "bad policy", Password: "pw", PasswordPolicyName: "missing"})
+	_, err = db.PlanRotation(RecordFilter{})
+	assert.NotNil(t, err)
+}
+
+func TestWriteChecklist(t *testing.T) {
+	rotation := Rotation{Items: []RotationItem{
+		{Title: "portal", Group: "clients", URL: "https://example.com", Username: "alice", Password: "secret", Done: true},
+		{Title: "vpn, shared", Username: "bob", Password: "secret"},
+	}}
+	var b bytes.Buffer
+	assert.Nil(t, rotation.WriteChecklist(&b))
+	assert.Equal(t, "done,group,title,url,username\nx,clients,portal,https://example.com,alice\n,,\"vpn, shared\",,bob\n",
+		b.String())
+}
+
+func TestCommitRotation(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := created
+	db := subsetDB()
+	db.Clock = func() time.Time { return now }
+	db.SetRecord(Record{Title: "history", Group: "clients", Password: "third", PasswordHistory: PasswordHistory{
+		Enabled: true, Max: 2, Entries: []PasswordHistoryEntry{{Changed: created.Add(-time.Hour), Password: "first"},
+			{Changed: created.Add(-time.Minute), Password: "second"}}}.String()})
+	rotation, err := db.PlanRotation(RecordFilter{Groups: []string{"clients"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(rotation.Items))
+
+	// nothing is changed until items are done
+	n, err := db.CommitRotation(rotation)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	before, _ := db.GetRecord("acme portal")
+	now = now.Add(time.Hour)
+	rotation.Items[0].Done = true
+	rotation.Items[2].Done = true
+	assert.Equal(t, 2, rotation.Done())
+	n, err = db.CommitRotation(rotation)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	portal, _ := db.GetRecord("acme portal")
+	assert.Equal(t, rotation.Items[0].Password, portal.Password)
+	assert.Equal(t, now, portal.PasswordModTime)
+	history, err := ParsePasswordHistory(portal.PasswordHistory)
+	assert.Nil(t, err)
+	kept := PasswordHistoryEntry{Changed: time.Unix(before.PasswordModTime.Unix(), 0), Password: "portal"}
+	assert.Equal(t, PasswordHistory{Enabled: true, Max: defaultHistoryMax, Entries: []PasswordHistoryEntry{kept}}, history)
+
+	// the oldest entry is dropped at the maximum
+	record, _ := db.GetRecord("history")
+	assert.Equal(t, rotation.Items[2].Password, record.Password)
+	history, err = ParsePasswordHistory(record.PasswordHistory)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, history.Max)
+	assert.Equal(t, []string{"second", "third"}, []string{history.Entries[0].Password, history.Entries[1].Password})
+
+	acmeish, _ := db.GetRecord("acmeish")
+	assert.Equal(t, "pw", acmeish.Password)
+
+	// committing again finds the passwords changed
+	_, err = db.CommitRotation(rotation)
+	assert.NotNil(t, err)
+	rotation.Items[0].Done, rotation.Items[2].Done = false, false
+	rotation.Items[1].Done = true
+	db.DeleteRecord("acmeish")
+	_, err = db.CommitRotation(rotation)
+	assert.NotNil(t, err)
+
+	db.readOnly = true
+	_, err = db.CommitRotation(rotation)
+	assert.True(t, errors.Is(err, ErrReadOnly))
+}
+
+func TestCommitRotationDuplicateUUIDs(t *testing.T) {
+	db := NewV3("", "password")
+	db.SetRecord(Record{Title: "mail", Password: "first"})
+	mail, _ := db.GetRecord("mail")
+	// importing the same export twice gives records with the same UUID
+	db.SetRecord(Record{Title: "mail (2)", Password: "second", UUID: mail.UUID})
+
+	for i := 0; i < 8; i++ {
+		rotation, err := db.PlanRotation(RecordFilter{Query: "mail (2)"})
+		assert.Nil(t, err)
+		rotation.Items[0].Done = true
+		n, err := db.CommitRotation(rotation)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		mail, _ = db.GetRecord("mail")
+		assert.Equal(t, "first", mail.Password)
+		second, _ := db.GetRecord("mail (2)")
+		assert.Equal(t, rotation.Items[0].Password, second.Password)
+	}
+
+	// a renamed record can't be told apart from others with its UUID
+	rotation, err := db.PlanRotation(RecordFilter{Query: "mail (2)"})
+	assert.Nil(t, err)
+	rotation.Items[0].Done = true
+	second, _ := db.GetRecord("mail (2)")
+	db.DeleteRecord("mail (2)")
+	second.Title = "mail renamed"
+	db.SetRecord(second)
+	_, err = db.CommitRotation(rotation)
+	assert.NotNil(t, err)
+	mail, _ = db.GetRecord("mail")
+	assert.Equal(t, "first", mail.Password)
+
+	// but is found once its UUID is unique
+	db.DeleteRecord("mail")
+	n, err := db.CommitRotation(rotation)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	renamed, _ := db.GetRecord("mail renamed")
+	assert.Equal(t, rotation.Items[0].Password, renamed.Password)
+}
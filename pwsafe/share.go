@@ -0,0 +1,289 @@
+package pwsafe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe/internal/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// A sharing bundle carries records encrypted for a passphrase or an X25519 public key. It is
+//
+//	MAGIC|VERSION|MODE|EXPIRES|KEY|NONCE|CIPHERTEXT
+//
+// MAGIC is "PWSB", VERSION 1, MODE sharePassphrase or shareX25519 and EXPIRES the Unix time in seconds as a little
+// endian int64 after which the bundle can't be imported, 0 if it never expires. For a passphrase KEY is the salt and
+// the Argon2id time, memory in KiB and threads, for a public key it is the ephemeral X25519 public key, the key is then
+// derived from the shared secret with HKDF-SHA256. The ciphertext is the records in the V3 field encoding encrypted
+// with XChaCha20-Poly1305, everything before the nonce is authenticated as additional data so the expiry can't be
+// changed.
+
+const (
+	shareMagic   = "PWSB"
+	shareVersion = 1
+	// sharePEMType is the type of the armored form which is PEM encoded
+	sharePEMType = "PWSAFE SHARE"
+	// shareHKDFInfo binds keys derived for sharing to this use
+	shareHKDFInfo = "gopwsafe share v1"
+	// shareMaxMemory is the most Argon2id memory in KiB accepted when importing so a bundle can't exhaust memory
+	shareMaxMemory = 1 << 20
+	// shareMaxTime is the most Argon2id passes accepted when importing
+	shareMaxTime = 100
+)
+
+// Sharing bundle modes
+const (
+	sharePassphrase byte = 1
+	shareX25519     byte = 2
+)
+
+// Default Argon2id parameters for a passphrase
+const (
+	shareTime    = 3
+	shareMemory  = 64 << 10
+	shareThreads = 4
+)
+
+//ShareOptions How records are shared by ExportShare and how ImportShare opens a bundle
+type ShareOptions struct {
+	// Passphrase encrypts the bundle with a key derived with Argon2id, it is used if Recipient is not set
+	Passphrase string
+	// Recipient is the X25519 public key to encrypt the bundle for, see GenerateShareKey
+	Recipient *[32]byte
+	// PrivateKey is the X25519 private key opening a bundle encrypted for its public key
+	PrivateKey *[32]byte
+	// Expires is when the bundle can no longer be imported, the zero time never expires
+	Expires time.Time
+	// Armor writes the bundle as text which is safe to paste
+	Armor bool
+}
+
+//GenerateShareKey Returns a new X25519 key pair for receiving shared records, random is crypto/rand if nil
+func GenerateShareKey(random io.Reader) (public, private [32]byte, err error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	if _, err = io.ReadFull(random, private[:]); err != nil {
+		return public, private, err
+	}
+	pub, err := curve25519.X25519(private[:], curve25519.Basepoint)
+	copy(public[:], pub)
+	return public, private, err
+}
+
+//FormatShareKey Encodes an X25519 key as text
+func FormatShareKey(key [32]byte) string {
+	return base64.StdEncoding.EncodeToString(key[:])
+}
+
+//ParseShareKey Decodes an X25519 key encoded by FormatShareKey
+func ParseShareKey(s string) ([32]byte, error) {
+	var key [32]byte
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(decoded) != len(key) {
+		return key, fmt.Errorf("%w, a share key is 32 bytes encoded as base64", ErrInvalidField)
+	}
+	copy(key[:], decoded)
+	return key, nil
+}
+
+// shareKDF derives the bundle key for a passphrase from its salt and Argon2id parameters
+func shareKDF(passphrase string, params []byte) []byte {
+	passes := binary.LittleEndian.Uint32(params[16:20])
+	memory := binary.LittleEndian.Uint32(params[20:24])
+	return argon2.IDKey([]byte(passphrase), params[:16], passes, memory, params[24], chacha20poly1305.KeySize)
+}
+
+// shareX25519Key derives the bundle key from the X25519 shared secret of the ephemeral and recipient keys
+func shareX25519Key(private, peer []byte, ephemeral, recipient []byte) ([]byte, error) {
+	shared, err := curve25519.X25519(private, peer)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, chacha20poly1305.KeySize)
+	kdf := hkdf.New(sha256.New, shared, append(append([]byte(nil), ephemeral...), recipient...), []byte(shareHKDFInfo))
+	_, err = io.ReadFull(kdf, key)
+	return key, err
+}
+
+//ExportShare Writes the records with the given titles as a sharing bundle encrypted for the passphrase or recipient
+//in opts. Password history is not shared.
+func (db *V3) ExportShare(w io.Writer, titles []string, opts ShareOptions) error {
+	if len(titles) == 0 {
+		return errors.New("No records to share")
+	}
+	fields := fieldWriter{rand: db.Rand}
+	var plaintext []byte
+	for _, title := range titles {
+		record, prs := db.Records[title]
+		if !prs {
+			return fmt.Errorf("No record with title %q", title)
+		}
+		record.PasswordHistory = ""
+		fields.reset()
+		record.marshalRecord(&fields)
+		plaintext = append(plaintext, fields.record...)
+	}
+
+	header := []byte(shareMagic)
+	header = append(header, shareVersion, sharePassphrase)
+	var expires [8]byte
+	if !opts.Expires.IsZero() {
+		binary.LittleEndian.PutUint64(expires[:], uint64(opts.Expires.Unix()))
+	}
+	header = append(header, expires[:]...)
+	var key []byte
+	if opts.Recipient != nil {
+		header[len(shareMagic)+1] = shareX25519
+		ephemeral, private, err := GenerateShareKey(db.random())
+		if err != nil {
+			return err
+		}
+		if key, err = shareX25519Key(private[:], opts.Recipient[:], ephemeral[:], opts.Recipient[:]); err != nil {
+			return err
+		}
+		header = append(header, ephemeral[:]...)
+	} else {
+		if opts.Passphrase == "" {
+			return errors.New("A passphrase or recipient is required to share records")
+		}
+		params := make([]byte, 16, 25)
+		if _, err := io.ReadFull(db.random(), params); err != nil {
+			return err
+		}
+		params = append(params, intToBytes(shareTime)...)
+		params = append(params, intToBytes(shareMemory)...)
+		params = append(params, shareThreads)
+		key = shareKDF(opts.Passphrase, params)
+		header = append(header, params...)
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(db.random(), nonce); err != nil {
+		return err
+	}
+	bundle := append(append(header, nonce...), aead.Seal(nil, nonce, plaintext, header)...)
+
+	if !opts.Armor {
+		_, err = w.Write(bundle)
+		return err
+	}
+	block := &pem.Block{Type: sharePEMType, Bytes: bundle}
+	if !opts.Expires.IsZero() {
+		block.Headers = map[string]string{"Expires": opts.Expires.UTC().Format(time.RFC3339)}
+	}
+	return pem.Encode(w, block)
+}
+
+//ImportShare Reads a sharing bundle, binary or armored, opening it with the passphrase or private key in opts and adds
+//its records with SetRecord, a record whose title is already used is renamed with a numbered suffix. An expired
+//bundle returns ErrShareExpired and the wrong passphrase or key ErrInvalidPassword. Nothing is added if the bundle is
+//invalid.
+func (db *V3) ImportShare(r io.Reader, opts ShareOptions) (int, error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	bundle, err := io.ReadAll(&countingReader{r: r, max: OpenOptions{}.maxFileSize()})
+	if err != nil {
+		return 0, err
+	}
+	if block, _ := pem.Decode(bundle); block != nil {
+		if block.Type != sharePEMType {
+			return 0, fmt.Errorf("%w, the armored text is a %q not a %q", ErrInvalidField, block.Type, sharePEMType)
+		}
+		bundle = block.Bytes
+	}
+
+	headerSize := len(shareMagic) + 2 + 8
+	if len(bundle) < headerSize || string(bundle[:len(shareMagic)]) != shareMagic {
+		return 0, fmt.Errorf("%w, not a sharing bundle", ErrInvalidField)
+	}
+	if bundle[len(shareMagic)] != shareVersion {
+		return 0, fmt.Errorf("%w, unsupported sharing bundle version %d", ErrInvalidField, bundle[len(shareMagic)])
+	}
+	mode := bundle[len(shareMagic)+1]
+	expires := int64(binary.LittleEndian.Uint64(bundle[headerSize-8 : headerSize]))
+
+	var key []byte
+	switch mode {
+	case sharePassphrase:
+		if len(bundle) < headerSize+25 {
+			return 0, fmt.Errorf("%w, the sharing bundle is incomplete", ErrTruncated)
+		}
+		params := bundle[headerSize : headerSize+25]
+		passes, memory := binary.LittleEndian.Uint32(params[16:20]), binary.LittleEndian.Uint32(params[20:24])
+		if passes == 0 || passes > shareMaxTime || memory > shareMaxMemory || params[24] == 0 {
+			return 0, fmt.Errorf("%w, invalid sharing bundle Argon2id parameters", ErrInvalidField)
+		}
+		key = shareKDF(opts.Passphrase, params)
+		headerSize += len(params)
+	case shareX25519:
+		if opts.PrivateKey == nil {
+			return 0, errors.New("The sharing bundle is encrypted for a public key, its private key is required")
+		}
+		if len(bundle) < headerSize+32 {
+			return 0, fmt.Errorf("%w, the sharing bundle is incomplete", ErrTruncated)
+		}
+		ephemeral := bundle[headerSize : headerSize+32]
+		recipient, err := curve25519.X25519(opts.PrivateKey[:], curve25519.Basepoint)
+		if err != nil {
+			return 0, err
+		}
+		if key, err = shareX25519Key(opts.PrivateKey[:], ephemeral, ephemeral, recipient); err != nil {
+			// A low order ephemeral key can't have come from ExportShare
+			return 0, fmt.Errorf("%w, invalid sharing bundle key - %v", ErrInvalidField, err)
+		}
+		headerSize += len(ephemeral)
+	default:
+		return 0, fmt.Errorf("%w, unknown sharing bundle mode %d", ErrInvalidField, mode)
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(bundle) < headerSize+aead.NonceSize()+aead.Overhead() {
+		return 0, fmt.Errorf("%w, the sharing bundle is incomplete", ErrTruncated)
+	}
+	nonce := bundle[headerSize : headerSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, bundle[headerSize+aead.NonceSize():], bundle[:headerSize])
+	if err != nil {
+		return 0, ErrInvalidPassword
+	}
+	// The expiry is only trusted once it is authenticated
+	if expires != 0 && db.now().After(time.Unix(expires, 0)) {
+		return 0, fmt.Errorf("%w at %s", ErrShareExpired, time.Unix(expires, 0).Format(time.RFC3339))
+	}
+
+	fields := newFieldReader(bytes.NewReader(plaintext), io.Discard)
+	var records []Record
+	for {
+		var record Record
+		err := unmarshalRecord(fields, record.decodeField)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Error parsing shared record %d - %w", len(records), err)
+		}
+		records = append(records, record)
+	}
+
+	db.importRecords(records, nil)
+	return len(records), nil
+}
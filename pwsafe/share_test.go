@@ -0,0 +1,107 @@
+package pwsafe
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShareKeys(t *testing.T) {
+	public, private, err := GenerateShareKey(nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, public, private)
+	parsed, err := ParseShareKey(FormatShareKey(public))
+	assert.Nil(t, err)
+	assert.Equal(t, public, parsed)
+	_, err = ParseShareKey("dGVzdA==")
+	assert.True(t, errors.Is(err, ErrInvalidField))
+}
+
+func TestSharePassphrase(t *testing.T) {
+	source := fullDB()
+	titles := []string{"history", testRecord().Title}
+	var buf bytes.Buffer
+	now := time.Unix(1700000000, 0)
+	assert.Nil(t, source.ExportShare(&buf, titles, ShareOptions{Passphrase: "correct horse", Armor: true,
+		Expires: now.Add(24 * time.Hour)}))
+	armored := buf.String()
+	assert.True(t, strings.HasPrefix(armored, "-----BEGIN PWSAFE SHARE-----\nExpires: 2023-11-15T22:13:20Z\n"))
+
+	db := NewV3("", "password")
+	db.Clock = func() time.Time { return now }
+	n, err := db.ImportShare(strings.NewReader("pasted:\n"+armored+"\nthanks"), ShareOptions{Passphrase: "correct horse"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	for _, title := range titles {
+		want, _ := source.GetRecord(title)
+		got, _ := db.GetRecord(title)
+		assert.Equal(t, "", got.PasswordHistory, title)
+		want.PasswordHistory = ""
+		equal, err := recordsEqual(want, got, false)
+		assert.True(t, equal, err)
+		assert.Equal(t, want.UUID, got.UUID)
+	}
+
+	// Importing again renames the records giving them new UUIDs
+	n, err = db.ImportShare(strings.NewReader(armored), ShareOptions{Passphrase: "correct horse"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	first, _ := db.GetRecord("history")
+	second, _ := db.GetRecord("history (2)")
+	assert.Equal(t, first.Password, second.Password)
+	assert.NotEqual(t, first.UUID, second.UUID)
+
+	_, err = db.ImportShare(strings.NewReader(armored), ShareOptions{Passphrase: "wrong"})
+	assert.Equal(t, ErrInvalidPassword, err)
+
+	db.Clock = func() time.Time { return now.Add(48 * time.Hour) }
+	_, err = db.ImportShare(strings.NewReader(armored), ShareOptions{Passphrase: "correct horse"})
+	assert.True(t, errors.Is(err, ErrShareExpired))
+	assert.Equal(t, 4, len(db.Records))
+
+	db.readOnly = true
+	_, err = db.ImportShare(strings.NewReader(armored), ShareOptions{Passphrase: "correct horse"})
+	assert.Equal(t, ErrReadOnly, err)
+}
+
+func TestShareRecipient(t *testing.T) {
+	source := fullDB()
+	public, private, err := GenerateShareKey(nil)
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	assert.Nil(t, source.ExportShare(&buf, []string{"history"}, ShareOptions{Recipient: &public}))
+	bundle := buf.Bytes()
+
+	db := NewV3("", "password")
+	n, err := db.ImportShare(bytes.NewReader(bundle), ShareOptions{PrivateKey: &private})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	record, _ := db.GetRecord("history")
+	assert.Equal(t, "current", record.Password)
+
+	_, other, err := GenerateShareKey(nil)
+	assert.Nil(t, err)
+	_, err = db.ImportShare(bytes.NewReader(bundle), ShareOptions{PrivateKey: &other})
+	assert.Equal(t, ErrInvalidPassword, err)
+	_, err = db.ImportShare(bytes.NewReader(bundle), ShareOptions{Passphrase: "password"})
+	assert.NotNil(t, err)
+
+	// The expiry is authenticated so it can't be removed
+	tampered := append([]byte(nil), bundle...)
+	tampered[len(shareMagic)+2] ^= 1
+	_, err = db.ImportShare(bytes.NewReader(tampered), ShareOptions{PrivateKey: &private})
+	assert.Equal(t, ErrInvalidPassword, err)
+
+	for _, invalid := range [][]byte{bundle[:20], bundle[:len(bundle)-20], []byte("PWS3 not a bundle")} {
+		_, err = db.ImportShare(bytes.NewReader(invalid), ShareOptions{PrivateKey: &private})
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, 1, len(db.Records))
+
+	assert.NotNil(t, source.ExportShare(&buf, []string{"missing"}, ShareOptions{Recipient: &public}))
+	assert.NotNil(t, source.ExportShare(&buf, []string{"history"}, ShareOptions{}))
+}
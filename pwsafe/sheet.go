@@ -0,0 +1,159 @@
+package pwsafe
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe/internal/qr"
+)
+
+//SheetOptions What ExportSheet includes in an emergency sheet
+type SheetOptions struct {
+	// Filter selects the records, typically by group
+	Filter RecordFilter
+	// QRCodes adds a QR code of each password so it can be scanned rather than typed
+	QRCodes bool
+}
+
+// sheetRecord is a record as shown on an emergency sheet
+type sheetRecord struct {
+	Record
+	// AliasOf is the title of the base record whose password an alias uses
+	AliasOf string
+	// QRCode is an SVG image of the password, empty if not requested or too long
+	QRCode template.HTML
+	// QRTooLong is set when a QR code was requested but the password doesn't fit
+	QRTooLong bool
+}
+
+// sheetGroup is the records of a group on an emergency sheet
+type sheetGroup struct {
+	Name    string
+	Records []sheetRecord
+}
+
+// sheetTemplate renders an emergency sheet, the document has no external resources so it can be opened and printed
+// offline
+var sheetTemplate = template.Must(template.New("sheet").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>CONFIDENTIAL - {{.Name}} emergency sheet</title>
+<style>
+body { font-family: sans-serif; font-size: 11pt; margin: 2em; color: #000; background: #fff; }
+.confidential { border: 3px solid #b00; color: #b00; font-weight: bold; text-align: center; padding: 0.3em;
+  letter-spacing: 0.2em; }
+h1 { margin-bottom: 0.2em; }
+h2 { border-bottom: 1px solid #000; margin-top: 1.5em; }
+.record { border: 1px solid #888; padding: 0.5em; margin: 0.7em 0; page-break-inside: avoid; break-inside: avoid;
+  display: flex; justify-content: space-between; }
+.record h3 { margin: 0 0 0.3em 0; }
+table { border-collapse: collapse; }
+th { text-align: left; vertical-align: top; padding-right: 1em; white-space: nowrap; }
+td { word-break: break-all; }
+.secret { font-family: monospace; font-size: 12pt; }
+.notes { white-space: pre-wrap; word-break: normal; }
+.qr { margin-left: 1em; text-align: center; font-size: 8pt; }
+@media print {
+  body { margin: 0; }
+  .confidential.top { position: fixed; top: 0; left: 0; right: 0; background: #fff; }
+  .page { padding-top: 3em; }
+}
+</style>
+</head>
+<body>
+<div class="confidential top">CONFIDENTIAL - CONTAINS PASSWORDS - STORE SECURELY AND DESTROY WHEN REPLACED</div>
+<div class="page">
+<h1>{{.Name}}</h1>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<p>Emergency sheet generated {{.Generated.Format "2006-01-02 15:04 MST"}}, {{.Count}} records{{if .Groups}} in the groups
+{{range $i, $g := .Groups}}{{if $i}}, {{end}}{{$g}}{{end}}{{end}}.</p>
+{{range .Sheet}}
+<h2>{{if .Name}}{{.Name}}{{else}}No group{{end}}</h2>
+{{range .Records}}
+<div class="record">
+<div>
+<h3>{{.Title}}</h3>
+<table>
+{{if .Username}}<tr><th>Username</th><td class="secret">{{.Username}}</td></tr>{{end}}
+<tr><th>Password</th><td class="secret">{{.Password}}</td></tr>
+{{if .AliasOf}}<tr><th></th><td>The password of {{.AliasOf}}</td></tr>{{end}}
+{{if .URL}}<tr><th>URL</th><td>{{.URL}}</td></tr>{{end}}
+{{if .Email}}<tr><th>Email</th><td>{{.Email}}</td></tr>{{end}}
+{{if not .PasswordExpiry.IsZero}}<tr><th>Password expires</th><td>{{.PasswordExpiry.Format "2006-01-02"}}</td></tr>{{end}}
+{{if .Notes}}<tr><th>Notes</th><td class="notes">{{.Notes}}</td></tr>{{end}}
+</table>
+</div>
+{{if .QRCode}}<div class="qr">{{.QRCode}}<br>Password</div>{{else if .QRTooLong}}<div class="qr">Password too long<br>for a QR code</div>{{end}}
+</div>
+{{end}}
+{{end}}
+<div class="confidential">CONFIDENTIAL</div>
+</div>
+</body>
+</html>
+`))
+
+//ExportSheet Writes the records selected by the filter in opts as a self-contained HTML document meant to be printed
+//as a paper copy for disaster recovery. Records are grouped by group and aliases show the password of their base
+//record. The document is not encrypted. Returns the number of records written.
+func (db *V3) ExportSheet(w io.Writer, opts SheetOptions) (int, error) {
+	titles := db.Select(opts.Filter)
+	if len(titles) == 0 {
+		return 0, errors.New("No records match the filter")
+	}
+	byUUID := make(map[[16]byte]Record, len(db.Records))
+	for _, record := range db.Records {
+		byUUID[record.UUID] = record
+	}
+
+	groups := make(map[string]*sheetGroup)
+	for _, title := range titles {
+		entry := sheetRecord{Record: db.Records[title]}
+		if id, ok := AliasBase(entry.Password); ok {
+			if base, prs := byUUID[id]; prs {
+				entry.AliasOf = base.Title
+				entry.Password = base.Password
+			}
+		}
+		if opts.QRCodes && entry.Password != "" {
+			code, err := qr.Encode(entry.Password)
+			switch {
+			case errors.Is(err, qr.ErrTooLong):
+				entry.QRTooLong = true
+			case err != nil:
+				return 0, err
+			default:
+				// the SVG is built from numbers only so is safe to include unescaped
+				entry.QRCode = template.HTML(code.SVG(3))
+			}
+		}
+		group, prs := groups[entry.Group]
+		if !prs {
+			group = &sheetGroup{Name: entry.Group}
+			groups[entry.Group] = group
+		}
+		group.Records = append(group.Records, entry)
+	}
+	var sheet []sheetGroup
+	for _, group := range groups {
+		sheet = append(sheet, *group)
+	}
+	sort.Slice(sheet, func(i, j int) bool { return sheet[i].Name < sheet[j].Name })
+	name := db.Name
+	if name == "" {
+		name = "Password Safe"
+	}
+
+	return len(titles), sheetTemplate.Execute(w, struct {
+		Name, Description string
+		Generated         time.Time
+		Count             int
+		Groups            []string
+		Sheet             []sheetGroup
+	}{name, db.Description, db.now(), len(titles), opts.Filter.Groups, sheet})
+}
@@ -0,0 +1,43 @@
+package pwsafe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportSheet(t *testing.T) {
+	db := subsetDB()
+	db.Description = "Client credentials"
+	db.SetRecord(Record{Title: "acme <admin>", Group: "clients.acme", Password: strings.Repeat("long", 60),
+		Notes: "<script>alert(1)</script>\nsecond line"})
+
+	var buf bytes.Buffer
+	n, err := db.ExportSheet(&buf, SheetOptions{Filter: RecordFilter{Groups: []string{"clients.acme"}}, QRCodes: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	sheet := buf.String()
+	for _, want := range []string{"CONFIDENTIAL", "<h1>clients</h1>", "Client credentials", "in the groups\nclients.acme.",
+		"<h2>clients.acme</h2>", "<h2>clients.acme.web</h2>", "<h3>acme portal</h3>", "alice", "portal",
+		// the alias shows the password of its base record
+		"vpn secret", "The password of shared vpn",
+		"acme &lt;admin&gt;", "&lt;script&gt;alert(1)&lt;/script&gt;\nsecond line", "Password too long",
+		`<svg xmlns="http://www.w3.org/2000/svg"`} {
+		assert.Contains(t, sheet, want)
+	}
+	for _, unwanted := range []string{"other portal", "acmeish", "<script>", "src=", "href=", "@import"} {
+		assert.NotContains(t, sheet, unwanted)
+	}
+	assert.Equal(t, 2, strings.Count(sheet, "<svg"))
+
+	buf.Reset()
+	n, err = db.ExportSheet(&buf, SheetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+	assert.NotContains(t, buf.String(), "<svg")
+
+	_, err = db.ExportSheet(&buf, SheetOptions{Filter: RecordFilter{Groups: []string{"none"}}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,185 @@
+package pwsafe
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/twofish"
+)
+
+// eofMarker is the unencrypted block ending the encrypted section of a db
+const eofMarker = "PWS3-EOFPWS3-EOF"
+
+// countingReader counts the bytes read returning ErrFileTooLarge once more than max have been read
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.n > c.max {
+		return n, ErrFileTooLarge
+	}
+	return n, err
+}
+
+// countingWriter counts the bytes written
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// cbcReader decrypts the twofish CBC encrypted section of a db one block at a time as it is read. The section ends
+// with the eof marker block, eofMarker for V3, at which point io.EOF is returned leaving the HMAC unread in the
+// underlying reader.
+type cbcReader struct {
+	r     io.Reader
+	cbc   cipher.BlockMode
+	eof   string
+	block [twofish.BlockSize]byte
+	buf   []byte // decrypted data not yet read
+	done  bool
+}
+
+func newCBCReader(r io.Reader, cbc cipher.BlockMode, eof string) *cbcReader {
+	return &cbcReader{r: r, cbc: cbc, eof: eof}
+}
+
+func (c *cbcReader) Read(p []byte) (int, error) {
+	if len(c.buf) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if _, err := io.ReadFull(c.r, c.block[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return 0, fmt.Errorf("%w, no EOF found", ErrTruncated)
+			}
+			return 0, err
+		}
+		if string(c.block[:]) == c.eof {
+			c.done = true
+			return 0, io.EOF
+		}
+		c.cbc.CryptBlocks(c.block[:], c.block[:])
+		c.buf = c.block[:]
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// writeEncrypted encrypts the block aligned plaintext with cbc writing the result to w
+func writeEncrypted(w io.Writer, cbc cipher.BlockMode, plaintext []byte) error {
+	encrypted := make([]byte, len(plaintext))
+	cbc.CryptBlocks(encrypted, plaintext)
+	_, err := w.Write(encrypted)
+	return err
+}
+
+// fieldReader reads fields from the decrypted data, reusing a buffer for the field data
+type fieldReader struct {
+	r     io.Reader
+	mac   io.Writer
+	block [twofish.BlockSize]byte
+	data  []byte
+}
+
+// newFieldReader returns a fieldReader which also writes each field's data to mac for hmac calculations
+func newFieldReader(r io.Reader, mac io.Writer) *fieldReader {
+	return &fieldReader{r: r, mac: mac}
+}
+
+// next reads a single field returning the field type and data, the data is only valid until the next call.
+// io.EOF is returned only if the data ends cleanly before the field.
+func (fr *fieldReader) next() (FieldType, []byte, error) {
+	if _, err := io.ReadFull(fr.r, fr.block[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("%w, incomplete field block", ErrTruncated)
+		}
+		return 0, nil, err
+	}
+	length := int64(binary.LittleEndian.Uint32(fr.block[:4]))
+	btype := FieldType(fr.block[4])
+
+	// The data is grown as blocks are read so a bogus length can't cause a large allocation
+	first := length
+	if first > twofish.BlockSize-5 {
+		first = twofish.BlockSize - 5
+	}
+	fr.data = append(fr.data[:0], fr.block[5:5+first]...)
+	for int64(len(fr.data)) < length {
+		if _, err := io.ReadFull(fr.r, fr.block[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = fmt.Errorf("%w, field length %d exceeds the data", ErrTruncated, length)
+			}
+			return btype, nil, &FieldError{Type: byte(btype), Err: err}
+		}
+		n := length - int64(len(fr.data))
+		if n > twofish.BlockSize {
+			n = twofish.BlockSize
+		}
+		fr.data = append(fr.data, fr.block[:n]...)
+	}
+	fr.mac.Write(fr.data)
+	return btype, fr.data, nil
+}
+
+// unmarshalRecord reads fields from the decrypted data passing each to decode until the END field
+// io.EOF is returned if the data ends before the record starts
+// This function is used both to UnMarshal the header and individual records in the DB
+// Every length is checked against the data available, errors for individual fields are returned as a *FieldError
+func unmarshalRecord(fr *fieldReader, decode func(FieldType, []byte) error) error {
+	for first := true; ; first = false {
+		btype, data, err := fr.next()
+		if err == io.EOF {
+			if first {
+				return io.EOF
+			}
+			return fmt.Errorf("%w, no END field found when UnMarshaling", ErrTruncated)
+		}
+		if err != nil {
+			return err
+		}
+		if btype == FieldEnd {
+			return nil
+		}
+		if err := decode(btype, data); err != nil {
+			return &FieldError{Type: byte(btype), Err: err}
+		}
+	}
+}
+
+// unmarshalRecords reads records from the decrypted data until it ends, that is at the "PWS3-EOFPWS3-EOF" marker
+func (db *V3) unmarshalRecords(fr *fieldReader) error {
+	db.Records = make(map[string]Record)
+	db.order = nil
+	for i := 0; ; i++ {
+		var record Record
+		err := unmarshalRecord(fr, record.decodeField)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if fieldErr, ok := err.(*FieldError); ok {
+				fieldErr.Record = i
+				return fieldErr
+			}
+			return fmt.Errorf("Error parsing record %d - %w", i, err)
+		}
+		if _, prs := db.Records[record.Title]; !prs {
+			db.order = append(db.order, record.Title)
+		}
+		db.Records[record.Title] = record
+	}
+}
@@ -0,0 +1,166 @@
+package pwsafe
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+)
+
+//RecordFilter Selects records, a record is selected if it matches every criterion which is set so the zero value
+//selects all records
+type RecordFilter struct {
+	// Groups selects the records in any of the groups or their subgroups
+	Groups []string
+	// Query selects the records whose title, username or URL contains it ignoring case
+	Query string
+	// UUIDs selects the records with any of the UUIDs
+	UUIDs [][16]byte
+}
+
+// inGroup returns true if group is parent or one of its subgroups
+func inGroup(group, parent string) bool {
+	return group == parent || strings.HasPrefix(group, parent+".")
+}
+
+//Match Returns true if the record matches the filter
+func (f RecordFilter) Match(record Record) bool {
+	if len(f.Groups) > 0 {
+		found := false
+		for _, group := range f.Groups {
+			found = found || inGroup(record.Group, group)
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Query != "" {
+		query := strings.ToLower(f.Query)
+		found := false
+		for _, value := range []string{record.Title, record.Username, record.URL} {
+			found = found || strings.Contains(strings.ToLower(value), query)
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.UUIDs) > 0 {
+		for _, id := range f.UUIDs {
+			if id == record.UUID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+//Select Returns the titles of the records matching the filter, sorted
+func (db V3) Select(filter RecordFilter) []string {
+	var titles []string
+	for title, record := range db.Records {
+		if filter.Match(record) {
+			titles = append(titles, title)
+		}
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+//AliasBase Returns the UUID of the base record if the password makes the record an alias "[[uuid]]" or a shortcut
+//"[~uuid~]", the uuid is 32 hex digits
+func AliasBase(password string) ([16]byte, bool) {
+	var base [16]byte
+	if len(password) != 36 {
+		return base, false
+	}
+	if !(strings.HasPrefix(password, "[[") && strings.HasSuffix(password, "]]")) &&
+		!(strings.HasPrefix(password, "[~") && strings.HasSuffix(password, "~]")) {
+		return base, false
+	}
+	if _, err := hex.Decode(base[:], []byte(password[2:34])); err != nil {
+		return base, false
+	}
+	return base, true
+}
+
+//Subset Returns a new db with the password holding copies of the records selected by filter. The base records of
+//any aliases or shortcuts selected, the named password policies the records use and the empty groups within the
+//filter groups are also copied. Records keep their UUIDs and times.
+func (db *V3) Subset(filter RecordFilter, password string) (*V3, error) {
+	selected := make(map[string]bool)
+	for _, title := range db.Select(filter) {
+		selected[title] = true
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("No records match the filter")
+	}
+
+	// Aliases need their base record to resolve the password
+	byUUID := make(map[[16]byte]string, len(db.Records))
+	for title, record := range db.Records {
+		byUUID[record.UUID] = title
+	}
+	for title := range selected {
+		if base, ok := AliasBase(db.Records[title].Password); ok {
+			if baseTitle, prs := byUUID[base]; prs {
+				selected[baseTitle] = true
+			}
+		}
+	}
+
+	policies, err := ParsePasswordPolicies(db.PasswordPolicy)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	subset := NewV3("", password)
+	for _, title := range db.recordOrder() {
+		if selected[title] {
+			record := db.Records[title]
+			subset.Records[title] = record
+			subset.order = append(subset.order, title)
+			used[record.PasswordPolicyName] = record.PasswordPolicyName != ""
+		}
+	}
+	var usedPolicies []PasswordPolicy
+	for _, policy := range policies {
+		if used[policy.Name] {
+			usedPolicies = append(usedPolicies, policy)
+		}
+	}
+	subset.PasswordPolicy = FormatPasswordPolicies(usedPolicies)
+
+	for _, empty := range db.EmptyGroups {
+		for _, group := range filter.Groups {
+			if inGroup(empty, group) {
+				subset.EmptyGroups = append(subset.EmptyGroups, empty)
+				break
+			}
+		}
+	}
+	return subset, nil
+}
+
+//ExportSubset Writes the records selected by filter to a new db at path encrypted with password, see Subset. Returns
+//the number of records written. An existing file at path is never overwritten, an error is returned instead.
+func (db *V3) ExportSubset(filter RecordFilter, path, password string) (int, error) {
+	subset, err := db.Subset(filter, password)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, err
+	}
+	_, err = subset.Encrypt(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	return len(subset.Records), nil
+}
@@ -0,0 +1,96 @@
+package pwsafe
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// subsetDB returns a db with records for two clients, one of them an alias of a record in another group
+func subsetDB() *V3 {
+	db := NewV3("clients", "password")
+	db.PasswordPolicy = FormatPasswordPolicies([]PasswordPolicy{
+		{Name: "pin", Flags: PolicyUseDigits, Length: 6, MinDigits: 6},
+		{Name: "strong", Flags: PolicyUseLowercase | PolicyUseSymbols, Length: 20, MinSymbols: 2},
+	})
+	db.EmptyGroups = []string{"clients.acme.archive", "clients.other.archive"}
+	db.SetRecord(Record{Title: "shared vpn", Group: "infra", Username: "vpn", Password: "vpn secret"})
+	vpn, _ := db.GetRecord("shared vpn")
+	db.SetRecord(Record{Title: "acme vpn", Group: "clients.acme", Password: "[[" + hex.EncodeToString(vpn.UUID[:]) + "]]"})
+	db.SetRecord(Record{Title: "acme portal", Group: "clients.acme.web", Username: "alice", URL: "https://acme.example.com",
+		Password: "portal", PasswordPolicyName: "strong"})
+	db.SetRecord(Record{Title: "other portal", Group: "clients.other", Username: "bob", Password: "other"})
+	db.SetRecord(Record{Title: "acmeish", Group: "clients.acmeish", Password: "pw", PasswordPolicyName: "pin"})
+	return db
+}
+
+func TestSelect(t *testing.T) {
+	db := subsetDB()
+	assert.Equal(t, db.List(), db.Select(RecordFilter{}))
+	assert.Equal(t, []string{"acme portal", "acme vpn"}, db.Select(RecordFilter{Groups: []string{"clients.acme"}}))
+	assert.Equal(t, []string{"acme portal", "acme vpn", "acmeish", "other portal"},
+		db.Select(RecordFilter{Groups: []string{"clients"}}))
+	assert.Equal(t, []string{"acme portal", "other portal"}, db.Select(RecordFilter{Query: "PORTAL"}))
+	assert.Equal(t, []string{"acme portal"}, db.Select(RecordFilter{Query: "acme.example"}))
+	assert.Equal(t, []string{"other portal"}, db.Select(RecordFilter{Groups: []string{"clients.other"}, Query: "portal"}))
+	other, _ := db.GetRecord("other portal")
+	vpn, _ := db.GetRecord("shared vpn")
+	assert.Equal(t, []string{"other portal", "shared vpn"}, db.Select(RecordFilter{UUIDs: [][16]byte{other.UUID, vpn.UUID}}))
+	assert.Equal(t, []string(nil), db.Select(RecordFilter{Groups: []string{"clients.acme"}, UUIDs: [][16]byte{other.UUID}}))
+}
+
+func TestExportSubset(t *testing.T) {
+	db := subsetDB()
+	path := filepath.Join(t.TempDir(), "acme.psafe3")
+	n, err := db.ExportSubset(RecordFilter{Groups: []string{"clients.acme"}}, path, "contractor")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	opened, err := OpenPWSafeFile(path, "contractor")
+	assert.Nil(t, err)
+	subset := opened.(*V3)
+	assert.Equal(t, []string{"acme portal", "acme vpn", "shared vpn"}, subset.List())
+	assert.Equal(t, []string{"clients.acme.archive"}, subset.EmptyGroups)
+	policies, err := ParsePasswordPolicies(subset.PasswordPolicy)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(policies))
+	assert.Equal(t, "strong", policies[0].Name)
+	for _, title := range subset.List() {
+		want, _ := db.GetRecord(title)
+		got, _ := subset.GetRecord(title)
+		equal, err := recordsEqual(want, got, false)
+		assert.True(t, equal, err)
+		assert.Equal(t, want.UUID, got.UUID)
+	}
+	// The source is unchanged
+	assert.Equal(t, 5, len(db.Records))
+	assert.Equal(t, "", db.LastSavePath)
+
+	_, err = db.ExportSubset(RecordFilter{Groups: []string{"none"}}, path, "contractor")
+	assert.NotNil(t, err)
+
+	// An existing file, such as the open db, is never overwritten
+	before, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	_, err = db.ExportSubset(RecordFilter{Groups: []string{"clients"}}, path, "other")
+	assert.True(t, errors.Is(err, os.ErrExist))
+	after, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestAliasBase(t *testing.T) {
+	base, ok := AliasBase("[[0102030405060708090a0b0c0d0e0f10]]")
+	assert.True(t, ok)
+	assert.Equal(t, [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, base)
+	_, ok = AliasBase("[~0102030405060708090a0b0c0d0e0f10~]")
+	assert.True(t, ok)
+	for _, password := range []string{"[[0102030405060708090a0b0c0d0e0f10~]", "[[0102030405060708090a0b0c0d0e0fxx]]", "password"} {
+		_, ok = AliasBase(password)
+		assert.False(t, ok, password)
+	}
+}
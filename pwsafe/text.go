@@ -0,0 +1,294 @@
+package pwsafe
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The plain text format is that of "Export to Plain Text" in the official Password Safe client, a header line naming
+// the columns then one tab delimited line per record. The group and title share a column with the group path first
+// and as the group path is dot separated dots in the title are replaced. Line breaks in notes are replaced by a
+// delimiter and the notes are quoted. Tabs can't be represented so are escaped in notes, along with backslashes, and
+// refused in other fields. A » in a title or notes would read back as a dot or line break so is refused too.
+
+const (
+	// textTitleDot replaces dots in the title in the Group/Title column
+	textTitleDot = "»"
+	// textNotesDelimiter replaces line breaks in notes
+	textNotesDelimiter = "»"
+	// textTimeFormat is the local time format for the time columns
+	textTimeFormat = "2006/01/02 15:04:05"
+)
+
+// textColumn is a column of the plain text format, set parses the column value into the record
+type textColumn struct {
+	name string
+	get  func(r *Record) string
+	set  func(r *Record, value string) error
+}
+
+// textColumns are all the columns in the order the official client writes them
+var textColumns = []textColumn{
+	{"Group/Title", textGroupTitle, setTextGroupTitle},
+	textStringColumn("Username", func(r *Record) *string { return &r.Username }),
+	textStringColumn("Password", func(r *Record) *string { return &r.Password }),
+	textStringColumn("URL", func(r *Record) *string { return &r.URL }),
+	textStringColumn("AutoType", func(r *Record) *string { return &r.Autotype }),
+	textTimeColumn("Created Time", func(r *Record) *time.Time { return &r.CreateTime }),
+	textTimeColumn("Password Modified Time", func(r *Record) *time.Time { return &r.PasswordModTime }),
+	textTimeColumn("Last Access Time", func(r *Record) *time.Time { return &r.AccessTime }),
+	textTimeColumn("Password Expiry Date", func(r *Record) *time.Time { return &r.PasswordExpiry }),
+	{"Password Expiry Interval",
+		func(r *Record) string {
+			if interval := binary.LittleEndian.Uint32(r.PasswordExpiryInterval[:]); interval != 0 {
+				return strconv.FormatUint(uint64(interval), 10)
+			}
+			return ""
+		},
+		func(r *Record, v string) error {
+			interval, err := parseTextUint(v, 32)
+			binary.LittleEndian.PutUint32(r.PasswordExpiryInterval[:], uint32(interval))
+			return err
+		},
+	},
+	textTimeColumn("Record Modified Time", func(r *Record) *time.Time { return &r.ModTime }),
+	{"Password Policy", func(r *Record) string { return r.PasswordPolicy },
+		func(r *Record, v string) error {
+			if v != "" {
+				if _, err := ParsePasswordPolicy(v); err != nil {
+					return err
+				}
+			}
+			r.PasswordPolicy = v
+			return nil
+		},
+	},
+	textStringColumn("Password Policy Name", func(r *Record) *string { return &r.PasswordPolicyName }),
+	{"History", func(r *Record) string { return r.PasswordHistory },
+		func(r *Record, v string) error {
+			if _, err := ParsePasswordHistory(v); err != nil {
+				return err
+			}
+			r.PasswordHistory = v
+			return nil
+		},
+	},
+	textStringColumn("Run Command", func(r *Record) *string { return &r.RunCommand }),
+	textActionColumn("DCA", func(r *Record) *[2]byte { return &r.DoubleClickAction }),
+	textActionColumn("Shift+DCA", func(r *Record) *[2]byte { return &r.ShiftDoubleClickAction }),
+	textStringColumn("e-mail", func(r *Record) *string { return &r.Email }),
+	{"Protected",
+		func(r *Record) string {
+			if r.ProtectedEntry != 0 {
+				return "Y"
+			}
+			return "N"
+		},
+		func(r *Record, v string) error {
+			if strings.EqualFold(v, "Y") || v == "1" {
+				r.ProtectedEntry = 1
+			}
+			return nil
+		},
+	},
+	{"Notes", textNotes, setTextNotes},
+}
+
+func textStringColumn(name string, field func(r *Record) *string) textColumn {
+	return textColumn{
+		name: name,
+		get:  func(r *Record) string { return *field(r) },
+		set:  func(r *Record, v string) error { *field(r) = v; return nil },
+	}
+}
+
+func textTimeColumn(name string, field func(r *Record) *time.Time) textColumn {
+	return textColumn{
+		name: name,
+		get: func(r *Record) string {
+			if t := field(r); !t.IsZero() {
+				return t.Local().Format(textTimeFormat)
+			}
+			return ""
+		},
+		set: func(r *Record, v string) error {
+			if v == "" {
+				return nil
+			}
+			t, err := time.ParseInLocation(textTimeFormat, v, time.Local)
+			if err != nil {
+				return fmt.Errorf("%w, invalid time %q", ErrInvalidField, v)
+			}
+			*field(r) = t
+			return nil
+		},
+	}
+}
+
+// textActionColumn is a double click action column, stored as a number with empty meaning the default
+func textActionColumn(name string, field func(r *Record) *[2]byte) textColumn {
+	return textColumn{
+		name: name,
+		get: func(r *Record) string {
+			if action := field(r); *action != [2]byte{} {
+				return strconv.Itoa(int(binary.LittleEndian.Uint16(action[:])))
+			}
+			return ""
+		},
+		set: func(r *Record, v string) error {
+			action, err := parseTextUint(v, 16)
+			binary.LittleEndian.PutUint16(field(r)[:], uint16(action))
+			return err
+		},
+	}
+}
+
+func parseTextUint(v string, bits int) (uint64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 10, bits)
+	if err != nil {
+		return 0, fmt.Errorf("%w, invalid number %q", ErrInvalidField, v)
+	}
+	return n, nil
+}
+
+func textGroupTitle(r *Record) string {
+	title := strings.ReplaceAll(r.Title, ".", textTitleDot)
+	if r.Group == "" {
+		return title
+	}
+	return r.Group + "." + title
+}
+
+// setTextGroupTitle splits the group path from the title at the last dot
+func setTextGroupTitle(r *Record, v string) error {
+	if i := strings.LastIndex(v, "."); i >= 0 {
+		r.Group, v = v[:i], v[i+1:]
+	}
+	r.Title = strings.ReplaceAll(v, textTitleDot, ".")
+	return nil
+}
+
+func textNotes(r *Record) string {
+	if r.Notes == "" {
+		return ""
+	}
+	notes := strings.ReplaceAll(r.Notes, "\r\n", "\n")
+	notes = strings.ReplaceAll(notes, "\n", textNotesDelimiter)
+	notes = strings.NewReplacer(`\`, `\\`, "\t", `\t`).Replace(notes)
+	return `"` + notes + `"`
+}
+
+func setTextNotes(r *Record, v string) error {
+	if len(v) >= 2 && strings.HasPrefix(v, `"`) && strings.HasSuffix(v, `"`) {
+		v = v[1 : len(v)-1]
+	}
+	v = strings.ReplaceAll(v, textNotesDelimiter, "\r\n")
+	r.Notes = strings.NewReplacer(`\\`, `\`, `\t`, "\t").Replace(v)
+	return nil
+}
+
+//ExportText Writes the records of the db to w in the tab delimited plain text format of the official Password Safe
+//client. Backslashes and tabs are escaped as \\ and \t in notes, a record with a tab or line break in any other field
+//or a » in its title or notes is an error.
+func (db *V3) ExportText(w io.Writer) error {
+	var b strings.Builder
+	for i, column := range textColumns {
+		if i > 0 {
+			b.WriteByte('\t')
+		}
+		b.WriteString(column.name)
+	}
+	b.WriteString("\r\n")
+
+	for _, title := range db.recordOrder() {
+		record := db.Records[title]
+		if strings.Contains(record.Title, textTitleDot) || strings.Contains(record.Notes, textNotesDelimiter) {
+			return fmt.Errorf("%w, the title or notes of record %q contain %s", ErrInvalidField, title, textTitleDot)
+		}
+		for i, column := range textColumns {
+			value := column.get(&record)
+			if strings.ContainsAny(value, "\t\r\n") {
+				return fmt.Errorf("%w, the %s of record %q contains a tab or line break", ErrInvalidField, column.name, title)
+			}
+			if i > 0 {
+				b.WriteByte('\t')
+			}
+			b.WriteString(value)
+		}
+		b.WriteString("\r\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+//ImportText Reads records in the tab delimited plain text format of the official Password Safe client adding them with
+//SetRecord, a record whose title is already used is renamed with a numbered suffix. The first line names the columns
+//which may be any subset in any order, as written by a spreadsheet, but must include Group/Title. Unknown columns are
+//ignored. Returns the number of records imported.
+func (db *V3) ImportText(r io.Reader) (int, error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w, no header line", ErrInvalidField)
+	}
+	byName := make(map[string]*textColumn, len(textColumns))
+	for i := range textColumns {
+		byName[strings.ToLower(textColumns[i].name)] = &textColumns[i]
+	}
+	header := strings.Split(strings.TrimPrefix(strings.TrimRight(scanner.Text(), "\r"), "\ufeff"), "\t")
+	columns := make([]*textColumn, len(header))
+	hasTitle := false
+	for i, name := range header {
+		columns[i] = byName[strings.ToLower(strings.TrimSpace(name))]
+		hasTitle = hasTitle || columns[i] == &textColumns[0]
+	}
+	if !hasTitle {
+		return 0, fmt.Errorf("%w, the header line has no %s column", ErrInvalidField, textColumns[0].name)
+	}
+
+	var records []Record
+	for line := 2; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		values := strings.Split(text, "\t")
+		if len(values) > len(columns) {
+			return 0, fmt.Errorf("%w, line %d has %d columns but the header has %d", ErrInvalidField, line, len(values),
+				len(columns))
+		}
+		var record Record
+		for i, value := range values {
+			if columns[i] == nil {
+				continue
+			}
+			if err := columns[i].set(&record, value); err != nil {
+				return 0, fmt.Errorf("Error importing line %d %s - %w", line, columns[i].name, err)
+			}
+		}
+		if record.Title == "" {
+			return 0, fmt.Errorf("%w, line %d has no title", ErrInvalidField, line)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	db.importRecords(records, nil)
+	return len(records), nil
+}
@@ -0,0 +1,93 @@
+package pwsafe
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextRoundTrip(t *testing.T) {
+	source := fullDB()
+	dotted := testRecord()
+	dotted.Title = "release v1.2"
+	dotted.Group = ""
+	dotted.Notes = "tab\tseparated\r\nlines in C:\\temp\\ \\\t"
+	source.SetRecord(dotted)
+
+	var buf bytes.Buffer
+	assert.Nil(t, source.ExportText(&buf))
+	lines := strings.Split(buf.String(), "\r\n")
+	assert.Equal(t, len(source.Records)+2, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], "Group/Title\tUsername\tPassword\tURL\t"))
+	assert.True(t, strings.HasPrefix(lines[3], "release v1»2\t"))
+	assert.True(t, strings.HasSuffix(lines[3], "\t"+`"tab\tseparated»lines in C:\\temp\\ \\\t"`))
+
+	imported := NewV3("", "password")
+	n, err := imported.ImportText(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, len(source.Records), n)
+	assert.Equal(t, source.List(), imported.List())
+	for _, title := range source.List() {
+		record, _ := source.GetRecord(title)
+		importedRecord, _ := imported.GetRecord(title)
+		equal, err := recordsEqual(record, importedRecord, false)
+		assert.True(t, equal, err)
+	}
+
+	// Tabs can't be stored in other fields and » in titles and notes would be read back as dots and line breaks
+	for _, record := range []Record{
+		{Title: "tab", Password: "pass\tword"},
+		{Title: "a » b", Password: "pw"},
+		{Title: "notes", Password: "pw", Notes: "a » b"},
+	} {
+		db := NewV3("", "password")
+		db.SetRecord(record)
+		assert.True(t, errors.Is(db.ExportText(&buf), ErrInvalidField), record.Title)
+	}
+}
+
+func TestImportText(t *testing.T) {
+	// As from a spreadsheet with a subset of columns in a different order
+	sheet := "\ufeffPassword\tgroup/title\tSomething Else\tnotes\n" +
+		"secret\twork.mail\tignored\t\"line one»line two\"\n" +
+		"\n" +
+		"other\twork.sub.mail\n" +
+		"third\tmail\n"
+	db := NewV3("", "password")
+	db.SetRecord(Record{Title: "mail", Password: "existing"})
+	n, err := db.ImportText(strings.NewReader(sheet))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []string{"mail", "mail (2)", "mail (3)", "mail (4)"}, db.List())
+
+	work, _ := db.GetRecord("mail (2)")
+	assert.Equal(t, "work", work.Group)
+	assert.Equal(t, "secret", work.Password)
+	assert.Equal(t, "line one\r\nline two", work.Notes)
+	sub, _ := db.GetRecord("mail (3)")
+	assert.Equal(t, "work.sub", sub.Group)
+	third, _ := db.GetRecord("mail (4)")
+	assert.Equal(t, "", third.Group)
+
+	for name, invalid := range map[string]string{
+		"empty":       "",
+		"no title":    "Password\nsecret\n",
+		"columns":     "Group/Title\tPassword\na\tb\tc\n",
+		"time":        "Group/Title\tCreated Time\na\tyesterday\n",
+		"number":      "Group/Title\tDCA\na\tx\n",
+		"policy":      "Group/Title\tPassword Policy\na\tf000\n",
+		"empty title": "Group/Title\tPassword\ngroup.\tb\n",
+	} {
+		db := NewV3("", "password")
+		_, err := db.ImportText(strings.NewReader(invalid))
+		assert.True(t, errors.Is(err, ErrInvalidField), name)
+		assert.Equal(t, 0, len(db.Records), name)
+	}
+
+	db.readOnly = true
+	_, err = db.ImportText(strings.NewReader(sheet))
+	assert.Equal(t, ErrReadOnly, err)
+}
@@ -0,0 +1,665 @@
+// The database type for a Password Safe V4 database
+// The db specification - https://github.com/pwsafe/pwsafe/blob/master/docs/formatV4.txt
+//
+// A V4 file is
+//
+//	TAG|N|KB1|...|KBn|IV|HDR|R1|...|Rn|A1|...|An|EOF|HMAC
+//
+// TAG is "PWS4" and N the number of key blocks, each lets one password open the db. A key block is the salt and
+// iterations for PBKDF2-HMAC-SHA256 deriving a key from the password, the encryption key K and HMAC key H each wrapped
+// with twofish keyed by the derived key as specified in RFC 3394, and the HMAC-SHA256 with H of those values.
+// The header, records and attachments use the V3 field encoding encrypted with twofish in CBC mode using K. EOF is
+// "PWS4-EOFPWS4-EOF" and the HMAC is the HMAC-SHA256 with H of everything before it, so unlike V3 the encrypted data
+// is authenticated.
+
+package pwsafe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/twofish"
+)
+
+const (
+	v4Tag = "PWS4"
+	// v4EOFMarker is the unencrypted block ending the encrypted section of a V4 db
+	v4EOFMarker = "PWS4-EOFPWS4-EOF"
+	// keyWrapSize is the size of a 32 byte key wrapped as specified in RFC 3394
+	keyWrapSize = 32 + 8
+	// keyBlockSize is the salt, iterations, wrapped K, wrapped H and the HMAC
+	keyBlockSize = 32 + 4 + 2*keyWrapSize + sha256.Size
+	// maxKeyBlocks is the most key blocks, that is passwords, a V4 db can hold
+	maxKeyBlocks = 0xff
+)
+
+// v4Version is the DB Format version 0x0400 as written in the header
+var v4Version = [2]byte{0x00, 0x04}
+
+// keyWrapIV is the RFC 3394 default initial value, checked on unwrapping to verify the key
+var keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// Attachment field types, attachments are only held by V4 dbs and are written after the records, the attachment UUID
+// is always the first field
+const (
+	AttachmentUUID       FieldType = 0x60
+	AttachmentRecordUUID FieldType = 0x61
+	AttachmentTitle      FieldType = 0x62
+	AttachmentMediaType  FieldType = 0x63
+	AttachmentFileName   FieldType = 0x64
+	AttachmentCreateTime FieldType = 0x65
+	AttachmentContent    FieldType = 0x66
+)
+
+//Attachment A file attached to a record of a V4 db
+type Attachment struct {
+	UUID       [16]byte
+	RecordUUID [16]byte //the UUID of the record the file is attached to
+	Title      string
+	MediaType  string
+	FileName   string
+	CreateTime time.Time
+	Content    []byte
+}
+
+// attachmentField is the codec for a single attachment field, encode returns nil if the field is unset
+type attachmentField struct {
+	Type   FieldType
+	Name   string
+	encode func(*Attachment) []byte
+	decode func(*Attachment, []byte) error
+}
+
+// attachmentFields are the attachment field codecs, the UUID must be first
+var attachmentFields = []attachmentField{
+	{AttachmentUUID, "UUID",
+		func(a *Attachment) []byte { return a.UUID[:] },
+		func(a *Attachment, data []byte) error { return decodeArray(a.UUID[:], data) }},
+	{AttachmentRecordUUID, "RecordUUID",
+		func(a *Attachment) []byte { return encodeArray(a.RecordUUID[:]) },
+		func(a *Attachment, data []byte) error { return decodeArray(a.RecordUUID[:], data) }},
+	{AttachmentTitle, "Title",
+		func(a *Attachment) []byte { return encodeString(a.Title) },
+		func(a *Attachment, data []byte) error { return decodeString(&a.Title, data) }},
+	{AttachmentMediaType, "MediaType",
+		func(a *Attachment) []byte { return encodeString(a.MediaType) },
+		func(a *Attachment, data []byte) error { return decodeString(&a.MediaType, data) }},
+	{AttachmentFileName, "FileName",
+		func(a *Attachment) []byte { return encodeString(a.FileName) },
+		func(a *Attachment, data []byte) error { return decodeString(&a.FileName, data) }},
+	{AttachmentCreateTime, "CreateTime",
+		func(a *Attachment) []byte { return encodeTime(a.CreateTime) },
+		func(a *Attachment, data []byte) error { return decodeTime(&a.CreateTime, data) }},
+	{AttachmentContent, "Content",
+		func(a *Attachment) []byte { return encodeBytes(a.Content) },
+		func(a *Attachment, data []byte) error { return decodeBytes(&a.Content, data) }},
+}
+
+// attachmentFieldsByType indexes the attachment field codecs by type for decoding
+var attachmentFieldsByType [256]*attachmentField
+
+func init() {
+	for i := range attachmentFields {
+		attachmentFieldsByType[attachmentFields[i].Type] = &attachmentFields[i]
+	}
+}
+
+// decodeField sets the attachment field of type t from data
+func (a *Attachment) decodeField(t FieldType, data []byte) error {
+	field := attachmentFieldsByType[t]
+	if field == nil {
+		return ErrUnknownField
+	}
+	return field.decode(a, data)
+}
+
+// marshalAttachment adds all set fields of the attachment to w
+func (a *Attachment) marshalAttachment(w *fieldWriter) {
+	for _, field := range attachmentFields {
+		if data := field.encode(a); data != nil {
+			w.add(field.Type, data)
+		}
+	}
+	w.end()
+}
+
+// attachmentsEqual compares the fields of two lists of attachments
+func attachmentsEqual(attachments, others []Attachment) (bool, error) {
+	if len(attachments) != len(others) {
+		return false, fmt.Errorf("attachment lengths don't match, %v != %v", len(attachments), len(others))
+	}
+	for i := range attachments {
+		for _, field := range attachmentFields {
+			if !bytes.Equal(field.encode(&attachments[i]), field.encode(&others[i])) {
+				return false, fmt.Errorf("Attachments don't match, %v field differs for %q and %q", field.Name,
+					attachments[i].Title, others[i].Title)
+			}
+		}
+	}
+	return true, nil
+}
+
+//V4 The type representing a password safe v4 database. The header fields and records are the same as V3 so are held
+//in the embedded V3, the Salt, Iter and StretchedKey are those of the password the db was opened with.
+type V4 struct {
+	V3
+	Attachments []Attachment
+	keyBlocks   [][]byte //each key block lets a password open the db
+	keyBlock    int      //the index in keyBlocks of the password the db was opened with
+}
+
+//NewV4 - create and initialize a new pwsafe.V4 db
+func NewV4(name, password string) *V4 {
+	var db V4
+	db.Name = name
+	// create the initial UUID, if this fails it is retried on save
+	db.UUID, _ = db.newUUID()
+	db.Version = v4Version
+	db.Records = make(map[string]Record, 0)
+	db.Iter = DefaultIterations
+
+	// Set the password
+	db.SetPassword(password)
+	return &db
+}
+
+// pbkdf2Key derives a key from passwd with PBKDF2-HMAC-SHA256, every progressInterval iterations checking ctx for
+// cancellation and reporting to the progress func if it is not nil
+func pbkdf2Key(ctx context.Context, passwd string, salt []byte, iter uint32, progress func(done, total uint32)) ([sha256.Size]byte, error) {
+	var key [sha256.Size]byte
+	prf := hmac.New(sha256.New, []byte(passwd))
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1}) // the key is a single block
+	u := prf.Sum(nil)
+	copy(key[:], u)
+	for i := uint32(1); i < iter; i++ {
+		if i%progressInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return key, err
+			}
+			if progress != nil {
+				progress(i, iter)
+			}
+		}
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	if progress != nil {
+		progress(iter, iter)
+	}
+	return key, nil
+}
+
+// keyWrap wraps key, a multiple of 8 bytes, with the kek cipher as specified in RFC 3394
+func keyWrap(kek cipher.Block, key []byte) []byte {
+	n := len(key) / 8
+	wrapped := make([]byte, 8+len(key))
+	copy(wrapped, keyWrapIV)
+	copy(wrapped[8:], key)
+	var b [16]byte
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b[:8], wrapped[:8])
+			copy(b[8:], wrapped[8*i:8*i+8])
+			kek.Encrypt(b[:], b[:])
+			binary.BigEndian.PutUint64(wrapped[:8], binary.BigEndian.Uint64(b[:8])^uint64(n*j+i))
+			copy(wrapped[8*i:], b[8:])
+		}
+	}
+	return wrapped
+}
+
+// keyUnwrap unwraps a key wrapped by keyWrap, returning false if the integrity check fails such as for the wrong kek
+func keyUnwrap(kek cipher.Block, wrapped []byte) ([]byte, bool) {
+	n := len(wrapped)/8 - 1
+	a := append([]byte(nil), wrapped[:8]...)
+	key := append([]byte(nil), wrapped[8:]...)
+	var b [16]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(a)^uint64(n*j+i))
+			copy(b[8:], key[8*(i-1):8*i])
+			kek.Decrypt(b[:], b[:])
+			copy(a, b[:8])
+			copy(key[8*(i-1):], b[8:])
+		}
+	}
+	return key, subtle.ConstantTimeCompare(a, keyWrapIV) == 1
+}
+
+// newKeyBlock returns a key block wrapping the db keys with kek, the key derived from a password with salt and iter
+func (db *V4) newKeyBlock(salt []byte, iter uint32, kek [sha256.Size]byte) []byte {
+	c, _ := twofish.NewCipher(kek[:])
+	block := make([]byte, 0, keyBlockSize)
+	block = append(block, salt...)
+	block = append(block, intToBytes(int(iter))...)
+	block = append(block, keyWrap(c, db.EncryptionKey[:])...)
+	block = append(block, keyWrap(c, db.HMACKey[:])...)
+	mac := hmac.New(sha256.New, db.HMACKey[:])
+	mac.Write(block)
+	return mac.Sum(block)
+}
+
+// openKeyBlock derives the key from passwd with the salt and iterations of the key block and unwraps the db keys,
+// returning false if the password doesn't open this key block
+func (db *V4) openKeyBlock(ctx context.Context, block []byte, passwd string, opts OpenOptions) (bool, error) {
+	iter := binary.LittleEndian.Uint32(block[32:36])
+	if iter > opts.maxIterations() {
+		return false, ErrIterationsExceeded
+	}
+	kek, err := pbkdf2Key(ctx, passwd, block[:32], iter, opts.Progress)
+	if err != nil {
+		return false, err
+	}
+	c, _ := twofish.NewCipher(kek[:])
+	pos := 36
+	encryptionKey, ok := keyUnwrap(c, block[pos:pos+keyWrapSize])
+	if !ok {
+		return false, nil
+	}
+	pos += keyWrapSize
+	hmacKey, ok := keyUnwrap(c, block[pos:pos+keyWrapSize])
+	if !ok {
+		return false, nil
+	}
+	copy(db.Salt[:], block[:32])
+	db.Iter = iter
+	db.StretchedKey = kek
+	copy(db.EncryptionKey[:], encryptionKey)
+	copy(db.HMACKey[:], hmacKey)
+	return true, nil
+}
+
+//Decrypt Decrypts the data in the reader using the given password and populates the information into the db
+func (db *V4) Decrypt(reader io.Reader, passwd string) (int, error) {
+	return db.DecryptContext(context.Background(), reader, passwd, OpenOptions{})
+}
+
+//DecryptContext Decrypts the data in the reader like Decrypt, stopping early if ctx is cancelled and rejecting files
+//which exceed the limits in opts. The password is tried against each key block in turn.
+func (db *V4) DecryptContext(ctx context.Context, reader io.Reader, passwd string, opts OpenOptions) (int, error) {
+	counter := &countingReader{r: reader, max: opts.maxFileSize()}
+	in := bufio.NewReader(counter)
+	db.readOnly = opts.ReadOnly
+
+	// The tag and number of key blocks
+	var tag [len(v4Tag) + 1]byte
+	if n, err := io.ReadFull(in, tag[:]); err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return int(counter.n), err
+		}
+		if n < len(v4Tag) || string(tag[:len(v4Tag)]) != v4Tag {
+			return int(counter.n), ErrNotPWS4
+		}
+		return int(counter.n), fmt.Errorf("%w, DB file is smaller than minimum size", ErrTruncated)
+	}
+	if string(tag[:len(v4Tag)]) != v4Tag {
+		return int(counter.n), ErrNotPWS4
+	}
+	if tag[len(v4Tag)] == 0 {
+		return int(counter.n), fmt.Errorf("%w, there are no key blocks", ErrInvalidField)
+	}
+
+	db.keyBlocks = nil
+	db.keyBlock = -1
+	for i := 0; i < int(tag[len(v4Tag)]); i++ {
+		block := make([]byte, keyBlockSize)
+		if _, err := io.ReadFull(in, block); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = fmt.Errorf("%w, key block %d is incomplete", ErrTruncated, i)
+			}
+			return int(counter.n), err
+		}
+		db.keyBlocks = append(db.keyBlocks, block)
+		if db.keyBlock >= 0 {
+			continue
+		}
+		opened, err := db.openKeyBlock(ctx, block, passwd, opts)
+		if err != nil {
+			return int(counter.n), err
+		}
+		if opened {
+			db.keyBlock = i
+		}
+	}
+	if db.keyBlock < 0 {
+		return int(counter.n), ErrInvalidPassword
+	}
+
+	// Everything up to the HMAC is authenticated, including the key blocks of the other passwords
+	mac := hmac.New(sha256.New, db.HMACKey[:])
+	mac.Write(tag[:])
+	for _, block := range db.keyBlocks {
+		blockMAC := hmac.New(sha256.New, db.HMACKey[:])
+		blockMAC.Write(block[:keyBlockSize-sha256.Size])
+		if !hmac.Equal(blockMAC.Sum(nil), block[keyBlockSize-sha256.Size:]) {
+			return int(counter.n), ErrBadHMAC
+		}
+		mac.Write(block)
+	}
+	if _, err := io.ReadFull(in, db.CBCIV[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("%w, DB file is smaller than minimum size", ErrTruncated)
+		}
+		return int(counter.n), err
+	}
+	mac.Write(db.CBCIV[:])
+
+	block, err := twofish.NewCipher(db.EncryptionKey[:])
+	if err != nil {
+		return int(counter.n), err
+	}
+	plaintext := newCBCReader(io.TeeReader(in, mac), cipher.NewCBCDecrypter(block, db.CBCIV[:]), v4EOFMarker)
+	fields := newFieldReader(plaintext, io.Discard)
+
+	if err := unmarshalRecord(fields, db.decodeField); err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("%w, no header found", ErrTruncated)
+		}
+		if fieldErr, ok := err.(*FieldError); ok {
+			fieldErr.Record = -1
+		}
+		return int(counter.n), fmt.Errorf("Error parsing the unencrypted header - %w", err)
+	}
+
+	if err := db.unmarshalRecords(fields); err != nil {
+		return int(counter.n), fmt.Errorf("Error parsing the unencrypted records - %w", err)
+	}
+
+	if err := db.readHMAC(in); err != nil {
+		return int(counter.n), err
+	}
+	if !hmac.Equal(db.HMAC[:], mac.Sum(nil)) {
+		return int(counter.n), ErrBadHMAC
+	}
+
+	return int(counter.n), nil
+}
+
+// unmarshalRecords reads records and attachments from the decrypted data until it ends, an attachment is identified
+// by its first field being the AttachmentUUID
+func (db *V4) unmarshalRecords(fr *fieldReader) error {
+	db.Records = make(map[string]Record)
+	db.order = nil
+	db.Attachments = nil
+	for i := 0; ; i++ {
+		var record Record
+		var attachment Attachment
+		first, isAttachment := true, false
+		err := unmarshalRecord(fr, func(t FieldType, data []byte) error {
+			if first {
+				first = false
+				isAttachment = t == AttachmentUUID
+			}
+			if isAttachment {
+				return attachment.decodeField(t, data)
+			}
+			return record.decodeField(t, data)
+		})
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if fieldErr, ok := err.(*FieldError); ok {
+				fieldErr.Record = i
+				return fieldErr
+			}
+			return fmt.Errorf("Error parsing record %d - %w", i, err)
+		}
+		if isAttachment {
+			db.Attachments = append(db.Attachments, attachment)
+			continue
+		}
+		if _, prs := db.Records[record.Title]; !prs {
+			db.order = append(db.order, record.Title)
+		}
+		db.Records[record.Title] = record
+	}
+}
+
+//Encrypt Encrypt the data in the db writing it to the writer a record at a time, returns bytesWritten, error
+//A db opened read-only is left unchanged and nothing is written, ErrReadOnly is returned
+func (db *V4) Encrypt(writer io.Writer) (int, error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	if len(db.keyBlocks) == 0 {
+		return 0, errors.New("Error no password is set")
+	}
+	counter := &countingWriter{w: writer}
+	out := bufio.NewWriter(counter)
+	// Everything but the HMAC itself is authenticated
+	mac := hmac.New(sha256.New, db.HMACKey[:])
+	body := io.MultiWriter(out, mac)
+
+	//update the LastSave time in the DB
+	db.LastSave = db.now()
+	if db.UUID == [16]byte{} {
+		var err error
+		if db.UUID, err = db.newUUID(); err != nil {
+			return 0, err
+		}
+	}
+
+	body.Write([]byte(v4Tag))
+	body.Write([]byte{byte(len(db.keyBlocks))})
+	for _, block := range db.keyBlocks {
+		body.Write(block)
+	}
+	if _, err := io.ReadFull(db.random(), db.CBCIV[:]); err != nil {
+		return 0, err
+	}
+	body.Write(db.CBCIV[:])
+
+	dbTwoFish, _ := twofish.NewCipher(db.EncryptionKey[:])
+	cbcTwoFish := cipher.NewCBCEncrypter(dbTwoFish, db.CBCIV[:])
+
+	db.Version = v4Version
+	fields := fieldWriter{rand: db.Rand}
+	db.marshalHeader(&fields)
+	if err := writeEncrypted(body, cbcTwoFish, fields.record); err != nil {
+		return counter.n, err
+	}
+	if err := db.marshalRecords(&fields, body, cbcTwoFish, io.Discard); err != nil {
+		return counter.n, err
+	}
+	for i := range db.Attachments {
+		if db.Attachments[i].UUID == [16]byte{} {
+			var err error
+			if db.Attachments[i].UUID, err = db.newUUID(); err != nil {
+				return counter.n, err
+			}
+		}
+		fields.reset()
+		db.Attachments[i].marshalAttachment(&fields)
+		if err := writeEncrypted(body, cbcTwoFish, fields.record); err != nil {
+			return counter.n, err
+		}
+	}
+
+	// Add the EOF and HMAC
+	body.Write([]byte(v4EOFMarker))
+	copy(db.HMAC[:], mac.Sum(nil))
+	out.Write(db.HMAC[:])
+
+	// Write out the remaining buffered data, any earlier write error is also reported here
+	err := out.Flush()
+	return counter.n, err
+}
+
+//SetPassword Sets the password that will be used to encrypt the file on next save. New keys are generated so any
+//other passwords added with AddPassword no longer open the db.
+func (db *V4) SetPassword(pw string) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	// The keys and iterations only change once every new key is ready so a failure leaves the db as it was, any
+	// pending iterations are dropped either way
+	iter := db.nextIterations()
+	db.pendingIter = 0
+	var salt, encryptionKey, hmacKey [32]byte
+	for _, key := range [][]byte{salt[:], encryptionKey[:], hmacKey[:]} {
+		if _, err := io.ReadFull(db.random(), key); err != nil {
+			return err
+		}
+	}
+	kek, err := pbkdf2Key(context.Background(), pw, salt[:], iter, nil)
+	if err != nil {
+		return err
+	}
+	db.Salt, db.EncryptionKey, db.HMACKey, db.Iter = salt, encryptionKey, hmacKey, iter
+	db.StretchedKey = kek
+	db.keyBlocks = [][]byte{db.newKeyBlock(db.Salt[:], db.Iter, kek)}
+	db.keyBlock = 0
+	db.LastMod = db.now()
+	return nil
+}
+
+//AddPassword Adds a key block so the db may also be opened with pw, the existing passwords keep working
+func (db *V4) AddPassword(pw string) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	if len(db.keyBlocks) == 0 {
+		return db.SetPassword(pw)
+	}
+	if len(db.keyBlocks) >= maxKeyBlocks {
+		return fmt.Errorf("A V4 db can't have more than %d passwords", maxKeyBlocks)
+	}
+	var salt [32]byte
+	if _, err := io.ReadFull(db.random(), salt[:]); err != nil {
+		return err
+	}
+	kek, err := pbkdf2Key(context.Background(), pw, salt[:], db.Iter, nil)
+	if err != nil {
+		return err
+	}
+	db.keyBlocks = append(db.keyBlocks, db.newKeyBlock(salt[:], db.Iter, kek))
+	db.LastMod = db.now()
+	return nil
+}
+
+//Passwords Returns the number of passwords which open the db
+func (db *V4) Passwords() int {
+	return len(db.keyBlocks)
+}
+
+//DeleteRecord Removes a record and its attachments from the db
+func (db *V4) DeleteRecord(title string) error {
+	record, prs := db.Records[title]
+	if err := db.V3.DeleteRecord(title); err != nil {
+		return err
+	}
+	if prs {
+		kept := db.Attachments[:0]
+		for _, attachment := range db.Attachments {
+			if attachment.RecordUUID != record.UUID {
+				kept = append(kept, attachment)
+			}
+		}
+		db.Attachments = kept
+	}
+	return nil
+}
+
+//AddAttachment Attaches a file to the record with the given title, the attachment UUID and CreateTime are set if
+//empty
+func (db *V4) AddAttachment(title string, attachment Attachment) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	record, prs := db.Records[title]
+	if !prs {
+		return fmt.Errorf("No record with title %q", title)
+	}
+	if record.UUID == [16]byte{} {
+		var err error
+		if record.UUID, err = db.newUUID(); err != nil {
+			return err
+		}
+		db.Records[title] = record
+	}
+	attachment.RecordUUID = record.UUID
+	if attachment.UUID == [16]byte{} {
+		var err error
+		if attachment.UUID, err = db.newUUID(); err != nil {
+			return err
+		}
+	}
+	now := db.now()
+	if attachment.CreateTime.IsZero() {
+		attachment.CreateTime = now
+	}
+	db.Attachments = append(db.Attachments, attachment)
+	db.LastMod = now
+	return nil
+}
+
+//RecordAttachments Returns the attachments of the record with the given title
+func (db *V4) RecordAttachments(title string) []Attachment {
+	record, prs := db.Records[title]
+	if !prs {
+		return nil
+	}
+	var attachments []Attachment
+	for _, attachment := range db.Attachments {
+		if attachment.RecordUUID == record.UUID {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments
+}
+
+//DeleteAttachment Removes the attachment with the given UUID
+func (db *V4) DeleteAttachment(uuid [16]byte) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	for i, attachment := range db.Attachments {
+		if attachment.UUID == uuid {
+			db.Attachments = append(db.Attachments[:i], db.Attachments[i+1:]...)
+			db.LastMod = db.now()
+			return nil
+		}
+	}
+	return fmt.Errorf("No attachment with UUID %x", uuid)
+}
+
+//Equal Returns true if the two V4 dbs have the same data and attachments, see V3.Equal
+func (db *V4) Equal(other DB) (bool, error) {
+	otherV4, ok := other.(*V4)
+	if !ok {
+		return false, fmt.Errorf("can't compare a V4 db with %T", other)
+	}
+	if equal, err := db.V3.Equal(&otherV4.V3); !equal {
+		return false, err
+	}
+	return attachmentsEqual(db.Attachments, otherV4.Attachments)
+}
+
+//Identical Returns true if the two V4 dbs have the same fields including the cryptographic keys and attachments, see
+//V3.Identical
+func (db *V4) Identical(other DB) (bool, error) {
+	otherV4, ok := other.(*V4)
+	if !ok {
+		return false, fmt.Errorf("can't compare a V4 db with %T", other)
+	}
+	if identical, err := db.V3.Identical(&otherV4.V3); !identical {
+		return false, err
+	}
+	return attachmentsEqual(db.Attachments, otherV4.Attachments)
+}
@@ -0,0 +1,203 @@
+package pwsafe
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// testV4 returns a V4 db with every header field and a record with each field set using few iterations to keep the
+// tests quick
+func testV4(t *testing.T) *V4 {
+	db := &V4{V3: *fullDB()}
+	assert.Nil(t, db.SetIterations(MinIterations))
+	assert.Nil(t, db.SetPassword("password"))
+	assert.Nil(t, db.AddAttachment(testRecord().Title, Attachment{Title: "scan", MediaType: "image/png",
+		FileName: "scan.png", CreateTime: time.Unix(1400000000, 0), Content: bytes.Repeat([]byte{0x89, 'P'}, 100)}))
+	return db
+}
+
+func TestKeyWrap(t *testing.T) {
+	// The RFC 3394 test vectors wrapping 128 and 256 bit keys
+	for _, vector := range []struct{ kek, key, wrapped string }{
+		{"000102030405060708090a0b0c0d0e0f", "00112233445566778899aabbccddeeff",
+			"1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5"},
+		{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			"00112233445566778899aabbccddeeff000102030405060708090a0b0c0d0e0f",
+			"28c9f404c4b810f4cbccb35cfb87f8263f5786e2d80ed326cbc7f0e71a99f43bfb988b9b7a02dd21"},
+	} {
+		kek, _ := hex.DecodeString(vector.kek)
+		key, _ := hex.DecodeString(vector.key)
+		block, err := aes.NewCipher(kek)
+		assert.Nil(t, err)
+		wrapped := keyWrap(block, key)
+		assert.Equal(t, vector.wrapped, hex.EncodeToString(wrapped))
+		unwrapped, ok := keyUnwrap(block, wrapped)
+		assert.True(t, ok)
+		assert.Equal(t, key, unwrapped)
+
+		wrapped[3] ^= 1
+		_, ok = keyUnwrap(block, wrapped)
+		assert.False(t, ok)
+	}
+}
+
+func TestPBKDF2Key(t *testing.T) {
+	salt := []byte("salt")
+	for _, iter := range []uint32{1, 2, progressInterval + 1} {
+		var reported uint32
+		key, err := pbkdf2Key(context.Background(), "password", salt, iter, func(done, total uint32) { reported = done })
+		assert.Nil(t, err)
+		assert.Equal(t, pbkdf2.Key([]byte("password"), salt, int(iter), sha256.Size, sha256.New), key[:])
+		assert.Equal(t, iter, reported)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := pbkdf2Key(ctx, "password", salt, progressInterval+1, nil)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestV4RoundTrip(t *testing.T) {
+	db := testV4(t)
+	assert.Nil(t, db.AddPassword("second"))
+	assert.Equal(t, 2, db.Passwords())
+	var buf bytes.Buffer
+	_, err := db.Encrypt(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, v4Tag, buf.String()[:4])
+
+	for _, password := range []string{"password", "second"} {
+		var read V4
+		_, err := read.Decrypt(bytes.NewReader(buf.Bytes()), password)
+		assert.Nil(t, err, password)
+		equal, err := db.Equal(&read)
+		assert.True(t, equal, err)
+		assert.Equal(t, v4Version, read.Version)
+		assert.Equal(t, 2, read.Passwords())
+		attachments := read.RecordAttachments(testRecord().Title)
+		assert.Equal(t, 1, len(attachments))
+		assert.Equal(t, "scan.png", attachments[0].FileName)
+	}
+
+	var read V4
+	_, err = read.Decrypt(bytes.NewReader(buf.Bytes()), "password")
+	assert.Nil(t, err)
+	identical, err := db.Identical(&read)
+	assert.True(t, identical, err)
+	equal, err := db.Equal(&db.V3)
+	assert.False(t, equal)
+	assert.NotNil(t, err)
+
+	// Setting the password removes the others
+	assert.Nil(t, read.SetPassword("new"))
+	assert.Equal(t, 1, read.Passwords())
+	buf.Reset()
+	_, err = read.Encrypt(&buf)
+	assert.Nil(t, err)
+	_, err = (&V4{}).Decrypt(bytes.NewReader(buf.Bytes()), "second")
+	assert.Equal(t, ErrInvalidPassword, err)
+	_, err = (&V4{}).Decrypt(bytes.NewReader(buf.Bytes()), "new")
+	assert.Nil(t, err)
+
+	// Deleting a record deletes its attachments
+	assert.Nil(t, read.DeleteRecord(testRecord().Title))
+	assert.Equal(t, 0, len(read.Attachments))
+}
+
+func TestV4DecryptErrors(t *testing.T) {
+	db := testV4(t)
+	assert.Nil(t, db.AddPassword("second"))
+	var buf bytes.Buffer
+	_, err := db.Encrypt(&buf)
+	assert.Nil(t, err)
+	data := buf.Bytes()
+
+	_, err = (&V4{}).Decrypt(bytes.NewReader(data), "wrong")
+	assert.Equal(t, ErrInvalidPassword, err)
+	_, err = (&V4{}).Decrypt(bytes.NewReader([]byte("PWS3 and more")), "password")
+	assert.Equal(t, ErrNotPWS4, err)
+	_, err = (&V4{}).DecryptContext(context.Background(), bytes.NewReader(data), "password",
+		OpenOptions{MaxIterations: MinIterations - 1})
+	assert.Equal(t, ErrIterationsExceeded, err)
+	for _, size := range []int{5, 100, len(v4Tag) + 1 + 2*keyBlockSize + 8, len(data) - 100, len(data) - 10} {
+		_, err = (&V4{}).Decrypt(bytes.NewReader(data[:size]), "password")
+		assert.True(t, errors.Is(err, ErrTruncated), size)
+	}
+
+	// The other key blocks and the encrypted data are authenticated
+	for _, pos := range []int{len(v4Tag) + 1 + keyBlockSize + 10, len(data) - sha256.Size - 20} {
+		tampered := append([]byte(nil), data...)
+		tampered[pos] ^= 1
+		_, err = (&V4{}).Decrypt(bytes.NewReader(tampered), "password")
+		assert.NotNil(t, err, pos)
+	}
+	tampered := append([]byte(nil), data...)
+	tampered[len(v4Tag)+1+keyBlockSize+10] ^= 1
+	_, err = (&V4{}).Decrypt(bytes.NewReader(tampered), "password")
+	assert.Equal(t, ErrBadHMAC, err)
+
+	_, err = (&V4{}).Encrypt(&buf)
+	assert.NotNil(t, err)
+}
+
+func TestOpenV4(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "v4.psafe4")
+	db := testV4(t)
+	assert.Nil(t, WritePWSafeFile(db, path))
+
+	opened, err := OpenPWSafeFileContext(context.Background(), path, "password", OpenOptions{ReadOnly: true})
+	assert.Nil(t, err)
+	v4db, ok := opened.(*V4)
+	assert.True(t, ok)
+	assert.Equal(t, path, v4db.LastSavePath)
+	assert.True(t, opened.ReadOnly())
+	assert.Equal(t, ErrReadOnly, v4db.AddAttachment(testRecord().Title, Attachment{}))
+	lastSave, iv := v4db.LastSave, v4db.CBCIV
+	var buf bytes.Buffer
+	n, err := v4db.Encrypt(&buf)
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Equal(t, 0, n+buf.Len())
+	assert.Equal(t, lastSave, v4db.LastSave)
+	assert.Equal(t, iv, v4db.CBCIV)
+	equal, err := db.Equal(opened)
+	assert.True(t, equal, err)
+
+	opened, err = OpenPWSafeFile(path, "wrong")
+	assert.Equal(t, ErrInvalidPassword, err)
+
+	base, ok := BaseV3(db)
+	assert.True(t, ok)
+	assert.Nil(t, base.SetRecord(Record{Title: "added", Password: "pw"}))
+	_, prs := db.GetRecord("added")
+	assert.True(t, prs)
+
+	// A V3 file still opens as V3, the embedded V3 needs its own key stretching
+	os.Remove(path)
+	assert.Nil(t, db.V3.SetPassword("password"))
+	assert.Nil(t, WritePWSafeFile(&db.V3, path))
+	opened, err = OpenPWSafeFile(path, "password")
+	assert.Nil(t, err)
+	_, ok = opened.(*V3)
+	assert.True(t, ok)
+}
+
+func TestExportV4KDBX(t *testing.T) {
+	db := testV4(t)
+	var buf bytes.Buffer
+	assert.Nil(t, ExportKDBX(db, &buf, "keepass", KDBXOptions{Memory: 32 * 1024, Iterations: 1, Parallelism: 1}))
+	imported := NewV3("", "password")
+	_, err := imported.ImportKDBX(bytes.NewReader(buf.Bytes()), "keepass")
+	assert.Nil(t, err)
+	assert.Equal(t, db.Description, imported.Description)
+	assert.Equal(t, []string{"empty.sub"}, imported.EmptyGroups)
+}
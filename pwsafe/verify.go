@@ -0,0 +1,235 @@
+package pwsafe
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/twofish"
+)
+
+// preludeSize is the size of the unencrypted tag, salt, iter, key hash, encrypted keys and CBC IV
+const preludeSize = 152
+
+//Structure Identifies the part of a db file a Problem was found in
+type Structure string
+
+// The structures of a db file checked by Verify and Salvage, in file order
+const (
+	StructurePrelude   Structure = "prelude"
+	StructureKeyHash   Structure = "key hash"
+	StructureAlignment Structure = "block alignment"
+	StructureHeader    Structure = "header"
+	StructureRecord    Structure = "record"
+	StructureEOF       Structure = "EOF marker"
+	StructureHMAC      Structure = "HMAC"
+)
+
+//Problem Describes a single problem found when verifying a db file
+type Problem struct {
+	Structure Structure
+	// Offset is the byte offset in the file where the problem structure starts
+	Offset int64
+	// Record is the index of the record in the file for StructureRecord problems
+	Record int
+	// Title is the title of a dropped record if it could be read
+	Title string
+	Err   error
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.Structure != StructureRecord:
+		return fmt.Sprintf("%s at offset %d: %v", p.Structure, p.Offset, p.Err)
+	case p.Title != "":
+		return fmt.Sprintf("%s %d %q at offset %d: %v", p.Structure, p.Record, p.Title, p.Offset, p.Err)
+	}
+	return fmt.Sprintf("%s %d at offset %d: %v", p.Structure, p.Record, p.Offset, p.Err)
+}
+
+//Report The result of verifying or salvaging a db file
+type Report struct {
+	// Size is the file size in bytes
+	Size int64
+	// Records is the number of records which were parsed successfully
+	Records int
+	// Dropped is the number of records which could not be parsed
+	Dropped int
+	// Problems are sorted by offset
+	Problems []Problem
+}
+
+// add records a problem
+func (r *Report) add(s Structure, offset int, err error) {
+	r.Problems = append(r.Problems, Problem{Structure: s, Offset: int64(offset), Err: err})
+}
+
+//OK Returns true if no problems were found
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+//String Returns a human readable report with one line per problem
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d bytes, %d records recovered, %d records dropped\n", r.Size, r.Records, r.Dropped)
+	if r.OK() {
+		b.WriteString("OK\n")
+	}
+	for _, p := range r.Problems {
+		b.WriteString(p.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+//Verify Checks every structure of the db read from reader reporting each that is damaged rather than stopping at the
+//first as Decrypt does. The returned error is only set if the data couldn't be read, including when it exceeds the
+//size limit in opts, or ctx is cancelled.
+func Verify(ctx context.Context, reader io.Reader, passwd string, opts OpenOptions) (*Report, error) {
+	_, report, err := Salvage(ctx, reader, passwd, opts)
+	return report, err
+}
+
+//Salvage Verifies the db read from reader like Verify and returns every record that could be parsed. Damaged records
+//are skipped up to their END field and counted as dropped in the report. The returned db is nil if it could not be
+//decrypted at all, that is if the prelude or key hash are damaged or the password is wrong.
+func Salvage(ctx context.Context, reader io.Reader, passwd string, opts OpenOptions) (*V3, *Report, error) {
+	var report Report
+	// Read it all, damaged data is easier to handle with random access
+	data, err := io.ReadAll(&countingReader{r: reader, max: opts.maxFileSize()})
+	report.Size = int64(len(data))
+	if err != nil {
+		return nil, &report, err
+	}
+
+	if len(data) < 4 || string(data[:4]) != "PWS3" {
+		report.add(StructurePrelude, 0, ErrNotPWS3)
+		return nil, &report, nil
+	}
+	if len(data) < preludeSize {
+		report.add(StructurePrelude, 0, fmt.Errorf("%w, DB file is smaller than minimum size", ErrTruncated))
+		return nil, &report, nil
+	}
+
+	db := &V3{Records: make(map[string]Record)}
+	copy(db.Salt[:], data[4:36])
+	db.Iter = binary.LittleEndian.Uint32(data[36:40])
+	if db.Iter > opts.maxIterations() {
+		report.add(StructurePrelude, 36, ErrIterationsExceeded)
+		return nil, &report, nil
+	}
+	if err := db.stretchKey(ctx, passwd, opts.Progress); err != nil {
+		return nil, &report, err
+	}
+	if keyHash := sha256.Sum256(db.StretchedKey[:]); !bytes.Equal(data[40:72], keyHash[:]) {
+		report.add(StructureKeyHash, 40, fmt.Errorf("%w or the key hash is damaged", ErrInvalidPassword))
+		return nil, &report, nil
+	}
+	db.extractKeys(data[72:136])
+	copy(db.CBCIV[:], data[136:preludeSize])
+
+	// Find the end of the encrypted data and the HMAC following it
+	body := data[preludeSize:]
+	end := bytes.Index(body, []byte(eofMarker))
+	var storedHMAC []byte
+	hmacStart := len(data)
+	if end < 0 {
+		end = len(body)
+		report.add(StructureEOF, len(data), fmt.Errorf("%w, no EOF found", ErrTruncated))
+	} else {
+		trailer := body[end+len(eofMarker):]
+		hmacStart = preludeSize + end + len(eofMarker)
+		switch {
+		case len(trailer) < sha256.Size:
+			report.add(StructureHMAC, hmacStart, fmt.Errorf("%w, HMAC is incomplete", ErrTruncated))
+		case len(trailer) > sha256.Size:
+			report.add(StructureEOF, hmacStart+sha256.Size, errors.New("Error unknown data after expected EOF"))
+			fallthrough
+		default:
+			storedHMAC = trailer[:sha256.Size]
+		}
+	}
+	if extra := end % twofish.BlockSize; extra != 0 {
+		end -= extra
+		report.add(StructureAlignment, preludeSize+end, fmt.Errorf("%w, %d bytes left over", ErrBlockAlignment, extra))
+	}
+
+	block, err := twofish.NewCipher(db.EncryptionKey[:])
+	if err != nil {
+		return nil, &report, err
+	}
+	plaintext := make([]byte, end)
+	cipher.NewCBCDecrypter(block, db.CBCIV[:]).CryptBlocks(plaintext, body[:end])
+	mac := hmac.New(sha256.New, db.HMACKey[:])
+
+	// Parse the header then each record, on failure skipping to the next END field
+	parse := func(pos int, decode func(FieldType, []byte) error) (int, error) {
+		r := bytes.NewReader(plaintext[pos:])
+		err := unmarshalRecord(newFieldReader(r, mac), decode)
+		return len(plaintext) - r.Len(), err
+	}
+	next, err := parse(0, db.decodeField)
+	if err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("%w, no header found", ErrTruncated)
+		}
+		report.add(StructureHeader, preludeSize, err)
+		next = nextRecord(plaintext, 0)
+	}
+	for i := 0; next < len(plaintext); i++ {
+		var record Record
+		pos := next
+		next, err = parse(pos, record.decodeField)
+		if err == nil {
+			if _, prs := db.Records[record.Title]; !prs {
+				db.order = append(db.order, record.Title)
+			}
+			db.Records[record.Title] = record
+			report.Records++
+			continue
+		}
+		if fieldErr, ok := err.(*FieldError); ok {
+			fieldErr.Record = i
+		}
+		report.Problems = append(report.Problems, Problem{
+			Structure: StructureRecord, Offset: int64(preludeSize + pos), Record: i, Title: record.Title, Err: err,
+		})
+		report.Dropped++
+		next = nextRecord(plaintext, pos)
+	}
+
+	// The HMAC only covers data which could be parsed so if anything was dropped it is expected to differ
+	if storedHMAC != nil {
+		copy(db.HMAC[:], storedHMAC)
+		if !hmac.Equal(storedHMAC, mac.Sum(nil)) {
+			report.add(StructureHMAC, hmacStart, ErrBadHMAC)
+		}
+	}
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		return report.Problems[i].Offset < report.Problems[j].Offset
+	})
+	db.LastMod = time.Now()
+	return db, &report, nil
+}
+
+// nextRecord Returns the position in the plaintext following the first END field found after the block at pos, or
+// the end of the plaintext if there is none
+func nextRecord(plaintext []byte, pos int) int {
+	endField := []byte{0, 0, 0, 0, byte(FieldEnd)}
+	for pos += twofish.BlockSize; pos+twofish.BlockSize <= len(plaintext); pos += twofish.BlockSize {
+		if bytes.Equal(plaintext[pos:pos+len(endField)], endField) {
+			return pos + twofish.BlockSize
+		}
+	}
+	return len(plaintext)
+}
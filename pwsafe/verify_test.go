@@ -0,0 +1,119 @@
+package pwsafe
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/twofish"
+)
+
+func TestVerify(t *testing.T) {
+	data, err := ioutil.ReadFile("./test_dbs/three.dat")
+	assert.Nil(t, err)
+
+	report, err := Verify(context.Background(), bytes.NewReader(data), "three3#;", OpenOptions{})
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.String())
+	assert.Equal(t, 3, report.Records)
+
+	report, err = Verify(context.Background(), bytes.NewReader(data), "badpass", OpenOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.Problems))
+	assert.Equal(t, StructureKeyHash, report.Problems[0].Structure)
+	assert.True(t, errors.Is(report.Problems[0].Err, ErrInvalidPassword))
+
+	cases := []struct {
+		name       string
+		data       []byte
+		structures []Structure
+		records    int
+	}{
+		{name: "not pws3", data: []byte("PWS2 and more"), structures: []Structure{StructurePrelude}},
+		{name: "short prelude", data: data[:100], structures: []Structure{StructurePrelude}},
+		{name: "no eof", data: data[:len(data)-48], structures: []Structure{StructureEOF}, records: 3},
+		{name: "no last block", data: data[:len(data)-64], structures: []Structure{StructureRecord, StructureEOF}, records: 2},
+		{name: "short hmac", data: data[:len(data)-10], structures: []Structure{StructureHMAC}, records: 3},
+		{name: "trailing data", data: append(append([]byte{}, data...), 1, 2, 3), structures: []Structure{StructureEOF}, records: 3},
+		{
+			name:       "misaligned",
+			data:       append(append([]byte{}, data[:len(data)-48-3]...), data[len(data)-48:]...),
+			structures: []Structure{StructureRecord, StructureAlignment},
+			records:    2,
+		},
+	}
+	for _, c := range cases {
+		report, err := Verify(context.Background(), bytes.NewReader(c.data), "three3#;", OpenOptions{})
+		assert.Nil(t, err, c.name)
+		var structures []Structure
+		for _, p := range report.Problems {
+			structures = append(structures, p.Structure)
+		}
+		assert.Equal(t, c.structures, structures, c.name)
+		assert.Equal(t, c.records, report.Records, c.name)
+	}
+
+	_, err = Verify(context.Background(), bytes.NewReader(data), "three3#;", OpenOptions{MaxFileSize: 100})
+	assert.Equal(t, ErrFileTooLarge, err)
+}
+
+func TestSalvageBadHMAC(t *testing.T) {
+	data, err := ioutil.ReadFile("./test_dbs/badHMAC.dat")
+	assert.Nil(t, err)
+	db, report, err := Salvage(context.Background(), bytes.NewReader(data), "password", OpenOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.Problems))
+	assert.Equal(t, StructureHMAC, report.Problems[0].Structure)
+	assert.Equal(t, ErrBadHMAC, report.Problems[0].Err)
+	assert.Equal(t, []string{"Test entry"}, db.List())
+}
+
+// TestSalvageRecord damages a single record verifying the others are recovered and can be saved to a new db
+func TestSalvageRecord(t *testing.T) {
+	var buf bytes.Buffer
+	source := deterministicDB("one", "two", "three")
+	_, err := source.Encrypt(&buf)
+	assert.Nil(t, err)
+	data := buf.Bytes()
+
+	// Find the start of the second record by parsing the plaintext
+	block, _ := twofish.NewCipher(source.EncryptionKey[:])
+	end := bytes.Index(data, []byte(eofMarker))
+	plaintext := make([]byte, end-preludeSize)
+	cipher.NewCBCDecrypter(block, source.CBCIV[:]).CryptBlocks(plaintext, data[preludeSize:end])
+	r := bytes.NewReader(plaintext)
+	fields := newFieldReader(r, ioutil.Discard)
+	var db V3
+	assert.Nil(t, unmarshalRecord(fields, db.decodeField))
+	assert.Nil(t, unmarshalRecord(fields, new(Record).decodeField))
+	second := preludeSize + len(plaintext) - r.Len()
+
+	// Garble the first block of the record, the damage spreads to the second block when decrypting
+	for i := second; i < second+twofish.BlockSize; i++ {
+		data[i] ^= 0xff
+	}
+	salvaged, report, err := Salvage(context.Background(), bytes.NewReader(data), "password", OpenOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.Records)
+	assert.Equal(t, 1, report.Dropped)
+	assert.Equal(t, 2, len(report.Problems), report.String())
+	assert.Equal(t, StructureRecord, report.Problems[0].Structure)
+	assert.Equal(t, 1, report.Problems[0].Record)
+	assert.Equal(t, int64(second), report.Problems[0].Offset)
+	assert.Equal(t, StructureHMAC, report.Problems[1].Structure)
+	assert.Equal(t, []string{"one", "three"}, salvaged.recordOrder())
+
+	// The salvaged db saves and opens cleanly
+	buf.Reset()
+	assert.Nil(t, salvaged.SetPassword("password"))
+	_, err = salvaged.Encrypt(&buf)
+	assert.Nil(t, err)
+	report, err = Verify(context.Background(), bytes.NewReader(buf.Bytes()), "password", OpenOptions{})
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.String())
+	assert.Equal(t, 2, report.Records)
+}
@@ -0,0 +1,492 @@
+package pwsafe
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The XML format is that of the official Password Safe client described by its pwsafe.xsd,
+// https://github.com/pwsafe/pwsafe/blob/master/xml/pwsafe.xsd
+// Header fields with no equivalent in the schema are kept as extra root attributes so a round trip is lossless.
+
+// xmlDelimiter is the delimiter attribute the official client writes
+const xmlDelimiter = "►"
+
+// xmlTimeFormats are accepted when importing times, the official client writes local times without a zone
+var xmlTimeFormats = []string{time.RFC3339, "2006-01-02T15:04:05"}
+
+type xmlSafe struct {
+	XMLName             xml.Name `xml:"passwordsafe"`
+	Delimiter           string   `xml:"delimiter,attr"`
+	ExportTimeStamp     string   `xml:"ExportTimeStamp,attr,omitempty"`
+	FromDatabaseFormat  string   `xml:"FromDatabaseFormat,attr,omitempty"`
+	WhoSaved            string   `xml:"WhoSaved,attr,omitempty"`
+	WhenLastSaved       string   `xml:"WhenLastSaved,attr,omitempty"`
+	DatabaseUUID        string   `xml:"Database_UUID,attr,omitempty"`
+	DatabaseName        string   `xml:"Database_Name,attr,omitempty"`
+	DatabaseDescription string   `xml:"Database_Description,attr,omitempty"`
+	// Extensions for header fields not in the schema
+	Preferences  string `xml:"Preferences,attr,omitempty"`
+	Tree         string `xml:"Tree,attr,omitempty"`
+	Filters      string `xml:"Filters,attr,omitempty"`
+	RecentlyUsed string `xml:"RecentlyUsed,attr,omitempty"`
+	LastSaveUser string `xml:"LastSaveUser,attr,omitempty"`
+	LastSaveHost string `xml:"LastSaveHost,attr,omitempty"`
+
+	NumberHashIterations uint32          `xml:"NumberHashIterations,omitempty"`
+	PasswordPolicies     *xmlPolicies    `xml:"PasswordPolicies"`
+	EmptyGroups          *xmlEmptyGroups `xml:"EmptyGroups"`
+	Entries              []xmlEntry      `xml:"entry"`
+}
+
+// xmlPolicies and xmlEmptyGroups are pointers in xmlSafe so the elements are left out when there are none
+type xmlPolicies struct {
+	Policies []xmlPolicy `xml:"Policy"`
+}
+
+type xmlEmptyGroups struct {
+	Groups []string `xml:"EGName"`
+}
+
+type xmlPolicy struct {
+	Name               string `xml:"PWName,omitempty"`
+	Length             int    `xml:"PWLength"`
+	UseLowercase       int    `xml:"PWUseLowercase,omitempty"`
+	UseUppercase       int    `xml:"PWUseUppercase,omitempty"`
+	UseDigits          int    `xml:"PWUseDigits,omitempty"`
+	UseSymbols         int    `xml:"PWUseSymbols,omitempty"`
+	UseHexDigits       int    `xml:"PWUseHexDigits,omitempty"`
+	UseEasyVision      int    `xml:"PWUseEasyVision,omitempty"`
+	MakePronounceable  int    `xml:"PWMakePronounceable,omitempty"`
+	LowercaseMinLength int    `xml:"PWLowercaseMinLength,omitempty"`
+	UppercaseMinLength int    `xml:"PWUppercaseMinLength,omitempty"`
+	DigitMinLength     int    `xml:"PWDigitMinLength,omitempty"`
+	SymbolMinLength    int    `xml:"PWSymbolMinLength,omitempty"`
+	Symbols            string `xml:"symbols,omitempty"`
+}
+
+type xmlHistoryEntry struct {
+	Num         int    `xml:"num,attr"`
+	Changed     string `xml:"changedx"`
+	OldPassword string `xml:"oldpassword"`
+}
+
+type xmlHistory struct {
+	Status  int               `xml:"status"`
+	Max     int               `xml:"max"`
+	Num     int               `xml:"num"`
+	Entries []xmlHistoryEntry `xml:"history_entries>history_entry,omitempty"`
+}
+
+// xmlEntry is a record, the element order is that required by the schema
+type xmlEntry struct {
+	ID                 int         `xml:"id,attr,omitempty"`
+	Group              string      `xml:"group,omitempty"`
+	Title              string      `xml:"title"`
+	Username           string      `xml:"username,omitempty"`
+	Password           string      `xml:"password"`
+	URL                string      `xml:"url,omitempty"`
+	Autotype           string      `xml:"autotype,omitempty"`
+	Notes              string      `xml:"notes,omitempty"`
+	UUID               string      `xml:"uuid,omitempty"`
+	CreateTime         string      `xml:"ctimex,omitempty"`
+	AccessTime         string      `xml:"atimex,omitempty"`
+	PasswordExpiry     string      `xml:"xtimex,omitempty"`
+	PasswordModTime    string      `xml:"pmtimex,omitempty"`
+	ModTime            string      `xml:"rmtimex,omitempty"`
+	ExpiryInterval     uint32      `xml:"xtime_interval,omitempty"`
+	PasswordHistory    *xmlHistory `xml:"pwhistory,omitempty"`
+	PasswordPolicy     *xmlPolicy  `xml:"PasswordPolicy,omitempty"`
+	PasswordPolicyName string      `xml:"PasswordPolicyName,omitempty"`
+	RunCommand         string      `xml:"runcommand,omitempty"`
+	DoubleClick        uint16      `xml:"dca,omitempty"`
+	ShiftDoubleClick   uint16      `xml:"shiftdca,omitempty"`
+	Email              string      `xml:"email,omitempty"`
+	Protected          byte        `xml:"protected,omitempty"`
+}
+
+// ExportXML Writes all header and record fields of the db to w in the XML format of the official Password Safe client
+func (db *V3) ExportXML(w io.Writer) error {
+	safe := xmlSafe{
+		Delimiter:            xmlDelimiter,
+		ExportTimeStamp:      formatXMLTime(db.now()),
+		WhoSaved:             string(db.LastSaveBy),
+		WhenLastSaved:        formatXMLTime(db.LastSave),
+		DatabaseName:         db.Name,
+		DatabaseDescription:  db.Description,
+		Preferences:          db.Preferences,
+		Tree:                 db.Tree,
+		Filters:              db.Filters,
+		RecentlyUsed:         db.RecentyUsed,
+		LastSaveUser:         string(db.LastSaveUser),
+		LastSaveHost:         string(db.LastSaveHost),
+		NumberHashIterations: db.Iter,
+	}
+	if db.Version != [2]byte{} {
+		safe.FromDatabaseFormat = fmt.Sprintf("%d.%02x", db.Version[1], db.Version[0])
+	}
+	if len(db.EmptyGroups) > 0 {
+		safe.EmptyGroups = &xmlEmptyGroups{Groups: db.EmptyGroups}
+	}
+	if db.UUID != [16]byte{} {
+		safe.DatabaseUUID = hex.EncodeToString(db.UUID[:])
+	}
+	policies, err := ParsePasswordPolicies(db.PasswordPolicy)
+	if err != nil {
+		return fmt.Errorf("Error exporting the named password policies - %w", err)
+	}
+	if len(policies) > 0 {
+		safe.PasswordPolicies = &xmlPolicies{}
+		for _, policy := range policies {
+			safe.PasswordPolicies.Policies = append(safe.PasswordPolicies.Policies, newXMLPolicy(policy))
+		}
+	}
+
+	for i, title := range db.recordOrder() {
+		entry, err := newXMLEntry(db.Records[title])
+		if err != nil {
+			return fmt.Errorf("Error exporting record %q - %w", title, err)
+		}
+		entry.ID = i + 1
+		safe.Entries = append(safe.Entries, entry)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(safe); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// ImportXML Reads records in the XML format of the official Password Safe client adding them with SetRecord, a record
+// whose title is already used is renamed with a numbered suffix. Header fields are only imported if they are unset in
+// the db. Returns the number of records imported.
+func (db *V3) ImportXML(r io.Reader) (int, error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	var safe xmlSafe
+	if err := xml.NewDecoder(r).Decode(&safe); err != nil {
+		return 0, fmt.Errorf("Error parsing XML - %w", err)
+	}
+
+	records := make([]Record, 0, len(safe.Entries))
+	for i, entry := range safe.Entries {
+		record, err := entry.record()
+		if err != nil {
+			return 0, fmt.Errorf("Error importing entry %d %q - %w", i+1, entry.Title, err)
+		}
+		records = append(records, record)
+	}
+	var policies []PasswordPolicy
+	if safe.PasswordPolicies != nil {
+		for _, policy := range safe.PasswordPolicies.Policies {
+			policies = append(policies, policy.policy())
+		}
+	}
+
+	for _, header := range []struct {
+		field *string
+		value string
+	}{
+		{&db.Name, safe.DatabaseName},
+		{&db.Description, safe.DatabaseDescription},
+		{&db.Preferences, safe.Preferences},
+		{&db.Tree, safe.Tree},
+		{&db.Filters, safe.Filters},
+		{&db.RecentyUsed, safe.RecentlyUsed},
+		{&db.PasswordPolicy, FormatPasswordPolicies(policies)},
+	} {
+		if *header.field == "" {
+			*header.field = header.value
+		}
+	}
+	if len(db.LastSaveUser) == 0 && safe.LastSaveUser != "" {
+		db.LastSaveUser = []byte(safe.LastSaveUser)
+	}
+	if len(db.LastSaveHost) == 0 && safe.LastSaveHost != "" {
+		db.LastSaveHost = []byte(safe.LastSaveHost)
+	}
+	var emptyGroups []string
+	if safe.EmptyGroups != nil {
+		emptyGroups = safe.EmptyGroups.Groups
+	}
+	db.importRecords(records, emptyGroups)
+	return len(records), nil
+}
+
+// importRecords adds imported records with SetRecord giving each a unique title and keeping its creation,
+// modification and password modification times and password expiry which SetRecord would otherwise set from now, then
+// adds the empty groups.
+// A record keeps its UUID unless a record in the db already has it, as when the same file is imported twice, then it
+// gets a new one. The titles given are returned in order.
+//
+// Imports are all or nothing, each checks the db isn't read-only and parses and checks all of its input before
+// changing the db then finishes with importRecords, which can't fail.
+func (db *V3) importRecords(records []Record, emptyGroups []string) []string {
+	titles := make([]string, 0, len(records))
+	for _, record := range records {
+		record.Title = db.uniqueTitle(record.Title)
+		if record.UUID != [16]byte{} {
+			for _, existing := range db.Records {
+				if existing.UUID == record.UUID {
+					record.UUID = [16]byte{}
+					break
+				}
+			}
+		}
+		// SetRecord only fails for a read-only db
+		db.SetRecord(record)
+		stored := db.Records[record.Title]
+		if !record.CreateTime.IsZero() {
+			stored.CreateTime = record.CreateTime
+		}
+		if !record.ModTime.IsZero() {
+			stored.ModTime = record.ModTime
+		}
+		// an imported password wasn't changed now, so it keeps any expiry it has rather than one an interval from now
+		stored.PasswordModTime = record.PasswordModTime
+		if !record.PasswordExpiry.IsZero() {
+			stored.PasswordExpiry = record.PasswordExpiry
+		}
+		db.Records[record.Title] = stored
+		titles = append(titles, record.Title)
+	}
+	db.addEmptyGroups(emptyGroups)
+	db.LastMod = db.now()
+	return titles
+}
+
+// appendNotes returns the notes followed by a blank line then the extra lines, for imported data with no field
+func appendNotes(notes string, extra []string) string {
+	if len(extra) == 0 {
+		return notes
+	}
+	if notes != "" {
+		extra = append([]string{notes, ""}, extra...)
+	}
+	return strings.Join(extra, "\r\n")
+}
+
+// addEmptyGroups adds any of the groups not already in the db's empty groups
+func (db *V3) addEmptyGroups(groups []string) {
+	existing := make(map[string]bool, len(db.EmptyGroups))
+	for _, group := range db.EmptyGroups {
+		existing[group] = true
+	}
+	for _, group := range groups {
+		if !existing[group] {
+			existing[group] = true
+			db.EmptyGroups = append(db.EmptyGroups, group)
+		}
+	}
+}
+
+func newXMLEntry(record Record) (xmlEntry, error) {
+	entry := xmlEntry{
+		Group:              record.Group,
+		Title:              record.Title,
+		Username:           record.Username,
+		Password:           record.Password,
+		URL:                record.URL,
+		Autotype:           record.Autotype,
+		Notes:              record.Notes,
+		CreateTime:         formatXMLTime(record.CreateTime),
+		AccessTime:         formatXMLTime(record.AccessTime),
+		PasswordExpiry:     formatXMLTime(record.PasswordExpiry),
+		PasswordModTime:    formatXMLTime(record.PasswordModTime),
+		ModTime:            formatXMLTime(record.ModTime),
+		ExpiryInterval:     binary.LittleEndian.Uint32(record.PasswordExpiryInterval[:]),
+		PasswordPolicyName: record.PasswordPolicyName,
+		RunCommand:         record.RunCommand,
+		DoubleClick:        binary.LittleEndian.Uint16(record.DoubleClickAction[:]),
+		ShiftDoubleClick:   binary.LittleEndian.Uint16(record.ShiftDoubleClickAction[:]),
+		Email:              record.Email,
+		Protected:          record.ProtectedEntry,
+	}
+	if record.UUID != [16]byte{} {
+		entry.UUID = hex.EncodeToString(record.UUID[:])
+	}
+	if record.PasswordHistory != "" {
+		history, err := ParsePasswordHistory(record.PasswordHistory)
+		if err != nil {
+			return entry, err
+		}
+		entry.PasswordHistory = &xmlHistory{Status: boolInt(history.Enabled), Max: history.Max, Num: len(history.Entries)}
+		for i, old := range history.Entries {
+			entry.PasswordHistory.Entries = append(entry.PasswordHistory.Entries, xmlHistoryEntry{
+				Num: i + 1, Changed: formatXMLTime(old.Changed), OldPassword: old.Password,
+			})
+		}
+	}
+	if record.PasswordPolicy != "" {
+		policy, err := ParsePasswordPolicy(record.PasswordPolicy)
+		if err != nil {
+			return entry, err
+		}
+		xmlPolicy := newXMLPolicy(policy)
+		entry.PasswordPolicy = &xmlPolicy
+	}
+	return entry, nil
+}
+
+// record converts the entry to a Record
+func (entry xmlEntry) record() (Record, error) {
+	record := Record{
+		Group:              entry.Group,
+		Title:              entry.Title,
+		Username:           entry.Username,
+		Password:           entry.Password,
+		URL:                entry.URL,
+		Autotype:           entry.Autotype,
+		Notes:              entry.Notes,
+		PasswordPolicyName: entry.PasswordPolicyName,
+		RunCommand:         entry.RunCommand,
+		Email:              entry.Email,
+		ProtectedEntry:     entry.Protected,
+	}
+	binary.LittleEndian.PutUint32(record.PasswordExpiryInterval[:], entry.ExpiryInterval)
+	binary.LittleEndian.PutUint16(record.DoubleClickAction[:], entry.DoubleClick)
+	binary.LittleEndian.PutUint16(record.ShiftDoubleClickAction[:], entry.ShiftDoubleClick)
+	if entry.UUID != "" {
+		id, err := hex.DecodeString(entry.UUID)
+		if err != nil || len(id) != len(record.UUID) {
+			return record, fmt.Errorf("%w, invalid uuid %q", ErrInvalidField, entry.UUID)
+		}
+		copy(record.UUID[:], id)
+	}
+	for _, t := range []struct {
+		field *time.Time
+		value string
+	}{
+		{&record.CreateTime, entry.CreateTime},
+		{&record.AccessTime, entry.AccessTime},
+		{&record.PasswordExpiry, entry.PasswordExpiry},
+		{&record.PasswordModTime, entry.PasswordModTime},
+		{&record.ModTime, entry.ModTime},
+	} {
+		var err error
+		if *t.field, err = parseXMLTime(t.value); err != nil {
+			return record, err
+		}
+	}
+	if entry.PasswordHistory != nil {
+		history := PasswordHistory{Enabled: entry.PasswordHistory.Status != 0}
+		for _, old := range entry.PasswordHistory.Entries {
+			changed, err := parseXMLTime(old.Changed)
+			if err != nil {
+				return record, err
+			}
+			history.Entries = append(history.Entries, PasswordHistoryEntry{Changed: changed, Password: old.OldPassword})
+		}
+		history = trimHistory(history)
+		// the file's max is kept if it holds the entries, up to the 255 the field can hold
+		limit := entry.PasswordHistory.Max
+		if limit > 0xff {
+			limit = 0xff
+		}
+		if limit > history.Max {
+			history.Max = limit
+		}
+		var err error
+		if record.PasswordHistory, err = history.Format(); err != nil {
+			return record, err
+		}
+	}
+	if entry.PasswordPolicy != nil {
+		record.PasswordPolicy = entry.PasswordPolicy.policy().String()
+	}
+	return record, nil
+}
+
+// flags maps the policy flags to the xmlPolicy fields
+func (p *xmlPolicy) flags() []struct {
+	flag  PolicyFlags
+	value *int
+} {
+	return []struct {
+		flag  PolicyFlags
+		value *int
+	}{
+		{PolicyUseLowercase, &p.UseLowercase},
+		{PolicyUseUppercase, &p.UseUppercase},
+		{PolicyUseDigits, &p.UseDigits},
+		{PolicyUseSymbols, &p.UseSymbols},
+		{PolicyUseHexDigits, &p.UseHexDigits},
+		{PolicyUseEasyVision, &p.UseEasyVision},
+		{PolicyMakePronounceable, &p.MakePronounceable},
+	}
+}
+
+func newXMLPolicy(policy PasswordPolicy) xmlPolicy {
+	p := xmlPolicy{
+		Name:               policy.Name,
+		Length:             policy.Length,
+		LowercaseMinLength: policy.MinLowercase,
+		UppercaseMinLength: policy.MinUppercase,
+		DigitMinLength:     policy.MinDigits,
+		SymbolMinLength:    policy.MinSymbols,
+		Symbols:            policy.Symbols,
+	}
+	for _, f := range p.flags() {
+		*f.value = boolInt(policy.Flags&f.flag != 0)
+	}
+	return p
+}
+
+// policy converts the xml policy to a PasswordPolicy
+func (p xmlPolicy) policy() PasswordPolicy {
+	policy := PasswordPolicy{
+		Name:         p.Name,
+		Length:       p.Length,
+		MinLowercase: p.LowercaseMinLength,
+		MinUppercase: p.UppercaseMinLength,
+		MinDigits:    p.DigitMinLength,
+		MinSymbols:   p.SymbolMinLength,
+		Symbols:      p.Symbols,
+	}
+	for _, f := range p.flags() {
+		if *f.value != 0 {
+			policy.Flags |= f.flag
+		}
+	}
+	return policy
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// formatXMLTime formats a time for export, the zero time is an empty string
+func formatXMLTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseXMLTime parses an imported time, an empty string is the zero time
+func parseXMLTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, format := range xmlTimeFormats {
+		if t, err := time.ParseInLocation(format, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w, invalid time %q", ErrInvalidField, s)
+}
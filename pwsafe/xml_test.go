@@ -0,0 +1,178 @@
+package pwsafe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fullDB returns a db with every header field set and records using every record field
+func fullDB() *V3 {
+	db := NewV3("full", "password")
+	db.Description = "all fields\r\nset"
+	db.EmptyGroups = []string{"empty", "empty.sub"}
+	db.Filters = "<filters/>"
+	db.LastSaveHost = []byte("host")
+	db.LastSaveUser = []byte("user")
+	db.PasswordPolicy = FormatPasswordPolicies([]PasswordPolicy{
+		{Name: "pin", Flags: PolicyUseDigits, Length: 6, MinDigits: 6},
+		{Name: "strong", Flags: PolicyUseLowercase | PolicyUseUppercase | PolicyUseSymbols, Length: 20, MinSymbols: 2, Symbols: "!@#"},
+	})
+	db.Preferences = "B 24 1 I 12 5 "
+	db.RecentyUsed = "01" + strings.Repeat("ab", 16)
+	db.Tree = "group"
+	db.SetRecord(testRecord())
+
+	history := PasswordHistory{Enabled: true, Max: 3, Entries: []PasswordHistoryEntry{
+		{Changed: time.Unix(1300000000, 0), Password: "first"},
+		{Changed: time.Unix(1350000000, 0), Password: "<second & ünïcode>"},
+	}}
+	db.SetRecord(Record{Title: "history", Password: "current", PasswordHistory: history.String(), Group: "group"})
+	return db
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	source := fullDB()
+	var buf bytes.Buffer
+	assert.Nil(t, source.ExportXML(&buf))
+
+	imported := NewV3("", "password")
+	n, err := imported.ImportXML(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, len(source.Records), n)
+	equal, err := source.Equal(imported)
+	assert.Nil(t, err)
+	assert.True(t, equal)
+
+	// Times are kept rather than set to the import time
+	for title, record := range source.Records {
+		importedRecord, _ := imported.GetRecord(title)
+		assert.Equal(t, record.CreateTime.Unix(), importedRecord.CreateTime.Unix(), title)
+		assert.Equal(t, record.ModTime.Unix(), importedRecord.ModTime.Unix(), title)
+		assert.Equal(t, record.UUID, importedRecord.UUID, title)
+	}
+
+	// The test dbs written by other clients
+	for path, password := range map[string]string{"./test_dbs/simple.dat": "password", "./test_dbs/three.dat": "three3#;"} {
+		source, err := OpenPWSafeFile(path, password)
+		assert.Nil(t, err)
+		var buf bytes.Buffer
+		assert.Nil(t, source.(*V3).ExportXML(&buf), path)
+		imported := NewV3("", "password")
+		_, err = imported.ImportXML(&buf)
+		assert.Nil(t, err, path)
+		equal, err := source.Equal(imported)
+		assert.Nil(t, err, path)
+		assert.True(t, equal, path)
+	}
+}
+
+func TestImportXML(t *testing.T) {
+	// As written by the official client, times are local and there are duplicate titles in different groups
+	official := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<passwordsafe delimiter="►" Database="test.psafe3" ExportTimeStamp="2020-01-02T03:04:05" FromDatabaseFormat="3.47" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:noNamespaceSchemaLocation="pwsafe.xsd">
+<NumberHashIterations>2048</NumberHashIterations>
+<entry id="1" normal="true">
+<group><![CDATA[work]]></group>
+<title><![CDATA[mail]]></title>
+<username><![CDATA[me]]></username>
+<password><![CDATA[secret]]></password>
+<notes><![CDATA[line one
+line two]]></notes>
+<uuid><![CDATA[0102030405060708090a0b0c0d0e0f10]]></uuid>
+<ctimex>2020-01-02T03:04:05</ctimex>
+<PasswordPolicy>
+<PWLength>12</PWLength>
+<PWUseLowercase>1</PWUseLowercase>
+<PWUseDigits>1</PWUseDigits>
+<PWDigitMinLength>2</PWDigitMinLength>
+</PasswordPolicy>
+</entry>
+<entry id="2" normal="true">
+<group><![CDATA[home]]></group>
+<title><![CDATA[mail]]></title>
+<password><![CDATA[other]]></password>
+</entry>
+</passwordsafe>
+`
+	db := NewV3("", "password")
+	n, err := db.ImportXML(strings.NewReader(official))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"mail", "mail (2)"}, db.List())
+
+	work, _ := db.GetRecord("mail")
+	assert.Equal(t, "work", work.Group)
+	assert.Equal(t, "me", work.Username)
+	assert.Equal(t, "line one\nline two", work.Notes)
+	assert.Equal(t, [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, work.UUID)
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local), work.CreateTime)
+	policy, err := ParsePasswordPolicy(work.PasswordPolicy)
+	assert.Nil(t, err)
+	assert.Equal(t, PasswordPolicy{Flags: PolicyUseLowercase | PolicyUseDigits, Length: 12, MinDigits: 2}, policy)
+
+	home, _ := db.GetRecord("mail (2)")
+	assert.Equal(t, "home", home.Group)
+	assert.NotEqual(t, [16]byte{}, home.UUID)
+	// the passwords weren't changed on import
+	assert.True(t, home.PasswordModTime.IsZero())
+
+	// Importing again gives new UUIDs to those already in the db
+	_, err = db.ImportXML(strings.NewReader(official))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(db.Records))
+	uuids := make(map[[16]byte]bool)
+	for _, record := range db.Records {
+		uuids[record.UUID] = true
+	}
+	assert.Equal(t, 4, len(uuids))
+	work, _ = db.GetRecord("mail")
+	assert.Equal(t, [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, work.UUID)
+
+	// A history is limited to what the field can hold
+	var entries strings.Builder
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&entries, "<history_entry num=\"%d\"><changedx>2020-01-02T03:04:05</changedx><oldpassword>pw%d</oldpassword></history_entry>", i, i)
+	}
+	db = NewV3("", "password")
+	_, err = db.ImportXML(strings.NewReader(`<passwordsafe><entry><title>a</title><password>b</password><pwhistory><status>1</status><max>300</max><num>300</num><history_entries>` +
+		entries.String() + `</history_entries></pwhistory></entry></passwordsafe>`))
+	assert.Nil(t, err)
+	long, _ := db.GetRecord("a")
+	history, err := ParsePasswordHistory(long.PasswordHistory)
+	assert.Nil(t, err)
+	assert.Equal(t, 255, history.Max)
+	assert.Equal(t, 255, len(history.Entries))
+	assert.Equal(t, "pw299", history.Entries[254].Password)
+
+	// An expiry is kept although the password has an interval and no modification time
+	db = NewV3("", "password")
+	_, err = db.ImportXML(strings.NewReader(`<passwordsafe><entry><title>a</title><password>b</password><xtimex>2020-01-02T03:04:05</xtimex><xtime_interval>30</xtime_interval></entry></passwordsafe>`))
+	assert.Nil(t, err)
+	expiring, _ := db.GetRecord("a")
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local), expiring.PasswordExpiry)
+	assert.Equal(t, 30, expiring.ExpiryInterval())
+	assert.True(t, expiring.PasswordModTime.IsZero())
+
+	// Invalid entries change nothing
+	db = NewV3("", "password")
+	lastMod := db.LastMod
+	_, err = db.ImportXML(strings.NewReader(`<passwordsafe Database_Name="db"><EmptyGroups><EGName>empty</EGName></EmptyGroups><entry><title>a</title><password>b</password><ctimex>yesterday</ctimex></entry></passwordsafe>`))
+	assert.True(t, errors.Is(err, ErrInvalidField))
+	assert.Equal(t, 0, len(db.Records))
+	assert.Equal(t, "", db.Name)
+	assert.Equal(t, 0, len(db.EmptyGroups))
+	assert.Equal(t, lastMod, db.LastMod)
+
+	_, err = db.ImportXML(strings.NewReader("not xml"))
+	assert.NotNil(t, err)
+
+	db.readOnly = true
+	_, err = db.ImportXML(strings.NewReader(official))
+	assert.Equal(t, ErrReadOnly, err)
+}